@@ -0,0 +1,68 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// Latest core.action counters to compute the per-interval failure ratio
+type actionCounters struct {
+	processed RsyslogStatsValue
+	failed    RsyslogStatsValue
+}
+
+// Family name suffix of the action failure ratio
+const actionFailureRatioGauge = "_action_failure_ratio"
+
+// Add the failure ratio (failed / processed) between two stats reports to the
+// core.action metrics. The first report and counter resets (rsyslog restart)
+// use the counters as is. The ratio is 0 if nothing was processed.
+func (rs *RsyslogStats) addActionFailureRatio(m RsyslogStatsMetrics) {
+	prefix := rs.MetricPrefix + "_core_action_"
+	processed := m[prefix+"processed"]
+
+	if len(processed) == 0 {
+		return
+	}
+
+	ratioFamily := rs.MetricPrefix + actionFailureRatioGauge
+	m[ratioFamily] = make(RsyslogStatsLabeledValues, len(processed))
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	for labels, total := range processed {
+		failed := m[prefix+"failed"][labels]
+		processedDelta, failedDelta := total, failed
+
+		if prev, found := rs.actionCounters[labels]; found && total >= prev.processed && failed >= prev.failed {
+			processedDelta, failedDelta = total-prev.processed, failed-prev.failed
+		}
+
+		rs.actionCounters[labels] = actionCounters{processed: total, failed: failed}
+
+		m[ratioFamily][labels] = 0
+		if processedDelta > 0 {
+			m[ratioFamily][labels] = failedDelta / processedDelta
+		}
+	}
+}
+
+// Check if the family is the action failure ratio one
+func (rs *RsyslogStats) isActionFailureRatio(metricName string) bool {
+	return metricName == rs.MetricPrefix+actionFailureRatioGauge
+}
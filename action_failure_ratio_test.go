@@ -0,0 +1,52 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+// RsyslogStats.addActionFailureRatio
+func TestRsyslogStatsActionFailureRatio(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ActionFailureRatio = true
+	labels := RsyslogStatsLabels{"name", "fwd"}
+
+	var tests = []struct {
+		line  string
+		ratio RsyslogStatsValue
+	}{
+		{`{"name": "fwd", "origin": "core.action", "processed": 100, "failed": 10}`, 0.1},
+		{`{"name": "fwd", "origin": "core.action", "processed": 200, "failed": 60}`, 0.5},
+		{`{"name": "fwd", "origin": "core.action", "processed": 200, "failed": 60}`, 0},
+		// rsyslog restart
+		{`{"name": "fwd", "origin": "core.action", "processed": 40, "failed": 10}`, 0.25},
+	}
+
+	for _, c := range tests {
+		rs.Parse(c.line)
+
+		if got := rs.Metrics["rsyslog_action_failure_ratio"][labels]; got != c.ratio {
+			t.Errorf("%s: want ratio %v, got %v", c.line, c.ratio, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// Action suspension state derived from the core.action counters
+type actionSuspension struct {
+	suspended bool
+	// Latest suspended.duration counter value
+	duration RsyslogStatsValue
+	// Amount of suspend and resume transitions observed
+	suspends RsyslogStatsValue
+	resumes  RsyslogStatsValue
+}
+
+// Family name suffixes of the derived action suspension metrics
+const (
+	actionSuspendedGauge = "_action_suspended"
+	actionSuspendCounter = "_action_suspend_transitions"
+	actionResumeCounter  = "_action_resume_transitions"
+)
+
+// Add the action suspension state gauge and the suspend/resume transitions
+// counters to the core.action metrics. The action is considered suspended if
+// rsyslog reports more suspensions than resumptions or the suspended.duration
+// counter grows between the reports (older versions w/o resumed counter).
+func (rs *RsyslogStats) addActionSuspension(m RsyslogStatsMetrics) {
+	prefix := rs.MetricPrefix + "_core_action_"
+	suspended := m[prefix+"suspended"]
+
+	if len(suspended) == 0 {
+		return
+	}
+
+	stateFamily := rs.MetricPrefix + actionSuspendedGauge
+	suspendFamily := rs.MetricPrefix + actionSuspendCounter
+	resumeFamily := rs.MetricPrefix + actionResumeCounter
+	m[stateFamily] = make(RsyslogStatsLabeledValues, len(suspended))
+	m[suspendFamily] = make(RsyslogStatsLabeledValues, len(suspended))
+	m[resumeFamily] = make(RsyslogStatsLabeledValues, len(suspended))
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	for labels, suspensions := range suspended {
+		resumed, hasResumed := m[prefix+"resumed"][labels]
+		duration := m[prefix+"suspended_duration"][labels]
+
+		s, found := rs.suspensions[labels]
+		if !found {
+			s = &actionSuspension{duration: duration}
+			rs.suspensions[labels] = s
+		}
+
+		state := (hasResumed && suspensions > resumed) || duration > s.duration
+
+		switch {
+		case state && !s.suspended:
+			s.suspends++
+		case !state && s.suspended:
+			s.resumes++
+		}
+
+		s.suspended, s.duration = state, duration
+
+		m[stateFamily][labels] = 0
+		if state {
+			m[stateFamily][labels] = 1
+		}
+
+		m[suspendFamily][labels] = s.suspends
+		m[resumeFamily][labels] = s.resumes
+	}
+}
+
+// Check if the family is the action suspension state one
+func (rs *RsyslogStats) isActionSuspendedGauge(metricName string) bool {
+	return metricName == rs.MetricPrefix+actionSuspendedGauge
+}
@@ -0,0 +1,95 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RsyslogStats.addActionSuspension
+func TestRsyslogStatsActionSuspension(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		lines    []string
+		state    RsyslogStatsValue
+		suspends RsyslogStatsValue
+		resumes  RsyslogStatsValue
+	}{
+		{
+			[]string{
+				`{"name": "fwd", "origin": "core.action", "suspended": 0, "resumed": 0, "suspended.duration": 0}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "resumed": 0, "suspended.duration": 30}`,
+			},
+			1, 1, 0,
+		},
+		{
+			[]string{
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "resumed": 0, "suspended.duration": 30}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "resumed": 1, "suspended.duration": 60}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "resumed": 1, "suspended.duration": 60}`,
+			},
+			0, 1, 1,
+		},
+		// no resumed counter (older rsyslog versions)
+		{
+			[]string{
+				`{"name": "fwd", "origin": "core.action", "suspended": 0, "suspended.duration": 0}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "suspended.duration": 30}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 1, "suspended.duration": 30}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 2, "suspended.duration": 40}`,
+			},
+			1, 2, 1,
+		},
+	}
+
+	labels := RsyslogStatsLabels{"name", "fwd"}
+
+	for i, c := range tests {
+		rs := NewRsyslogStats()
+		rs.ActionSuspension = true
+
+		for _, line := range c.lines {
+			rs.Parse(line)
+		}
+
+		want := RsyslogStatsMetrics{
+			"rsyslog_action_suspended":           {labels: c.state},
+			"rsyslog_action_suspend_transitions": {labels: c.suspends},
+			"rsyslog_action_resume_transitions":  {labels: c.resumes},
+		}
+
+		got := RsyslogStatsMetrics{}
+		for name := range want {
+			got[name] = rs.Metrics[name]
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%d: suspension mismatch (-want +got):\n%s", i, diff)
+		}
+
+		if mType := metricValueType(rs, "rsyslog_action_suspended"); mType != prometheus.GaugeValue {
+			t.Errorf("suspension state must be a gauge, got %v", mType)
+		}
+	}
+}
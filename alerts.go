@@ -0,0 +1,185 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	alertsSent = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_alerts_sent",
+			Help: "Amount of webhook alerts sent",
+		},
+		[]string{"alert"},
+	)
+
+	alertFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_alert_failures",
+			Help: "Amount of webhook alerts failed to send",
+		},
+	)
+)
+
+// Metrics exported by the alert webhook
+var alertMetrics = []prometheus.Collector{
+	alertsSent,
+	alertFailures,
+}
+
+// Alert names
+const (
+	alertParserFailures  = "parser_failures"
+	alertDroppedMessages = "dropped_messages"
+)
+
+// Webhook alert payload
+type alertPayload struct {
+	Alert     string    `json:"alert"`
+	Instance  string    `json:"instance"`
+	Rate      float64   `json:"rate_per_minute"`
+	Threshold float64   `json:"threshold_per_minute"`
+	Time      time.Time `json:"time"`
+}
+
+// Built-in alerting for the sites w/o Alertmanager: the webhook is called when
+// the parser failures or the messages dropped by rsyslog queues (discarded.full
+// + discarded.nf) per minute exceed the thresholds (0 disables the alert)
+type alertWebhook struct {
+	url          string
+	failuresRate float64
+	droppedRate  float64
+	cooldown     time.Duration
+	client       *http.Client
+	instance     string
+	lastSent     map[string]time.Time
+	lastFailures float64
+	lastDropped  float64
+	lastCheck    time.Time
+}
+
+// Create the webhook alerter
+func newAlertWebhook(url string, failuresRate, droppedRate float64, cooldown time.Duration) *alertWebhook {
+	instance, _ := os.Hostname()
+
+	return &alertWebhook{
+		url:          url,
+		failuresRate: failuresRate,
+		droppedRate:  droppedRate,
+		cooldown:     cooldown,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		instance:     instance,
+		lastSent:     make(map[string]time.Time),
+	}
+}
+
+// Get the parser failures and the messages dropped by the queues
+func alertCounters(rs *RsyslogStats) (failures float64, dropped float64) {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	for _, counter := range []string{"discarded_full", "discarded_nf"} {
+		for _, value := range rs.Metrics[rs.MetricPrefix+"_core_queue_"+counter] {
+			dropped += float64(value)
+		}
+	}
+
+	return float64(rs.ParserFailures), dropped
+}
+
+// Post the alert unless it's in the cooldown
+func (aw *alertWebhook) fire(alert string, rate, threshold float64, now time.Time) {
+	if now.Sub(aw.lastSent[alert]) < aw.cooldown {
+		return
+	}
+
+	body, err := json.Marshal(alertPayload{Alert: alert, Instance: aw.instance, Rate: rate, Threshold: threshold, Time: now})
+	if err != nil {
+		alertFailures.Inc()
+		return
+	}
+
+	resp, err := aw.client.Post(aw.url, "application/json", bytes.NewReader(body))
+	if err == nil {
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			err = fmt.Errorf("unexpected status %s", resp.Status)
+		}
+	}
+
+	if err != nil {
+		alertFailures.Inc()
+		log.Printf("cannot send %s alert: %s", alert, err)
+
+		return
+	}
+
+	aw.lastSent[alert] = now
+	alertsSent.WithLabelValues(alert).Inc()
+}
+
+// Check the rates since the previous check and fire the alerts
+func (aw *alertWebhook) check(rs *RsyslogStats, now time.Time) {
+	failures, dropped := alertCounters(rs)
+
+	if !aw.lastCheck.IsZero() {
+		minutes := now.Sub(aw.lastCheck).Minutes()
+
+		// counters may only go down on rsyslog restart
+		if rate := (failures - aw.lastFailures) / minutes; aw.failuresRate > 0 && rate > aw.failuresRate {
+			aw.fire(alertParserFailures, rate, aw.failuresRate, now)
+		}
+
+		if rate := (dropped - aw.lastDropped) / minutes; aw.droppedRate > 0 && rate > aw.droppedRate {
+			aw.fire(alertDroppedMessages, rate, aw.droppedRate, now)
+		}
+	}
+
+	aw.lastFailures, aw.lastDropped, aw.lastCheck = failures, dropped, now
+}
+
+// Check the rates every interval until the context is done
+func (aw *alertWebhook) run(ctx context.Context, rs *RsyslogStats, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	aw.check(rs, time.Now())
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			aw.check(rs, now)
+		}
+	}
+}
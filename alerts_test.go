@@ -0,0 +1,82 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// alertWebhook.check
+func TestAlertWebhookCheck(t *testing.T) {
+	t.Parallel()
+
+	alerts := make(chan alertPayload, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p alertPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("cannot decode alert: %s", err)
+		}
+		alerts <- p
+	}))
+	defer srv.Close()
+
+	rs := NewRsyslogStats()
+	aw := newAlertWebhook(srv.URL, 5, 100, 15*time.Minute)
+	start := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		failures int
+		dropped  RsyslogStatsValue
+		offset   time.Duration
+		want     []string
+	}{
+		{0, 0, 0, nil},
+		{3, 50, time.Minute, nil},
+		{10, 50, 2 * time.Minute, []string{alertParserFailures}},
+		{20, 500, 3 * time.Minute, []string{alertDroppedMessages}}, // parser failures are in cooldown
+		{200, 500, 20 * time.Minute, []string{alertParserFailures}},
+	}
+
+	for _, tt := range tests {
+		rs.Lock()
+		rs.ParserFailures = tt.failures
+		rs.Metrics[rs.MetricPrefix+"_core_queue_discarded_full"] = RsyslogStatsLabeledValues{
+			RsyslogStatsLabels{}.With("name", "main Q"): tt.dropped,
+		}
+		rs.Unlock()
+
+		aw.check(rs, start.Add(tt.offset))
+
+		got := []string(nil)
+		for len(alerts) > 0 {
+			got = append(got, (<-alerts).Alert)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("alerts at %s mismatch (-want +got):\n%s", tt.offset, diff)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// JSON API metric value types
+var apiValueTypes = map[prometheus.ValueType]string{
+	prometheus.CounterValue: "counter",
+	prometheus.GaugeValue:   "gauge",
+	prometheus.UntypedValue: "untyped",
+}
+
+// API labeled metric value
+type apiSeries struct {
+	Labels map[string]string `json:"labels"`
+	Value  RsyslogStatsValue `json:"value"`
+}
+
+// API metric family
+type apiFamily struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Updated time.Time   `json:"updated"`
+	Series  []apiSeries `json:"series"`
+}
+
+// API metric tree
+type apiMetrics struct {
+	ParsedMessages int         `json:"parsed_messages"`
+	ParserFailures int         `json:"parser_failures"`
+	ParseTimestamp int64       `json:"parse_timestamp"`
+	Families       []apiFamily `json:"families"`
+}
+
+// Build the metric tree sorted by family name and labels
+func buildAPIMetrics(rs *RsyslogStats) apiMetrics {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	rv := apiMetrics{
+		ParsedMessages: rs.ParsedMessages,
+		ParserFailures: rs.ParserFailures,
+		ParseTimestamp: rs.ParseTimestamp,
+		Families:       []apiFamily{},
+	}
+
+	for _, metricName := range rs.Metrics.SortedNames() {
+		labeledValues := rs.Metrics[metricName]
+		family := apiFamily{
+			Name:    metricName,
+			Type:    apiValueTypes[metricValueType(rs, metricName)],
+			Updated: rs.FamilyUpdated(metricName),
+			Series:  []apiSeries{},
+		}
+
+		for _, labels := range labeledValues.SortedLabels() {
+			family.Series = append(family.Series, apiSeries{Labels: labelsToMap(labels), Value: labeledValues[labels]})
+		}
+
+		rv.Families = append(rv.Families, family)
+	}
+
+	return rv
+}
+
+// Register the JSON metrics API endpoint:
+//
+//	GET /api/v1/metrics   parsed metric tree
+func registerMetricsAPIHandlers(mux *http.ServeMux, rs *RsyslogStats) {
+	mux.Handle("/api/v1/metrics", instrumentHandler("api-metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(buildAPIMetrics(rs)); err != nil {
+			log.Printf("cannot send metrics: %s", err)
+		}
+	})))
+}
+
+// Register the quarantined series debug endpoint:
+//
+//	GET /debug/quarantine   latest quarantined series
+func registerQuarantineHandlers(mux *http.ServeMux, rs *RsyslogStats) {
+	mux.Handle("/debug/quarantine", instrumentHandler("quarantine", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(rs.QuarantineSamples()); err != nil {
+			log.Printf("cannot send quarantined series: %s", err)
+		}
+	})))
+}
@@ -0,0 +1,87 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+// registerMetricsAPIHandlers
+func TestMetricsAPI(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 10, "enqueued": 20}`)
+	rs.Parse(`{"name": "action 1 queue", "origin": "core.queue", "size": 1}`)
+
+	mux := http.NewServeMux()
+	registerMetricsAPIHandlers(mux, rs)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/metrics", nil))
+
+	var got apiMetrics
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := apiMetrics{
+		ParsedMessages: 2,
+		ParseTimestamp: rs.ParseTimestamp,
+		Families: []apiFamily{
+			{
+				Name:   "rsyslog_core_queue_enqueued",
+				Type:   "counter",
+				Series: []apiSeries{{Labels: map[string]string{"name": "main Q"}, Value: 20}},
+			},
+			{
+				Name: "rsyslog_core_queue_size",
+				Type: "gauge",
+				Series: []apiSeries{
+					{Labels: map[string]string{"name": "action 1 queue"}, Value: 1},
+					{Labels: map[string]string{"name": "main Q"}, Value: 10},
+				},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(apiFamily{}, "Updated")); diff != "" {
+		t.Errorf("apiMetrics mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, f := range got.Families {
+		if f.Updated.IsZero() {
+			t.Errorf("%s: update time is not set", f.Name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/metrics", nil))
+
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("want %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
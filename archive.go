@@ -0,0 +1,267 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	_ "modernc.org/sqlite" // database/sql driver
+)
+
+var (
+	archivedRows = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_archived_rows",
+			Help: "Amount of stats counters archived to SQLite database",
+		},
+	)
+
+	archiveFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_archive_failures",
+			Help: "Amount of stats counters failed to archive or dropped as the archive is behind",
+		},
+	)
+)
+
+// Metrics exported by the SQLite archive
+var archiveMetrics = []prometheus.Collector{
+	archivedRows,
+	archiveFailures,
+}
+
+// SQLite archive of the raw stats (disabled if nil)
+var archive *statsArchive
+
+// Archive table: a row per counter of every stats line
+const archiveSchema = `
+CREATE TABLE IF NOT EXISTS stats (
+	time    INTEGER NOT NULL, -- unix time in milliseconds
+	sender  TEXT NOT NULL,
+	origin  TEXT NOT NULL,
+	name    TEXT NOT NULL,
+	counter TEXT NOT NULL,
+	value   NUMERIC NOT NULL
+);
+CREATE INDEX IF NOT EXISTS stats_time ON stats (time);
+CREATE INDEX IF NOT EXISTS stats_name ON stats (name, counter, time);
+`
+
+// Archived counter value
+type archiveRow struct {
+	Time    time.Time
+	Sender  string
+	Origin  string
+	Name    string
+	Counter string
+	Value   interface{}
+}
+
+// Archive of every stats line received to the local SQLite database for the
+// offline history queries. Rows are written in batches by the archive
+// goroutine, rows older than the retention are pruned every hour.
+type statsArchive struct {
+	db          *sql.DB
+	retention   time.Duration
+	nameField   string
+	originField string
+	rows        chan []archiveRow
+}
+
+// Open the archive database creating the table if needed
+func newStatsArchive(path string, retention time.Duration, nameField, originField string) (*statsArchive, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows the only writer
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000", archiveSchema} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &statsArchive{
+		db:          db,
+		retention:   retention,
+		nameField:   nameField,
+		originField: originField,
+		rows:        make(chan []archiveRow, 1024),
+	}, nil
+}
+
+// Flatten the stats line counters: nested objects (dynstats values e.g.) are
+// joined with dots, non-numeric values are skipped
+func flattenCounters(prefix string, m map[string]interface{}, rv map[string]interface{}) {
+	for key, value := range m {
+		switch v := value.(type) {
+		case json.Number:
+			if i, err := v.Int64(); err == nil {
+				rv[prefix+key] = i
+			} else if f, err := v.Float64(); err == nil {
+				rv[prefix+key] = f
+			}
+		case map[string]interface{}:
+			flattenCounters(prefix+key+".", v, rv)
+		}
+	}
+}
+
+// Get the archive rows of the stats line (nil if it's not a JSON object)
+func (a *statsArchive) lineRows(now time.Time, sender string, line string) []archiveRow {
+	d := json.NewDecoder(strings.NewReader(line))
+	d.UseNumber()
+
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		return nil
+	}
+
+	name, _ := m[a.nameField].(string)
+	origin, _ := m[a.originField].(string)
+	counters := map[string]interface{}{}
+
+	flattenCounters("", m, counters)
+
+	rows := make([]archiveRow, 0, len(counters))
+	for counter, value := range counters {
+		rows = append(rows, archiveRow{Time: now, Sender: sender, Origin: origin, Name: name, Counter: counter, Value: value})
+	}
+
+	return rows
+}
+
+// Queue the stats line counters for archiving. Rows are dropped if the
+// archive is behind, so the database never blocks the parser.
+func (a *statsArchive) archive(now time.Time, sender string, line string) {
+	if a == nil {
+		return
+	}
+
+	rows := a.lineRows(now, sender, line)
+	if len(rows) == 0 {
+		return
+	}
+
+	select {
+	case a.rows <- rows:
+	default:
+		archiveFailures.Add(float64(len(rows)))
+	}
+}
+
+// Insert the rows in the single transaction
+func (a *statsArchive) insert(rows []archiveRow) error {
+	tx, err := a.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO stats (time, sender, origin, name, counter, value) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.Time.UnixMilli(), r.Sender, r.Origin, r.Name, r.Counter, r.Value); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete the rows older than the retention
+func (a *statsArchive) prune(now time.Time) (int64, error) {
+	res, err := a.db.Exec("DELETE FROM stats WHERE time < ?", now.Add(-a.retention).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// Write the rows queued every second and prune the old ones every hour until
+// the context is done, the rows queued are written on shutdown
+func (a *statsArchive) run(ctx context.Context) {
+	flush := time.NewTicker(time.Second)
+	defer flush.Stop()
+
+	pruning := time.NewTicker(time.Hour)
+	defer pruning.Stop()
+
+	if _, err := a.prune(time.Now()); err != nil {
+		log.Printf("cannot prune archive: %s", err)
+	}
+
+	batch := []archiveRow{}
+
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := a.insert(batch); err != nil {
+			log.Printf("cannot archive %d stats counters: %s", len(batch), err)
+			archiveFailures.Add(float64(len(batch)))
+		} else {
+			archivedRows.Add(float64(len(batch)))
+		}
+
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case rows := <-a.rows:
+			batch = append(batch, rows...)
+		case <-flush.C:
+			write()
+		case now := <-pruning.C:
+			if pruned, err := a.prune(now); err != nil {
+				log.Printf("cannot prune archive: %s", err)
+			} else if pruned > 0 {
+				log.Printf("%d archived stats counters are pruned", pruned)
+			}
+		case <-ctx.Done():
+			for len(a.rows) > 0 {
+				batch = append(batch, <-a.rows...)
+			}
+
+			write()
+			a.db.Close()
+
+			return
+		}
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Open the test archive in the temporary directory
+func newTestArchive(t *testing.T) *statsArchive {
+	t.Helper()
+
+	a, err := newStatsArchive(filepath.Join(t.TempDir(), "archive.db"), 24*time.Hour, "name", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+// statsArchive.lineRows
+func TestStatsArchiveLineRows(t *testing.T) {
+	t.Parallel()
+
+	a := newTestArchive(t)
+	defer a.db.Close()
+
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		line string
+		want []archiveRow
+	}{
+		{
+			`{"name":"main Q","origin":"core.queue","size":10,"enqueued":1234,"ratio":0.5}`,
+			[]archiveRow{
+				{now, "host1", "core.queue", "main Q", "enqueued", int64(1234)},
+				{now, "host1", "core.queue", "main Q", "ratio", 0.5},
+				{now, "host1", "core.queue", "main Q", "size", int64(10)},
+			},
+		},
+		{
+			`{"name":"global","origin":"dynstats","values":{"msg_per_host.ops_overflow":3}}`,
+			[]archiveRow{
+				{now, "host1", "dynstats", "global", "values.msg_per_host.ops_overflow", int64(3)},
+			},
+		},
+		{`not a json`, nil},
+	}
+
+	for _, tt := range tests {
+		got := a.lineRows(now, "host1", tt.line)
+		sort.Slice(got, func(i, j int) bool { return got[i].Counter < got[j].Counter })
+
+		if len(got) == 0 {
+			got = nil
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("lineRows(%s) mismatch (-want +got):\n%s", tt.line, diff)
+		}
+	}
+}
+
+// statsArchive.run
+func TestStatsArchiveRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.db")
+
+	a, err := newStatsArchive(path, 24*time.Hour, "name", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archived := testutil.ToFloat64(archivedRows)
+
+	old := time.Now().Add(-48 * time.Hour)
+	if err := a.insert(a.lineRows(old, "host1", `{"name":"main Q","origin":"core.queue","size":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	a.archive(time.Now(), "host1", `{"name":"main Q","origin":"core.queue","size":10,"enqueued":1234}`)
+	a.archive(time.Now(), "host2", `{"name":"action 1","origin":"core.action","processed":5}`)
+
+	// the old rows are pruned on start, the rows queued are written on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	a.run(ctx)
+
+	if got := testutil.ToFloat64(archivedRows) - archived; got != 3 {
+		t.Errorf("rsyslog_exporter_archived_rows: want 3, got %v", got)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT sender, name, counter, value FROM stats ORDER BY sender, counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	got := []string{}
+	for rows.Next() {
+		var (
+			sender, name, counter string
+			value                 int64
+		)
+
+		if err := rows.Scan(&sender, &name, &counter, &value); err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, fmt.Sprintf("%s/%s/%s=%d", sender, name, counter, value))
+	}
+
+	want := []string{"host1/main Q/enqueued=1234", "host1/main Q/size=10", "host2/action 1/processed=5"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("archived rows mismatch (-want +got):\n%s", diff)
+	}
+}
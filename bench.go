@@ -0,0 +1,328 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Synthesized impstats line generators by origin
+var benchGenerators = map[string]func(sender int, seq int) []string{
+	"core.queue": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"main Q","origin":"core.queue","size":%d,"enqueued":%d,"full":0,"discarded.full":0,"discarded.nf":0,"maxqsize":%d}`, seq%100, seq*10, 100),
+			fmt.Sprintf(`{"name":"action-%d-omfwd queue","origin":"core.queue","size":%d,"enqueued":%d,"full":0,"discarded.full":0,"discarded.nf":0,"maxqsize":%d}`, sender%4, seq%10, seq*5, 10),
+		}
+	},
+	"core.action": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"action-%d-omfwd","origin":"core.action","processed":%d,"failed":%d,"suspended":0,"suspended.duration":0,"resumed":0}`, sender%4, seq*5, seq%3),
+		}
+	},
+	"impstats": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"resource-usage","origin":"impstats","utime":%d,"stime":%d,"maxrss":%d,"minflt":%d,"majflt":0,"inblock":0,"oublock":%d,"nvcsw":%d,"nivcsw":%d,"openfiles":%d}`, seq*1000, seq*500, 20480+seq%1024, seq*3, seq, seq*7, seq*2, 10+seq%5),
+		}
+	},
+	"dynstats": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"global","origin":"dynstats","values":{"msg_per_host.ops_overflow":0,"msg_per_host.new_metric_add":%d,"msg_per_host.no_metric":0,"msg_per_host.metrics_purged":0,"msg_per_host.ops_ignored":0}}`, seq),
+		}
+	},
+	"dynstats.bucket": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"msg_per_host","origin":"dynstats.bucket","values":{"bench-host-%d":%d,"bench-host-%d":%d}}`, sender, seq*10, sender+1, seq*5),
+		}
+	},
+	"sender": func(sender int, seq int) []string {
+		return []string{
+			fmt.Sprintf(`{"name":"_sender_stat","origin":"impstats","sender":"bench-client-%d","messages":"%d"}`, sender, seq*42),
+		}
+	},
+}
+
+// Generate impstats lines of a single stats cycle for the sender
+func benchCycle(origins []string, sender int, seq int) ([]string, error) {
+	lines := []string{}
+
+	for _, origin := range origins {
+		gen, found := benchGenerators[origin]
+		if !found {
+			return nil, fmt.Errorf("bench origin %s is not supported", origin)
+		}
+
+		lines = append(lines, gen(sender, seq)...)
+	}
+
+	return lines, nil
+}
+
+// Format the impstats line as a syslog message of the sender
+func benchSyslogMessage(syslogFormat string, sender int, line string) string {
+	hostname := fmt.Sprintf("bench-host-%d", sender)
+
+	if syslogFormat == "rfc5424" {
+		return fmt.Sprintf("<46>1 %s %s rsyslogd - - - %s", time.Now().Format(time.RFC3339), hostname, line)
+	}
+
+	return fmt.Sprintf("<46>%s %s rsyslogd-pstats: %s", time.Now().Format(time.Stamp), hostname, line)
+}
+
+// Size of the parse latency sample kept by the bench
+const benchLatencySamples = 100000
+
+// Bench results
+type benchResult struct {
+	sent     int
+	failures int
+	elapsed  time.Duration
+	// Fixed-size uniform sample of the parse latencies (reservoir sampling)
+	latencies []time.Duration
+	// Amount of the latencies observed and the maximum one
+	observed   int
+	maxLatency time.Duration
+}
+
+// Record the parse latency, keeping the memory bounded by the sample size
+func (br *benchResult) observe(d time.Duration) {
+	br.observed++
+
+	if d > br.maxLatency {
+		br.maxLatency = d
+	}
+
+	if len(br.latencies) < benchLatencySamples {
+		br.latencies = append(br.latencies, d)
+	} else if i := rand.Intn(br.observed); i < benchLatencySamples {
+		br.latencies[i] = d
+	}
+}
+
+// Print bench results
+func (br *benchResult) print(w io.Writer) {
+	fmt.Fprintf(w, "Lines:      %d\n", br.sent)
+	fmt.Fprintf(w, "Elapsed:    %s\n", br.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Throughput: %.0f lines/s\n", float64(br.sent)/br.elapsed.Seconds())
+
+	if len(br.latencies) == 0 {
+		return
+	}
+
+	sort.Slice(br.latencies, func(i, j int) bool { return br.latencies[i] < br.latencies[j] })
+
+	percentile := func(p float64) time.Duration {
+		return br.latencies[int(float64(len(br.latencies)-1)*p)]
+	}
+
+	fmt.Fprintf(w, "Failures:   %d\n", br.failures)
+	fmt.Fprintf(w, "Parse latency: p50=%s p90=%s p99=%s max=%s\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), br.maxLatency)
+}
+
+// Pace lines to the rate set (lines per second, 0 means unlimited)
+type benchPacer struct {
+	rate  int
+	start time.Time
+}
+
+func (bp *benchPacer) wait(sent int) {
+	if bp.rate <= 0 {
+		return
+	}
+
+	due := bp.start.Add(time.Duration(float64(sent) / float64(bp.rate) * float64(time.Second)))
+	if d := time.Until(due); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Run the bench against the parser in-process
+func benchInProcess(origins []string, senders int, rate int, duration time.Duration) (*benchResult, error) {
+	rs := NewRsyslogStats()
+	br := &benchResult{}
+	pacer := &benchPacer{rate: rate, start: time.Now()}
+
+	for seq := 1; time.Since(pacer.start) < duration; seq++ {
+		for sender := 0; sender < senders; sender++ {
+			lines, err := benchCycle(origins, sender, seq)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, line := range lines {
+				pacer.wait(br.sent)
+
+				start := time.Now()
+				rs.Parse(line)
+				br.observe(time.Since(start))
+				br.sent++
+			}
+		}
+	}
+
+	br.elapsed = time.Since(pacer.start)
+	br.failures = rs.ParserFailures
+
+	return br, nil
+}
+
+// Run the bench against the exporter syslog listener
+func benchTarget(target string, syslogFormat string, origins []string, senders int, rate int, duration time.Duration) (*benchResult, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("wrong bench target: %s", target)
+	}
+
+	conn, err := net.Dial(u.Scheme, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriter(conn)
+	br := &benchResult{}
+	pacer := &benchPacer{rate: rate, start: time.Now()}
+
+	for seq := 1; time.Since(pacer.start) < duration; seq++ {
+		for sender := 0; sender < senders; sender++ {
+			lines, err := benchCycle(origins, sender, seq)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, line := range lines {
+				pacer.wait(br.sent)
+
+				msg := benchSyslogMessage(syslogFormat, sender, line)
+				if u.Scheme == "tcp" {
+					_, err = w.WriteString(msg + "\n")
+				} else {
+					_, err = conn.Write([]byte(msg))
+				}
+
+				if err != nil {
+					return nil, err
+				}
+
+				br.sent++
+			}
+		}
+	}
+
+	if err = w.Flush(); err != nil {
+		return nil, err
+	}
+
+	br.elapsed = time.Since(pacer.start)
+
+	return br, nil
+}
+
+// Read the parsed messages counter from the exporter metrics endpoint
+func benchScrapeParsed(metricsURL string) (float64, error) {
+	resp, err := http.Get(metricsURL)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "rsyslog_exporter_parsed_messages" {
+			return strconv.ParseFloat(fields[1], 64)
+		}
+	}
+
+	return 0, fmt.Errorf("rsyslog_exporter_parsed_messages metric is not found at %s", metricsURL)
+}
+
+// `rsyslog_exporter bench` subcommand
+func benchMain(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+
+	var (
+		target       = fs.String("target", "", "Exporter syslog listener to send stats to as proto://ip:port (in-process parser is benchmarked if empty)")
+		metricsURL   = fs.String("metrics-url", "", "Exporter metrics URL to read the parsed messages counter from after the run")
+		syslogFormat = fs.String("syslog-format", "rfc3164", "Syslog version to use (rfc3164, rfc5424)")
+		origins      = fs.String("origins", "core.queue,core.action,impstats,dynstats,dynstats.bucket,sender", "Comma-separated list of origins to synthesize")
+		senders      = fs.Int("senders", 10, "Number of rsyslog instances to simulate")
+		rate         = fs.Int("rate", 0, "Lines per second to send (0 means unlimited)")
+		duration     = fs.Duration("duration", 10*time.Second, "Bench duration")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	var (
+		br  *benchResult
+		err error
+	)
+
+	if *target == "" {
+		br, err = benchInProcess(splitList(*origins), *senders, *rate, *duration)
+	} else {
+		var before float64
+
+		if *metricsURL != "" {
+			if before, err = benchScrapeParsed(*metricsURL); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		br, err = benchTarget(*target, *syslogFormat, splitList(*origins), *senders, *rate, *duration)
+
+		if err == nil && *metricsURL != "" {
+			// let the exporter drain its input
+			time.Sleep(time.Second)
+
+			after, err := benchScrapeParsed(*metricsURL)
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Fprintf(os.Stdout, "Parsed:     %.0f (%.1f%% of sent)\n", after-before, (after-before)*100/float64(br.sent))
+		}
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	br.print(os.Stdout)
+}
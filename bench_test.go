@@ -0,0 +1,90 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// benchCycle
+func TestBenchCycle(t *testing.T) {
+	t.Parallel()
+
+	origins := []string{}
+	for origin := range benchGenerators {
+		origins = append(origins, origin)
+	}
+
+	rs := NewRsyslogStats()
+
+	for seq := 1; seq <= 3; seq++ {
+		for sender := 0; sender < 2; sender++ {
+			lines, err := benchCycle(origins, sender, seq)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, line := range lines {
+				rs.Parse(line)
+			}
+		}
+	}
+
+	if rs.ParserFailures != 0 {
+		t.Errorf("ParserFailures: want 0, got %d", rs.ParserFailures)
+	}
+
+	if _, err := benchCycle([]string{"unknown"}, 0, 1); err == nil {
+		t.Error("benchCycle: want error for unknown origin")
+	}
+}
+
+// benchResult.observe
+func TestBenchResultObserve(t *testing.T) {
+	t.Parallel()
+
+	br := &benchResult{}
+	n := benchLatencySamples * 3
+
+	for i := 1; i <= n; i++ {
+		br.observe(time.Duration(i))
+	}
+
+	if len(br.latencies) != benchLatencySamples {
+		t.Errorf("latencies: want %d samples, got %d", benchLatencySamples, len(br.latencies))
+	}
+
+	if br.observed != n || br.maxLatency != time.Duration(n) {
+		t.Errorf("observed, max: want %d, %d, got %d, %d", n, n, br.observed, br.maxLatency)
+	}
+
+	// the sample is uniform: about 2/3 of it is taken from the later values
+	later := 0
+	for _, d := range br.latencies {
+		if d > time.Duration(benchLatencySamples) {
+			later++
+		}
+	}
+
+	if share := float64(later) / benchLatencySamples; share < 0.6 || share > 0.73 {
+		t.Errorf("later values share: want ~0.67, got %.2f", share)
+	}
+}
@@ -20,19 +20,148 @@
 package main
 
 import (
-	_ "net/http/pprof"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var (
+	parseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rsyslog_exporter_parse_duration_seconds",
+			Help:    "Time spent parsing a single rsyslog stat message",
+			Buckets: prometheus.ExponentialBuckets(1e-6, 4, 10),
+		},
+		[]string{"origin", "type"},
+	)
+
+	collectDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "rsyslog_exporter_collect_duration_seconds",
+			Help:    "Time spent collecting rsyslog metrics on scrape",
+			Buckets: prometheus.ExponentialBuckets(1e-4, 4, 10),
+		},
+	)
+)
+
+// Metrics exported by the parsing pipeline
+var pipelineMetrics = []prometheus.Collector{
+	parseDuration,
+	collectDuration,
+}
+
+// Get the metric family value type
+func metricValueType(rs *RsyslogStats, metricName string) prometheus.ValueType {
+	// renamed families have the type of the source ones
+	if source, found := rs.renamedFamilies[metricName]; found {
+		return metricValueType(rs, source)
+	}
+
+	// logical series have the type of the per-worker ones
+	if source, found := rs.workerSumSource(metricName); found {
+		return metricValueType(rs, source)
+	}
+
+	switch {
+	case metricName == "rsyslog_core_queue_size", rs.isQueueWatermark(metricName),
+		rs.isActionSuspendedGauge(metricName), rs.isActionFailureRatio(metricName),
+		rs.isWorkerCount(metricName), rs.isStatusGauge(metricName), rs.isProcessGauge(metricName),
+		rs.isPercentileGauge(metricName), rs.isDynafileCacheGauge(metricName),
+		rs.isOmkafkaGauge(metricName):
+		return prometheus.GaugeValue
+	case rs.IsCounterLabelFamily(metricName), metricName == rs.RawFamily():
+		// gauges and counters are mixed in the family
+		return prometheus.UntypedValue
+	}
+
+	return prometheus.CounterValue
+}
+
+// Metric groups selectable by the collect[] scrape parameter: group name to
+// the family name prefix (w/o the metric prefix)
+var collectGroups = map[string]string{
+	"queues":      "core_queue",
+	"actions":     "core_action",
+	"databases":   "database_action",
+	"dynstats":    "dynstats",
+	"percentiles": "percentile",
+	"senders":     "sender_stat",
+	"resources":   "impstats",
+	"raw":         "raw",
+}
+
+// Groups of the families not matching any prefix and the exporter's own metrics
+const (
+	otherGroup    = "other"
+	exporterGroup = "exporter"
+)
+
+// Get the metric family group
+func metricGroup(rs *RsyslogStats, metricName string) string {
+	for group, prefix := range collectGroups {
+		family := rs.MetricPrefix + "_" + prefix
+		if metricName == family || strings.HasPrefix(metricName, family+"_") {
+			return group
+		}
+	}
+
+	return otherGroup
+}
+
+// Get the sorted list of the metric groups supported
+func metricGroups() []string {
+	groups := []string{otherGroup, exporterGroup}
+	for group := range collectGroups {
+		groups = append(groups, group)
+	}
+
+	sort.Strings(groups)
+
+	return groups
+}
+
 // RsyslogStatsCollector is the prometheus collector implementation
 type RsyslogStatsCollector struct {
 	RS *RsyslogStats
+	// Number of the biggest metric families to export series counts for
+	TopFamilies int
+	// Metric groups to export (all if nil)
+	Groups map[string]bool
+	// Subtracted from the Prometheus scrape timeout to get the collect deadline
+	TimeoutOffset time.Duration
+	// Families are truncated once the deadline is exceeded (if set)
+	Deadline time.Time
+	// Exporter parsing metrics set: legacy, v2 or both
+	SelfMetrics string
+	// Exporter group is served by the separate endpoint, skipped here
+	SeparateExporter bool
+	// Per-scraper counter values to export the *_delta families (if set)
+	Deltas *deltaTracker
+	// Scraper the deltas are computed for
+	Scraper string
 }
 
+// Exporter parsing metrics sets: the legacy flat counters, the reworked
+// metrics with the per-listener and per-origin breakdowns, or both of them to
+// migrate the dashboards
+const (
+	selfMetricsLegacy = "legacy"
+	selfMetricsV2     = "v2"
+	selfMetricsBoth   = "both"
+)
+
+// Exporter start time
+var exporterStart = time.Now()
+
 // NewRsyslogStatsCollector constructor
 func NewRsyslogStatsCollector(rs *RsyslogStats) *RsyslogStatsCollector {
-	return &RsyslogStatsCollector{RS: rs}
+	return &RsyslogStatsCollector{RS: rs, TopFamilies: 10, TimeoutOffset: 500 * time.Millisecond, SelfMetrics: selfMetricsLegacy}
 }
 
 // Describe metrics
@@ -42,25 +171,288 @@ func (rsc *RsyslogStatsCollector) Describe(ch chan<- *prometheus.Desc) {}
 func (rsc *RsyslogStatsCollector) Collect(ch chan<- prometheus.Metric) {
 	var mType prometheus.ValueType
 
+	start := time.Now()
+	defer func() { collectDuration.Observe(time.Since(start).Seconds()) }()
+
 	rsc.RS.RLock()
 
-	for metricName, labeledValues := range rsc.RS.Metrics {
-		for labels, value := range labeledValues {
-			switch metricName {
-			case "rsyslog_core_queue_size":
-				mType = prometheus.GaugeValue
-			default:
-				mType = prometheus.CounterValue
+	truncated := 0.0
+
+	var deltas *deltaScraper
+	if rsc.Deltas != nil {
+		deltas = rsc.Deltas.scraper(rsc.Scraper, start)
+		deltas.Lock()
+		defer deltas.Unlock()
+	}
+
+	// families and label sets are sorted to keep the exposition stable
+families:
+	for _, metricName := range rsc.RS.Metrics.SortedNames() {
+		if rsc.Groups != nil && !rsc.Groups[metricGroup(rsc.RS, metricName)] {
+			continue
+		}
+
+		labeledValues := rsc.RS.Metrics[metricName]
+		mType = metricValueType(rsc.RS, metricName)
+
+		for _, labels := range labeledValues.SortedLabels() {
+			if !rsc.Deadline.IsZero() && time.Now().After(rsc.Deadline) {
+				truncated = 1
+				break families
 			}
 
-			desc := prometheus.NewDesc(metricName, "", []string{labels.Name}, nil)
-			ch <- prometheus.MustNewConstMetric(desc, mType, float64(value), labels.Value)
+			desc := prometheus.NewDesc(metricName, "", labels.Names(), nil)
+			ch <- prometheus.MustNewConstMetric(desc, mType, float64(labeledValues[labels]), labels.Values()...)
+
+			if deltas == nil || mType != prometheus.CounterValue {
+				continue
+			}
+
+			if delta, ok := deltas.delta(metricName, labels, labeledValues[labels]); ok {
+				desc := prometheus.NewDesc(metricName+deltaSuffix, "", labels.Names(), nil)
+				ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(delta), labels.Values()...)
+			}
 		}
 	}
 
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_scrape_truncated",
+			"Whether the rsyslog metrics were truncated to fit the Prometheus scrape timeout",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		truncated,
+	)
+
+	if rsc.SeparateExporter || (rsc.Groups != nil && !rsc.Groups[exporterGroup]) {
+		rsc.RS.RUnlock()
+		return
+	}
+
+	rejectedDesc := prometheus.NewDesc(
+		"rsyslog_exporter_rejected_series",
+		"Amount of label sets rejected due to the per-family series limit",
+		[]string{"family"}, nil,
+	)
+
+	for _, family := range sortedKeys(rsc.RS.RejectedSeries) {
+		ch <- prometheus.MustNewConstMetric(rejectedDesc, prometheus.CounterValue, float64(rsc.RS.RejectedSeries[family]), family)
+	}
+
+	senderFailuresDesc := prometheus.NewDesc(
+		"rsyslog_exporter_sender_parser_failures",
+		"Amount of rsyslog stats parsing failures per sender",
+		[]string{"sender"}, nil,
+	)
+
+	for _, sender := range sortedKeys(rsc.RS.SenderParserFailures) {
+		ch <- prometheus.MustNewConstMetric(senderFailuresDesc, prometheus.CounterValue, float64(rsc.RS.SenderParserFailures[sender]), sender)
+	}
+
+	senderSeenDesc := prometheus.NewDesc(
+		"rsyslog_exporter_sender_last_seen_timestamp_seconds",
+		"Unix timestamp of the latest stats message parsed per sender",
+		[]string{"sender"}, nil,
+	)
+
+	for _, sender := range sortedKeys(rsc.RS.senderSeen) {
+		seen := rsc.RS.senderSeen[sender]
+		ch <- prometheus.MustNewConstMetric(senderSeenDesc, prometheus.GaugeValue, float64(seen.UnixNano())/1e9, sender)
+	}
+
+	collisionsDesc := prometheus.NewDesc(
+		"rsyslog_exporter_metric_collisions",
+		"Amount of series overwritten by a different raw counter sanitised to the same name",
+		[]string{"family"}, nil,
+	)
+
+	for _, family := range sortedKeys(rsc.RS.Collisions) {
+		ch <- prometheus.MustNewConstMetric(collisionsDesc, prometheus.CounterValue, float64(rsc.RS.Collisions[family]), family)
+	}
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_quarantined_series",
+			"Amount of series not stored due to invalid metric/label names or values",
+			nil, nil,
+		),
+		prometheus.CounterValue,
+		float64(rsc.RS.QuarantinedSeries),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_metric_store_bytes",
+			"Approximate memory used by the metric store in bytes",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(rsc.RS.storeBytes),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_interned_strings",
+			"Amount of distinct metric names and label names and values interned",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(rsc.RS.interned.size()),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_expired_series",
+			"Amount of series expired as not updated within the series TTL",
+			nil, nil,
+		),
+		prometheus.CounterValue,
+		float64(rsc.RS.ExpiredSeries),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_metric_family_rebuilds",
+			"Amount of metric families rebuilt to return the memory of the expired series",
+			nil, nil,
+		),
+		prometheus.CounterValue,
+		float64(rsc.RS.RebuiltFamilies),
+	)
+
+	rsc.collectFieldFailures(ch)
+
+	if rsc.SelfMetrics != selfMetricsLegacy {
+		rsc.collectParseBreakdown(ch)
+	}
+
 	rsc.RS.RUnlock()
 
-	// export internal counters
+	if rsc.SelfMetrics != selfMetricsV2 {
+		rsc.collectLegacy(ch)
+	}
+
+	if rsc.SelfMetrics != selfMetricsLegacy {
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"rsyslog_exporter_last_parse_timestamp_seconds",
+				"Unix timestamp of the latest stats message parsed",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(rsc.RS.ParseTimestamp),
+		)
+
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(
+				"rsyslog_exporter_start_time_seconds",
+				"Unix timestamp the exporter was started at",
+				nil, nil,
+			),
+			prometheus.GaugeValue,
+			float64(exporterStart.UnixNano())/1e9,
+		)
+	}
+
+	// export cardinality counters
+	families, series, biggest := rsc.RS.Cardinality(rsc.TopFamilies)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_metric_families",
+			"Amount of metric families stored",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(families),
+	)
+
+	ch <- prometheus.MustNewConstMetric(
+		prometheus.NewDesc(
+			"rsyslog_exporter_metric_series",
+			"Amount of labeled series stored",
+			nil, nil,
+		),
+		prometheus.GaugeValue,
+		float64(series),
+	)
+
+	familySeriesDesc := prometheus.NewDesc(
+		"rsyslog_exporter_metric_family_series",
+		"Amount of labeled series stored for the biggest metric families",
+		[]string{"family"}, nil,
+	)
+
+	for _, f := range biggest {
+		ch <- prometheus.MustNewConstMetric(familySeriesDesc, prometheus.GaugeValue, float64(f.Series), f.Name)
+	}
+}
+
+// Export the parsed messages and the parsing failures per listener and
+// origin. Must be called with the stats read lock held.
+func (rsc *RsyslogStatsCollector) collectParseBreakdown(ch chan<- prometheus.Metric) {
+	messagesDesc := prometheus.NewDesc(
+		"rsyslog_exporter_parsed_messages_total",
+		"Amount of rsyslog stat messages parsed per listener and origin",
+		[]string{"listener", "origin"}, nil,
+	)
+
+	keys := make([]RsyslogStatsListenerOrigin, 0, len(rsc.RS.ListenerOriginMessages))
+	for key := range rsc.RS.ListenerOriginMessages {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Listener != keys[j].Listener {
+			return keys[i].Listener < keys[j].Listener
+		}
+		return keys[i].Origin < keys[j].Origin
+	})
+
+	for _, key := range keys {
+		ch <- prometheus.MustNewConstMetric(messagesDesc, prometheus.CounterValue, float64(rsc.RS.ListenerOriginMessages[key]), key.Listener, key.Origin)
+	}
+
+	failuresDesc := prometheus.NewDesc(
+		"rsyslog_exporter_parser_failures_total",
+		"Amount of rsyslog stats parsing failures per listener",
+		[]string{"listener"}, nil,
+	)
+
+	for _, listener := range sortedKeys(rsc.RS.ListenerParserFailures) {
+		ch <- prometheus.MustNewConstMetric(failuresDesc, prometheus.CounterValue, float64(rsc.RS.ListenerParserFailures[listener]), listener)
+	}
+}
+
+// Export the fields skipped due to the value conversion errors. Must be
+// called with the stats read lock held.
+func (rsc *RsyslogStatsCollector) collectFieldFailures(ch chan<- prometheus.Metric) {
+	fieldFailuresDesc := prometheus.NewDesc(
+		"rsyslog_exporter_field_conversion_failures_total",
+		"Amount of fields skipped due to the value conversion errors per origin and field",
+		[]string{"origin", "field"}, nil,
+	)
+
+	fields := make([]RsyslogStatsOriginField, 0, len(rsc.RS.FieldFailures))
+	for key := range rsc.RS.FieldFailures {
+		fields = append(fields, key)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].Origin != fields[j].Origin {
+			return fields[i].Origin < fields[j].Origin
+		}
+		return fields[i].Field < fields[j].Field
+	})
+
+	for _, key := range fields {
+		ch <- prometheus.MustNewConstMetric(fieldFailuresDesc, prometheus.CounterValue, float64(rsc.RS.FieldFailures[key]), key.Origin, key.Field)
+	}
+}
+
+// Export the legacy flat parsing counters (parse timestamp as a counter)
+func (rsc *RsyslogStatsCollector) collectLegacy(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(
 		prometheus.NewDesc(
 			"rsyslog_exporter_parser_failures",
@@ -91,3 +483,63 @@ func (rsc *RsyslogStatsCollector) Collect(ch chan<- prometheus.Metric) {
 		float64(rsc.RS.ParseTimestamp),
 	)
 }
+
+// Serve the metrics limited to the collect[] groups if requested, like
+// `/metrics?collect[]=queues&collect[]=actions`. Other gatherers (exporter
+// self-metrics e.g.) are always served. The rsyslog metrics are truncated to
+// fit the X-Prometheus-Scrape-Timeout-Seconds header if it's set. The deltas
+// are computed per scraper if enabled.
+func newMetricsHandler(rsc *RsyslogStatsCollector, gatherers prometheus.Gatherers, opts promhttp.HandlerOpts) http.Handler {
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(rsc)
+
+	handler := promhttp.HandlerFor(append(prometheus.Gatherers{reg}, gatherers...), opts)
+	supported := map[string]bool{}
+
+	for _, group := range metricGroups() {
+		supported[group] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collect := r.URL.Query()["collect[]"]
+		timeout := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+
+		if len(collect) == 0 && timeout == "" && rsc.Deltas == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		filtered := *rsc
+
+		if rsc.Deltas != nil {
+			filtered.Scraper = deltaScraperKey(r)
+		}
+
+		if timeout != "" {
+			seconds, err := strconv.ParseFloat(timeout, 64)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("cannot parse X-Prometheus-Scrape-Timeout-Seconds: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			filtered.Deadline = time.Now().Add(time.Duration(seconds*float64(time.Second)) - rsc.TimeoutOffset)
+		}
+
+		if len(collect) > 0 {
+			filtered.Groups = make(map[string]bool, len(collect))
+		}
+
+		for _, group := range collect {
+			if !supported[group] {
+				http.Error(w, fmt.Sprintf("unknown collect[] group %s, supported: %s", group, strings.Join(metricGroups(), ", ")), http.StatusBadRequest)
+				return
+			}
+
+			filtered.Groups[group] = true
+		}
+
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(&filtered)
+		promhttp.HandlerFor(append(prometheus.Gatherers{reg}, gatherers...), opts).ServeHTTP(w, r)
+	})
+}
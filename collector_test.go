@@ -0,0 +1,407 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Collect metric descriptions and label values in the collection order
+func collectOrder(t *testing.T, c prometheus.Collector) []string {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	rv := []string{}
+
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			t.Fatal(err)
+		}
+
+		entry := metric.Desc().String()
+		for _, l := range m.GetLabel() {
+			entry += " " + l.GetName() + "=" + l.GetValue()
+		}
+
+		rv = append(rv, entry)
+	}
+
+	return rv
+}
+
+// RsyslogStatsCollector.Collect (ordering)
+func TestRsyslogStatsCollectorCollectOrder(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1, "enqueued": 2, "full": 3}`)
+	rs.Parse(`{"name": "action 1 queue", "origin": "core.queue", "size": 1, "enqueued": 2, "full": 3}`)
+	rs.Parse(`{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {"c": 1, "a": 2, "b": 3}}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `not a json`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, `not a json`)
+
+	rsc := NewRsyslogStatsCollector(rs)
+	want := collectOrder(t, rsc)
+
+	for i := 0; i < 10; i++ {
+		if diff := cmp.Diff(want, collectOrder(t, rsc)); diff != "" {
+			t.Fatalf("collection order mismatch (-want +got):\n%s", diff)
+		}
+	}
+
+	if want[0] > want[1] {
+		t.Errorf("families are not sorted: %s > %s", want[0], want[1])
+	}
+}
+
+// RsyslogStatsCollector.Collect (sender heartbeat)
+func TestRsyslogStatsCollectorSenderLastSeen(t *testing.T) {
+	t.Parallel()
+
+	before := time.Now()
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `{"name": "main Q", "origin": "core.queue", "size": 2}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host3"}, `not a json`)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewRsyslogStatsCollector(rs))
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]float64{}
+
+	for _, mf := range mfs {
+		if mf.GetName() != "rsyslog_exporter_sender_last_seen_timestamp_seconds" {
+			continue
+		}
+
+		for _, m := range mf.GetMetric() {
+			got[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+		}
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("want heartbeats of host1 and host2, got %v", got)
+	}
+
+	for sender, seen := range got {
+		if seen < float64(before.Unix()) || seen > float64(time.Now().Unix()+1) {
+			t.Errorf("%s: last seen timestamp %f is out of the test time range", sender, seen)
+		}
+	}
+}
+
+// RsyslogStatsCollector.Collect (exporter group served separately)
+func TestRsyslogStatsCollectorSeparateExporter(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1}`)
+
+	rsc := NewRsyslogStatsCollector(rs)
+	exporter := *rsc
+	exporter.Groups = map[string]bool{exporterGroup: true}
+	rsc.SeparateExporter = true
+
+	families := func(c prometheus.Collector) map[string]bool {
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(c)
+
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rv := map[string]bool{}
+		for _, mf := range mfs {
+			rv[mf.GetName()] = true
+		}
+
+		return rv
+	}
+
+	rsyslog, self := families(rsc), families(&exporter)
+
+	if !rsyslog["rsyslog_core_queue_size"] || rsyslog["rsyslog_exporter_parsed_messages"] {
+		t.Errorf("want rsyslog metrics only, got %v", rsyslog)
+	}
+
+	if self["rsyslog_core_queue_size"] || !self["rsyslog_exporter_parsed_messages"] {
+		t.Errorf("want exporter metrics only, got %v", self)
+	}
+}
+
+// RsyslogStatsCollector.Collect (self-metrics sets)
+func TestRsyslogStatsCollectorSelfMetrics(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Listener: "udp:0.0.0.0:5145"}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Listener: "udp:0.0.0.0:5145"}, `{"name": "main Q", "origin": "core.queue", "size": 2}`)
+	rs.ParseFrom(RsyslogStatsSource{Listener: "tcp:0.0.0.0:5145"}, `{"name": "fwd", "origin": "core.action", "processed": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Listener: "tcp:0.0.0.0:5145"}, `not a json`)
+
+	legacy := []string{
+		"rsyslog_exporter_parse_timestamp",
+		"rsyslog_exporter_parsed_messages",
+		"rsyslog_exporter_parser_failures",
+	}
+
+	v2 := []string{
+		"rsyslog_exporter_last_parse_timestamp_seconds",
+		"rsyslog_exporter_parsed_messages_total",
+		"rsyslog_exporter_parser_failures_total",
+		"rsyslog_exporter_start_time_seconds",
+	}
+
+	var tests = []struct {
+		selfMetrics string
+		families    []string
+	}{
+		{selfMetricsLegacy, legacy},
+		{selfMetricsV2, v2},
+		{selfMetricsBoth, append(append([]string{}, v2...), legacy...)},
+	}
+
+	for _, c := range tests {
+		rsc := NewRsyslogStatsCollector(rs)
+		rsc.SelfMetrics = c.selfMetrics
+
+		reg := prometheus.NewPedanticRegistry()
+		reg.MustRegister(rsc)
+
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		families := map[string]*dto.MetricFamily{}
+		for _, mf := range mfs {
+			families[mf.GetName()] = mf
+		}
+
+		got := []string{}
+		for _, name := range append(append([]string{}, legacy...), v2...) {
+			if families[name] != nil {
+				got = append(got, name)
+			}
+		}
+
+		want := append([]string{}, c.families...)
+		sort.Strings(want)
+		sort.Strings(got)
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: families mismatch (-want +got):\n%s", c.selfMetrics, diff)
+		}
+
+		if mf := families["rsyslog_exporter_parsed_messages_total"]; mf != nil {
+			messages := map[string]float64{}
+			for _, m := range mf.GetMetric() {
+				messages[m.GetLabel()[0].GetValue()+" "+m.GetLabel()[1].GetValue()] = m.GetCounter().GetValue()
+			}
+
+			want := map[string]float64{"tcp:0.0.0.0:5145 core.action": 1, "udp:0.0.0.0:5145 core.queue": 2}
+			if diff := cmp.Diff(want, messages); diff != "" {
+				t.Errorf("%s: parsed messages mismatch (-want +got):\n%s", c.selfMetrics, diff)
+			}
+		}
+
+		if mf := families["rsyslog_exporter_last_parse_timestamp_seconds"]; mf != nil && mf.GetType() != dto.MetricType_GAUGE {
+			t.Errorf("%s: last parse timestamp must be a gauge, got %s", c.selfMetrics, mf.GetType())
+		}
+	}
+}
+
+// newMetricsHandler (collect[] filtering)
+func TestMetricsHandlerCollect(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.Parse(`{"name": "fwd", "origin": "core.action", "processed": 2}`)
+	rs.Parse(`{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {"a": 1}}`)
+	rs.Parse(`{"name": "imudp(*:514)", "origin": "imudp", "submitted": 3}`)
+
+	handler := newMetricsHandler(NewRsyslogStatsCollector(rs), nil, promhttp.HandlerOpts{})
+	reFamily := regexp.MustCompile(`(?m)^(rsyslog_[a-z_]+)`)
+
+	var tests = []struct {
+		query    string
+		families []string
+	}{
+		{"?collect[]=queues&collect[]=actions", []string{"rsyslog_core_action_processed", "rsyslog_core_queue_size", "rsyslog_exporter_scrape_truncated"}},
+		{"?collect[]=dynstats", []string{"rsyslog_dynstats_bucket_msg_per_host", "rsyslog_exporter_scrape_truncated"}},
+		{"?collect[]=other", []string{"rsyslog_exporter_scrape_truncated", "rsyslog_imudp_submitted"}},
+	}
+
+	for _, c := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics"+c.query, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want status 200, got %d", c.query, rec.Code)
+		}
+
+		families := map[string]bool{}
+		for _, family := range reFamily.FindAllString(rec.Body.String(), -1) {
+			families[family] = true
+		}
+
+		got := []string{}
+		for family := range families {
+			got = append(got, family)
+		}
+
+		sort.Strings(got)
+
+		if diff := cmp.Diff(c.families, got); diff != "" {
+			t.Errorf("%s: families mismatch (-want +got):\n%s", c.query, diff)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?collect[]=exporter", nil))
+
+	for _, family := range reFamily.FindAllString(rec.Body.String(), -1) {
+		if !regexp.MustCompile(`^rsyslog_exporter_`).MatchString(family) {
+			t.Errorf("exporter group must contain exporter metrics only, got %s", family)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?collect[]=unknown", nil))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown group: want status 400, got %d", rec.Code)
+	}
+}
+
+// newMetricsHandler (scrape timeout truncation)
+func TestMetricsHandlerScrapeTimeout(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1}`)
+
+	rsc := NewRsyslogStatsCollector(rs)
+	rsc.TimeoutOffset = time.Second
+	handler := newMetricsHandler(rsc, nil, promhttp.HandlerOpts{})
+
+	var tests = []struct {
+		timeout string
+		status  int
+		want    []string
+	}{
+		{"", http.StatusOK, []string{"rsyslog_core_queue_size{name=\"main Q\"} 1", "rsyslog_exporter_scrape_truncated 0"}},
+		{"10", http.StatusOK, []string{"rsyslog_core_queue_size{name=\"main Q\"} 1", "rsyslog_exporter_scrape_truncated 0"}},
+		{"0.5", http.StatusOK, []string{"rsyslog_exporter_scrape_truncated 1"}},
+		{"x", http.StatusBadRequest, nil},
+	}
+
+	for _, c := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if c.timeout != "" {
+			req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", c.timeout)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != c.status {
+			t.Fatalf("%s: want status %d, got %d", c.timeout, c.status, rec.Code)
+		}
+
+		got := regexp.MustCompile(`(?m)^(rsyslog_core_queue_size.*|rsyslog_exporter_scrape_truncated.*)$`).FindAllString(rec.Body.String(), -1)
+		if diff := cmp.Diff(c.want, got); diff != "" {
+			t.Errorf("%s: metrics mismatch (-want +got):\n%s", c.timeout, diff)
+		}
+	}
+}
+
+// newMetricsHandler (per-scraper deltas)
+func TestMetricsHandlerDeltas(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 5, "enqueued": 10}`)
+
+	rsc := NewRsyslogStatsCollector(rs)
+	rsc.Deltas = newDeltaTracker(deltaScraperTTL)
+	handler := newMetricsHandler(rsc, nil, promhttp.HandlerOpts{})
+
+	reDelta := regexp.MustCompile(`(?m)^(rsyslog_[a-z_]+_delta)\{[^}]*\} (\S+)$`)
+
+	scrape := func(scraper string) map[string]string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics?collect[]=queues&scraper="+scraper, nil))
+
+		rv := map[string]string{}
+		for _, match := range reDelta.FindAllStringSubmatch(rec.Body.String(), -1) {
+			rv[match[1]] = match[2]
+		}
+
+		return rv
+	}
+
+	var tests = []struct {
+		line    string
+		scraper string
+		want    map[string]string
+	}{
+		// first scrape of the series
+		{"", "a", map[string]string{}},
+		{`{"name": "main Q", "origin": "core.queue", "size": 1, "enqueued": 15}`, "a", map[string]string{"rsyslog_core_queue_enqueued_delta": "5"}},
+		{"", "b", map[string]string{}},
+		{`{"name": "main Q", "origin": "core.queue", "size": 1, "enqueued": 18}`, "a", map[string]string{"rsyslog_core_queue_enqueued_delta": "3"}},
+		{"", "b", map[string]string{"rsyslog_core_queue_enqueued_delta": "3"}},
+		// counter reset
+		{`{"name": "main Q", "origin": "core.queue", "size": 1, "enqueued": 2}`, "a", map[string]string{"rsyslog_core_queue_enqueued_delta": "2"}},
+	}
+
+	for i, c := range tests {
+		if c.line != "" {
+			rs.Parse(c.line)
+		}
+
+		if diff := cmp.Diff(c.want, scrape(c.scraper)); diff != "" {
+			t.Errorf("%d: deltas mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+}
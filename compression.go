@@ -0,0 +1,167 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of the stats files and streams
+const (
+	compressionNone = ""
+	compressionAuto = "auto"
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+	compressionZstd = "zstd"
+)
+
+// Magic numbers of the compressed data
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Check the compression is supported
+func checkCompression(compression string) error {
+	switch compression {
+	case compressionNone, compressionAuto, compressionGzip, compressionZlib, compressionZstd:
+		return nil
+	}
+
+	return fmt.Errorf("compression %s is not supported (auto, gzip, zlib, zstd)", compression)
+}
+
+// Detect the compression by the magic number. zlib (rsyslog omfwd stream
+// compression) is detected by the header checksum, syslog messages never
+// start with 'x' (0x78).
+func sniffCompression(br *bufio.Reader) string {
+	magic, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(magic, zstdMagic):
+		return compressionZstd
+	case len(magic) >= 2 && magic[0] == 0x78 && (uint16(magic[0])<<8|uint16(magic[1]))%31 == 0:
+		return compressionZlib
+	}
+
+	return compressionNone
+}
+
+// Reader of the decompressed data, closing the decompressor
+type decompressor struct {
+	io.Reader
+	close func() error
+}
+
+// Close the decompressor
+func (d *decompressor) Close() error {
+	if d.close == nil {
+		return nil
+	}
+
+	return d.close()
+}
+
+// Get the reader decompressing the data (detecting the compression with
+// auto). Returns the compression used.
+func newDecompressor(br *bufio.Reader, compression string) (io.ReadCloser, string, error) {
+	if compression == compressionAuto {
+		compression = sniffCompression(br)
+	}
+
+	switch compression {
+	case compressionGzip:
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+
+		return gz, compression, nil
+	case compressionZlib:
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, compression, err
+		}
+
+		return zr, compression, nil
+	case compressionZstd:
+		zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, compression, err
+		}
+
+		return zr.IOReadCloser(), compression, nil
+	}
+
+	return &decompressor{Reader: br}, compressionNone, nil
+}
+
+// Input is larger than the reader allows
+var errInputTooLarge = errors.New("input exceeds the max size")
+
+// Reader failing once more than the max amount of bytes is read, so the
+// oversized input (decompression bombs e.g.) is rejected, not truncated
+type maxBytesReader struct {
+	r    io.Reader
+	max  int64
+	left int64
+}
+
+// Get the reader of the max amount of bytes
+func newMaxBytesReader(r io.Reader, max int64) *maxBytesReader {
+	return &maxBytesReader{r: r, max: max, left: max}
+}
+
+// Read the data until the max amount is exceeded
+func (mr *maxBytesReader) Read(p []byte) (int, error) {
+	if mr.left < 0 {
+		return 0, mr.err()
+	}
+
+	n, err := mr.r.Read(p)
+	if int64(n) > mr.left {
+		n, mr.left = int(mr.left), -1
+		return n, mr.err()
+	}
+
+	mr.left -= int64(n)
+
+	return n, err
+}
+
+// Allow the max amount of bytes again (the next message of the stream e.g.)
+func (mr *maxBytesReader) reset() {
+	mr.left = mr.max
+}
+
+// Get the error of the input exceeding the max amount
+func (mr *maxBytesReader) err() error {
+	return fmt.Errorf("%w of %d bytes", errInputTooLarge, mr.max)
+}
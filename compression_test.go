@@ -0,0 +1,109 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// sniffCompression
+func TestSniffCompression(t *testing.T) {
+	t.Parallel()
+
+	compressed := func(newWriter func(w io.Writer) io.WriteCloser) []byte {
+		var b bytes.Buffer
+		w := newWriter(&b)
+		w.Write([]byte(`{"name":"main Q"}`))
+		w.Close()
+		return b.Bytes()
+	}
+
+	var tests = []struct {
+		name        string
+		stream      []byte
+		compression string
+	}{
+		{"gzip", compressed(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }), compressionGzip},
+		{"zlib", compressed(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }), compressionZlib},
+		{"zstd", compressed(func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		}), compressionZstd},
+		{"json", []byte(`{"name":"main Q"}`), compressionNone},
+		{"syslog", []byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}"), compressionNone},
+		{"short", []byte{0x1f}, compressionNone},
+		{"empty", nil, compressionNone},
+	}
+
+	for _, tt := range tests {
+		if got := sniffCompression(bufio.NewReader(bytes.NewReader(tt.stream))); got != tt.compression {
+			t.Errorf("%s: want %q, got %q", tt.name, tt.compression, got)
+		}
+	}
+}
+
+// maxBytesReader
+func TestMaxBytesReader(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input   string
+		max     int64
+		wantErr bool
+	}{
+		{"", 4, false},
+		{"1234", 4, false},
+		{"12345", 4, true},
+		{strings.Repeat("1", 1<<16), 1 << 10, true},
+	}
+
+	for _, tt := range tests {
+		b, err := io.ReadAll(newMaxBytesReader(strings.NewReader(tt.input), tt.max))
+
+		if gotErr := errors.Is(err, errInputTooLarge); gotErr != tt.wantErr {
+			t.Errorf("%d/%d: want error %v, got %v", len(tt.input), tt.max, tt.wantErr, err)
+		}
+
+		if int64(len(b)) > tt.max {
+			t.Errorf("%d/%d: read %d bytes", len(tt.input), tt.max, len(b))
+		}
+	}
+
+	// the max amount is allowed again after the reset
+	mr := newMaxBytesReader(strings.NewReader("12345678"), 4)
+	p := make([]byte, 4)
+
+	for i := 0; i < 2; i++ {
+		if n, err := io.ReadFull(mr, p); n != 4 || err != nil {
+			t.Errorf("read %d: want 4 bytes, got %d, %v", i, n, err)
+		}
+
+		mr.reset()
+	}
+}
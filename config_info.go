@@ -0,0 +1,97 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Flags exported as the value hash instead of the value itself
+var configInfoHashedFlags = map[string]bool{
+	"transform": true,
+}
+
+// Flags not exported in the config info
+var configInfoSkippedFlags = map[string]bool{
+	"V":       true,
+	"version": true,
+}
+
+// Redact passwords in URL values
+func redactConfigValue(value string) string {
+	items := strings.Split(value, ", ")
+
+	for i, item := range items {
+		if u, err := url.Parse(item); err == nil && u.User != nil {
+			items[i] = u.Redacted()
+		}
+	}
+
+	return strings.Join(items, ", ")
+}
+
+// Get the flag name and value as exported: the flags listed in
+// configInfoHashedFlags are exported as "<name>_hash" with the value hash,
+// passwords in URLs are redacted
+func configValue(f *flag.Flag) (string, string) {
+	name := strings.ReplaceAll(f.Name, "-", "_")
+	value := f.Value.String()
+
+	if configInfoHashedFlags[f.Name] {
+		name += "_hash"
+		if value != "" {
+			value = fmt.Sprintf("%x", sha256.Sum256([]byte(value)))[:12]
+		}
+	} else {
+		value = redactConfigValue(value)
+	}
+
+	return name, value
+}
+
+// Create the info gauge carrying the effective configuration as labels.
+// Every flag is exported as a label with "-" replaced by "_".
+func newConfigInfo(fs *flag.FlagSet) prometheus.Collector {
+	labels := prometheus.Labels{}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if configInfoSkippedFlags[f.Name] {
+			return
+		}
+
+		name, value := configValue(f)
+		labels[name] = value
+	})
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "rsyslog_exporter_config_info",
+		Help:        "Effective exporter configuration",
+		ConstLabels: labels,
+	})
+	info.Set(1)
+
+	return info
+}
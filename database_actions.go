@@ -0,0 +1,102 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Database output modules: module name to the database label
+var databaseModules = map[string]string{
+	"ommysql":   "mysql",
+	"ompgsql":   "pgsql",
+	"ommongodb": "mongodb",
+}
+
+// Default name of the core.action stats of the database output modules:
+// "action-2-ommysql"
+var reDatabaseAction = regexp.MustCompile(`^action-\d+-(om[a-z]+)$`)
+
+// Get the database of the origin (origins mapped to the database type by the
+// schema are named after their module)
+func databaseOrigin(origin string) string {
+	if database, found := databaseModules[origin]; found {
+		return database
+	}
+
+	return strings.TrimPrefix(origin, "om")
+}
+
+// Get the database of the core.action stats name (false if the action is not
+// a database output module)
+func databaseAction(name string) (string, bool) {
+	match := reDatabaseAction.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+
+	database, found := databaseModules[match[1]]
+
+	return database, found
+}
+
+// Check if the stats object is the database output module action
+func (rs *RsyslogStats) isDatabaseStats(name, origin string) bool {
+	if _, found := databaseModules[origin]; found {
+		return true
+	}
+
+	if rs.DatabaseActions && origin == "core.action" {
+		_, found := databaseAction(name)
+		return found
+	}
+
+	return false
+}
+
+// Parse the database output module action counters into the
+// <prefix>_database_action_<counter>{database,action} families
+func (rs *RsyslogStats) parseDatabaseStats(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
+	m := RsyslogStatsMetrics{}
+
+	database, found := databaseAction(name)
+	if origin != "core.action" || !found {
+		database = databaseOrigin(origin)
+	}
+
+	l := RsyslogStatsLabels{}.With("database", database).With("action", name)
+	metricName := rs.MetricPrefix + "_database_action"
+
+	for counter, value := range data {
+		if counter == rs.NameField || counter == rs.OriginField {
+			continue
+		}
+
+		if v, e := getValue(value); e != nil {
+			errs = append(errs, &fieldError{counter, e})
+		} else {
+			rs.appendMetric(m, metricName+"_"+counter, l, v)
+		}
+	}
+
+	return m, errs
+}
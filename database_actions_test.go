@@ -0,0 +1,91 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// databaseAction
+func TestDatabaseAction(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name     string
+		database string
+		ok       bool
+	}{
+		{"action-2-ommysql", "mysql", true},
+		{"action-10-ompgsql", "pgsql", true},
+		{"action-3-ommongodb", "mongodb", true},
+		{"action-1-omfwd", "", false},
+		{"action-1-builtin:omfile", "", false},
+		{"mysql-logs", "", false},
+	}
+
+	for _, tt := range tests {
+		database, ok := databaseAction(tt.name)
+		if database != tt.database || ok != tt.ok {
+			t.Errorf("%s: want %s, %v, got %s, %v", tt.name, tt.database, tt.ok, database, ok)
+		}
+	}
+}
+
+// RsyslogStats.parseDatabaseStats
+func TestRsyslogStatsDatabaseActions(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.DatabaseActions = true
+	rs.Parse(`{"name": "action-2-ommysql", "origin": "core.action", "processed": 10, "failed": 1, "suspended": 2, "suspended.duration": 30, "resumed": 2}`)
+	rs.Parse(`{"name": "pg-logs", "origin": "ompgsql", "processed": 5}`)
+	rs.Parse(`{"name": "action-3-omfwd", "origin": "core.action", "processed": 7}`)
+
+	mysql := RsyslogStatsLabels{}.With("database", "mysql").With("action", "action-2-ommysql")
+	pgsql := RsyslogStatsLabels{}.With("database", "pgsql").With("action", "pg-logs")
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_database_action_processed":          {mysql: 10, pgsql: 5},
+		"rsyslog_database_action_failed":             {mysql: 1},
+		"rsyslog_database_action_suspended":          {mysql: 2},
+		"rsyslog_database_action_suspended_duration": {mysql: 30},
+		"rsyslog_database_action_resumed":            {mysql: 2},
+		"rsyslog_core_action_processed":              {{"name", "action-3-omfwd"}: 7},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := metricValueType(rs, "rsyslog_database_action_processed"); got != prometheus.CounterValue {
+		t.Errorf("want counter, got %v", got)
+	}
+
+	// core.action stats are kept w/o DatabaseActions
+	rs = NewRsyslogStats()
+	rs.Parse(`{"name": "action-2-ommysql", "origin": "core.action", "processed": 10}`)
+
+	if _, found := rs.Metrics["rsyslog_core_action_processed"]; !found {
+		t.Errorf("want core.action metrics, got %v", rs.Metrics)
+	}
+}
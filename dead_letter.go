@@ -0,0 +1,83 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	parserPanics = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_parser_panics",
+			Help: "Amount of stats lines the parser panicked on",
+		},
+	)
+
+	deadLetterFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_dead_letter_failures",
+			Help: "Amount of stats lines failed to write to the dead letter file",
+		},
+	)
+)
+
+// Metrics exported by the parser panic isolation
+var deadLetterMetrics = []prometheus.Collector{
+	parserPanics,
+	deadLetterFailures,
+}
+
+// Dead letter file of the lines the parser panicked on (only logged if nil)
+var deadLetters *streamRecorder
+
+// Dead-lettered stats line
+type deadLetter struct {
+	recordedLine
+	Error string `json:"error"`
+}
+
+// Recover the parser panic caused by the line, so one malformed line from an
+// untrusted sender can't crash the exporter. Must be deferred by the parser
+// goroutines processing the line.
+func recoverParser(peer, line string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	parserPanics.Inc()
+	log.Printf("parser panic on the line from %s: %v! Line is %s\n%s", peer, r, line, debug.Stack())
+
+	if deadLetters == nil {
+		return
+	}
+
+	dl := deadLetter{recordedLine: recordedLine{Time: time.Now(), Peer: peer, Line: line}, Error: fmt.Sprint(r)}
+	if err := deadLetters.write(dl); err != nil {
+		deadLetterFailures.Inc()
+		log.Printf("cannot write the dead letter to %s: %s", deadLetters.path, err)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Get the stats parser panicking on every line
+func panickingStats() *RsyslogStats {
+	rs := NewRsyslogStats()
+	rs.parsersByType = map[rsyslogStatType]parserForType{}
+
+	return rs
+}
+
+// processSyslogMessage
+func TestProcessSyslogMessagePanic(t *testing.T) {
+	// deadLetters is global, the test must not be parallel
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+
+	r, err := newStreamRecorder(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadLetters = r
+	t.Cleanup(func() { deadLetters = nil })
+
+	line := `{"name":"global","origin":"dynstats","values":{}}`
+	panics := testutil.ToFloat64(parserPanics)
+
+	processSyslogMessage(panickingStats(), format.LogParts{"content": line, "client": "192.0.2.1:514"}, nil)
+
+	if got := testutil.ToFloat64(parserPanics) - panics; got != 1 {
+		t.Errorf("rsyslog_exporter_parser_panics: want 1, got %v", got)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dl deadLetter
+	if err := json.Unmarshal(data, &dl); err != nil {
+		t.Fatal(err)
+	}
+
+	if dl.Line != line || dl.Peer != "192.0.2.1:514" || dl.Error == "" {
+		t.Errorf("unexpected dead letter %+v", dl)
+	}
+}
+
+// directDecoder.decode
+func TestDirectDecoderPanic(t *testing.T) {
+	t.Parallel()
+
+	d := &directDecoder{rs: panickingStats()}
+	lines := "{\"name\":\"a\",\"origin\":\"dynstats\",\"values\":{}}\n{\"name\":\"b\",\"origin\":\"dynstats\",\"values\":{}}\n"
+
+	if err := d.decode(strings.NewReader(lines), format.LogParts{"client": "192.0.2.1:1234"}, nil); err != nil {
+		t.Errorf("decode: want all lines processed, got %v", err)
+	}
+
+	// the parser lock must be released after the panic
+	parseMu.Lock()
+	defer parseMu.Unlock()
+}
@@ -0,0 +1,114 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Suffix of the families exposing the counter change since the previous
+// scrape
+const deltaSuffix = "_delta"
+
+// Scrapers not seen within the TTL are forgotten
+const deltaScraperTTL = time.Hour
+
+// Per-scraper counter values of the previous scrapes, so every scraper gets
+// the change since its own previous scrape
+type deltaTracker struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	scrapers map[string]*deltaScraper
+}
+
+// Counter values exposed to the scraper
+type deltaScraper struct {
+	sync.Mutex
+	seen   time.Time
+	values map[string]map[RsyslogStatsLabels]RsyslogStatsValue
+}
+
+// newDeltaTracker is the deltaTracker constructor
+func newDeltaTracker(ttl time.Duration) *deltaTracker {
+	return &deltaTracker{ttl: ttl, scrapers: make(map[string]*deltaScraper)}
+}
+
+// Get the scraper state, the expired scrapers are forgotten
+func (dt *deltaTracker) scraper(key string, now time.Time) *deltaScraper {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	for k, ds := range dt.scrapers {
+		if now.Sub(ds.seen) > dt.ttl {
+			delete(dt.scrapers, k)
+		}
+	}
+
+	ds, found := dt.scrapers[key]
+	if !found {
+		ds = &deltaScraper{values: make(map[string]map[RsyslogStatsLabels]RsyslogStatsValue)}
+		dt.scrapers[key] = ds
+	}
+
+	ds.seen = now
+
+	return ds
+}
+
+// Store the counter value and get its change since the previous scrape.
+// Returns false for the first scrape of the series. Counter resets (rsyslog
+// restart) return the value as is. Must be called with the scraper locked.
+func (ds *deltaScraper) delta(family string, labels RsyslogStatsLabels, value RsyslogStatsValue) (RsyslogStatsValue, bool) {
+	values, found := ds.values[family]
+	if !found {
+		values = make(map[RsyslogStatsLabels]RsyslogStatsValue)
+		ds.values[family] = values
+	}
+
+	prev, found := values[labels]
+	values[labels] = value
+
+	switch {
+	case !found:
+		return 0, false
+	case value < prev:
+		return value, true
+	}
+
+	return value - prev, true
+}
+
+// Get the scraper identity: the scraper query parameter (Prometheus HA pairs
+// behind NAT e.g.) or the remote host
+func deltaScraperKey(r *http.Request) string {
+	if scraper := r.URL.Query().Get("scraper"); scraper != "" {
+		return scraper
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,62 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "strings"
+
+// Name prefix of the omfile dynafile cache stats ("dynafile cache cluster")
+const dynafileCachePrefix = "dynafile cache "
+
+// Dynafile cache families: <prefix>_omfile_dynafile_cache_<counter>
+const dynafileCacheFamily = "_omfile_dynafile_cache"
+
+// Check if the stats object is the omfile dynafile cache one
+func isDynafileCacheStats(name, origin string) bool {
+	return origin == "omfile" && strings.HasPrefix(name, dynafileCachePrefix)
+}
+
+// Check if the family is the dynafile cache high-water mark
+func (rs *RsyslogStats) isDynafileCacheGauge(metricName string) bool {
+	return metricName == rs.MetricPrefix+dynafileCacheFamily+"_maxused"
+}
+
+// Parse the dynafile cache counters (requests, level0, missed, evicted,
+// maxused, closetimeouts) into the
+// <prefix>_omfile_dynafile_cache_<counter>{cache} families
+func (rs *RsyslogStats) parseDynafileCacheStats(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
+	m := RsyslogStatsMetrics{}
+	l := RsyslogStatsLabels{"cache", strings.TrimPrefix(name, dynafileCachePrefix)}
+	metricName := rs.MetricPrefix + dynafileCacheFamily
+
+	for counter, value := range data {
+		if counter == rs.NameField || counter == rs.OriginField {
+			continue
+		}
+
+		if v, e := getValue(value); e != nil {
+			errs = append(errs, &fieldError{counter, e})
+		} else {
+			rs.appendMetric(m, metricName+"_"+counter, l, v)
+		}
+	}
+
+	return m, errs
+}
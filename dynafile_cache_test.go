@@ -0,0 +1,63 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RsyslogStats.parseDynafileCacheStats
+func TestRsyslogStatsDynafileCache(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "dynafile cache cluster", "origin": "omfile", "requests": 100, "level0": 90, "missed": 5, "evicted": 2, "maxused": 10, "closetimeouts": 1}`)
+	rs.Parse(`{"name": "dynafile cache per-host", "origin": "omfile", "requests": 7}`)
+	rs.Parse(`{"name": "other", "origin": "omfile", "requests": 3}`)
+
+	cluster := RsyslogStatsLabels{"cache", "cluster"}
+	perHost := RsyslogStatsLabels{"cache", "per-host"}
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_omfile_dynafile_cache_requests":      {cluster: 100, perHost: 7},
+		"rsyslog_omfile_dynafile_cache_level0":        {cluster: 90},
+		"rsyslog_omfile_dynafile_cache_missed":        {cluster: 5},
+		"rsyslog_omfile_dynafile_cache_evicted":       {cluster: 2},
+		"rsyslog_omfile_dynafile_cache_maxused":       {cluster: 10},
+		"rsyslog_omfile_dynafile_cache_closetimeouts": {cluster: 1},
+		"rsyslog_omfile_requests":                     {{"name", "other"}: 3},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	for family, want := range map[string]prometheus.ValueType{
+		"rsyslog_omfile_dynafile_cache_requests": prometheus.CounterValue,
+		"rsyslog_omfile_dynafile_cache_maxused":  prometheus.GaugeValue,
+	} {
+		if got := metricValueType(rs, family); got != want {
+			t.Errorf("%s: want type %v, got %v", family, want, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+//go:build e2e
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// rsyslog container image and command, override them to test other versions:
+//
+//	RSYSLOG_E2E_IMAGE=alpine:3.19
+//	RSYSLOG_E2E_COMMAND='apk add --no-cache rsyslog && rsyslogd -n -f /etc/rsyslog.conf'
+const (
+	e2eImage   = "alpine:3.19"
+	e2eCommand = "apk add --no-cache rsyslog >/dev/null && rsyslogd -n -f /etc/rsyslog.conf"
+)
+
+// rsyslog config forwarding impstats to the exporter's UDP and TCP listeners
+const e2eConfig = `
+module(load="impstats" interval="1" format="json" log.syslog="on" ruleset="stats")
+
+ruleset(name="stats") {
+  action(type="omfwd" target="127.0.0.1" port="%d" protocol="udp")
+  action(type="omfwd" target="127.0.0.1" port="%d" protocol="tcp")
+}
+`
+
+// Get the environment variable or the default value
+func e2eEnv(name, value string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return value
+}
+
+// Get the free local port
+func e2eFreePort(t *testing.T, network string) int {
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		return conn.LocalAddr().(*net.UDPAddr).Port
+	default:
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		return l.Addr().(*net.TCPAddr).Port
+	}
+}
+
+// Launch rsyslog in a docker container (host network) until the test is done
+func e2eRunRsyslog(t *testing.T, config string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rsyslog.conf")
+
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	name := fmt.Sprintf("rsyslog-exporter-e2e-%d", time.Now().UnixNano())
+	cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "--name", name, "--network", "host",
+		"-v", path+":/etc/rsyslog.conf:ro",
+		e2eEnv("RSYSLOG_E2E_IMAGE", e2eImage), "sh", "-c", e2eEnv("RSYSLOG_E2E_COMMAND", e2eCommand))
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = exec.Command("docker", "rm", "-f", name).Run()
+		cancel()
+		_ = cmd.Wait()
+	})
+}
+
+// Scrape the exporter until all wanted lines are exposed or the timeout
+func e2eScrape(t *testing.T, url string, want []string, timeout time.Duration) string {
+	var body string
+
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(time.Second) {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body = string(b)
+		found := 0
+
+		for _, line := range want {
+			if strings.Contains(body, line) {
+				found++
+			}
+		}
+
+		if found == len(want) {
+			return body
+		}
+	}
+
+	t.Fatalf("metrics %q are not exposed in %s, got:\n%s", want, timeout, body)
+
+	return body
+}
+
+// rsyslog impstats forwarded over UDP and TCP: go test -tags e2e -run TestE2E
+func TestE2ERsyslog(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker is required to run rsyslog")
+	}
+
+	udpPort, tcpPort := e2eFreePort(t, "udp"), e2eFreePort(t, "tcp")
+
+	listenerLabel, listenerTagged = true, true
+	defer func() { listenerLabel, listenerTagged = false, false }()
+
+	channel := make(syslog.LogPartsChannel)
+	for _, conn := range []string{fmt.Sprintf("udp://127.0.0.1:%d", udpPort), fmt.Sprintf("tcp://127.0.0.1:%d", tcpPort)} {
+		if err := inputInit("rfc3164", conn, channel); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := NewRsyslogStats()
+	go processSyslogMessages(rs, channel, nil)
+
+	srv := httptest.NewServer(newMetricsHandler(NewRsyslogStatsCollector(rs), prometheus.Gatherers{}, promhttp.HandlerOpts{}))
+	defer srv.Close()
+
+	e2eRunRsyslog(t, fmt.Sprintf(e2eConfig, udpPort, tcpPort))
+
+	body := e2eScrape(t, srv.URL, []string{
+		fmt.Sprintf(`rsyslog_core_queue_size{listener="udp:127.0.0.1:%d",name="main Q"}`, udpPort),
+		fmt.Sprintf(`rsyslog_core_queue_size{listener="tcp:127.0.0.1:%d",name="main Q"}`, tcpPort),
+		fmt.Sprintf(`rsyslog_impstats_utime{listener="udp:127.0.0.1:%d",name="resource-usage"}`, udpPort),
+		fmt.Sprintf(`rsyslog_impstats_utime{listener="tcp:127.0.0.1:%d",name="resource-usage"}`, tcpPort),
+	}, 2*time.Minute)
+
+	if !strings.Contains(body, "rsyslog_exporter_parser_failures 0") {
+		t.Errorf("parser failures are not expected, got:\n%s", body)
+	}
+}
@@ -0,0 +1,304 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SQLite database file header
+const sqliteMagic = "SQLite format 3\x00"
+
+// Export output writer
+type rowWriter interface {
+	writeRow(r archiveRow) error
+	close() error
+}
+
+// CSV export writer
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+// Create the CSV writer writing the header
+func newCSVRowWriter(w io.Writer) (*csvRowWriter, error) {
+	c := &csvRowWriter{w: csv.NewWriter(w)}
+	if err := c.w.Write([]string{"time", "sender", "origin", "name", "counter", "value"}); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *csvRowWriter) writeRow(r archiveRow) error {
+	return c.w.Write([]string{
+		r.Time.UTC().Format(time.RFC3339Nano),
+		r.Sender,
+		r.Origin,
+		r.Name,
+		r.Counter,
+		formatExportValue(r.Value),
+	})
+}
+
+func (c *csvRowWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// Counter value as float (archive values are int64 or float64)
+func exportValue(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case []byte:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+
+	return 0
+}
+
+// Counter value as text keeping the integers exact
+func formatExportValue(v interface{}) string {
+	if i, ok := v.(int64); ok {
+		return strconv.FormatInt(i, 10)
+	}
+
+	return strconv.FormatFloat(exportValue(v), 'g', -1, 64)
+}
+
+// Check whether the file is the SQLite archive (the record file otherwise)
+func isSQLiteFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteMagic))
+	if _, err := io.ReadFull(f, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return string(header) == sqliteMagic, nil
+}
+
+// Export the archive database rows ordered by time
+func exportArchive(path string, w rowWriter) (int, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT time, sender, origin, name, counter, value FROM stats ORDER BY time")
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+
+	for rows.Next() {
+		var (
+			r  archiveRow
+			ms int64
+		)
+
+		if err := rows.Scan(&ms, &r.Sender, &r.Origin, &r.Name, &r.Counter, &r.Value); err != nil {
+			return n, err
+		}
+
+		r.Time = time.UnixMilli(ms)
+
+		if err := w.writeRow(r); err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, rows.Err()
+}
+
+// Export the recorded stats lines. The peer recorded is exported as the
+// sender, malformed records are skipped.
+func exportRecord(path string, a *statsArchive, w rowWriter) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16<<20)
+
+	n := 0
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var rec recordedLine
+		if err := json.Unmarshal(data, &rec); err != nil {
+			log.Printf("%s:%d: skipping malformed record: %s", path, lineno, err)
+			continue
+		}
+
+		rows := a.lineRows(rec.Time, rec.Peer, rec.Line)
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Counter < rows[j].Counter })
+
+		for _, r := range rows {
+			if err := w.writeRow(r); err != nil {
+				return n, err
+			}
+
+			n++
+		}
+	}
+
+	return n, scanner.Err()
+}
+
+// Export the record files and archive databases to the writer
+func exportFiles(paths []string, nameField, originField string, w rowWriter) (int, error) {
+	a := &statsArchive{nameField: nameField, originField: originField}
+	total := 0
+
+	for _, path := range paths {
+		isDB, err := isSQLiteFile(path)
+		if err != nil {
+			return total, err
+		}
+
+		var n int
+		if isDB {
+			n, err = exportArchive(path, w)
+		} else {
+			n, err = exportRecord(path, a, w)
+		}
+
+		total += n
+
+		if err != nil {
+			return total, fmt.Errorf("cannot export %s: %w", path, err)
+		}
+	}
+
+	return total, nil
+}
+
+// `rsyslog_exporter export` subcommand
+func exportMain(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s export [flags] <record file or archive db>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+
+	var (
+		format      = fs.String("format", "csv", "Output format (csv, parquet)")
+		output      = fs.String("o", "-", "Output file (- for stdout)")
+		nameField   = fs.String("name-field", "name", "Stats line field to export as the name of the record file counters")
+		originField = fs.String("origin-field", "origin", "Stats line field to export as the origin of the record file counters")
+	)
+
+	if err := fs.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		out = f
+	}
+
+	bw := bufio.NewWriter(out)
+
+	var (
+		w   rowWriter
+		err error
+	)
+
+	switch *format {
+	case "csv":
+		w, err = newCSVRowWriter(bw)
+	case "parquet":
+		w, err = newParquetWriter(bw, "rsyslog_exporter version "+version)
+	default:
+		err = fmt.Errorf("export format %s is not supported", *format)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := exportFiles(fs.Args(), *nameField, *originField, w)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := w.close(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := bw.Flush(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("%d rows exported", n)
+}
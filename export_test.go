@@ -0,0 +1,348 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+// exportFiles
+func TestExportFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record.jsonl")
+
+	err := os.WriteFile(record, []byte(strings.Join([]string{
+		`{"time":"2026-10-16T10:00:00.123Z","peer":"10.0.0.1:514","line":"{\"name\":\"main Q\",\"origin\":\"core.queue\",\"size\":3,\"enqueued\":10}"}`,
+		`not a record`,
+		``,
+		`{"time":"2026-10-16T10:00:10Z","peer":"10.0.0.2:514","line":"{\"name\":\"global\",\"origin\":\"dynstats\",\"values\":{\"ops\":1.5}}"}`,
+	}, "\n")), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := filepath.Join(dir, "archive.db")
+
+	a, err := newStatsArchive(db, 24*time.Hour, "name", "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.insert(a.lineRows(time.UnixMilli(1792144800000), "host1", `{"name":"action 0","origin":"core.action","processed":7}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	a.db.Close()
+
+	var buf bytes.Buffer
+
+	w, err := newCSVRowWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := exportFiles([]string{record, db}, "name", "origin", w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 4 {
+		t.Errorf("rows exported: want 4, got %d", n)
+	}
+
+	want := strings.Join([]string{
+		"time,sender,origin,name,counter,value",
+		"2026-10-16T10:00:00.123Z,10.0.0.1:514,core.queue,main Q,enqueued,10",
+		"2026-10-16T10:00:00.123Z,10.0.0.1:514,core.queue,main Q,size,3",
+		"2026-10-16T10:00:10Z,10.0.0.2:514,dynstats,global,values.ops,1.5",
+		"2026-10-16T10:00:00Z,host1,core.action,action 0,processed,7",
+		"",
+	}, "\n")
+
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("CSV mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// Decode the Thrift compact struct (field id -> value)
+func decodeThriftStruct(t *testing.T, b []byte, pos *int) map[int16]interface{} {
+	t.Helper()
+
+	uvarint := func() uint64 {
+		v, n := binary.Uvarint(b[*pos:])
+		*pos += n
+		return v
+	}
+
+	zigzag := func() int64 {
+		v := uvarint()
+		return int64(v>>1) ^ -int64(v&1)
+	}
+
+	var value func(typ byte) interface{}
+	value = func(typ byte) interface{} {
+		switch typ {
+		case thriftI32, thriftI64:
+			return zigzag()
+		case thriftBinary:
+			n := int(uvarint())
+			*pos += n
+			return string(b[*pos-n : *pos])
+		case thriftList:
+			h := b[*pos]
+			*pos++
+
+			n := int(h >> 4)
+			if n == 15 {
+				n = int(uvarint())
+			}
+
+			list := make([]interface{}, n)
+			for i := range list {
+				list[i] = value(h & 0x0f)
+			}
+
+			return list
+		case thriftStruct:
+			return decodeThriftStruct(t, b, pos)
+		}
+
+		t.Fatalf("unexpected thrift type %d at %d", typ, *pos)
+		return nil
+	}
+
+	rv := map[int16]interface{}{}
+	last := int16(0)
+
+	for {
+		h := b[*pos]
+		*pos++
+
+		if h == 0 {
+			return rv
+		}
+
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(zigzag())
+		}
+
+		rv[id] = value(h & 0x0f)
+		last = id
+	}
+}
+
+// parquetWriter
+func TestParquetWriter(t *testing.T) {
+	t.Parallel()
+
+	rows := []archiveRow{
+		{Time: time.UnixMilli(1792144800123), Sender: "host1", Origin: "core.queue", Name: "main Q", Counter: "size", Value: int64(3)},
+		{Time: time.UnixMilli(1792144810000), Sender: "host2", Origin: "dynstats", Name: "global", Counter: "values.ops", Value: 1.5},
+	}
+
+	var buf bytes.Buffer
+
+	w, err := newParquetWriter(&buf, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range rows {
+		if err := w.writeRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if !bytes.HasPrefix(b, []byte(parquetMagic)) || !bytes.HasSuffix(b, []byte(parquetMagic)) {
+		t.Fatal("parquet magic is missing")
+	}
+
+	pos := len(b) - 8 - int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	meta := decodeThriftStruct(t, b, &pos)
+
+	if got := meta[3]; got != int64(len(rows)) {
+		t.Errorf("num_rows: want %d, got %v", len(rows), got)
+	}
+
+	if got := len(meta[2].([]interface{})); got != len(parquetColumns)+1 {
+		t.Errorf("schema elements: want %d, got %d", len(parquetColumns)+1, got)
+	}
+
+	// Decode the PLAIN-encoded column values of the only row group
+	got := map[string][]interface{}{}
+
+	for _, c := range meta[4].([]interface{})[0].(map[int16]interface{})[1].([]interface{}) {
+		md := c.(map[int16]interface{})[3].(map[int16]interface{})
+		name := md[3].([]interface{})[0].(string)
+
+		pos := int(md[9].(int64))
+		page := decodeThriftStruct(t, b, &pos)
+
+		if size := page[2].(int64); pos+int(size) != int(md[9].(int64)+md[7].(int64)) {
+			t.Errorf("%s page size %d does not match the column chunk size", name, size)
+		}
+
+		for i := int64(0); i < md[5].(int64); i++ {
+			switch md[1].(int64) {
+			case parquetInt64:
+				got[name] = append(got[name], int64(binary.LittleEndian.Uint64(b[pos:])))
+				pos += 8
+			case parquetDouble:
+				got[name] = append(got[name], math.Float64frombits(binary.LittleEndian.Uint64(b[pos:])))
+				pos += 8
+			case parquetByteArray:
+				n := int(binary.LittleEndian.Uint32(b[pos:]))
+				got[name] = append(got[name], string(b[pos+4:pos+4+n]))
+				pos += 4 + n
+			}
+		}
+	}
+
+	want := map[string][]interface{}{
+		"time":    {int64(1792144800123), int64(1792144810000)},
+		"sender":  {"host1", "host2"},
+		"origin":  {"core.queue", "dynstats"},
+		"name":    {"main Q", "global"},
+		"counter": {"size", "values.ops"},
+		"value":   {3.0, 1.5},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parquet columns mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// Rows of testdata/golden/export.parquet
+var parquetGoldenRows = []archiveRow{
+	{Time: time.UnixMilli(1792144800123), Sender: "host1", Origin: "core.queue", Name: "main Q", Counter: "size", Value: int64(3)},
+	{Time: time.UnixMilli(1792144810000), Sender: "host2", Origin: "dynstats", Name: "global", Counter: "values.ops", Value: 1.5},
+	{Time: time.UnixMilli(1792144820456), Sender: "", Origin: "core.action", Name: "action 0 (omfwd)", Counter: "failed", Value: "42"},
+}
+
+// parquetWriter output is compared to testdata/golden/export.parquet, which is
+// read by a reference Parquet reader in TestParquetGoldenReader
+func TestParquetGolden(t *testing.T) {
+	t.Parallel()
+
+	golden := filepath.Join("testdata", "golden", "export.parquet")
+
+	var buf bytes.Buffer
+
+	w, err := newParquetWriter(&buf, "rsyslog_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, r := range parquetGoldenRows {
+		if err := w.writeRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if *updateGolden {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%s (run with -update to create it)", err)
+	}
+
+	if !bytes.Equal(want, buf.Bytes()) {
+		t.Errorf("%s mismatch, run with -update and check the file with TestParquetGoldenReader", golden)
+	}
+}
+
+// Row of the export schema read by the reference reader
+type parquetGoldenRow struct {
+	Time    int64   `parquet:"name=time, type=INT64, convertedtype=TIMESTAMP_MILLIS"`
+	Sender  string  `parquet:"name=sender, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Origin  string  `parquet:"name=origin, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Name    string  `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Counter string  `parquet:"name=counter, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Value   float64 `parquet:"name=value, type=DOUBLE"`
+}
+
+// testdata/golden/export.parquet is read by the xitongsys/parquet-go reader
+func TestParquetGoldenReader(t *testing.T) {
+	t.Parallel()
+
+	f, err := local.NewLocalFileReader(filepath.Join("testdata", "golden", "export.parquet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	pr, err := reader.NewParquetReader(f, new(parquetGoldenRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+
+	got := make([]parquetGoldenRow, pr.GetNumRows())
+	if err := pr.Read(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []parquetGoldenRow
+	for _, r := range parquetGoldenRows {
+		want = append(want, parquetGoldenRow{
+			Time:    r.Time.UnixMilli(),
+			Sender:  r.Sender,
+			Origin:  r.Origin,
+			Name:    r.Name,
+			Counter: r.Counter,
+			Value:   exportValue(r.Value),
+		})
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parquet rows mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"expvar"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Exporter internal state variables
+func newExpvars(rs *RsyslogStats, channel syslog.LogPartsChannel) map[string]expvar.Var {
+	vars := map[string]expvar.Var{}
+
+	vars["channel"] = expvar.Func(func() interface{} {
+		return map[string]int{"depth": len(channel), "capacity": cap(channel)}
+	})
+
+	vars["origins"] = expvar.Func(func() interface{} {
+		rs.RLock()
+		defer rs.RUnlock()
+
+		origins := make(map[string]int, len(rs.OriginMessages))
+		for origin, messages := range rs.OriginMessages {
+			origins[origin] = messages
+		}
+
+		return origins
+	})
+
+	vars["inputs"] = expvar.Func(func() interface{} {
+		return getInputStates()
+	})
+
+	vars["stats"] = expvar.Func(func() interface{} {
+		families, series, _ := rs.Cardinality(0)
+
+		return map[string]int64{
+			"parsed_messages": int64(rs.ParsedMessages),
+			"parser_failures": int64(rs.ParserFailures),
+			"parse_timestamp": rs.ParseTimestamp,
+			"metric_families": int64(families),
+			"metric_series":   int64(series),
+		}
+	})
+
+	return vars
+}
+
+// Publish the exporter internal state via expvar
+func publishExpvars(rs *RsyslogStats, channel syslog.LogPartsChannel) {
+	for name, v := range newExpvars(rs, channel) {
+		expvar.Publish(name, v)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// TCP syslog framing (RFC 6587): non-transparent (LF-terminated messages),
+// octet-counted (`MSG-LEN SP SYSLOG-MSG`) or detected per message like
+// rsyslog imtcp does (octet-counted messages start with a digit)
+const (
+	framingLF           = "lf"
+	framingOctetCounted = "octet-counted"
+	framingAuto         = "auto"
+)
+
+// Framing of the TCP and TLS syslog listeners
+var tcpFraming = framingLF
+
+// Max syslog message size read from the TCP streams (impstats lines of the
+// big dynstats buckets may be long)
+const maxSyslogMessageSize = 1 << 20
+
+// Check the framing is supported
+func checkFraming(framing string) error {
+	switch framing {
+	case framingLF, framingOctetCounted, framingAuto:
+		return nil
+	}
+
+	return fmt.Errorf("TCP framing %s is not supported (lf, octet-counted, auto)", framing)
+}
+
+// Get the split function of the framing
+func syslogSplitFunc(framing string, f format.Format) bufio.SplitFunc {
+	lf := f.GetSplitFunc()
+	if lf == nil {
+		lf = bufio.ScanLines
+	}
+
+	switch framing {
+	case framingOctetCounted:
+		return scanOctetCounted
+	case framingAuto:
+		return func(data []byte, atEOF bool) (int, []byte, error) {
+			if skip := leadingNewlines(data); skip > 0 {
+				return skip, nil, nil
+			}
+
+			if len(data) > 0 && '0' <= data[0] && data[0] <= '9' {
+				return scanOctetCounted(data, atEOF)
+			}
+
+			return lf(data, atEOF)
+		}
+	}
+
+	return lf
+}
+
+// Amount of the newlines before the message (sent by some senders after the
+// octet-counted messages)
+func leadingNewlines(data []byte) int {
+	n := 0
+	for n < len(data) && (data[n] == '\n' || data[n] == '\r') {
+		n++
+	}
+
+	return n
+}
+
+// Split the octet-counted messages: MSG-LEN SP SYSLOG-MSG
+func scanOctetCounted(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if skip := leadingNewlines(data); skip > 0 {
+		return skip, nil, nil
+	}
+
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	// MSG-LEN is up to 7 digits w/o the leading zeros (1MiB max)
+	i := bytes.IndexByte(data, ' ')
+	if i < 0 {
+		if len(data) > 7 || atEOF {
+			return 0, nil, fmt.Errorf("octet-counted message length is missing: %q", truncate(data, 16))
+		}
+
+		return 0, nil, nil
+	}
+
+	size, err := strconv.Atoi(string(data[:i]))
+	if err != nil || size <= 0 || size > maxSyslogMessageSize || data[0] == '0' {
+		return 0, nil, fmt.Errorf("wrong octet-counted message length %q", truncate(data[:i], 16))
+	}
+
+	end := i + 1 + size
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, fmt.Errorf("octet-counted message is truncated: %d of %d bytes", len(data)-i-1, size)
+		}
+
+		return 0, nil, nil
+	}
+
+	return end, data[i+1 : end], nil
+}
+
+// Get up to n bytes of the data
+func truncate(data []byte, n int) []byte {
+	if len(data) > n {
+		return data[:n]
+	}
+
+	return data
+}
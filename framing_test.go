@@ -0,0 +1,77 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// syslogSplitFunc
+func TestSyslogSplitFunc(t *testing.T) {
+	t.Parallel()
+
+	long := "<14>" + strings.Repeat("x", 100000)
+
+	var tests = []struct {
+		framing string
+		input   string
+		want    []string
+		ok      bool
+	}{
+		{framingLF, "<14>a {}\n<14>b {}\n", []string{"<14>a {}", "<14>b {}"}, true},
+		{framingLF, long + "\n", []string{long}, true},
+		{framingOctetCounted, "8 <14>a {}8 <14>b {}", []string{"<14>a {}", "<14>b {}"}, true},
+		// JSON line with newlines inside is kept in one piece
+		{framingOctetCounted, "14 <14>a {\n\"n\":1}\n", []string{"<14>a {\n\"n\":1}"}, true},
+		{framingOctetCounted, "100004 " + long, []string{long}, true},
+		{framingOctetCounted, "<14>a {}\n", []string{}, false},
+		{framingOctetCounted, "9 <14>a {}", []string{}, false},
+		{framingOctetCounted, "08 <14>a {}", []string{}, false},
+		{framingAuto, "8 <14>a {}<14>b {}\n8 <14>c {}\n", []string{"<14>a {}", "<14>b {}", "<14>c {}"}, true},
+	}
+
+	for _, tt := range tests {
+		scanner := bufio.NewScanner(strings.NewReader(tt.input))
+		scanner.Buffer(nil, maxSyslogMessageSize+16)
+		scanner.Split(syslogSplitFunc(tt.framing, syslog.RFC3164))
+
+		got := []string{}
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s %.20q: messages mismatch (-want +got):\n%s", tt.framing, tt.input, diff)
+		}
+
+		if err := scanner.Err(); (err == nil) != tt.ok {
+			t.Errorf("%s %.20q: want ok %v, got error %v", tt.framing, tt.input, tt.ok, err)
+		}
+	}
+
+	if checkFraming("octet-stuffing") == nil {
+		t.Error("octet-stuffing: error expected")
+	}
+}
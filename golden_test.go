@@ -0,0 +1,128 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Regenerate the golden files: go test -run TestGoldenCorpus -update
+var updateGolden = flag.Bool("update", false, "Update the golden files in testdata/golden")
+
+// Render the exposition of the metrics parsed from the corpus file. Families
+// changing between runs are skipped.
+func renderCorpus(t *testing.T, path string) []byte {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rs := NewRsyslogStats()
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			rs.Parse(line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewRsyslogStatsCollector(rs))
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	for _, mf := range families {
+		if mf.GetName() == "rsyslog_exporter_parse_timestamp" {
+			continue
+		}
+
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Golden corpus: impstats lines of the real rsyslog versions in
+// testdata/corpus/*.log are rendered and compared to testdata/golden/*.prom
+func TestGoldenCorpus(t *testing.T) {
+	t.Parallel()
+
+	corpus, err := filepath.Glob(filepath.Join("testdata", "corpus", "*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(corpus) == 0 {
+		t.Fatal("no corpus files found")
+	}
+
+	for _, path := range corpus {
+		path := path
+		name := strings.TrimSuffix(filepath.Base(path), ".log")
+		golden := filepath.Join("testdata", "golden", name+".prom")
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got := renderCorpus(t, path)
+
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%s (run with -update to create it)", err)
+			}
+
+			if diff := cmp.Diff(string(want), string(got)); diff != "" {
+				t.Errorf("%s exposition mismatch (-want +got):\n%s", golden, diff)
+			}
+		})
+	}
+}
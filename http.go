@@ -0,0 +1,274 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsInFlight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_http_requests_in_flight",
+			Help: "Amount of HTTP requests currently being served",
+		},
+		[]string{"handler"},
+	)
+
+	httpRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rsyslog_exporter_http_request_duration_seconds",
+			Help:    "HTTP request latencies",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"handler", "code", "method"},
+	)
+
+	httpResponseSize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rsyslog_exporter_http_response_size_bytes",
+			Help:    "HTTP response sizes",
+			Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+		},
+		[]string{"handler", "code", "method"},
+	)
+
+	scrapesRejected = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_scrapes_rejected",
+			Help: "Amount of scrapes answered with 503 due to the in-flight limit or the timeout",
+		},
+		[]string{"reason"},
+	)
+)
+
+// Metrics exported by the HTTP middleware
+var httpMetrics = []prometheus.Collector{
+	httpRequestsInFlight,
+	httpRequestDuration,
+	httpResponseSize,
+	scrapesRejected,
+}
+
+// Wrap the handler with the promhttp instrumentation
+func instrumentHandler(name string, handler http.Handler) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+
+	return promhttp.InstrumentHandlerInFlight(
+		httpRequestsInFlight.With(labels),
+		promhttp.InstrumentHandlerDuration(
+			httpRequestDuration.MustCurryWith(labels),
+			promhttp.InstrumentHandlerResponseSize(
+				httpResponseSize.MustCurryWith(labels),
+				handler,
+			),
+		),
+	)
+}
+
+// Limit the amount of requests served concurrently and the request duration
+// (0 disables the limit). Requests beyond the limits get 503.
+func limitHandler(handler http.Handler, maxInFlight int, timeout time.Duration) http.Handler {
+	var inFlight chan struct{}
+	if maxInFlight > 0 {
+		inFlight = make(chan struct{}, maxInFlight)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release := func() {}
+
+		if inFlight != nil {
+			select {
+			case inFlight <- struct{}{}:
+				release = func() { <-inFlight }
+			default:
+				scrapesRejected.WithLabelValues("in_flight").Inc()
+				http.Error(w, "too many scrapes in flight", http.StatusServiceUnavailable)
+
+				return
+			}
+		}
+
+		if timeout <= 0 {
+			defer release()
+			handler.ServeHTTP(w, r)
+
+			return
+		}
+
+		// timeout handler returns before the wrapped one finishes on timeout.
+		// The slot is released once the wrapped handler returns, so timed out
+		// scrapes still collecting (and holding the store lock) are counted.
+		var done atomic.Bool
+
+		http.TimeoutHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer release()
+			handler.ServeHTTP(w, r)
+			// the request context is cancelled once it's timed out
+			done.Store(r.Context().Err() == nil)
+		}), timeout, "scrape timed out\n").ServeHTTP(w, r)
+
+		if !done.Load() {
+			scrapesRejected.WithLabelValues("timeout").Inc()
+		}
+	})
+}
+
+// Response writer recording the status code and the response size
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (lrw *loggingResponseWriter) WriteHeader(status int) {
+	lrw.status = status
+	lrw.ResponseWriter.WriteHeader(status)
+}
+
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lrw.status == 0 {
+		lrw.status = http.StatusOK
+	}
+
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.size += n
+
+	return n, err
+}
+
+// Log every request served by the handler
+func accessLogHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		lrw := &loggingResponseWriter{ResponseWriter: w}
+
+		handler.ServeHTTP(lrw, r)
+
+		log.Printf("%s \"%s %s %s\" %d %d %s \"%s\"",
+			r.RemoteAddr, r.Method, r.RequestURI, r.Proto, lrw.status, lrw.size, time.Since(start), r.UserAgent())
+	})
+}
+
+// Register debug and health endpoints on the admin mux
+func registerAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	mux.Handle("/healthz", instrumentHandler("health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("OK\n"))
+	})))
+}
+
+// HTTP server timeouts
+type httpTimeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Idle  time.Duration
+}
+
+// Create the HTTP server with the timeouts set
+func newHTTPServer(addr string, handler http.Handler, timeouts httpTimeouts) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       timeouts.Read,
+		ReadHeaderTimeout: timeouts.Read,
+		WriteTimeout:      timeouts.Write,
+		IdleTimeout:       timeouts.Idle,
+	}
+}
+
+// Serve HTTP until the context is done, then shut the servers down gracefully
+// waiting for the in-flight requests up to shutdownTimeout. The listener
+// options are applied to all servers. The ready callback (if set) is called
+// once all servers are listening.
+func serveHTTP(ctx context.Context, servers []*http.Server, listen listenOptions, shutdownTimeout time.Duration, ready func()) error {
+	listeners := make([]net.Listener, 0, len(servers))
+
+	for _, server := range servers {
+		addr := server.Addr
+		if addr == "" {
+			addr = ":http"
+		}
+
+		ln, err := listen.listen("tcp", addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+
+			return fmt.Errorf("cannot listen on %s: %w", addr, err)
+		}
+
+		listeners = append(listeners, ln)
+	}
+
+	errs := make(chan error, len(servers))
+
+	for i, server := range servers {
+		go func(server *http.Server, ln net.Listener) {
+			if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}(server, listeners[i])
+	}
+
+	if ready != nil {
+		ready()
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("shutting down HTTP servers")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	var err error
+
+	for _, server := range servers {
+		if e := server.Shutdown(shutdownCtx); e != nil {
+			err = e
+		}
+	}
+
+	return err
+}
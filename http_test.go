@@ -0,0 +1,180 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// loggingResponseWriter
+func TestLoggingResponseWriter(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		handler http.HandlerFunc
+		status  int
+		size    int
+	}{
+		{func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) }, http.StatusOK, 2},
+		{func(w http.ResponseWriter, r *http.Request) { http.Error(w, "gone", http.StatusGone) }, http.StatusGone, 5},
+	}
+
+	for _, c := range tests {
+		lrw := &loggingResponseWriter{ResponseWriter: httptest.NewRecorder()}
+		c.handler(lrw, httptest.NewRequest("GET", "/", nil))
+
+		if lrw.status != c.status || lrw.size != c.size {
+			t.Errorf("want (%d, %d), got (%d, %d)", c.status, c.size, lrw.status, lrw.size)
+		}
+	}
+}
+
+// instrumentHandler
+func TestInstrumentHandler(t *testing.T) {
+	t.Parallel()
+
+	handler := accessLogHandler(instrumentHandler("test", http.NotFoundHandler()))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+// registerAdminHandlers
+func TestRegisterAdminHandlers(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	registerAdminHandlers(mux)
+
+	for _, path := range []string{"/healthz", "/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: want %d, got %d", path, http.StatusOK, rec.Code)
+		}
+	}
+}
+
+// serveHTTP
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	timeouts := httpTimeouts{Read: time.Second, Write: time.Second, Idle: time.Second}
+	servers := []*http.Server{newHTTPServer("127.0.0.1:0", http.NotFoundHandler(), timeouts)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	ready := make(chan struct{})
+
+	go func() { done <- serveHTTP(ctx, servers, listenOptions{}, time.Second, func() { close(ready) }) }()
+
+	<-ready
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("graceful shutdown failed: %v", err)
+	}
+
+	servers = []*http.Server{newHTTPServer("256.0.0.1:0", http.NotFoundHandler(), timeouts)}
+	if err := serveHTTP(context.Background(), servers, listenOptions{}, time.Second, nil); err == nil {
+		t.Error("listen error expected")
+	}
+}
+
+// limitHandler
+func TestLimitHandler(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	blocking := limitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), 1, 0)
+
+	rejected := testutil.ToFloat64(scrapesRejected.WithLabelValues("in_flight"))
+
+	go blocking.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	blocking.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("in-flight limit: want status 503, got %d", rec.Code)
+	}
+
+	if got := testutil.ToFloat64(scrapesRejected.WithLabelValues("in_flight")); got != rejected+1 {
+		t.Errorf("in-flight limit: want %v rejected, got %v", rejected+1, got)
+	}
+
+	close(release)
+
+	slow := limitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}), 0, 10*time.Millisecond)
+
+	rejected = testutil.ToFloat64(scrapesRejected.WithLabelValues("timeout"))
+	rec = httptest.NewRecorder()
+	slow.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("timeout: want status 503, got %d", rec.Code)
+	}
+
+	if got := testutil.ToFloat64(scrapesRejected.WithLabelValues("timeout")); got != rejected+1 {
+		t.Errorf("timeout: want %v rejected, got %v", rejected+1, got)
+	}
+
+	// the slot of the timed out scrape is kept until its handler returns
+	collecting := make(chan struct{})
+	stuck := limitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-collecting
+	}), 1, 10*time.Millisecond)
+
+	stuck.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	rec = httptest.NewRecorder()
+	stuck.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "in flight") {
+		t.Errorf("timed out scrape: want in-flight 503, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	close(collecting)
+
+	rec = httptest.NewRecorder()
+	limitHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), 1, time.Second).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("want status 200, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Pushed request body size limit (decompressed)
+const maxIngestBodySize = 32 << 20
+
+var ingestLines = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rsyslog_exporter_ingest_lines",
+		Help: "Amount of impstats lines pushed to the HTTP ingest endpoint by result (accepted, rejected)",
+	},
+	[]string{"result"},
+)
+
+// Metrics exported by the HTTP ingest endpoint
+var ingestMetrics = []prometheus.Collector{
+	ingestLines,
+}
+
+// Ingest request summary
+type ingestResult struct {
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Content-Encoding values supported by the ingest endpoint
+var ingestEncodings = map[string]string{
+	"":         compressionNone,
+	"identity": compressionNone,
+	"gzip":     compressionGzip,
+	"x-gzip":   compressionGzip,
+	"deflate":  compressionZlib,
+	"zstd":     compressionZstd,
+}
+
+// Check the pushed line is an impstats JSON object
+func isIngestLine(line []byte) bool {
+	return len(line) > 0 && line[0] == '{' && json.Valid(line)
+}
+
+// Send the newline-delimited impstats lines to the channel. Lines which
+// aren't JSON objects are rejected.
+func ingestStats(channel syslog.LogPartsChannel, r *bufio.Reader, parts format.LogParts) (ingestResult, error) {
+	rv := ingestResult{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxSyslogMessageSize)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if !isIngestLine(line) {
+			rv.Rejected++
+			continue
+		}
+
+		message := format.LogParts{"content": string(line)}
+		for key, value := range parts {
+			message[key] = value
+		}
+
+		channel <- message
+		rv.Accepted++
+	}
+
+	return rv, scanner.Err()
+}
+
+// Register the impstats HTTP ingest endpoint (omhttp e.g.):
+//
+//	POST <path>   newline-delimited impstats JSON lines
+func registerIngestHandlers(mux *http.ServeMux, path string, listener string, channel syslog.LogPartsChannel) {
+	mux.Handle(path, instrumentHandler("ingest", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		compression, found := ingestEncodings[r.Header.Get("Content-Encoding")]
+		if !found {
+			http.Error(w, "content encoding is not supported", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		status := http.StatusOK
+		rv := ingestResult{}
+
+		body, _, err := newDecompressor(bufio.NewReader(r.Body), compression)
+		if err == nil {
+			defer body.Close()
+
+			parts := format.LogParts{"client": r.RemoteAddr}
+			if listenerTagged {
+				parts["listener"] = listener
+			}
+
+			limited := http.MaxBytesReader(w, body, maxIngestBodySize)
+			rv, err = ingestStats(channel, bufio.NewReader(limited), parts)
+		}
+
+		if err != nil {
+			status = http.StatusBadRequest
+			rv.Error = err.Error()
+		}
+
+		ingestLines.WithLabelValues("accepted").Add(float64(rv.Accepted))
+		ingestLines.WithLabelValues("rejected").Add(float64(rv.Rejected))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(rv); err != nil {
+			log.Printf("cannot send ingest result: %s", err)
+		}
+	})))
+}
@@ -0,0 +1,120 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// registerIngestHandlers
+func TestIngestHandler(t *testing.T) {
+	t.Parallel()
+
+	lines := `{"name": "main Q", "origin": "core.queue", "size": 10}
+not a json line
+
+{"name": "action 1", "origin": "core.action", "processed": 5}
+[1, 2]
+`
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	if _, err := gz.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+
+	var tests = []struct {
+		name     string
+		method   string
+		encoding string
+		body     []byte
+		status   int
+		want     ingestResult
+		messages int
+	}{
+		{"plain", "POST", "", []byte(lines), http.StatusOK, ingestResult{Accepted: 2, Rejected: 2}, 2},
+		{"gzip", "POST", "gzip", gzipped.Bytes(), http.StatusOK, ingestResult{Accepted: 2, Rejected: 2}, 2},
+		{"bad gzip", "POST", "gzip", []byte(lines), http.StatusBadRequest, ingestResult{Error: "gzip: invalid header"}, 0},
+		{"encoding", "POST", "br", []byte(lines), http.StatusUnsupportedMediaType, ingestResult{}, 0},
+		{"method", "GET", "", nil, http.StatusMethodNotAllowed, ingestResult{}, 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			channel := make(syslog.LogPartsChannel, 10)
+			mux := http.NewServeMux()
+			registerIngestHandlers(mux, "/ingest", "http::9292/ingest", channel)
+
+			req := httptest.NewRequest(tt.method, "/ingest", bytes.NewReader(tt.body))
+			if tt.encoding != "" {
+				req.Header.Set("Content-Encoding", tt.encoding)
+			}
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+			close(channel)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status: want %d, got %d (%s)", tt.status, rec.Code, rec.Body.String())
+			}
+
+			if rec.Code == http.StatusOK || rec.Code == http.StatusBadRequest {
+				var got ingestResult
+				if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("ingestResult mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			messages := 0
+			for parts := range channel {
+				messages++
+
+				if content := parts["content"].(string); !strings.HasPrefix(content, `{"name"`) {
+					t.Errorf("unexpected line sent: %s", content)
+				}
+
+				if client := parts["client"]; client != req.RemoteAddr {
+					t.Errorf("client: want %s, got %v", req.RemoteAddr, client)
+				}
+			}
+
+			if messages != tt.messages {
+				t.Errorf("messages: want %d, got %d", tt.messages, messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// AMQP 0-9-1 input: amqp[s]://user:password@host:port/vhost?queue=...[&prefetch=100]
+// Lines are consumed from the existing queue (omrabbitmq output) and
+// acknowledged once queued for parsing.
+type amqpInput struct {
+	addr     string
+	queue    string
+	prefetch int
+
+	mu      sync.Mutex
+	conn    *amqp.Connection
+	stopped bool
+}
+
+// Init AMQP input
+func amqpInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	var err error
+
+	q := u.Query()
+	ai := &amqpInput{
+		queue:    q.Get("queue"),
+		prefetch: 100,
+	}
+
+	if ai.queue == "" {
+		return inputListener{}, fmt.Errorf("queue must be set for the AMQP input %s", u.Redacted())
+	}
+
+	if p := q.Get("prefetch"); p != "" {
+		if ai.prefetch, err = strconv.Atoi(p); err != nil {
+			return inputListener{}, fmt.Errorf("wrong AMQP prefetch count %s: %w", p, err)
+		}
+	}
+
+	clean := *u
+	clean.RawQuery = ""
+	ai.addr = clean.String()
+
+	deliveries, conn, err := ai.consume()
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	ai.conn = conn
+
+	return runListener(func() error { return ai.receive(deliveries, conn, channel) }, ai.stop), nil
+}
+
+// Stop the input closing its connection, so the delivery channel is closed
+func (ai *amqpInput) stop() error {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	ai.stopped = true
+
+	return ai.conn.Close()
+}
+
+// Check if the input is stopped
+func (ai *amqpInput) isStopped() bool {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	return ai.stopped
+}
+
+// Reconnect unless the input is stopped
+func (ai *amqpInput) reconnect() (<-chan amqp.Delivery, *amqp.Connection, error) {
+	deliveries, conn, err := ai.consume()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+
+	if ai.stopped {
+		conn.Close()
+		return nil, nil, errInputStopped
+	}
+
+	ai.conn = conn
+
+	return deliveries, conn, nil
+}
+
+// Connect and start consuming the queue
+func (ai *amqpInput) consume() (<-chan amqp.Delivery, *amqp.Connection, error) {
+	conn, err := amqp.Dial(ai.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if err = ch.Qos(ai.prefetch, 0, false); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	deliveries, err := ch.Consume(ai.queue, "rsyslog_exporter", false, false, false, false, nil)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return deliveries, conn, nil
+}
+
+// Send the delivered lines acknowledging them until the delivery channel is
+// closed
+func (ai *amqpInput) deliver(deliveries <-chan amqp.Delivery, channel syslog.LogPartsChannel) {
+	for d := range deliveries {
+		sendLine(channel, string(d.Body), ai.queue)
+
+		if err := d.Ack(false); err != nil {
+			log.Printf("AMQP input cannot ack the message: %s", err)
+		}
+	}
+}
+
+// Read messages and reconnect when the delivery channel is closed until the
+// input is stopped
+func (ai *amqpInput) receive(deliveries <-chan amqp.Delivery, conn *amqp.Connection, channel syslog.LogPartsChannel) error {
+	for {
+		ai.deliver(deliveries, channel)
+
+		if ai.isStopped() {
+			return errInputStopped
+		}
+
+		log.Printf("AMQP input %s consumer closed, reconnecting", ai.queue)
+		conn.Close()
+
+		for {
+			time.Sleep(time.Second)
+
+			var err error
+			if deliveries, conn, err = ai.reconnect(); err == nil {
+				break
+			}
+
+			if errors.Is(err, errInputStopped) {
+				return err
+			}
+
+			log.Printf("AMQP input reconnect failed: %s", err)
+		}
+	}
+}
@@ -0,0 +1,526 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Suffix of the rotated predecessors of the stats file: logrotate numbered
+// (.1, .2.gz) or dated (-20261016, -2026-10-16.zst) ones
+var reRotatedSuffix = regexp.MustCompile(`^[.-][0-9][0-9-]*(\.gz|\.zst)?$`)
+
+// File input: file:///var/log/rsyslog-stats.log[?backfill=true][&follow=false][&poll=1s]
+// The impstats log file (log.file= of impstats, or the stats messages written
+// by omfile) is read from the start and followed for the lines appended
+// across the renames and truncations by logrotate. With backfill the rotated predecessors are read first (oldest first), gzip
+// and zstd compressed files are decompressed transparently. With follow
+// disabled the files are replayed once and the input is done.
+type fileInput struct {
+	path     string
+	backfill bool
+	follow   bool
+	poll     time.Duration
+	parts    format.LogParts
+}
+
+// Glob file input: file:///var/log/rsyslog-stats-*.log[?discover=10s]
+// All files matching the pattern (multi-instance rsyslog hosts write one stats
+// file per instance) are read concurrently with the file label attached. New
+// matches are discovered every discover interval, the files not matching
+// anymore are not followed. The rotated predecessors of the matches are not
+// read as separate files.
+type globInput struct {
+	pattern  string
+	file     fileInput
+	discover time.Duration
+	channel  syslog.LogPartsChannel
+
+	mu    sync.Mutex
+	files map[string]*globFile
+}
+
+// File read by the glob input
+type globFile struct {
+	cancel context.CancelFunc
+}
+
+// Parse the boolean input parameter
+func boolParam(q url.Values, name string, value bool) (bool, error) {
+	if q.Get(name) == "" {
+		return value, nil
+	}
+
+	return strconv.ParseBool(q.Get(name))
+}
+
+// Check if the path is the glob pattern
+func isGlobPattern(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// Init file input
+func fileInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+	fi := fileInput{path: u.Path, poll: time.Second}
+
+	if fi.path == "" {
+		return inputListener{}, fmt.Errorf("%w %s: file path must be set", errInputAddress, u)
+	}
+
+	var err error
+
+	if fi.backfill, err = boolParam(q, "backfill", false); err != nil {
+		return inputListener{}, fmt.Errorf("%w %s: backfill: %s", errInputAddress, u, err)
+	}
+
+	if fi.follow, err = boolParam(q, "follow", true); err != nil {
+		return inputListener{}, fmt.Errorf("%w %s: follow: %s", errInputAddress, u, err)
+	}
+
+	if poll := q.Get("poll"); poll != "" {
+		if fi.poll, err = time.ParseDuration(poll); err != nil || fi.poll <= 0 {
+			return inputListener{}, fmt.Errorf("%w %s: wrong poll interval %s", errInputAddress, u, poll)
+		}
+	}
+
+	fi.parts = format.LogParts{"client": fi.path}
+	if listenerTagged {
+		fi.parts["listener"] = listenerName(u)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := func() error {
+		cancel()
+		return nil
+	}
+
+	if isGlobPattern(fi.path) {
+		gi := &globInput{pattern: fi.path, file: fi, discover: 10 * time.Second, channel: channel, files: map[string]*globFile{}}
+
+		if _, err := filepath.Match(gi.pattern, ""); err != nil {
+			return inputListener{}, fmt.Errorf("%w %s: %s", errInputAddress, u, err)
+		}
+
+		if discover := q.Get("discover"); discover != "" {
+			if gi.discover, err = time.ParseDuration(discover); err != nil || gi.discover <= 0 {
+				return inputListener{}, fmt.Errorf("%w %s: wrong discover interval %s", errInputAddress, u, discover)
+			}
+		}
+
+		return runListener(func() error { return gi.run(ctx) }, stop), nil
+	}
+
+	// the start is retried until the file is created
+	if _, err := os.Stat(fi.path); err != nil {
+		return inputListener{}, err
+	}
+
+	if !fi.follow {
+		go func() {
+			if err := fi.read(ctx, channel); err != nil {
+				log.Printf("file input %s failed: %s", fi.path, err)
+			}
+		}()
+
+		return inputListener{stop: stop}, nil
+	}
+
+	return runListener(func() error { return fi.read(ctx, channel) }, stop), nil
+}
+
+// Discover the files matching the pattern until the context is done
+func (gi *globInput) run(ctx context.Context) error {
+	ticker := time.NewTicker(gi.discover)
+	defer ticker.Stop()
+
+	for {
+		gi.scan(ctx)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Start reading the new matches, stop following the files not matching
+// anymore. Replayed files (follow disabled) are read once, the followed ones
+// failing are retried on the next scan.
+func (gi *globInput) scan(ctx context.Context) {
+	matches, _ := filepath.Glob(gi.pattern)
+	matched := map[string]bool{}
+
+	gi.mu.Lock()
+	defer gi.mu.Unlock()
+
+	for _, path := range liveFiles(matches) {
+		matched[path] = true
+
+		if gi.files[path] != nil {
+			continue
+		}
+
+		fi := gi.file
+		fi.path = path
+		fi.parts = format.LogParts{"client": path, "file": path}
+
+		if listener, ok := gi.file.parts["listener"]; ok {
+			fi.parts["listener"] = listener
+		}
+
+		fctx, cancel := context.WithCancel(ctx)
+		gf := &globFile{cancel: cancel}
+		gi.files[path] = gf
+
+		go func() {
+			if err := fi.read(fctx, gi.channel); err != nil {
+				log.Printf("file input %s failed: %s", path, err)
+			}
+
+			if fi.follow {
+				gi.mu.Lock()
+				if gi.files[path] == gf {
+					delete(gi.files, path)
+				}
+				gi.mu.Unlock()
+			}
+		}()
+	}
+
+	for path, gf := range gi.files {
+		if !matched[path] {
+			gf.cancel()
+			delete(gi.files, path)
+		}
+	}
+}
+
+// Get the matches which are not the rotated predecessors of other matches
+func liveFiles(matches []string) []string {
+	rv := []string{}
+
+	for _, path := range matches {
+		rotated := false
+
+		for _, other := range matches {
+			if rest, found := strings.CutPrefix(path, other); found && rest != "" && reRotatedSuffix.MatchString(rest) {
+				rotated = true
+				break
+			}
+		}
+
+		if !rotated {
+			rv = append(rv, path)
+		}
+	}
+
+	return rv
+}
+
+// Read the rotated predecessors (with backfill) and the file itself
+func (fi *fileInput) read(ctx context.Context, channel syslog.LogPartsChannel) error {
+	if fi.backfill {
+		rotated, err := rotatedFiles(fi.path)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range rotated {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			// a broken archive must not stop the backfill
+			if err := fi.readFile(ctx, channel, path, false); err != nil {
+				log.Printf("file input cannot read %s: %s", path, err)
+			}
+		}
+	}
+
+	return fi.readFile(ctx, channel, fi.path, fi.follow)
+}
+
+// Read the stats lines of the file following the lines appended if it's set
+// (compressed files are read once)
+func (fi *fileInput) readFile(ctx context.Context, channel syslog.LogPartsChannel, path string, follow bool) error {
+	rc, compressed, err := openStatsFile(path)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var r io.Reader = rc
+	if follow && !compressed {
+		fr := &followReader{ctx: ctx, path: path, f: rc.f, r: r, poll: fi.poll}
+		defer fr.Close()
+
+		r = fr
+	}
+
+	return readLines(channel, &statsLogReader{ctx: ctx, r: bufio.NewReader(r)}, fi.parts)
+}
+
+// Get the rotated predecessors of the stats file, oldest first
+func rotatedFiles(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type rotated struct {
+		path    string
+		modTime time.Time
+	}
+
+	files := []rotated{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || len(name) <= len(base) || name[:len(base)] != base || !reRotatedSuffix.MatchString(name[len(base):]) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, rotated{path: filepath.Join(dir, name), modTime: info.ModTime()})
+	}
+
+	// numbered files are renamed on rotation keeping their times
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+
+		return files[i].path > files[j].path
+	})
+
+	rv := make([]string, len(files))
+	for i, f := range files {
+		rv[i] = f.path
+	}
+
+	return rv, nil
+}
+
+// Reader closing both the decompressor and the file
+type statsFile struct {
+	io.ReadCloser
+	f *os.File
+}
+
+// Close the decompressor and the file
+func (sf *statsFile) Close() error {
+	err := sf.ReadCloser.Close()
+	if e := sf.f.Close(); e != nil && err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// Open the stats file decompressing gzip, zlib and zstd ones (detected by the
+// magic number). Returns true if the file is compressed.
+func openStatsFile(path string) (*statsFile, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r, compression, err := newDecompressor(bufio.NewReader(f), compressionAuto)
+	if err != nil {
+		f.Close()
+		return nil, false, fmt.Errorf("cannot read %s file %s: %w", compression, path, err)
+	}
+
+	return &statsFile{ReadCloser: r, f: f}, compression != compressionNone, nil
+}
+
+// Reader waiting for the data appended to the file on EOF until the context
+// is done. The file is reopened once it's renamed (logrotate create) and
+// read from the start once it's truncated (logrotate copytruncate).
+type followReader struct {
+	ctx  context.Context
+	path string
+	// file being read and the amount of bytes read from it
+	f      *os.File
+	r      io.Reader
+	offset int64
+	poll   time.Duration
+	// new file at the path, switched to once the renamed one is drained
+	next *os.File
+	// file opened by the reader, closed by it
+	opened *os.File
+}
+
+// Read the data waiting for more on EOF
+func (fr *followReader) Read(p []byte) (int, error) {
+	for {
+		n, err := fr.r.Read(p)
+		fr.offset += int64(n)
+
+		if n > 0 || !errors.Is(err, io.EOF) {
+			return n, err
+		}
+
+		if fr.rotated() {
+			continue
+		}
+
+		select {
+		case <-fr.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(fr.poll):
+		}
+	}
+}
+
+// Check the file for the rotation at EOF. Returns true if there is the file
+// to read: the truncated one from the start, the renamed one one more time
+// (the lines written before the rename was noticed) or the new one.
+func (fr *followReader) rotated() bool {
+	if fr.next != nil {
+		fr.switchTo(fr.next)
+		fr.next = nil
+
+		return true
+	}
+
+	cur, err := fr.f.Stat()
+	if err != nil {
+		return false
+	}
+
+	if cur.Size() < fr.offset {
+		if _, err := fr.f.Seek(0, io.SeekStart); err != nil {
+			return false
+		}
+
+		log.Printf("file input %s is truncated, reading from the start", fr.path)
+		fr.r, fr.offset = fr.f, 0
+
+		return true
+	}
+
+	// the path is missing between the rename and the new file creation
+	fi, err := os.Stat(fr.path)
+	if err != nil || os.SameFile(cur, fi) {
+		return false
+	}
+
+	f, err := os.Open(fr.path)
+	if err != nil {
+		return false
+	}
+
+	log.Printf("file input %s is rotated, reading the new file", fr.path)
+	fr.next = f
+
+	return true
+}
+
+// Switch to the new file
+func (fr *followReader) switchTo(f *os.File) {
+	if fr.opened != nil {
+		fr.opened.Close()
+	}
+
+	fr.f, fr.r, fr.offset, fr.opened = f, f, 0, f
+}
+
+// Close the files opened by the reader (the first one is closed by the
+// caller)
+func (fr *followReader) Close() error {
+	if fr.next != nil {
+		fr.next.Close()
+	}
+
+	if fr.opened != nil {
+		return fr.opened.Close()
+	}
+
+	return nil
+}
+
+// Reader of the impstats log lines stripping the prefixes before the JSON
+// object: the timestamp of impstats log.file lines (`Fri Oct 16 14:00:00
+// 2026: {...}`) or the syslog header of the messages written by omfile. The
+// reading stops once the context is done.
+type statsLogReader struct {
+	ctx  context.Context
+	r    *bufio.Reader
+	line []byte
+}
+
+// Read the stripped lines
+func (sr *statsLogReader) Read(p []byte) (int, error) {
+	for len(sr.line) == 0 {
+		if sr.ctx.Err() != nil {
+			return 0, io.EOF
+		}
+
+		line, err := sr.r.ReadBytes('\n')
+		if i := bytes.IndexByte(line, '{'); i > 0 {
+			line = line[i:]
+		}
+
+		sr.line = line
+
+		if err != nil {
+			if len(line) == 0 {
+				return 0, err
+			}
+
+			break
+		}
+	}
+
+	n := copy(p, sr.line)
+	sr.line = sr.line[n:]
+
+	return n, nil
+}
@@ -0,0 +1,309 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Write the file compressing it by the extension, the modification time is
+// set to the age ago
+func writeStatsFile(t *testing.T, path string, content string, age time.Duration) {
+	t.Helper()
+
+	data := []byte(content)
+
+	switch filepath.Ext(path) {
+	case ".gz":
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		data = buf.Bytes()
+	case ".zst":
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = enc.EncodeAll(data, nil)
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Receive the message contents from the channel
+func receiveContents(t *testing.T, channel syslog.LogPartsChannel, n int) []string {
+	t.Helper()
+
+	got := []string{}
+
+	for len(got) < n {
+		select {
+		case parts := <-channel:
+			got = append(got, parts["content"].(string))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want %d lines, got %v", n, got)
+		}
+	}
+
+	return got
+}
+
+// rotatedFiles
+func TestRotatedFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.log")
+
+	for name, age := range map[string]time.Duration{
+		"stats.log":           0,
+		"stats.log.1":         time.Hour,
+		"stats.log.2.gz":      2 * time.Hour,
+		"stats.log-20261001":  3 * time.Hour,
+		"stats.log.pos":       time.Hour,
+		"stats.log.3.bz2":     time.Hour,
+		"other.log.1":         time.Hour,
+		"stats.log.4.zst":     4 * time.Hour,
+		"stats.log.5.gz.part": time.Hour,
+	} {
+		writeStatsFile(t, filepath.Join(dir, name), "", age)
+	}
+
+	got, err := rotatedFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{}
+	for _, name := range []string{"stats.log.4.zst", "stats.log-20261001", "stats.log.2.gz", "stats.log.1"} {
+		want = append(want, filepath.Join(dir, name))
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("rotated files mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fileInputInit (backfill through the compressed predecessors)
+func TestFileInputBackfill(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats.log")
+
+	writeStatsFile(t, path+".3.zst", "Fri Oct 16 11:00:00 2026: {\"n\": 1}\n", 3*time.Hour)
+	writeStatsFile(t, path+".2.gz", "Fri Oct 16 12:00:00 2026: {\"n\": 2}\n", 2*time.Hour)
+	writeStatsFile(t, path+".1", "{\"n\": 3}\n\n", time.Hour)
+	writeStatsFile(t, path, "Oct 16 14:00:00 host rsyslogd-pstats: {\"n\": 4}\n{\"n\": 5}", 0)
+
+	u, _ := url.Parse("file://" + path + "?backfill=true&follow=false")
+	channel := make(syslog.LogPartsChannel)
+
+	listener, err := fileInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.stop()
+
+	got := receiveContents(t, channel, 5)
+	want := []string{`{"n": 1}`, `{"n": 2}`, `{"n": 3}`, `{"n": 4}`, `{"n": 5}`}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fileInputInit (following the lines appended)
+func TestFileInputFollow(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.log")
+	writeStatsFile(t, path, "{\"n\": 1}\n", 0)
+
+	u, _ := url.Parse("file://" + path + "?poll=10ms")
+	channel := make(syslog.LogPartsChannel)
+
+	listener, err := fileInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receiveContents(t, channel, 1)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// partial lines are not sent until they're complete
+	f.WriteString(`{"n":`)
+	time.Sleep(50 * time.Millisecond)
+	f.WriteString(" 2}\n")
+	f.Close()
+
+	if diff := cmp.Diff([]string{`{"n": 2}`}, receiveContents(t, channel, 1)); diff != "" {
+		t.Errorf("lines mismatch (-want +got):\n%s", diff)
+	}
+
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if err != nil {
+			t.Errorf("want stopped listener, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("listener is not stopped")
+	}
+
+	for _, conn := range []string{"file://", "file:///tmp/stats.log?poll=0s", "file:///tmp/stats.log?follow=maybe"} {
+		u, _ := url.Parse(conn)
+		if _, err := fileInputInit(u, channel); err == nil {
+			t.Errorf("%s: error expected", conn)
+		}
+	}
+}
+
+// fileInputInit (following the renamed and truncated files)
+func TestFileInputRotation(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.log")
+	writeStatsFile(t, path, "{\"n\": 1}\n", 0)
+
+	u, _ := url.Parse("file://" + path + "?poll=10ms")
+	channel := make(syslog.LogPartsChannel)
+
+	listener, err := fileInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.stop()
+
+	receiveContents(t, channel, 1)
+
+	appendLine := func(path string, line string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+
+	// logrotate create: the line written to the renamed file is not lost
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	appendLine(path+".1", `{"n": 2}`)
+	appendLine(path, `{"n": 3}`)
+
+	if diff := cmp.Diff([]string{`{"n": 2}`, `{"n": 3}`}, receiveContents(t, channel, 2)); diff != "" {
+		t.Errorf("renamed: lines mismatch (-want +got):\n%s", diff)
+	}
+
+	// logrotate copytruncate
+	appendLine(path, `{"n": 4}`)
+	receiveContents(t, channel, 1)
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	appendLine(path, `{"n": 5}`)
+
+	if diff := cmp.Diff([]string{`{"n": 5}`}, receiveContents(t, channel, 1)); diff != "" {
+		t.Errorf("truncated: lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fileInputInit (glob pattern)
+func TestFileInputGlob(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeStatsFile(t, filepath.Join(dir, "stats-a.log"), "{\"n\": 1}\n", 0)
+	writeStatsFile(t, filepath.Join(dir, "stats-a.log.1"), "{\"n\": 0}\n", time.Hour)
+
+	u, _ := url.Parse("file://" + dir + "/stats-*?poll=10ms&discover=10ms")
+	channel := make(syslog.LogPartsChannel)
+
+	listener, err := fileInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.stop()
+
+	receive := func() format.LogParts {
+		select {
+		case parts := <-channel:
+			return parts
+		case <-time.After(5 * time.Second):
+			t.Fatal("no lines received")
+		}
+
+		return nil
+	}
+
+	parts := receive()
+	if got, want := parts["content"], `{"n": 1}`; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	// new matches are discovered
+	path := filepath.Join(dir, "stats-b.log")
+	writeStatsFile(t, path, "{\"n\": 2}\n", 0)
+
+	parts = receive()
+	if got, want := parts["content"], `{"n": 2}`; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+
+	if diff := cmp.Diff(RsyslogStatsLabels{"file", path}, messageLabels(parts)); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+
+	u, _ = url.Parse("file:///tmp/stats-[.log")
+	if _, err := fileInputInit(u, channel); err == nil {
+		t.Error("bad pattern: error expected")
+	}
+}
@@ -0,0 +1,290 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Fluentd forward protocol EventTime extension. Event time is not used so
+// the value is just skipped.
+type fluentEventTime struct{}
+
+func (t *fluentEventTime) MarshalMsgpack() ([]byte, error) {
+	return make([]byte, 8), nil
+}
+
+func (t *fluentEventTime) UnmarshalMsgpack(b []byte) error {
+	if len(b) != 8 {
+		return fmt.Errorf("wrong EventTime length %d", len(b))
+	}
+
+	return nil
+}
+
+func init() {
+	msgpack.RegisterExt(0, (*fluentEventTime)(nil))
+}
+
+// Max forward protocol event size and decompressed PackedForward entries size
+const (
+	fluentMaxEventSize        = 16 << 20
+	fluentMaxDecompressedSize = 32 << 20
+)
+
+// Fluentd forward protocol input: fluent://host:port[?key=log][&family=...&iface=...]
+// Message, Forward, PackedForward and CompressedPackedForward modes are
+// supported over TCP. The impstats line is taken from the record field set
+// by the key parameter ("message" or "log" by default). Chunks are
+// acknowledged when the sender asks for it.
+type fluentInput struct {
+	keys []string
+}
+
+// Init Fluentd forward protocol input
+func fluentInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	fi := &fluentInput{keys: []string{"message", "log"}}
+
+	if key := u.Query().Get("key"); key != "" {
+		fi.keys = []string{key}
+	}
+
+	ln, err := listenOptionsFromQuery(u.Query()).listen("tcp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return fi.serveListener(ln, channel) }, ln.Close), nil
+}
+
+// Serve forward protocol connections until the listener is closed
+func (fi *fluentInput) serveListener(ln net.Listener, channel syslog.LogPartsChannel) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("Fluentd forward input accept failed: %s", err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go fi.serve(conn, channel)
+	}
+}
+
+// Read forward protocol events from the connection
+func (fi *fluentInput) serve(conn net.Conn, channel syslog.LogPartsChannel) {
+	defer conn.Close()
+
+	client := conn.RemoteAddr().String()
+
+	r := newFluentEventReader(conn)
+	dec := msgpack.NewDecoder(r)
+
+	for {
+		var event []interface{}
+
+		r.reset()
+		if err := dec.Decode(&event); err != nil {
+			if err != io.EOF {
+				log.Printf("Fluentd forward input cannot decode event from %s: %s", client, err)
+			}
+			return
+		}
+
+		if err := fi.handle(event, conn, channel, client); err != nil {
+			log.Printf("Fluentd forward input cannot handle event from %s: %s", client, err)
+			return
+		}
+	}
+}
+
+// Buffered connection reader limiting the event size. The decoder reads it
+// directly (it's a ByteScanner), so the bytes read ahead are not counted
+// against the event being decoded.
+type fluentEventReader struct {
+	*maxBytesReader
+	br *bufio.Reader
+}
+
+// Get the event reader of the connection
+func newFluentEventReader(conn io.Reader) *fluentEventReader {
+	br := bufio.NewReader(conn)
+
+	return &fluentEventReader{maxBytesReader: newMaxBytesReader(br, fluentMaxEventSize), br: br}
+}
+
+// Read the byte of the event
+func (fr *fluentEventReader) ReadByte() (byte, error) {
+	if fr.left <= 0 {
+		fr.left = -1
+		return 0, fr.err()
+	}
+
+	b, err := fr.br.ReadByte()
+	if err == nil {
+		fr.left--
+	}
+
+	return b, err
+}
+
+// Unread the last byte read
+func (fr *fluentEventReader) UnreadByte() error {
+	if err := fr.br.UnreadByte(); err != nil {
+		return err
+	}
+
+	fr.left++
+
+	return nil
+}
+
+// Handle a single forward protocol event: [tag, time|entries, record|option, option]
+// The chunk acknowledgement is written to the connection.
+func (fi *fluentInput) handle(event []interface{}, conn io.Writer, channel syslog.LogPartsChannel, client string) error {
+	var option map[string]interface{}
+
+	if len(event) < 2 {
+		return fmt.Errorf("event is too short")
+	}
+
+	switch entries := event[1].(type) {
+	case []interface{}: // Forward mode
+		for _, e := range entries {
+			if entry, ok := e.([]interface{}); ok && len(entry) == 2 {
+				fi.sendRecord(entry[1], channel, client)
+			}
+		}
+
+		option = fi.option(event, 2)
+	case string, []byte: // PackedForward mode
+		option = fi.option(event, 2)
+
+		if err := fi.unpack(entries, option, channel, client); err != nil {
+			return err
+		}
+	default: // Message mode
+		if len(event) < 3 {
+			return fmt.Errorf("message event is too short")
+		}
+
+		fi.sendRecord(event[2], channel, client)
+		option = fi.option(event, 3)
+	}
+
+	if chunk, found := option["chunk"]; found {
+		b, err := msgpack.Marshal(map[string]interface{}{"ack": chunk})
+		if err != nil {
+			return err
+		}
+
+		if _, err = conn.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Get the event option map
+func (fi *fluentInput) option(event []interface{}, i int) map[string]interface{} {
+	if len(event) > i {
+		if option, ok := event[i].(map[string]interface{}); ok {
+			return option
+		}
+	}
+
+	return nil
+}
+
+// Decode PackedForward mode entries stream
+func (fi *fluentInput) unpack(entries interface{}, option map[string]interface{}, channel syslog.LogPartsChannel, client string) error {
+	var r io.Reader
+
+	switch e := entries.(type) {
+	case string:
+		r = bytes.NewReader([]byte(e))
+	case []byte:
+		r = bytes.NewReader(e)
+	}
+
+	if option["compressed"] == "gzip" {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+
+		r = newMaxBytesReader(gz, fluentMaxDecompressedSize)
+	}
+
+	dec := msgpack.NewDecoder(r)
+
+	for {
+		var entry []interface{}
+
+		if err := dec.Decode(&entry); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if len(entry) == 2 {
+			fi.sendRecord(entry[1], channel, client)
+		}
+	}
+}
+
+// Send the impstats line from the record
+func (fi *fluentInput) sendRecord(r interface{}, channel syslog.LogPartsChannel, client string) {
+	record, ok := r.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, key := range fi.keys {
+		switch line := record[key].(type) {
+		case string:
+			sendLine(channel, line, client)
+			return
+		case []byte:
+			sendLine(channel, string(line), client)
+			return
+		}
+	}
+}
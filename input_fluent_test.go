@@ -0,0 +1,316 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Encode the msgpack values one after another (PackedForward entries e.g.)
+func fluentPack(t *testing.T, values ...interface{}) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	enc := msgpack.NewEncoder(&b)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// fluentInput.handle
+func TestFluentHandle(t *testing.T) {
+	t.Parallel()
+
+	const line = `{"name":"main Q","size":1}`
+
+	record := map[string]interface{}{"message": line}
+	entries := fluentPack(t, []interface{}{1600000000, record}, []interface{}{&fluentEventTime{}, map[string]interface{}{"log": line}})
+
+	var compressed bytes.Buffer
+
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(entries)
+	gz.Close()
+
+	// entries w/o the impstats line decompressed beyond the limit
+	var bomb bytes.Buffer
+
+	gz = gzip.NewWriter(&bomb)
+	filler := fluentPack(t, []interface{}{1600000000, map[string]interface{}{"other": strings.Repeat("x", 1<<16)}})
+	for n := 0; n <= fluentMaxDecompressedSize; n += len(filler) {
+		gz.Write(filler)
+	}
+	gz.Close()
+
+	var tests = []struct {
+		name    string
+		keys    []string
+		event   []interface{}
+		want    []string
+		ack     interface{}
+		wantErr bool
+	}{
+		{
+			name:  "message",
+			event: []interface{}{"rsyslog", 1600000000, record},
+			want:  []string{line},
+		},
+		{
+			name:  "message with event time",
+			event: []interface{}{"rsyslog", &fluentEventTime{}, map[string]interface{}{"log": line}},
+			want:  []string{line},
+		},
+		{
+			name:  "message with chunk",
+			event: []interface{}{"rsyslog", 1600000000, record, map[string]interface{}{"chunk": "c1"}},
+			want:  []string{line},
+			ack:   "c1",
+		},
+		{
+			name:  "forward",
+			event: []interface{}{"rsyslog", []interface{}{[]interface{}{1600000000, record}, []interface{}{1600000001, record}}, map[string]interface{}{"chunk": "c2"}},
+			want:  []string{line, line},
+			ack:   "c2",
+		},
+		{
+			name:  "packed forward",
+			event: []interface{}{"rsyslog", entries},
+			want:  []string{line, line},
+		},
+		{
+			name:  "packed forward as string",
+			event: []interface{}{"rsyslog", string(entries)},
+			want:  []string{line, line},
+		},
+		{
+			name:  "compressed packed forward",
+			event: []interface{}{"rsyslog", compressed.Bytes(), map[string]interface{}{"compressed": "gzip", "chunk": "c3"}},
+			want:  []string{line, line},
+			ack:   "c3",
+		},
+		{
+			name:  "custom key",
+			keys:  []string{"stats"},
+			event: []interface{}{"rsyslog", 1600000000, map[string]interface{}{"message": "other", "stats": line}},
+			want:  []string{line},
+		},
+		{
+			name:  "record without key",
+			event: []interface{}{"rsyslog", 1600000000, map[string]interface{}{"other": line}},
+			want:  []string{},
+		},
+		{
+			name:    "too short",
+			event:   []interface{}{"rsyslog"},
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:    "short message",
+			event:   []interface{}{"rsyslog", 1600000000},
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:    "broken compressed entries",
+			event:   []interface{}{"rsyslog", entries, map[string]interface{}{"compressed": "gzip"}},
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:    "decompression bomb",
+			event:   []interface{}{"rsyslog", bomb.Bytes(), map[string]interface{}{"compressed": "gzip", "chunk": "c4"}},
+			want:    []string{},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		fi := &fluentInput{keys: []string{"message", "log"}}
+		if tt.keys != nil {
+			fi.keys = tt.keys
+		}
+
+		// decode the event the way it's read from the connection
+		var event []interface{}
+		if err := msgpack.Unmarshal(fluentPack(t, tt.event), &event); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		var ack bytes.Buffer
+
+		channel := make(syslog.LogPartsChannel, 10)
+		err := fi.handle(event, &ack, channel, "192.0.2.1:24224")
+
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: want error %v, got %v", tt.name, tt.wantErr, err)
+		}
+
+		close(channel)
+
+		got := []string{}
+		for parts := range channel {
+			got = append(got, parts["content"].(string))
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s: lines mismatch (-want +got):\n%s", tt.name, diff)
+		}
+
+		var wantAck []byte
+		if tt.ack != nil {
+			wantAck = fluentPack(t, map[string]interface{}{"ack": tt.ack})
+		}
+
+		if !bytes.Equal(wantAck, ack.Bytes()) {
+			t.Errorf("%s: want ack %q, got %q", tt.name, wantAck, ack.Bytes())
+		}
+	}
+}
+
+// fluentInput.serveListener
+func TestFluentInput(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := make(syslog.LogPartsChannel, 1)
+	fi := &fluentInput{keys: []string{"message", "log"}}
+	listener := runListener(func() error { return fi.serveListener(ln, channel) }, ln.Close)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(fluentPack(t, []interface{}{"rsyslog", 1600000000, map[string]interface{}{"log": `{"name":"main Q","size":1}`}}))
+
+	select {
+	case parts := <-channel:
+		if want := `{"name":"main Q","size":1}`; parts["content"] != want {
+			t.Errorf("want line %q, got %v", want, parts["content"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not received")
+	}
+
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed listener error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still serving")
+	}
+}
+
+// fluentInput.serve (oversized event)
+func TestFluentServeEventSize(t *testing.T) {
+	t.Parallel()
+
+	server, client := net.Pipe()
+	channel := make(syslog.LogPartsChannel, 2)
+	fi := &fluentInput{keys: []string{"message", "log"}}
+
+	done := make(chan struct{})
+	go func() {
+		fi.serve(server, channel)
+		close(done)
+	}()
+
+	line := `{"name":"main Q","size":1}`
+	huge := make([]byte, fluentMaxEventSize)
+
+	events := fluentPack(t,
+		[]interface{}{"rsyslog", 1600000000, map[string]interface{}{"log": line}},
+		[]interface{}{"rsyslog", 1600000000, map[string]interface{}{"log": line, "padding": huge}})
+
+	go client.Write(events)
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("oversized event is still being read")
+	}
+
+	client.Close()
+	close(channel)
+
+	got := []string{}
+	for parts := range channel {
+		got = append(got, parts["content"].(string))
+	}
+
+	if diff := cmp.Diff([]string{line}, got); diff != "" {
+		t.Errorf("lines mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// fluentInputInit (listen options)
+func TestFluentInputInit(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		conn    string
+		wantErr bool
+	}{
+		{"fluent://127.0.0.1:0?family=ipv4", false},
+		{"fluent://127.0.0.1:0?family=ipv6", true},
+		{"fluent://127.0.0.1:0?iface=no-such-interface", true},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := fluentInputInit(u, make(syslog.LogPartsChannel))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: want error %v, got %v", tt.conn, tt.wantErr, err)
+		}
+
+		if err == nil {
+			listener.stop()
+		}
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+const (
+	gelfChunkMagic     = "\x1e\x0f"
+	gelfChunkHeaderLen = 12
+	gelfMaxChunks      = 128
+	gelfChunkTimeout   = 5 * time.Second
+	gelfMaxMessageSize = 1 << 20
+)
+
+// Partially received chunked GELF message
+type gelfChunks struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+// GELF input: gelf://host:port (UDP) or gelf+tcp://host:port
+// The impstats line is taken from the short_message field. Chunked and
+// gzip/zlib-compressed UDP messages are supported, TCP messages are expected
+// to be null-byte delimited.
+type gelfInput struct {
+	sync.Mutex
+	pending map[string]*gelfChunks
+}
+
+// GELF TCP frame is larger than the max message size
+var errGELFFrameTooLarge = errors.New("GELF frame exceeds the max message size")
+
+// Init GELF input
+func gelfInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	gi := &gelfInput{pending: make(map[string]*gelfChunks)}
+
+	if u.Scheme == "gelf+tcp" {
+		ln, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return inputListener{}, err
+		}
+
+		return runListener(func() error { return gi.serveTCPListener(ln, channel) }, ln.Close), nil
+	}
+
+	conn, err := net.ListenPacket("udp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return gi.serveUDP(conn, channel) }, conn.Close), nil
+}
+
+// Serve GELF TCP connections until the listener is closed
+func (gi *gelfInput) serveTCPListener(ln net.Listener, channel syslog.LogPartsChannel) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("GELF input accept failed: %s", err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go gi.serveTCP(conn, channel)
+	}
+}
+
+// Read null-byte delimited messages from the TCP connection
+func (gi *gelfInput) serveTCP(conn net.Conn, channel syslog.LogPartsChannel) {
+	defer conn.Close()
+
+	client := conn.RemoteAddr().String()
+	r := bufio.NewReader(conn)
+
+	for {
+		msg, err := readGELFFrame(r)
+		if len(msg) > 1 {
+			gi.send(bytes.TrimRight(msg, "\x00"), channel, client)
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("GELF input cannot read from %s: %s", client, err)
+			}
+			return
+		}
+	}
+}
+
+// Read the null-byte delimited TCP frame up to the max message size, so the
+// sender never terminating the frame can't exhaust the memory
+func readGELFFrame(r *bufio.Reader) ([]byte, error) {
+	var frame []byte
+
+	for {
+		chunk, err := r.ReadSlice(0)
+		if len(frame)+len(chunk) > gelfMaxMessageSize+1 {
+			return nil, errGELFFrameTooLarge
+		}
+
+		frame = append(frame, chunk...)
+
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return frame, err
+		}
+	}
+}
+
+// Read datagrams, reassemble chunked ones and decompress them until the
+// connection is closed
+func (gi *gelfInput) serveUDP(conn net.PacketConn, channel syslog.LogPartsChannel) error {
+	buf := make([]byte, 65536)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("GELF input read failed: %s", err)
+			continue
+		}
+
+		msg := make([]byte, n)
+		copy(msg, buf[:n])
+
+		if bytes.HasPrefix(msg, []byte(gelfChunkMagic)) {
+			if msg = gi.reassemble(msg); msg == nil {
+				continue
+			}
+		}
+
+		msg, err = gelfDecompress(msg)
+		if err != nil {
+			log.Printf("GELF input cannot decompress message from %s: %s", addr, err)
+			continue
+		}
+
+		gi.send(msg, channel, addr.String())
+	}
+}
+
+// Store the chunk and return the whole message once all chunks are received
+func (gi *gelfInput) reassemble(chunk []byte) []byte {
+	if len(chunk) < gelfChunkHeaderLen {
+		return nil
+	}
+
+	id := string(chunk[2:10])
+	seq, count := int(chunk[10]), int(chunk[11])
+
+	if count == 0 || count > gelfMaxChunks || seq >= count {
+		return nil
+	}
+
+	gi.Lock()
+	defer gi.Unlock()
+
+	now := time.Now()
+
+	// expire incomplete messages
+	for k, p := range gi.pending {
+		if now.Sub(p.started) > gelfChunkTimeout {
+			delete(gi.pending, k)
+		}
+	}
+
+	p, found := gi.pending[id]
+	if !found {
+		p = &gelfChunks{chunks: make([][]byte, count), started: now}
+		gi.pending[id] = p
+	}
+
+	if len(p.chunks) != count || p.chunks[seq] != nil {
+		return nil
+	}
+
+	p.chunks[seq] = chunk[gelfChunkHeaderLen:]
+	p.received++
+
+	if p.received < count {
+		return nil
+	}
+
+	delete(gi.pending, id)
+
+	return bytes.Join(p.chunks, nil)
+}
+
+// Decompress gzip or zlib compressed message
+func gelfDecompress(msg []byte) ([]byte, error) {
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	switch {
+	case len(msg) > 1 && msg[0] == 0x1f && msg[1] == 0x8b:
+		r, err = gzip.NewReader(bytes.NewReader(msg))
+	case len(msg) > 0 && msg[0] == 0x78:
+		r, err = zlib.NewReader(bytes.NewReader(msg))
+	default:
+		return msg, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(io.LimitReader(r, gelfMaxMessageSize))
+}
+
+// Send the short_message of the GELF message
+func (gi *gelfInput) send(msg []byte, channel syslog.LogPartsChannel, client string) {
+	var gelf struct {
+		ShortMessage string `json:"short_message"`
+	}
+
+	if err := json.Unmarshal(msg, &gelf); err != nil {
+		log.Printf("GELF input cannot parse message from %s: %s", client, err)
+		return
+	}
+
+	if gelf.ShortMessage == "" {
+		log.Printf("GELF input got message without short_message from %s", client)
+		return
+	}
+
+	sendLine(channel, gelf.ShortMessage, client)
+}
@@ -0,0 +1,248 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Build the GELF chunk
+func gelfChunk(id string, seq, count byte, data string) []byte {
+	return append([]byte(gelfChunkMagic+id+string([]byte{seq, count})), data...)
+}
+
+// gelfInput.reassemble
+func TestGELFReassemble(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		chunks [][]byte
+		want   string
+	}{
+		{
+			name:   "in order",
+			chunks: [][]byte{gelfChunk("msgid001", 0, 2, "foo"), gelfChunk("msgid001", 1, 2, "bar")},
+			want:   "foobar",
+		},
+		{
+			name:   "out of order",
+			chunks: [][]byte{gelfChunk("msgid002", 2, 3, "baz"), gelfChunk("msgid002", 0, 3, "foo"), gelfChunk("msgid002", 1, 3, "bar")},
+			want:   "foobarbaz",
+		},
+		{
+			name:   "duplicate chunk",
+			chunks: [][]byte{gelfChunk("msgid003", 0, 2, "foo"), gelfChunk("msgid003", 0, 2, "foo")},
+		},
+		{
+			name:   "count mismatch",
+			chunks: [][]byte{gelfChunk("msgid004", 0, 2, "foo"), gelfChunk("msgid004", 1, 3, "bar")},
+		},
+		{
+			name:   "sequence out of range",
+			chunks: [][]byte{gelfChunk("msgid005", 2, 2, "foo")},
+		},
+		{
+			name:   "too many chunks",
+			chunks: [][]byte{gelfChunk("msgid006", 0, gelfMaxChunks+1, "foo")},
+		},
+		{
+			name:   "short chunk",
+			chunks: [][]byte{[]byte(gelfChunkMagic + "short")},
+		},
+	}
+
+	for _, tt := range tests {
+		gi := &gelfInput{pending: make(map[string]*gelfChunks)}
+
+		var got []byte
+		for _, chunk := range tt.chunks {
+			if msg := gi.reassemble(chunk); msg != nil {
+				got = msg
+			}
+		}
+
+		if string(got) != tt.want {
+			t.Errorf("%s: want message %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// gelfInput.reassemble
+func TestGELFReassembleExpired(t *testing.T) {
+	t.Parallel()
+
+	gi := &gelfInput{pending: make(map[string]*gelfChunks)}
+	gi.reassemble(gelfChunk("msgid001", 0, 2, "foo"))
+	gi.pending["msgid001"].started = time.Now().Add(-2 * gelfChunkTimeout)
+
+	// the incomplete message is expired, so the chunk starts a new one
+	if got := gi.reassemble(gelfChunk("msgid001", 1, 2, "bar")); got != nil {
+		t.Errorf("want expired message dropped, got %q", got)
+	}
+}
+
+// gelfDecompress
+func TestGELFDecompress(t *testing.T) {
+	t.Parallel()
+
+	const msg = `{"short_message":"{\"name\":\"main Q\",\"size\":1}"}`
+
+	var gz, zl bytes.Buffer
+
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(msg))
+	gw.Close()
+
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(msg))
+	zw.Close()
+
+	var tests = []struct {
+		name    string
+		data    []byte
+		wantErr bool
+	}{
+		{"plain", []byte(msg), false},
+		{"gzip", gz.Bytes(), false},
+		{"zlib", zl.Bytes(), false},
+		{"broken gzip", gz.Bytes()[:4], true},
+	}
+
+	for _, tt := range tests {
+		got, err := gelfDecompress(tt.data)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: error expected", tt.name)
+			}
+
+			continue
+		}
+
+		if err != nil || string(got) != msg {
+			t.Errorf("%s: want %q, got %q, %v", tt.name, msg, got, err)
+		}
+	}
+}
+
+// readGELFFrame
+func TestReadGELFFrame(t *testing.T) {
+	t.Parallel()
+
+	r := bufio.NewReader(strings.NewReader("first\x00second\x00tail"))
+
+	for _, want := range []string{"first\x00", "second\x00"} {
+		if got, err := readGELFFrame(r); err != nil || string(got) != want {
+			t.Errorf("want frame %q, got %q, %v", want, got, err)
+		}
+	}
+
+	if got, err := readGELFFrame(r); err != io.EOF || string(got) != "tail" {
+		t.Errorf("want unterminated frame %q and EOF, got %q, %v", "tail", got, err)
+	}
+
+	// the frame is not terminated within the max message size
+	r = bufio.NewReader(io.MultiReader(strings.NewReader(strings.Repeat("x", gelfMaxMessageSize+1)), strings.NewReader("\x00")))
+	if _, err := readGELFFrame(r); !errors.Is(err, errGELFFrameTooLarge) {
+		t.Errorf("want frame too large error, got %v", err)
+	}
+}
+
+// gelfInput.serveTCPListener
+func TestGELFInputTCP(t *testing.T) {
+	t.Parallel()
+
+	channel := make(syslog.LogPartsChannel, 1)
+	gi := &gelfInput{pending: make(map[string]*gelfChunks)}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := runListener(func() error { return gi.serveTCPListener(ln, channel) }, ln.Close)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte(`{"short_message":"{\"name\":\"main Q\",\"size\":1}"}` + "\x00"))
+	conn.Close()
+
+	select {
+	case parts := <-channel:
+		if want := `{"name":"main Q","size":1}`; parts["content"] != want {
+			t.Errorf("want line %q, got %v", want, parts["content"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not received")
+	}
+
+	if err := listener.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed listener error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still serving")
+	}
+}
+
+// gelfInputInit
+func TestGELFInputUDPStop(t *testing.T) {
+	t.Parallel()
+
+	u, _ := url.Parse("gelf://127.0.0.1:0")
+
+	listener, err := gelfInputInit(u, make(syslog.LogPartsChannel))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := listener.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed connection error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still reading")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Input modules reporting the stats per listener and worker
+var listenerStatsOrigins = map[string]bool{
+	"imudp":  true,
+	"imtcp":  true,
+	"imptcp": true,
+}
+
+// Worker stats names: "imudp(w0)", "imptcp(worker 1)"
+var reListenerStatsWorker = regexp.MustCompile(`^(?:w|worker[ -]?)(\d+)$`)
+
+// Get the parenthesized part of the input stats name: "imudp(*:514)" -> "*:514"
+func listenerStatsID(name string) string {
+	i, j := strings.IndexByte(name, '('), strings.LastIndexByte(name, ')')
+	if i < 0 || j < i {
+		return ""
+	}
+
+	return name[i+1 : j]
+}
+
+// Get the module name of the input stats name: "imudp(*:514)" -> "imudp"
+func listenerStatsModule(name string) string {
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		return name[:i]
+	}
+
+	return name
+}
+
+// Get the worker number of the input stats name (empty if it's not the worker
+// stats)
+func listenerStatsWorker(name string) string {
+	if match := reListenerStatsWorker.FindStringSubmatch(listenerStatsID(name)); match != nil {
+		return match[1]
+	}
+
+	return ""
+}
+
+// Get the listener address and port of the input stats name:
+// "imudp(*:514)" -> "*", "514", "imtcp(514)" -> "", "514",
+// "imptcp(*/514/IPv4)" -> "*", "514". Both are empty for the other stats of
+// the module (imudp(w0) worker stats e.g.).
+func listenerStatsAddress(name string) (listener string, port string) {
+	address := listenerStatsID(name)
+
+	// imptcp: address/port/family
+	if parts := strings.Split(address, "/"); len(parts) == 3 && isPort(parts[1]) {
+		return parts[0], parts[1]
+	}
+
+	if k := strings.LastIndexByte(address, ':'); k >= 0 {
+		return address[:k], address[k+1:]
+	}
+
+	if isPort(address) {
+		return "", address
+	}
+
+	return "", ""
+}
+
+// Check if the string is the port number
+func isPort(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
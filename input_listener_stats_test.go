@@ -0,0 +1,129 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// listenerStatsAddress
+func TestListenerStatsAddress(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name     string
+		listener string
+		port     string
+	}{
+		{"imudp(*:514)", "*", "514"},
+		{"imudp(10.0.0.1:5140)", "10.0.0.1", "5140"},
+		{"imudp(::1:514)", "::1", "514"},
+		{"imtcp(6514)", "", "6514"},
+		{"imptcp(*/514/IPv4)", "*", "514"},
+		{"imptcp(10.0.0.1/5140/IPv6)", "10.0.0.1", "5140"},
+		{"imudp(w0)", "", ""},
+		{"imudp", "", ""},
+	}
+
+	for _, tt := range tests {
+		listener, port := listenerStatsAddress(tt.name)
+		if listener != tt.listener || port != tt.port {
+			t.Errorf("%s: want %q, %q, got %q, %q", tt.name, tt.listener, tt.port, listener, port)
+		}
+	}
+}
+
+// listenerStatsModule
+func TestListenerStatsModule(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		module string
+	}{
+		{"imudp(*:514)", "imudp"},
+		{"imptcp(*/514/IPv4)", "imptcp"},
+		{"imudp(w0)", "imudp"},
+		{"imtcp", "imtcp"},
+	}
+
+	for _, tt := range tests {
+		if module := listenerStatsModule(tt.name); module != tt.module {
+			t.Errorf("%s: want %q, got %q", tt.name, tt.module, module)
+		}
+	}
+}
+
+// listenerStatsWorker
+func TestListenerStatsWorker(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name   string
+		worker string
+	}{
+		{"imudp(w0)", "0"},
+		{"imptcp(worker 12)", "12"},
+		{"imptcp(worker-1)", "1"},
+		{"imudp(*:514)", ""},
+		{"imptcp(*/514/IPv4)", ""},
+		{"imtcp", ""},
+	}
+
+	for _, tt := range tests {
+		if worker := listenerStatsWorker(tt.name); worker != tt.worker {
+			t.Errorf("%s: want %q, got %q", tt.name, tt.worker, worker)
+		}
+	}
+}
+
+// RsyslogStats.namedLabels (listener stats labels)
+func TestRsyslogStatsListenerStatsLabels(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ListenerStatsLabels = true
+	rs.Parse(`{"name": "imudp(*:514)", "origin": "imudp", "submitted": 42}`)
+	rs.Parse(`{"name": "imtcp(6514)", "origin": "imtcp", "submitted": 7}`)
+	rs.Parse(`{"name": "imudp(w0)", "origin": "imudp", "msgs.received": 5}`)
+	rs.Parse(`{"name": "imptcp(*/514/IPv4)", "origin": "imptcp", "submitted": 12, "bytes.received": 2048}`)
+
+	imptcp := RsyslogStatsLabels{}.With("name", "imptcp").With("listener", "*").With("port", "514")
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_imudp_submitted": {
+			RsyslogStatsLabels{}.With("name", "imudp").With("listener", "*").With("port", "514"): 42,
+		},
+		"rsyslog_imtcp_submitted": {
+			RsyslogStatsLabels{}.With("name", "imtcp").With("listener", "").With("port", "6514"): 7,
+		},
+		"rsyslog_imudp_msgs_received": {
+			RsyslogStatsLabels{}.With("name", "imudp").With("listener", "").With("port", "").With("worker", "0"): 5,
+		},
+		"rsyslog_imptcp_submitted":      {imptcp: 12},
+		"rsyslog_imptcp_bytes_received": {imptcp: 2048},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+}
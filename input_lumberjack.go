@@ -0,0 +1,277 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Lumberjack v2 frame types
+const (
+	ljVersion       = '2'
+	ljFrameWindow   = 'W'
+	ljFrameCompress = 'C'
+	ljFrameJSON     = 'J'
+	ljFrameData     = 'D'
+	ljFrameAck      = 'A'
+
+	// Max JSON frame or data frame key or value size
+	ljMaxFieldSize = 1 << 20
+	// Max amount of the data frame key/value pairs
+	ljMaxDataPairs = 1024
+	// Max decompressed size of the compressed frame
+	ljMaxDecompressedSize = 32 << 20
+)
+
+// Beats/Lumberjack v2 input: lumberjack://host:port[?key=message]
+// The impstats line is taken from the event field set by the key parameter
+// ("message" by default). Every window is acknowledged once all its events
+// are queued for parsing.
+type lumberjackInput struct {
+	key string
+}
+
+// Init Lumberjack input
+func lumberjackInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	li := &lumberjackInput{key: u.Query().Get("key")}
+
+	if li.key == "" {
+		li.key = "message"
+	}
+
+	ln, err := net.Listen("tcp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return li.serveListener(ln, channel) }, ln.Close), nil
+}
+
+// Serve Lumberjack connections until the listener is closed
+func (li *lumberjackInput) serveListener(ln net.Listener, channel syslog.LogPartsChannel) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("Lumberjack input accept failed: %s", err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go li.serve(conn, channel)
+	}
+}
+
+// Lumberjack connection state, the acks are written to the conn
+type lumberjackConn struct {
+	conn    io.Writer
+	client  string
+	window  uint32
+	pending uint32
+}
+
+// Read frames from the connection
+func (li *lumberjackInput) serve(conn net.Conn, channel syslog.LogPartsChannel) {
+	defer conn.Close()
+
+	lc := &lumberjackConn{conn: conn, client: conn.RemoteAddr().String()}
+	r := bufio.NewReader(conn)
+
+	for {
+		if err := li.readFrame(r, lc, channel, false); err != nil {
+			if err != io.EOF {
+				log.Printf("Lumberjack input cannot read frame from %s: %s", lc.client, err)
+			}
+			return
+		}
+	}
+}
+
+// Read and handle the single frame. Compressed frames can't be nested.
+func (li *lumberjackInput) readFrame(r io.Reader, lc *lumberjackConn, channel syslog.LogPartsChannel, compressed bool) error {
+	var header [2]byte
+
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return err
+	}
+
+	if header[0] != ljVersion {
+		return fmt.Errorf("protocol version %c is not supported", header[0])
+	}
+
+	switch header[1] {
+	case ljFrameWindow:
+		if err := binary.Read(r, binary.BigEndian, &lc.window); err != nil {
+			return err
+		}
+		lc.pending = 0
+	case ljFrameCompress:
+		if compressed {
+			return errors.New("compressed frame is nested in the compressed frame")
+		}
+
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return err
+		}
+
+		zr, err := zlib.NewReader(io.LimitReader(r, int64(size)))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+
+		fr := newMaxBytesReader(zr, ljMaxDecompressedSize)
+
+		for {
+			if err := li.readFrame(fr, lc, channel, true); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+				return err
+			}
+		}
+	case ljFrameJSON:
+		seq, event, err := li.readJSON(r)
+		if err != nil {
+			return err
+		}
+
+		return li.handle(seq, event, lc, channel)
+	case ljFrameData:
+		seq, event, err := li.readData(r)
+		if err != nil {
+			return err
+		}
+
+		return li.handle(seq, event, lc, channel)
+	default:
+		return fmt.Errorf("frame type %c is not supported", header[1])
+	}
+
+	return nil
+}
+
+// Read JSON frame payload
+func (li *lumberjackInput) readJSON(r io.Reader) (uint32, map[string]interface{}, error) {
+	var seq, size uint32
+
+	if err := binary.Read(r, binary.BigEndian, &seq); err != nil {
+		return 0, nil, err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return 0, nil, err
+	}
+
+	if size > ljMaxFieldSize {
+		return 0, nil, fmt.Errorf("JSON frame size %d exceeds %d bytes", size, ljMaxFieldSize)
+	}
+
+	var event map[string]interface{}
+
+	if err := json.NewDecoder(io.LimitReader(r, int64(size))).Decode(&event); err != nil {
+		return 0, nil, err
+	}
+
+	return seq, event, nil
+}
+
+// Read key/value data frame payload
+func (li *lumberjackInput) readData(r io.Reader) (uint32, map[string]interface{}, error) {
+	var seq, pairs uint32
+
+	if err := binary.Read(r, binary.BigEndian, &seq); err != nil {
+		return 0, nil, err
+	}
+
+	if err := binary.Read(r, binary.BigEndian, &pairs); err != nil {
+		return 0, nil, err
+	}
+
+	if pairs > ljMaxDataPairs {
+		return 0, nil, fmt.Errorf("data frame pair count %d exceeds %d", pairs, ljMaxDataPairs)
+	}
+
+	event := map[string]interface{}{}
+	readString := func() (string, error) {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return "", err
+		}
+
+		if size > ljMaxFieldSize {
+			return "", fmt.Errorf("data frame field size %d exceeds %d bytes", size, ljMaxFieldSize)
+		}
+
+		b := make([]byte, size)
+		_, err := io.ReadFull(r, b)
+
+		return string(b), err
+	}
+
+	for i := uint32(0); i < pairs; i++ {
+		key, err := readString()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if event[key], err = readString(); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return seq, event, nil
+}
+
+// Send the impstats line from the event and ack the window once complete
+func (li *lumberjackInput) handle(seq uint32, event map[string]interface{}, lc *lumberjackConn, channel syslog.LogPartsChannel) error {
+	if line, ok := event[li.key].(string); ok {
+		sendLine(channel, line, lc.client)
+	}
+
+	lc.pending++
+	if lc.pending < lc.window {
+		return nil
+	}
+
+	lc.pending = 0
+	ack := []byte{ljVersion, ljFrameAck, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(ack[2:], seq)
+	_, err := lc.conn.Write(ack)
+
+	return err
+}
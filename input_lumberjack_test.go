@@ -0,0 +1,263 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Build the Lumberjack v2 frame of the type with the big endian uint32 fields
+// followed by the payload
+func ljFrame(frameType byte, fields []uint32, payload []byte) []byte {
+	b := []byte{ljVersion, frameType}
+	for _, f := range fields {
+		b = binary.BigEndian.AppendUint32(b, f)
+	}
+
+	return append(b, payload...)
+}
+
+// Build the window frame
+func ljWindow(size uint32) []byte {
+	return ljFrame(ljFrameWindow, []uint32{size}, nil)
+}
+
+// Build the JSON frame
+func ljJSON(seq uint32, event string) []byte {
+	return ljFrame(ljFrameJSON, []uint32{seq, uint32(len(event))}, []byte(event))
+}
+
+// Build the key/value data frame
+func ljData(seq uint32, pairs ...string) []byte {
+	var payload []byte
+	for _, s := range pairs {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(len(s)))
+		payload = append(payload, s...)
+	}
+
+	return ljFrame(ljFrameData, []uint32{seq, uint32(len(pairs) / 2)}, payload)
+}
+
+// Build the compressed frame of the frames
+func ljCompressed(frames ...[]byte) []byte {
+	var b bytes.Buffer
+
+	zw := zlib.NewWriter(&b)
+	zw.Write(bytes.Join(frames, nil))
+	zw.Close()
+
+	return ljFrame(ljFrameCompress, []uint32{uint32(b.Len())}, b.Bytes())
+}
+
+// Build the ack frame
+func ljAck(seq uint32) []byte {
+	return ljFrame(ljFrameAck, []uint32{seq}, nil)
+}
+
+// lumberjackInput.readFrame
+func TestLumberjackReadFrame(t *testing.T) {
+	t.Parallel()
+
+	const line = `{"name":"main Q","size":1}`
+
+	event := `{"message":` + `"{\"name\":\"main Q\",\"size\":1}"}`
+
+	var tests = []struct {
+		name    string
+		stream  [][]byte
+		want    []string
+		acks    [][]byte
+		wantErr bool
+	}{
+		{
+			name:   "json window",
+			stream: [][]byte{ljWindow(2), ljJSON(1, event), ljJSON(2, event)},
+			want:   []string{line, line},
+			acks:   [][]byte{ljAck(2)},
+		},
+		{
+			name:   "incomplete window",
+			stream: [][]byte{ljWindow(3), ljJSON(1, event), ljJSON(2, event)},
+			want:   []string{line, line},
+		},
+		{
+			name:   "windows",
+			stream: [][]byte{ljWindow(1), ljJSON(1, event), ljWindow(1), ljJSON(2, event)},
+			want:   []string{line, line},
+			acks:   [][]byte{ljAck(1), ljAck(2)},
+		},
+		{
+			name:   "data frames",
+			stream: [][]byte{ljWindow(2), ljData(1, "message", line, "host", "rsyslog"), ljData(2, "host", "rsyslog")},
+			want:   []string{line},
+			acks:   [][]byte{ljAck(2)},
+		},
+		{
+			name:   "compressed frames",
+			stream: [][]byte{ljWindow(2), ljCompressed(ljJSON(1, event), ljData(2, "message", line))},
+			want:   []string{line, line},
+			acks:   [][]byte{ljAck(2)},
+		},
+		{
+			name:   "compressed window",
+			stream: [][]byte{ljCompressed(ljWindow(1), ljJSON(7, event))},
+			want:   []string{line},
+			acks:   [][]byte{ljAck(7)},
+		},
+		{
+			name:    "unsupported version",
+			stream:  [][]byte{{'1', ljFrameWindow, 0, 0, 0, 1}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported frame",
+			stream:  [][]byte{{ljVersion, 'X'}},
+			wantErr: true,
+		},
+		{
+			name:    "broken json",
+			stream:  [][]byte{ljWindow(1), ljJSON(1, "{")},
+			wantErr: true,
+		},
+		{
+			name:    "huge data field",
+			stream:  [][]byte{ljWindow(1), ljFrame(ljFrameData, []uint32{1, 1, ljMaxFieldSize + 1}, nil)},
+			wantErr: true,
+		},
+		{
+			name:    "huge json frame",
+			stream:  [][]byte{ljWindow(1), ljFrame(ljFrameJSON, []uint32{1, ljMaxFieldSize + 1}, nil)},
+			wantErr: true,
+		},
+		{
+			name:    "too many data pairs",
+			stream:  [][]byte{ljWindow(1), ljFrame(ljFrameData, []uint32{1, ljMaxDataPairs + 1}, nil)},
+			wantErr: true,
+		},
+		{
+			name:    "decompression bomb",
+			stream:  [][]byte{ljCompressed(bytes.Repeat(ljWindow(1), ljMaxDecompressedSize/6+1))},
+			wantErr: true,
+		},
+		{
+			name:    "nested compressed frames",
+			stream:  [][]byte{ljCompressed(ljCompressed(ljWindow(1), ljJSON(1, event)))},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		li := &lumberjackInput{key: "message"}
+		channel := make(syslog.LogPartsChannel, 10)
+
+		var acks bytes.Buffer
+
+		lc := &lumberjackConn{conn: &acks, client: "192.0.2.1:5044"}
+		r := bytes.NewReader(bytes.Join(tt.stream, nil))
+
+		var err error
+		for err == nil {
+			err = li.readFrame(r, lc, channel, false)
+		}
+
+		if gotErr := !errors.Is(err, io.EOF); gotErr != tt.wantErr {
+			t.Errorf("%s: want error %v, got %v", tt.name, tt.wantErr, err)
+		}
+
+		close(channel)
+
+		got := []string{}
+		for parts := range channel {
+			got = append(got, parts["content"].(string))
+		}
+
+		want := tt.want
+		if want == nil {
+			want = []string{}
+		}
+
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: lines mismatch (-want +got):\n%s", tt.name, diff)
+		}
+
+		if wantAcks := bytes.Join(tt.acks, nil); !bytes.Equal(wantAcks, acks.Bytes()) {
+			t.Errorf("%s: want acks %v, got %v", tt.name, wantAcks, acks.Bytes())
+		}
+	}
+}
+
+// lumberjackInput.serveListener
+func TestLumberjackInput(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := make(syslog.LogPartsChannel, 1)
+	li := &lumberjackInput{key: "message"}
+	listener := runListener(func() error { return li.serveListener(ln, channel) }, ln.Close)
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(bytes.Join([][]byte{ljWindow(1), ljData(3, "message", "stats line")}, nil))
+
+	select {
+	case parts := <-channel:
+		if parts["content"] != "stats line" {
+			t.Errorf("want line %q, got %v", "stats line", parts["content"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not received")
+	}
+
+	ack := make([]byte, 6)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := io.ReadFull(conn, ack); err != nil || !bytes.Equal(ack, ljAck(3)) {
+		t.Errorf("want ack %v, got %v, %v", ljAck(3), ack, err)
+	}
+
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed listener error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still serving")
+	}
+}
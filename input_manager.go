@@ -0,0 +1,403 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+var inputDiscarded = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rsyslog_exporter_input_discarded",
+		Help: "Amount of messages discarded as the input is paused or removed",
+	},
+	[]string{"input"},
+)
+
+// Input already exists
+var errInputExists = errors.New("input already exists")
+
+// Input is not found
+var errInputNotFound = errors.New("input not found")
+
+// Input managed at runtime
+type managedInput struct {
+	conn       string
+	input      string
+	supervisor *inputSupervisor
+	paused     atomic.Bool
+	removed    atomic.Bool
+	// Closed once the input listener is stopped
+	done chan struct{}
+	// Messages of the stopped input still sent by its open connections are
+	// discarded until none is received for the timeout
+	drainTimeout time.Duration
+}
+
+// Default drain timeout of the stopped inputs
+const inputDrainTimeout = time.Minute
+
+// Check if the input message is discarded as the input is paused or removed
+// (never for the unmanaged input)
+func (mi *managedInput) discards() bool {
+	if mi == nil || !mi.paused.Load() && !mi.removed.Load() {
+		return false
+	}
+
+	inputDiscarded.WithLabelValues(mi.input).Inc()
+
+	return true
+}
+
+// Forward the input messages unless the input is paused or removed, until
+// the input is stopped
+func (mi *managedInput) forward(in syslog.LogPartsChannel, out syslog.LogPartsChannel) {
+	for {
+		select {
+		case parts := <-in:
+			if mi.discards() {
+				continue
+			}
+
+			out <- parts
+		case <-mi.done:
+			mi.drain(in)
+			return
+		}
+	}
+}
+
+// Discard the messages of the stopped input until it's idle, so the
+// connections still open are not blocked
+func (mi *managedInput) drain(in syslog.LogPartsChannel) {
+	for {
+		select {
+		case <-in:
+			mi.discards()
+		case <-time.After(mi.drainTimeout):
+			return
+		}
+	}
+}
+
+// Input manager: adds, removes and pauses the inputs at runtime. The input
+// list is persisted to the inputs file if it's set.
+type inputManager struct {
+	syslogFormat string
+	channel      syslog.LogPartsChannel
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	file         string
+
+	mu     sync.Mutex
+	inputs []*managedInput
+	// Serialises the inputs file writes
+	persistMu sync.Mutex
+	// Removed inputs which listeners can't be stopped: they are muted and
+	// reused when added again
+	muted map[string]*managedInput
+}
+
+// Input manager constructor
+func newInputManager(syslogFormat string, channel syslog.LogPartsChannel, backoff, maxBackoff time.Duration, file string) *inputManager {
+	return &inputManager{
+		syslogFormat: syslogFormat,
+		channel:      channel,
+		backoff:      backoff,
+		maxBackoff:   maxBackoff,
+		file:         file,
+		muted:        map[string]*managedInput{},
+	}
+}
+
+// Find the input by its address (redacted or not)
+func (im *inputManager) find(input string) (int, *managedInput) {
+	for i, mi := range im.inputs {
+		if mi.input == input || mi.conn == input {
+			return i, mi
+		}
+	}
+
+	return -1, nil
+}
+
+// Get the redacted input address
+func redactedInput(conn string) (string, error) {
+	u, err := parseInputURL(conn)
+	if err != nil {
+		return "", err
+	}
+
+	return u.Redacted(), nil
+}
+
+// Add the input and start it under supervision
+func (im *inputManager) add(conn string) error {
+	input, err := redactedInput(conn)
+	if err != nil {
+		return err
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if _, mi := im.find(input); mi != nil {
+		return fmt.Errorf("%w: %s", errInputExists, input)
+	}
+
+	if mi, found := im.muted[input]; found {
+		delete(im.muted, input)
+		mi.removed.Store(false)
+		mi.paused.Store(false)
+		im.inputs = append(im.inputs, mi)
+		setInputState(mi.supervisor.u, "started")
+
+		return nil
+	}
+
+	mi := &managedInput{conn: conn, input: input, done: make(chan struct{}), drainTimeout: inputDrainTimeout}
+	in := make(syslog.LogPartsChannel)
+
+	if mi.supervisor, err = superviseInput(im.syslogFormat, conn, in, im.backoff, im.maxBackoff, mi); err != nil {
+		return err
+	}
+
+	go mi.forward(in, im.channel)
+
+	im.inputs = append(im.inputs, mi)
+
+	return nil
+}
+
+// Remove the input stopping its listener. Inputs which listeners can't be
+// stopped are muted instead.
+func (im *inputManager) remove(input string) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	i, mi := im.find(input)
+	if mi == nil {
+		return fmt.Errorf("%w: %s", errInputNotFound, input)
+	}
+
+	im.inputs = append(im.inputs[:i], im.inputs[i+1:]...)
+	mi.removed.Store(true)
+
+	if !mi.supervisor.stop() {
+		log.Printf("input %s listener can't be stopped, its messages are discarded until it's added again", mi.input)
+		im.muted[mi.input] = mi
+	} else {
+		directGates.Delete(mi.supervisor.channel)
+		close(mi.done)
+	}
+
+	deleteInputState(mi.supervisor.u)
+
+	return nil
+}
+
+// Pause or resume the input. Messages received by the paused input are
+// discarded.
+func (im *inputManager) pause(input string, paused bool) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	_, mi := im.find(input)
+	if mi == nil {
+		return fmt.Errorf("%w: %s", errInputNotFound, input)
+	}
+
+	mi.paused.Store(paused)
+
+	return nil
+}
+
+// Managed input status
+type inputStatus struct {
+	Input  string `json:"input"`
+	State  string `json:"state"`
+	Paused bool   `json:"paused"`
+}
+
+// Get the managed inputs status
+func (im *inputManager) list() []inputStatus {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	states := getInputStates()
+	rv := make([]inputStatus, 0, len(im.inputs))
+
+	for _, mi := range im.inputs {
+		rv = append(rv, inputStatus{Input: mi.input, State: states[mi.input], Paused: mi.paused.Load()})
+	}
+
+	return rv
+}
+
+// Write the input list to the inputs file (if set). The file is replaced
+// atomically, it's readable by the owner only as the addresses may contain
+// credentials.
+func (im *inputManager) persist() error {
+	if im.file == "" {
+		return nil
+	}
+
+	// the copy, the write and the rename are serialised, so an older list is
+	// never renamed over a newer one
+	im.persistMu.Lock()
+	defer im.persistMu.Unlock()
+
+	im.mu.Lock()
+
+	var b strings.Builder
+
+	b.WriteString("# Inputs managed by rsyslog_exporter admin API\n")
+
+	for _, mi := range im.inputs {
+		b.WriteString(mi.conn + "\n")
+	}
+
+	im.mu.Unlock()
+
+	tmp := im.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("cannot write inputs file: %w", err)
+	}
+
+	if err := os.Rename(tmp, im.file); err != nil {
+		return fmt.Errorf("cannot write inputs file: %w", err)
+	}
+
+	return nil
+}
+
+// Read the inputs file: an input address per line, empty lines and lines
+// starting with # are ignored. Missing file has no inputs, it's created once
+// the inputs are changed.
+func readInputsFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inputs := []string{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		inputs = append(inputs, line)
+	}
+
+	return inputs, scanner.Err()
+}
+
+// Get the HTTP status for the input manager error
+func inputErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errInputNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errInputExists):
+		return http.StatusConflict
+	}
+
+	return http.StatusBadRequest
+}
+
+// Register the input management endpoints. The input address is passed as
+// the `input` form value:
+//
+//	GET    /api/v1/inputs          list the inputs
+//	POST   /api/v1/inputs          add the input
+//	DELETE /api/v1/inputs          remove the input
+//	POST   /api/v1/inputs/pause    discard the input messages
+//	POST   /api/v1/inputs/resume   process the input messages again
+func registerInputHandlers(mux *http.ServeMux, im *inputManager, token string) {
+	change := func(w http.ResponseWriter, action string, input string, err error) {
+		if err != nil {
+			http.Error(w, err.Error(), inputErrorStatus(err))
+			return
+		}
+
+		log.Printf("input %s is %s via admin API", input, action)
+
+		if err := im.persist(); err != nil {
+			log.Print(err)
+			http.Error(w, fmt.Sprintf("input %s is %s, but %s", input, action, err), http.StatusInternalServerError)
+
+			return
+		}
+
+		fmt.Fprintf(w, "input %s is %s\n", input, action)
+	}
+
+	mux.Handle("/api/v1/inputs", instrumentHandler("inputs", requireToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := r.FormValue("input")
+
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(im.list()); err != nil {
+				log.Printf("cannot send inputs: %s", err)
+			}
+		case http.MethodPost:
+			change(w, "added", input, im.add(input))
+		case http.MethodDelete:
+			change(w, "removed", input, im.remove(input))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))))
+
+	for action, paused := range map[string]bool{"pause": true, "resume": false} {
+		action, paused := action, paused
+
+		mux.Handle("/api/v1/inputs/"+action, instrumentHandler("inputs", requireToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			input := r.FormValue("input")
+			change(w, action+"d", input, im.pause(input, paused))
+		}))))
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const multicastReadBufferSize = 64 * 1024
+
+var multicastMembership = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rsyslog_exporter_multicast_membership",
+		Help: "Whether the multicast group is joined on the interface",
+	},
+	[]string{"group", "interface"},
+)
+
+// Check if the UDP input address is a multicast group
+func isMulticastAddress(u *url.URL) bool {
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsMulticast()
+}
+
+// Multicast syslog input: udp://group:port[?iface=eth0]
+// The group is joined on the interface set (system default one otherwise).
+func multicastInputInit(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	f, err := syslogFormatByName(syslogFormat)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	var ifi *net.Interface
+
+	ifname := u.Query().Get("iface")
+	if ifname != "" {
+		if ifi, err = net.InterfaceByName(ifname); err != nil {
+			return inputListener{}, fmt.Errorf("cannot use interface %s for multicast group %s: %w", ifname, addr, err)
+		}
+	} else {
+		ifname = "default"
+	}
+
+	conn, err := net.ListenMulticastUDP("udp", ifi, addr)
+	if err != nil {
+		return inputListener{}, fmt.Errorf("cannot join multicast group %s on %s interface: %w", addr, ifname, err)
+	}
+
+	if err = conn.SetReadBuffer(multicastReadBufferSize); err != nil {
+		log.Printf("cannot set read buffer size for multicast group %s: %s", addr, err)
+	}
+
+	multicastMembership.WithLabelValues(addr.String(), ifname).Set(1)
+
+	return runListener(func() error {
+		defer multicastMembership.WithLabelValues(addr.String(), ifname).Set(0)
+		return receiveDatagrams(conn, f, channel)
+	}, conn.Close), nil
+}
+
+// Read syslog datagrams from the connection until it's closed
+func receiveDatagrams(conn net.PacketConn, f format.Format, channel syslog.LogPartsChannel) error {
+	if udpBatchSize > 1 {
+		if br := newBatchReader(conn); br != nil {
+			return receiveDatagramBatches(br, udpBatchSize, f, channel)
+		}
+	}
+
+	buf := make([]byte, datagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("cannot read datagram on %s: %s", conn.LocalAddr(), err)
+			continue
+		}
+
+		// unix datagram senders are unnamed usually
+		client := conn.LocalAddr().String()
+		if addr != nil {
+			client = addr.String()
+		}
+
+		sendSyslog(channel, f, buf[:n], client)
+	}
+}
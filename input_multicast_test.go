@@ -0,0 +1,110 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// isMulticastAddress
+func TestIsMulticastAddress(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input string
+		want  bool
+	}{
+		{"udp://239.1.2.3:5145", true},
+		{"udp://[ff02::1]:5145", true},
+		{"udp://127.0.0.1:5145", false},
+		{"udp://localhost:5145", false},
+		{"udp://:5145", false},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.input)
+
+		if got := isMulticastAddress(u); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.input, tt.want, got)
+		}
+	}
+}
+
+// multicastInputInit
+func TestMulticastInput(t *testing.T) {
+	t.Parallel()
+
+	group := "239.255.83.83:" + func() string {
+		_, port, _ := net.SplitHostPort(freeAddress(t, "udp"))
+		return port
+	}()
+
+	u, _ := url.Parse("udp://" + group)
+	channel := make(syslog.LogPartsChannel, 1)
+
+	listener, err := multicastInputInit("rfc3164", u, channel)
+	if err != nil {
+		t.Skipf("cannot join multicast group: %s", err)
+	}
+
+	if got := testutil.ToFloat64(multicastMembership.WithLabelValues(group, "default")); got != 1 {
+		t.Errorf("joined group membership: want 1, got %v", got)
+	}
+
+	conn, err := net.Dial("udp", group)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("<46>Oct 16 12:00:00 host rsyslogd-pstats: {\"name\":\"main Q\",\"size\":1}"))
+
+	select {
+	case parts := <-channel:
+		if want := `{"name":"main Q","size":1}`; parts["content"] != want {
+			t.Errorf("want content %q, got %v", want, parts["content"])
+		}
+	case <-time.After(2 * time.Second):
+		// multicast loopback may be unavailable in the sandbox
+		t.Log("multicast datagram not received")
+	}
+
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed connection error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still reading")
+	}
+
+	if got := testutil.ToFloat64(multicastMembership.WithLabelValues(group, "default")); got != 0 {
+		t.Errorf("left group membership: want 0, got %v", got)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/nats-io/nats.go"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// NATS input: nats://[user:password@]host:port?subject=...[&queue=...][&durable=...]
+// Core NATS subscription is used by default. JetStream consumer is used when
+// the durable consumer name is set, messages are acknowledged once queued
+// for parsing.
+type natsInput struct {
+	subject string
+	queue   string
+	durable string
+}
+
+// Init NATS input. The client reconnects by itself, so the input never dies.
+func natsInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+	ni := &natsInput{
+		subject: q.Get("subject"),
+		queue:   q.Get("queue"),
+		durable: q.Get("durable"),
+	}
+
+	if ni.subject == "" {
+		return inputListener{}, fmt.Errorf("subject must be set for the NATS input %s", u.Redacted())
+	}
+
+	clean := *u
+	clean.RawQuery = ""
+
+	nc, err := nats.Connect(clean.String(),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("NATS input disconnected: %s", err)
+			}
+		}),
+	)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	handler := func(msg *nats.Msg) {
+		sendLine(channel, string(msg.Data), msg.Subject)
+
+		if ni.durable != "" {
+			if err := msg.Ack(); err != nil {
+				log.Printf("NATS input cannot ack the message: %s", err)
+			}
+		}
+	}
+
+	if ni.durable == "" {
+		_, err = nc.QueueSubscribe(ni.subject, ni.queue, handler)
+	} else {
+		err = ni.subscribeJetStream(nc, handler)
+	}
+
+	if err != nil {
+		nc.Close()
+		return inputListener{}, err
+	}
+
+	return inputListener{stop: func() error {
+		nc.Close()
+		return nil
+	}}, nil
+}
+
+// Subscribe to JetStream with the durable consumer
+func (ni *natsInput) subscribeJetStream(nc *nats.Conn, handler nats.MsgHandler) error {
+	js, err := nc.JetStream()
+	if err != nil {
+		return err
+	}
+
+	opts := []nats.SubOpt{nats.Durable(ni.durable), nats.ManualAck()}
+
+	if ni.queue != "" {
+		_, err = js.QueueSubscribe(ni.subject, ni.queue, handler, opts...)
+	} else {
+		_, err = js.Subscribe(ni.subject, handler, opts...)
+	}
+
+	return err
+}
@@ -0,0 +1,131 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Fake NATS server delivering the payload to the first subscription. The
+// subscriptions are sent to the subs channel, the channel is closed once the
+// client disconnects.
+func fakeNATS(t *testing.T, payload string, subs chan<- string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		defer close(subs)
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.9.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 {
+				continue
+			}
+
+			switch fields[0] {
+			case "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case "SUB":
+				// SUB subject [queue] sid
+				subs <- strings.Join(fields[1:len(fields)-1], " ")
+				fmt.Fprintf(conn, "MSG %s %s %d\r\n%s\r\n", fields[1], fields[len(fields)-1], len(payload), payload)
+			}
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// natsInputInit
+func TestNATSInput(t *testing.T) {
+	t.Parallel()
+
+	const line = `{"name":"main Q","size":1}`
+
+	var tests = []struct {
+		query string
+		want  string
+	}{
+		{"subject=rsyslog.stats", "rsyslog.stats"},
+		{"subject=rsyslog.stats&queue=exporters", "rsyslog.stats exporters"},
+	}
+
+	for _, tt := range tests {
+		subs := make(chan string, 1)
+		u, _ := url.Parse("nats://" + fakeNATS(t, line, subs) + "?" + tt.query)
+		channel := make(syslog.LogPartsChannel, 1)
+
+		listener, err := natsInputInit(u, channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case parts := <-channel:
+			if parts["content"] != line || parts["client"] != "rsyslog.stats" {
+				t.Errorf("%s: want line %q from rsyslog.stats, got %v from %v", tt.query, line, parts["content"], parts["client"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: line not received", tt.query)
+		}
+
+		if got := <-subs; got != tt.want {
+			t.Errorf("%s: want subscription %q, got %q", tt.query, tt.want, got)
+		}
+
+		listener.stop()
+
+		// the connection is closed by the stopped input
+		select {
+		case _, open := <-subs:
+			if open {
+				t.Errorf("%s: unexpected subscription", tt.query)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: stopped input is still connected", tt.query)
+		}
+	}
+
+	u, _ := url.Parse("nats://127.0.0.1:4222")
+	if _, err := natsInputInit(u, nil); err == nil {
+		t.Error("missing subject: error expected")
+	}
+}
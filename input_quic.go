@@ -0,0 +1,134 @@
+//go:build quic
+// +build quic
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Experimental QUIC input: quic://host:port?cert=...&key=...[&ca=...][&alpn=rsyslog-stats]
+// Every stream carries newline-delimited impstats lines. Client certificates
+// are verified against the ca bundle if it's set. Build with "-tags quic" to
+// enable it.
+func quicInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+	alpn := q.Get("alpn")
+
+	if alpn == "" {
+		alpn = "rsyslog-stats"
+	}
+
+	if q.Get("cert") == "" || q.Get("key") == "" {
+		return inputListener{}, fmt.Errorf("cert and key must be set for the QUIC input %s", u.Host)
+	}
+
+	cert, err := tls.LoadX509KeyPair(q.Get("cert"), q.Get("key"))
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{alpn},
+		MinVersion:   tls.VersionTLS13,
+	}
+
+	if ca := q.Get("ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return inputListener{}, err
+		}
+
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return inputListener{}, fmt.Errorf("no certificates found in %s", ca)
+		}
+
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	ln, err := quic.ListenAddr(u.Host, tlsConfig, nil)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return serveQUIC(ln, channel, listenerName(u)) }, ln.Close), nil
+}
+
+// Serve QUIC connections until the listener is closed
+func serveQUIC(ln *quic.Listener, channel syslog.LogPartsChannel, listener string) error {
+	for {
+		conn, err := ln.Accept(context.Background())
+		if errors.Is(err, quic.ErrServerClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("QUIC input accept failed: %s", err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go quicServeConn(conn, channel, listener)
+	}
+}
+
+// Accept streams of the connection
+func quicServeConn(conn quic.Connection, channel syslog.LogPartsChannel, listener string) {
+	client := conn.RemoteAddr().String()
+	state := conn.ConnectionState().TLS
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			log.Printf("QUIC input connection from %s closed: %s", client, err)
+			return
+		}
+
+		go func() {
+			defer stream.Close()
+
+			parts := tenants.tag(format.LogParts{"client": client}, state)
+			if listenerTagged {
+				parts["listener"] = listener
+			}
+
+			if err := readLines(channel, stream, parts); err != nil {
+				log.Printf("QUIC input cannot read stream from %s: %s", client, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,35 @@
+//go:build !quic
+// +build !quic
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// QUIC input is experimental and is not built by default
+func quicInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	return inputListener{}, fmt.Errorf("%w: QUIC input is not supported by this build, rebuild with '-tags quic'", errInputAddress)
+}
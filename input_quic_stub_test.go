@@ -0,0 +1,43 @@
+//go:build !quic
+// +build !quic
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// quicInputInit (stub build)
+func TestQUICInputStub(t *testing.T) {
+	conn := "quic://127.0.0.1:0?cert=cert.pem&key=key.pem"
+	cleanupInputState(t, conn)
+
+	// the input fails at the start instead of being retried
+	if _, err := superviseInput("rfc3164", conn, make(syslog.LogPartsChannel), time.Millisecond, time.Millisecond, nil); !errors.Is(err, errInputAddress) || !strings.Contains(err.Error(), "-tags quic") {
+		t.Errorf("want input address error, got %v", err)
+	}
+}
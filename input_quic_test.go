@@ -0,0 +1,92 @@
+//go:build quic
+// +build quic
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// quicInputInit
+func TestQUICInput(t *testing.T) {
+	t.Parallel()
+
+	cert, key := writeTestCertificate(t, t.TempDir())
+	addr := freeAddress(t, "udp")
+	u, _ := url.Parse("quic://" + addr + "?cert=" + url.QueryEscape(cert) + "&key=" + url.QueryEscape(key))
+	channel := make(syslog.LogPartsChannel, 1)
+
+	listener, err := quicInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"rsyslog-stats"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.CloseWithError(0, "")
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.Write([]byte("{\"name\":\"main Q\",\"size\":1}\n"))
+	stream.Close()
+
+	select {
+	case parts := <-channel:
+		if want := `{"name":"main Q","size":1}`; parts["content"] != want {
+			t.Errorf("want line %q, got %v", want, parts["content"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not received")
+	}
+
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, quic.ErrServerClosed) {
+			t.Errorf("want closed server error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped listener is still serving")
+	}
+
+	u, _ = url.Parse("quic://" + addr)
+	if _, err := quicInputInit(u, channel); err == nil {
+		t.Error("missing certificate: error expected")
+	}
+}
@@ -0,0 +1,95 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Raw JSON lines input (omfwd with the `%msg%` template, no syslog header):
+// raw://host:port[?family=...&iface=...][&compression=auto|gzip|zlib|zstd]
+func rawInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+
+	compression := q.Get("compression")
+	if err := checkCompression(compression); err != nil {
+		return inputListener{}, err
+	}
+
+	ln, err := listenOptionsFromQuery(q).listen("tcp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return serveRawLines(ln, channel, compression, listenerName(u)) }, ln.Close), nil
+}
+
+// Serve the raw stats line streams until the listener is closed
+func serveRawLines(ln net.Listener, channel syslog.LogPartsChannel, compression string, listener string) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("cannot accept raw input connection on %s: %s", ln.Addr(), err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+
+			client := conn.RemoteAddr().String()
+
+			var r io.Reader = conn
+			if compression != compressionNone {
+				dr, used, err := newDecompressor(bufio.NewReader(conn), compression)
+				if err != nil {
+					log.Printf("cannot read %s stream from %s: %s", used, client, err)
+					return
+				}
+				defer dr.Close()
+
+				r = dr
+			}
+
+			parts := format.LogParts{"client": client}
+			if listenerTagged {
+				parts["listener"] = listener
+			}
+
+			if err := readLines(channel, r, parts); err != nil {
+				log.Printf("raw input cannot read stream from %s: %s", client, err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// serveRawLines
+func TestRawInput(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []string{compressionNone, compressionGzip} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		channel := make(syslog.LogPartsChannel, 2)
+		listener := runListener(func() error { return serveRawLines(ln, channel, compression, "raw:"+ln.Addr().String()) }, ln.Close)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := []byte("{\"name\": \"main Q\", \"size\": 1}\n\n<14>not a header\n")
+		if compression == compressionGzip {
+			gz := gzip.NewWriter(conn)
+			gz.Write(lines)
+			gz.Close()
+		} else {
+			conn.Write(lines)
+		}
+		conn.Close()
+
+		for _, want := range []string{`{"name": "main Q", "size": 1}`, "<14>not a header"} {
+			select {
+			case parts := <-channel:
+				if parts["content"] != want {
+					t.Errorf("%q: want line %q, got %v", compression, want, parts["content"])
+				}
+
+				if parts["client"] != conn.LocalAddr().String() {
+					t.Errorf("%q: want client %s, got %v", compression, conn.LocalAddr(), parts["client"])
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%q: line %q not received", compression, want)
+			}
+		}
+
+		listener.stop()
+	}
+}
+
+// serveRawLines (batch-compressed relay streams)
+func TestRawInputCompression(t *testing.T) {
+	t.Parallel()
+
+	newZstd := func(w io.Writer) io.WriteCloser {
+		zw, _ := zstd.NewWriter(w)
+		return zw
+	}
+	newGzip := func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+	var tests = []struct {
+		compression string
+		sent        string
+		newWriter   func(w io.Writer) io.WriteCloser
+	}{
+		{compressionGzip, compressionGzip, newGzip},
+		{compressionZstd, compressionZstd, newZstd},
+		{compressionAuto, compressionGzip, newGzip},
+		{compressionAuto, compressionZstd, newZstd},
+	}
+
+	line := `{"name":"main Q","origin":"core.queue","size":1,"enqueued":42,"full":0,"maxqsize":100}`
+	batch := strings.Repeat(line+"\n", 100)
+
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		channel := make(syslog.LogPartsChannel, 100)
+		listener := runListener(func() error { return serveRawLines(ln, channel, tt.compression, "raw:"+ln.Addr().String()) }, ln.Close)
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the repetitive stats compress well
+		var compressed bytes.Buffer
+		w := tt.newWriter(&compressed)
+		w.Write([]byte(batch))
+		w.Close()
+
+		if compressed.Len()*10 > len(batch) {
+			t.Errorf("%s/%s: batch of %d bytes compressed to %d", tt.compression, tt.sent, len(batch), compressed.Len())
+		}
+
+		conn.Write(compressed.Bytes())
+		conn.Close()
+
+		for i := 0; i < 100; i++ {
+			select {
+			case parts := <-channel:
+				if parts["content"] != line {
+					t.Fatalf("%s/%s: line %d: want %q, got %v", tt.compression, tt.sent, i, line, parts["content"])
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s/%s: line %d not received", tt.compression, tt.sent, i)
+			}
+		}
+
+		listener.stop()
+	}
+
+	u, _ := url.Parse("raw://127.0.0.1:0?compression=lz4")
+	if _, err := rawInputInit(u, make(syslog.LogPartsChannel)); err == nil {
+		t.Error("lz4: error expected")
+	}
+}
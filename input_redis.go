@@ -0,0 +1,186 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Redis input: redis://[:password@]host:port[/db]?channel=...|list=...[&pop=left|right]
+// Lines are consumed either from the pub/sub channel (omhiredis publish mode)
+// or from the list (omhiredis queue mode). omhiredis pushes to the left of the
+// list by default so lines are popped from the right unless pop=left is set.
+type redisInput struct {
+	addr    string
+	channel string
+	list    string
+	pop     string
+
+	mu      sync.Mutex
+	conn    redis.Conn
+	stopped bool
+}
+
+// Init Redis input
+func redisInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+	ri := &redisInput{
+		channel: q.Get("channel"),
+		list:    q.Get("list"),
+		pop:     q.Get("pop"),
+	}
+
+	if (ri.channel == "") == (ri.list == "") {
+		return inputListener{}, fmt.Errorf("either channel or list must be set for the Redis input %s", u.Redacted())
+	}
+
+	switch ri.pop {
+	case "":
+		ri.pop = "right"
+	case "left", "right":
+	default:
+		return inputListener{}, fmt.Errorf("list pop side %s is not supported by the Redis input", ri.pop)
+	}
+
+	clean := *u
+	clean.RawQuery = ""
+	ri.addr = clean.String()
+
+	conn, err := redis.DialURL(ri.addr)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	ri.conn = conn
+
+	return runListener(func() error { return ri.receive(conn, channel) }, ri.stop), nil
+}
+
+// Stop the input closing its connection, so the blocked read returns
+func (ri *redisInput) stop() error {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.stopped = true
+
+	return ri.conn.Close()
+}
+
+// Reconnect unless the input is stopped
+func (ri *redisInput) reconnect() (redis.Conn, error) {
+	conn, err := redis.DialURL(ri.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	if ri.stopped {
+		conn.Close()
+		return nil, errInputStopped
+	}
+
+	ri.conn = conn
+
+	return conn, nil
+}
+
+// Consume lines from the channel until an error occurs
+func (ri *redisInput) subscribe(conn redis.Conn, channel syslog.LogPartsChannel) error {
+	psc := redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(ri.channel); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			sendLine(channel, string(v.Data), ri.channel)
+		case error:
+			return v
+		}
+	}
+}
+
+// Consume lines from the list until an error occurs
+func (ri *redisInput) popList(conn redis.Conn, channel syslog.LogPartsChannel) error {
+	cmd := "BRPOP"
+	if ri.pop == "left" {
+		cmd = "BLPOP"
+	}
+
+	for {
+		// reply is [key, value]
+		reply, err := redis.Strings(conn.Do(cmd, ri.list, 0))
+		if err != nil {
+			return err
+		}
+
+		sendLine(channel, reply[1], ri.list)
+	}
+}
+
+// Read messages and reconnect on errors until the input is stopped
+func (ri *redisInput) receive(conn redis.Conn, channel syslog.LogPartsChannel) error {
+	for {
+		var err error
+
+		if ri.channel != "" {
+			err = ri.subscribe(conn, channel)
+		} else {
+			err = ri.popList(conn, channel)
+		}
+
+		conn.Close()
+
+		ri.mu.Lock()
+		stopped := ri.stopped
+		ri.mu.Unlock()
+
+		if stopped {
+			return errInputStopped
+		}
+
+		log.Printf("Redis input failed: %s, reconnecting", err)
+
+		for {
+			time.Sleep(time.Second)
+
+			if conn, err = ri.reconnect(); err == nil {
+				break
+			}
+
+			if errors.Is(err, errInputStopped) {
+				return err
+			}
+
+			log.Printf("Redis input reconnect failed: %s", err)
+		}
+	}
+}
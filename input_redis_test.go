@@ -0,0 +1,174 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Fake Redis server answering the first command with the reply and blocking
+// the connection afterwards (as BRPOP and SUBSCRIBE do). The command names
+// are sent to the commands channel.
+func fakeRedis(t *testing.T, reply string, commands chan<- string) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+
+				// *N\r\n followed by N bulk strings: $len\r\nvalue\r\n
+				var n int
+				if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+					return
+				}
+
+				args := make([]string, n)
+				for i := range args {
+					var size int
+					if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+						return
+					}
+
+					value := make([]byte, size+2)
+					if _, err := io.ReadFull(r, value); err != nil {
+						return
+					}
+
+					args[i] = string(value[:size])
+				}
+
+				commands <- strings.Join(args, " ")
+				conn.Write([]byte(reply))
+
+				// wait for the client to close the connection
+				r.ReadByte()
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// redisInputInit
+func TestRedisInputConfig(t *testing.T) {
+	t.Parallel()
+
+	var tests = []string{
+		"redis://127.0.0.1:6379",
+		"redis://127.0.0.1:6379?channel=stats&list=stats",
+		"redis://127.0.0.1:6379?list=stats&pop=middle",
+	}
+
+	for _, conn := range tests {
+		u, _ := url.Parse(conn)
+
+		if _, err := redisInputInit(u, nil); err == nil {
+			t.Errorf("%s: error expected", conn)
+		}
+	}
+}
+
+// redisInputInit
+func TestRedisInput(t *testing.T) {
+	t.Parallel()
+
+	const line = `{"name":"main Q","size":1}`
+
+	var tests = []struct {
+		query   string
+		reply   string
+		command string
+	}{
+		{
+			query:   "list=stats",
+			reply:   fmt.Sprintf("*2\r\n$5\r\nstats\r\n$%d\r\n%s\r\n", len(line), line),
+			command: "BRPOP stats 0",
+		},
+		{
+			query:   "list=stats&pop=left",
+			reply:   fmt.Sprintf("*2\r\n$5\r\nstats\r\n$%d\r\n%s\r\n", len(line), line),
+			command: "BLPOP stats 0",
+		},
+		{
+			query:   "channel=stats",
+			reply:   fmt.Sprintf("*3\r\n$9\r\nsubscribe\r\n$5\r\nstats\r\n:1\r\n*3\r\n$7\r\nmessage\r\n$5\r\nstats\r\n$%d\r\n%s\r\n", len(line), line),
+			command: "SUBSCRIBE stats",
+		},
+	}
+
+	for _, tt := range tests {
+		commands := make(chan string, 1)
+		u, _ := url.Parse("redis://" + fakeRedis(t, tt.reply, commands) + "?" + tt.query)
+		channel := make(syslog.LogPartsChannel, 1)
+
+		listener, err := redisInputInit(u, channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case parts := <-channel:
+			if parts["content"] != line {
+				t.Errorf("%s: want line %q, got %v", tt.query, line, parts["content"])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: line not received", tt.query)
+		}
+
+		if got := <-commands; got != tt.command {
+			t.Errorf("%s: want command %q, got %q", tt.query, tt.command, got)
+		}
+
+		listener.stop()
+
+		select {
+		case err := <-listener.died:
+			if !errors.Is(err, errInputStopped) {
+				t.Errorf("%s: want input stopped error, got %v", tt.query, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: stopped input is still receiving", tt.query)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// RELP commands
+const (
+	relpOpen     = "open"
+	relpSyslog   = "syslog"
+	relpClose    = "close"
+	relpResponse = "rsp"
+)
+
+// Max RELP frame data size (librelp max message size is 128KiB by default)
+const relpMaxDataSize = 1 << 20
+
+// Offers sent in the open command response
+const relpOffers = "relp_version=0\nrelp_software=rsyslog_exporter\ncommands=" + relpSyslog
+
+// RELP input (omrelp output): relp://host:port[?family=...&iface=...]
+// Every syslog message is acknowledged once it's queued for parsing, so
+// rsyslog resends the messages not acknowledged after the reconnect.
+func relpInputInit(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	f, err := syslogFormatByName(syslogFormat)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	ln, err := listenOptionsFromQuery(u.Query()).listen("tcp", u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return serveRELP(ln, f, channel) }, ln.Close), nil
+}
+
+// Serve RELP sessions until the listener is closed
+func serveRELP(ln net.Listener, f format.Format, channel syslog.LogPartsChannel) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("cannot accept RELP connection on %s: %s", ln.Addr(), err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+
+			if err := serveRELPSession(conn, f, channel); err != nil {
+				log.Printf("RELP session with %s failed: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// RELP frame: TXNR SP COMMAND SP DATALEN [SP DATA] LF
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+}
+
+// Handle the RELP session commands until the client closes it
+func serveRELPSession(conn net.Conn, f format.Format, channel syslog.LogPartsChannel) error {
+	client := conn.RemoteAddr().String()
+	r := bufio.NewReader(conn)
+	opened := false
+
+	for {
+		frame, err := readRELPFrame(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case frame.command == relpOpen:
+			opened = true
+			err = writeRELPFrame(conn, relpFrame{frame.txnr, relpResponse, []byte("200 OK\n" + relpOffers)})
+		case frame.command == relpClose:
+			writeRELPFrame(conn, relpFrame{frame.txnr, relpResponse, nil})
+			return nil
+		case !opened:
+			return fmt.Errorf("%s command before the session is opened", frame.command)
+		case frame.command == relpSyslog:
+			sendSyslog(channel, f, frame.data, client)
+			err = writeRELPFrame(conn, relpFrame{frame.txnr, relpResponse, []byte("200 OK")})
+		default:
+			err = writeRELPFrame(conn, relpFrame{frame.txnr, relpResponse, []byte("500 command " + frame.command + " is not supported")})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read the space or LF terminated frame header field
+func readRELPField(r *bufio.Reader) (string, byte, error) {
+	field := make([]byte, 0, 16)
+
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(field) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return "", 0, err
+		}
+
+		if c == ' ' || c == '\n' {
+			return string(field), c, nil
+		}
+
+		// TXNR and DATALEN are up to 9 digits, commands are up to 32 chars
+		if len(field) == 32 {
+			return "", 0, fmt.Errorf("RELP frame header field %q... is too long", field)
+		}
+
+		field = append(field, c)
+	}
+}
+
+// Read the RELP frame
+func readRELPFrame(r *bufio.Reader) (relpFrame, error) {
+	var frame relpFrame
+
+	txnr, _, err := readRELPField(r)
+	if err != nil {
+		return frame, err
+	}
+
+	if frame.txnr, err = strconv.Atoi(txnr); err != nil || frame.txnr < 0 {
+		return frame, fmt.Errorf("wrong RELP transaction number %q", txnr)
+	}
+
+	if frame.command, _, err = readRELPField(r); err != nil {
+		return frame, err
+	}
+
+	datalen, sep, err := readRELPField(r)
+	if err != nil {
+		return frame, err
+	}
+
+	size, err := strconv.Atoi(datalen)
+	if err != nil || size < 0 || size > relpMaxDataSize {
+		return frame, fmt.Errorf("wrong RELP data length %q", datalen)
+	}
+
+	if size > 0 {
+		if sep != ' ' {
+			return frame, fmt.Errorf("RELP frame %d data is missing", frame.txnr)
+		}
+
+		frame.data = make([]byte, size)
+		if _, err := io.ReadFull(r, frame.data); err != nil {
+			return frame, err
+		}
+
+		sep, err = r.ReadByte()
+		if err != nil {
+			return frame, err
+		}
+	}
+
+	if sep != '\n' {
+		return frame, fmt.Errorf("RELP frame %d trailer is missing", frame.txnr)
+	}
+
+	return frame, nil
+}
+
+// Write the RELP frame
+func writeRELPFrame(w io.Writer, frame relpFrame) error {
+	header := strconv.Itoa(frame.txnr) + " " + frame.command + " " + strconv.Itoa(len(frame.data))
+	if len(frame.data) > 0 {
+		header += " "
+	}
+
+	_, err := w.Write(append(append([]byte(header), frame.data...), '\n'))
+
+	return err
+}
@@ -0,0 +1,134 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// readRELPFrame
+func TestReadRELPFrame(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input string
+		want  relpFrame
+		ok    bool
+	}{
+		{"1 open 5 a=b\nc\n", relpFrame{1, "open", []byte("a=b\nc")}, true},
+		{"2 close 0\n", relpFrame{2, "close", nil}, true},
+		{"3 syslog 2 ab", relpFrame{}, false},
+		{"3 syslog 2 abc\n", relpFrame{}, false},
+		{"x syslog 2 ab\n", relpFrame{}, false},
+		{"4 syslog 2\n", relpFrame{}, false},
+		{"5 syslog 99999999999 ab\n", relpFrame{}, false},
+		{"6 " + strings.Repeat("a", 40) + " 0\n", relpFrame{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := readRELPFrame(bufio.NewReader(strings.NewReader(tt.input)))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: want ok %v, got error %v", tt.input, tt.ok, err)
+			continue
+		}
+
+		if tt.ok {
+			if diff := cmp.Diff(tt.want, got, cmp.AllowUnexported(relpFrame{})); diff != "" {
+				t.Errorf("%q: frame mismatch (-want +got):\n%s", tt.input, diff)
+			}
+		}
+	}
+}
+
+// serveRELP
+func TestRELPInput(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := make(syslog.LogPartsChannel, 1)
+	listener := runListener(func() error { return serveRELP(ln, syslog.RFC3164, channel) }, ln.Close)
+	defer listener.stop()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+
+	exchange := func(frame relpFrame) relpFrame {
+		if err := writeRELPFrame(conn, frame); err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := readRELPFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rsp.txnr != frame.txnr || rsp.command != relpResponse {
+			t.Errorf("%s: unexpected response %d %s", frame.command, rsp.txnr, rsp.command)
+		}
+
+		return rsp
+	}
+
+	if rsp := exchange(relpFrame{1, relpOpen, []byte("relp_version=0\nrelp_software=librelp\ncommands=syslog")}); !strings.HasPrefix(string(rsp.data), "200 OK\n") || !strings.Contains(string(rsp.data), "commands=syslog") {
+		t.Errorf("open: unexpected response %q", rsp.data)
+	}
+
+	if rsp := exchange(relpFrame{2, relpSyslog, []byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}")}); string(rsp.data) != "200 OK" {
+		t.Errorf("syslog: unexpected response %q", rsp.data)
+	}
+
+	select {
+	case line := <-channel:
+		if line["hostname"] != "host1" || line["content"] != "{}" {
+			t.Errorf("unexpected message %v", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("message not received")
+	}
+
+	if rsp := exchange(relpFrame{3, "starttls", nil}); !strings.HasPrefix(string(rsp.data), "500 ") {
+		t.Errorf("starttls: unexpected response %q", rsp.data)
+	}
+
+	if rsp := exchange(relpFrame{4, relpClose, nil}); len(rsp.data) != 0 {
+		t.Errorf("close: unexpected response %q", rsp.data)
+	}
+
+	if _, err := r.ReadByte(); err == nil {
+		t.Error("want connection closed by the server")
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Input supervisor: starts the input retrying with exponential backoff (the
+// interface is not up at boot yet, the address is in use e.g.) and restarts
+// it when its listener dies
+type inputSupervisor struct {
+	syslogFormat string
+	u            *url.URL
+	channel      syslog.LogPartsChannel
+	// Initial and max delay between the start attempts
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mu       sync.Mutex
+	listener inputListener
+	running  bool
+	stopped  bool
+}
+
+// Start the input under supervision. Wrong input addresses are returned as
+// errors, other start errors are retried in background. Lines decoded directly
+// by the input (see readLines) are checked against the gate if it's set.
+func superviseInput(syslogFormat string, conn string, channel syslog.LogPartsChannel, backoff, maxBackoff time.Duration, gate *managedInput) (*inputSupervisor, error) {
+	u, err := parseInputURL(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	is := &inputSupervisor{
+		syslogFormat: syslogFormat,
+		u:            u,
+		channel:      inputChannel(u, channel),
+		backoff:      backoff,
+		maxBackoff:   maxBackoff,
+	}
+
+	if gate != nil {
+		directGates.Store(is.channel, gate)
+	}
+
+	listener, err := inputStart(is.syslogFormat, is.u, is.channel)
+	if errors.Is(err, errInputAddress) {
+		return nil, err
+	}
+
+	is.listener, is.running = listener, err == nil
+
+	go is.run(listener.died, err)
+
+	return is, nil
+}
+
+// Get the next delay doubling the current one up to the max backoff
+func (is *inputSupervisor) nextDelay(delay time.Duration) time.Duration {
+	if delay *= 2; delay > is.maxBackoff {
+		return is.maxBackoff
+	}
+
+	return delay
+}
+
+// Mark the input listener died. Returns true if it's stopped.
+func (is *inputSupervisor) markDied() bool {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	is.running = false
+
+	return is.stopped
+}
+
+// Stop the input and its supervision. Returns false if the input listener
+// can't be stopped, so it keeps running until the exporter exits.
+func (is *inputSupervisor) stop() bool {
+	is.mu.Lock()
+	is.stopped = true
+	listener, running := is.listener, is.running
+	is.mu.Unlock()
+
+	// nothing to stop if the input is not started
+	if !running {
+		return true
+	}
+
+	if listener.stop == nil {
+		return false
+	}
+
+	if err := listener.stop(); err != nil {
+		log.Printf("cannot stop input %s: %s", is.u.Redacted(), err)
+	}
+
+	return true
+}
+
+// Start the input again unless it's stopped
+func (is *inputSupervisor) restart() (<-chan error, error) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+
+	if is.stopped {
+		return nil, errInputStopped
+	}
+
+	inputRestarts.WithLabelValues(is.u.Redacted()).Inc()
+
+	listener, err := inputStart(is.syslogFormat, is.u, is.channel)
+	is.listener, is.running = listener, err == nil
+
+	return listener.died, err
+}
+
+// The input is stopped, it's not restarted anymore
+var errInputStopped = errors.New("input is stopped")
+
+// Retry the input start until it succeeds, wait for the listener death and
+// start it again. The delay is reset once the listener survives the max
+// backoff, so the listener dying right after the start is not restarted in
+// a busy loop.
+func (is *inputSupervisor) run(died <-chan error, err error) {
+	input := is.u.Redacted()
+	delay := is.backoff
+
+	for {
+		if err != nil {
+			log.Printf("cannot start input %s: %s, retrying in %s", input, err, delay)
+		} else {
+			// inputs not reporting their listener errors are done
+			if died == nil {
+				return
+			}
+
+			started := time.Now()
+			err = <-died
+
+			if is.markDied() {
+				return
+			}
+
+			if time.Since(started) > is.maxBackoff {
+				delay = is.backoff
+			}
+
+			setInputState(is.u, "died")
+			log.Printf("input %s listener died: %s, restarting in %s", input, err, delay)
+		}
+
+		time.Sleep(delay)
+		delay = is.nextDelay(delay)
+
+		if died, err = is.restart(); errors.Is(err, errInputStopped) {
+			return
+		}
+	}
+}
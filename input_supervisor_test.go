@@ -0,0 +1,137 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Remove the input state set by the test, so the tests comparing all input
+// states are not affected (tests starting inputs must not be parallel)
+func cleanupInputState(t *testing.T, input string) {
+	t.Cleanup(func() {
+		inputStatesLock.Lock()
+		delete(inputStates, input)
+		inputStatesLock.Unlock()
+	})
+}
+
+// superviseInput
+func TestSuperviseInputWrongAddress(t *testing.T) {
+	for _, conn := range []string{"foo://127.0.0.1:5145", "udp://::1:5145"} {
+		cleanupInputState(t, conn)
+
+		_, err := superviseInput("rfc3164", conn, make(syslog.LogPartsChannel), time.Millisecond, time.Millisecond, nil)
+		if !errors.Is(err, errInputAddress) {
+			t.Errorf("%s: want wrong input address error, got %v", conn, err)
+		}
+	}
+}
+
+// superviseInput
+func TestSuperviseInputRetry(t *testing.T) {
+	// the address is in use until the blocker is closed
+	blocker, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := "tcp://" + blocker.Addr().String() + "?family=ipv4"
+	cleanupInputState(t, conn)
+	restarts := testutil.ToFloat64(inputRestarts.WithLabelValues(conn))
+
+	if _, err := superviseInput("rfc3164", conn, make(syslog.LogPartsChannel), 10*time.Millisecond, 50*time.Millisecond, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if state := getInputStates()[conn]; state != "failed" {
+		t.Errorf("state: want failed, got %s", state)
+	}
+
+	blocker.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for getInputStates()[conn] != "started" {
+		if time.Now().After(deadline) {
+			t.Fatalf("input %s is not restarted", conn)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := testutil.ToFloat64(inputUp.WithLabelValues(conn)); got != 1 {
+		t.Errorf("rsyslog_exporter_input_up: want 1, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(inputRestarts.WithLabelValues(conn)) - restarts; got < 1 {
+		t.Errorf("rsyslog_exporter_input_restarts: want at least 1, got %v", got)
+	}
+}
+
+// inputSupervisor.nextDelay
+func TestInputSupervisorNextDelay(t *testing.T) {
+	t.Parallel()
+
+	is := &inputSupervisor{backoff: time.Second, maxBackoff: 5 * time.Second}
+
+	var tests = []struct {
+		delay time.Duration
+		want  time.Duration
+	}{
+		{time.Second, 2 * time.Second},
+		{2 * time.Second, 4 * time.Second},
+		{4 * time.Second, 5 * time.Second},
+		{5 * time.Second, 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := is.nextDelay(tt.delay); got != tt.want {
+			t.Errorf("nextDelay(%s): want %s, got %s", tt.delay, tt.want, got)
+		}
+	}
+}
+
+// serveSyslogTCP
+func TestServeSyslogTCPClosed(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener := runListener(func() error { return serveSyslogTCP(ln, syslog.RFC3164, make(syslog.LogPartsChannel), compressionNone) }, ln.Close)
+	listener.stop()
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed listener error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("serveSyslogTCP is not returned on the closed listener")
+	}
+}
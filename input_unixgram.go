@@ -0,0 +1,171 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Unix datagram socket input (omuxsock, /dev/log-style):
+// unixgram:///run/rsyslog_exporter.sock?mode=0660&owner=syslog&group=adm
+// The stale socket file left by the previous run is removed on start.
+func unixgramInputInit(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	f, err := syslogFormatByName(syslogFormat)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	path := u.Path
+	if path == "" {
+		return inputListener{}, fmt.Errorf("%w %s: socket path must be set", errInputAddress, u)
+	}
+
+	q := u.Query()
+
+	mode, err := socketMode(q.Get("mode"))
+	if err != nil {
+		return inputListener{}, fmt.Errorf("%w %s: %s", errInputAddress, u, err)
+	}
+
+	uid, gid, err := socketOwner(q.Get("owner"), q.Get("group"))
+	if err != nil {
+		return inputListener{}, fmt.Errorf("%w %s: %s", errInputAddress, u, err)
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return inputListener{}, err
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	// the socket file is removed on close by the stream listeners only
+	stop := func() error {
+		err := conn.Close()
+		os.Remove(path)
+
+		return err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			stop()
+			return inputListener{}, fmt.Errorf("cannot set socket %s mode: %w", path, err)
+		}
+	}
+
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			stop()
+			return inputListener{}, fmt.Errorf("cannot set socket %s owner: %w", path, err)
+		}
+	}
+
+	return runListener(func() error { return receiveDatagrams(conn, f, channel) }, stop), nil
+}
+
+// Parse the octal socket file mode (0 keeps the umask one)
+func socketMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0o777 {
+		return 0, fmt.Errorf("wrong socket mode %s, octal permissions expected (0660 e.g.)", mode)
+	}
+
+	return os.FileMode(m), nil
+}
+
+// Get the socket file owner and group IDs by their names or IDs (-1 keeps
+// the current ones)
+func socketOwner(owner, group string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+
+	if owner != "" {
+		if _, err := strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return uid, gid, err
+			}
+
+			owner = u.Uid
+		}
+
+		uid, _ = strconv.Atoi(owner)
+	}
+
+	if group != "" {
+		if _, err := strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return uid, gid, err
+			}
+
+			group = g.Gid
+		}
+
+		gid, _ = strconv.Atoi(group)
+	}
+
+	return uid, gid, nil
+}
+
+// Remove the socket file nobody listens on (left by the killed exporter).
+// Other files and the sockets in use are kept.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unixgram", path)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+
+	if !errors.Is(err, syscall.ECONNREFUSED) {
+		return fmt.Errorf("cannot check socket %s: %w", path, err)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,97 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// unixgramInputInit
+func TestUnixgramInput(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.sock")
+
+	// stale socket left by the killed exporter
+	stale, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	u, _ := parseInputURL("unixgram://" + path + "?mode=0620")
+	channel := make(syslog.LogPartsChannel, 1)
+
+	listener, err := unixgramInputInit("rfc3164", u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o620 {
+		t.Errorf("want socket mode 0620, got %v (%v)", fi.Mode(), err)
+	}
+
+	if _, err := unixgramInputInit("rfc3164", u, channel); err == nil {
+		t.Error("socket in use: error expected")
+	}
+
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}"))
+
+	select {
+	case line := <-channel:
+		if line["hostname"] != "host1" || line["content"] != "{}" {
+			t.Errorf("unexpected message %v", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("message not received")
+	}
+
+	listener.stop()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want socket removed on stop, got %v", err)
+	}
+
+	// regular files are never removed
+	os.WriteFile(path, nil, 0o600)
+
+	if _, err := unixgramInputInit("rfc3164", u, channel); err == nil {
+		t.Error("regular file: error expected")
+	}
+
+	for _, conn := range []string{"unixgram://" + path + "?mode=999", "unixgram://" + path + "?owner=no-such-user-here", "unixgram://"} {
+		u, _ := parseInputURL(conn)
+		if _, err := unixgramInputInit("rfc3164", u, channel); err == nil {
+			t.Errorf("%s: error expected", conn)
+		}
+	}
+}
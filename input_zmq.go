@@ -0,0 +1,166 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// ZeroMQ input: zmq://host:port[?type=sub|pull][&bind=true][&topic=...]
+// SUB socket connecting to the omczmq PUB endpoint is used by default.
+type zmqInput struct {
+	endpoint string
+	sockType string
+	bind     bool
+	topics   []string
+	// Cancelled once the input is stopped
+	ctx context.Context
+}
+
+// Init ZeroMQ input
+func zmqInputInit(u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	q := u.Query()
+	zi := &zmqInput{
+		endpoint: "tcp://" + u.Host,
+		sockType: q.Get("type"),
+		bind:     q.Get("bind") == "true",
+		topics:   q["topic"],
+	}
+
+	if zi.sockType == "" {
+		zi.sockType = "sub"
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	zi.ctx = ctx
+
+	sock, err := zi.open()
+	if err != nil {
+		cancel()
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return zi.receive(sock, channel) }, func() error {
+		cancel()
+		return nil
+	}), nil
+}
+
+// Open the socket and connect or bind it to the endpoint
+func (zi *zmqInput) open() (zmq4.Socket, error) {
+	var sock zmq4.Socket
+
+	switch zi.sockType {
+	case "sub":
+		sock = zmq4.NewSub(zi.ctx, zmq4.WithDialerRetry(time.Second))
+	case "pull":
+		sock = zmq4.NewPull(zi.ctx, zmq4.WithDialerRetry(time.Second))
+	default:
+		return nil, fmt.Errorf("socket type %s is not supported by the ZeroMQ input", zi.sockType)
+	}
+
+	var err error
+	if zi.bind {
+		err = sock.Listen(zi.endpoint)
+	} else {
+		err = sock.Dial(zi.endpoint)
+	}
+
+	if err != nil {
+		sock.Close()
+		return nil, err
+	}
+
+	if zi.sockType == "sub" {
+		topics := zi.topics
+		if len(topics) == 0 {
+			topics = []string{""}
+		}
+
+		for _, topic := range topics {
+			if err := sock.SetOption(zmq4.OptionSubscribe, topic); err != nil {
+				sock.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return sock, nil
+}
+
+// Strip the topic omczmq prepends to the message when topicframe is off
+func (zi *zmqInput) stripTopic(msg zmq4.Msg) string {
+	// topic is sent as a separate frame
+	line := string(msg.Frames[len(msg.Frames)-1])
+	if len(msg.Frames) > 1 {
+		return line
+	}
+
+	for _, topic := range zi.topics {
+		if topic != "" && strings.HasPrefix(line, topic) {
+			return line[len(topic):]
+		}
+	}
+
+	return line
+}
+
+// Read messages and reconnect on errors until the input is stopped
+func (zi *zmqInput) receive(sock zmq4.Socket, channel syslog.LogPartsChannel) error {
+	for {
+		msg, err := sock.Recv()
+		if err == nil {
+			if len(msg.Frames) > 0 {
+				sendLine(channel, zi.stripTopic(msg), zi.endpoint)
+			}
+			continue
+		}
+
+		sock.Close()
+
+		if zi.ctx.Err() != nil {
+			return zi.ctx.Err()
+		}
+
+		log.Printf("ZeroMQ input %s failed: %s, reconnecting", zi.endpoint, err)
+
+		for {
+			select {
+			case <-zi.ctx.Done():
+				return zi.ctx.Err()
+			case <-time.After(time.Second):
+			}
+
+			if sock, err = zi.open(); err == nil {
+				break
+			}
+
+			log.Printf("ZeroMQ input %s reconnect failed: %s", zi.endpoint, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-zeromq/zmq4"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// zmqInput.stripTopic
+func TestZMQStripTopic(t *testing.T) {
+	t.Parallel()
+
+	zi := &zmqInput{topics: []string{"", "stats."}}
+
+	var tests = []struct {
+		name   string
+		frames []string
+		want   string
+	}{
+		{"no topic", []string{`{"name":"main Q"}`}, `{"name":"main Q"}`},
+		{"topic prefix", []string{`stats.{"name":"main Q"}`}, `{"name":"main Q"}`},
+		{"topic frame", []string{"stats.", `{"name":"main Q"}`}, `{"name":"main Q"}`},
+		{"other prefix", []string{`other{"name":"main Q"}`}, `other{"name":"main Q"}`},
+	}
+
+	for _, tt := range tests {
+		msg := zmq4.NewMsgFromString(tt.frames)
+
+		if got := zi.stripTopic(msg); got != tt.want {
+			t.Errorf("%s: want %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+// zmqInputInit
+func TestZMQInput(t *testing.T) {
+	t.Parallel()
+
+	addr := freeAddress(t, "tcp")
+	u, _ := url.Parse("zmq://" + addr + "?type=pull&bind=true")
+	channel := make(syslog.LogPartsChannel, 1)
+
+	listener, err := zmqInputInit(u, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	push := zmq4.NewPush(context.Background())
+	defer push.Close()
+
+	if err := push.Dial("tcp://" + addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := push.Send(zmq4.NewMsgString(`{"name":"main Q","size":1}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case parts := <-channel:
+		if want := `{"name":"main Q","size":1}`; parts["content"] != want {
+			t.Errorf("want line %q, got %v", want, parts["content"])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("line not received")
+	}
+
+	if err := listener.stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("want cancelled input error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped input is still receiving")
+	}
+}
@@ -0,0 +1,261 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Input states by the redacted input address
+var (
+	inputStates     = map[string]string{}
+	inputStatesLock sync.RWMutex
+)
+
+// Set the input state
+func setInputState(u *url.URL, state string) {
+	inputStatesLock.Lock()
+	inputStates[u.Redacted()] = state
+	inputStatesLock.Unlock()
+
+	if state == "started" {
+		inputUp.WithLabelValues(u.Redacted()).Set(1)
+	} else {
+		inputUp.WithLabelValues(u.Redacted()).Set(0)
+	}
+}
+
+// Delete the input state once the input is removed
+func deleteInputState(u *url.URL) {
+	inputStatesLock.Lock()
+	delete(inputStates, u.Redacted())
+	inputStatesLock.Unlock()
+
+	inputUp.DeleteLabelValues(u.Redacted())
+}
+
+// Get the copy of the input states
+func getInputStates() map[string]string {
+	inputStatesLock.RLock()
+	defer inputStatesLock.RUnlock()
+
+	rv := make(map[string]string, len(inputStates))
+	for input, state := range inputStates {
+		rv[input] = state
+	}
+
+	return rv
+}
+
+// Attach the listener label to the metrics ingested
+var listenerLabel bool
+
+// Tag the messages with the listener name: set if the listener label or the
+// per-listener self-metrics are enabled
+var listenerTagged bool
+
+// Get the listener name: input scheme and address (udp:0.0.0.0:5145 e.g.)
+func listenerName(u *url.URL) string {
+	return u.Scheme + ":" + u.Host + u.Path
+}
+
+// Tag the messages received by the input with the listener name
+func tagListener(in syslog.LogPartsChannel, out syslog.LogPartsChannel, listener string) {
+	for parts := range in {
+		parts["listener"] = listener
+		out <- parts
+	}
+}
+
+var (
+	inputUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_input_up",
+			Help: "Whether the input is started",
+		},
+		[]string{"input"},
+	)
+
+	inputRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_input_restarts",
+			Help: "Amount of input start retries after failures and restarts after the listener died",
+		},
+		[]string{"input"},
+	)
+)
+
+// Metrics exported by the inputs
+var inputMetrics = []prometheus.Collector{
+	multicastMembership,
+	inputUp,
+	inputRestarts,
+	inputDiscarded,
+}
+
+// Input address errors are configuration errors, such inputs are not retried
+var errInputAddress = errors.New("wrong input address")
+
+// Parse the input address
+func parseInputURL(conn string) (*url.URL, error) {
+	u, err := parseListenURL(conn)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %s", errInputAddress, conn, err)
+	}
+
+	return u, nil
+}
+
+// Get the channel to send the input messages to
+func inputChannel(u *url.URL, channel syslog.LogPartsChannel) syslog.LogPartsChannel {
+	if listenerTagged {
+		tagged := make(syslog.LogPartsChannel)
+		go tagListener(tagged, channel, listenerName(u))
+		channel = tagged
+	}
+
+	return channel
+}
+
+// Init the input by its address scheme. All inputs share the same channel.
+func inputInit(syslogFormat string, conn string, channel syslog.LogPartsChannel) error {
+	u, err := parseInputURL(conn)
+	if err != nil {
+		return err
+	}
+
+	_, err = inputStart(syslogFormat, u, inputChannel(u, channel))
+
+	return err
+}
+
+// Running input listener
+type inputListener struct {
+	// The listener error is sent here when it dies (nil if the input doesn't
+	// report it)
+	died <-chan error
+	// Stop the listener (nil if the input can't be stopped)
+	stop func() error
+}
+
+// Start the input
+func inputStart(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (listener inputListener, err error) {
+	switch u.Scheme {
+	case "udp":
+		if isMulticastAddress(u) {
+			listener, err = multicastInputInit(syslogFormat, u, channel)
+		} else {
+			listener, err = syslogServerInit(syslogFormat, u, channel)
+		}
+	case "tcp", "tls":
+		listener, err = syslogServerInit(syslogFormat, u, channel)
+	case "zmq":
+		listener, err = zmqInputInit(u, channel)
+	case "redis":
+		listener, err = redisInputInit(u, channel)
+	case "nats":
+		listener, err = natsInputInit(u, channel)
+	case "amqp", "amqps":
+		listener, err = amqpInputInit(u, channel)
+	case "fluent":
+		listener, err = fluentInputInit(u, channel)
+	case "lumberjack":
+		listener, err = lumberjackInputInit(u, channel)
+	case "gelf", "gelf+tcp":
+		listener, err = gelfInputInit(u, channel)
+	case "quic":
+		listener, err = quicInputInit(u, channel)
+	case "file":
+		listener, err = fileInputInit(u, channel)
+	case "unixgram":
+		listener, err = unixgramInputInit(syslogFormat, u, channel)
+	case "relp":
+		listener, err = relpInputInit(syslogFormat, u, channel)
+	case "raw":
+		listener, err = rawInputInit(u, channel)
+	case "dtls":
+		listener, err = dtlsListenerInit(syslogFormat, u, channel)
+	default:
+		err = fmt.Errorf("%w: %s", errInputAddress, u.Redacted())
+	}
+
+	if err != nil {
+		setInputState(u, "failed")
+	} else {
+		setInputState(u, "started")
+	}
+
+	return listener, err
+}
+
+// Send the raw stats line received by a non-syslog input to the channel
+func sendLine(channel syslog.LogPartsChannel, line string, client string) {
+	channel <- format.LogParts{
+		"content": line,
+		"client":  client,
+	}
+}
+
+// Parse the syslog message received by the exporter's own listeners (the same
+// way go-syslog server does) and send it to the channel
+func sendSyslog(channel syslog.LogPartsChannel, f format.Format, msg []byte, client string) {
+	if logParts := parseSyslog(f, msg, client); logParts != nil {
+		channel <- logParts
+	}
+}
+
+// Parse the syslog message (nil if it's empty)
+func parseSyslog(f format.Format, msg []byte, client string) format.LogParts {
+	// ignore trailing control characters and NULs
+	n := len(msg)
+	for ; n > 0 && msg[n-1] < 32; n-- {
+	}
+
+	if n == 0 {
+		return nil
+	}
+
+	parser := f.GetParser(msg[:n])
+	if err := parser.Parse(); err != nil {
+		log.Printf("cannot parse syslog message from %s: %s", client, err)
+	}
+
+	logParts := parser.Dump()
+	logParts["client"] = client
+
+	if logParts["hostname"] == "" && f == syslog.RFC3164 {
+		if i := strings.LastIndex(client, ":"); i > 1 {
+			logParts["hostname"] = client[:i]
+		} else {
+			logParts["hostname"] = client
+		}
+	}
+
+	return logParts
+}
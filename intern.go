@@ -0,0 +1,79 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// Max amount of distinct strings interned, the pool is reset once it's full
+const maxInternedStrings = 1 << 20
+
+// String interning pool. Every parse allocates its own copy of the metric
+// names and label names and values, the stored series keep them alive. The
+// strings stored are interned, so millions of identical sender/queue strings
+// share the single copy.
+type stringPool struct {
+	mu      sync.Mutex
+	strings map[string]string
+}
+
+// Get the interned copy of the string
+func (p *stringPool) intern(s string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if interned, found := p.strings[s]; found {
+		return interned
+	}
+
+	if p.strings == nil || len(p.strings) >= maxInternedStrings {
+		p.strings = make(map[string]string)
+	}
+
+	// the string may point into the whole line received
+	s = strings.Clone(s)
+	p.strings[s] = s
+
+	return s
+}
+
+// Get the labels with the interned names and values
+func (p *stringPool) internLabels(l RsyslogStatsLabels) RsyslogStatsLabels {
+	return RsyslogStatsLabels{Name: p.intern(l.Name), Value: p.intern(l.Value)}
+}
+
+// Drop the strings interned, so the ones of the series gone are released.
+// The series kept are re-interned once they are updated.
+func (p *stringPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.strings = nil
+}
+
+// Amount of the strings interned
+func (p *stringPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.strings)
+}
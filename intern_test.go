@@ -0,0 +1,84 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// stringPool.intern
+func TestStringPoolIntern(t *testing.T) {
+	t.Parallel()
+
+	var p stringPool
+
+	line := `{"name":"main Q"}`
+	first := p.intern(line[9:15])
+	second := p.intern(strings.Clone("main Q"))
+
+	if unsafe.StringData(first) != unsafe.StringData(second) {
+		t.Error("identical strings must share the storage")
+	}
+
+	if unsafe.StringData(first) == unsafe.StringData(line[9:15]) {
+		t.Error("interned string must not point into the line")
+	}
+
+	if got := p.size(); got != 1 {
+		t.Errorf("size: want 1, got %d", got)
+	}
+
+	p.reset()
+
+	if got := p.size(); got != 0 {
+		t.Errorf("size after reset: want 0, got %d", got)
+	}
+}
+
+// RsyslogStats.add (interning)
+func TestRsyslogStatsInterning(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+
+	// every parse allocates its own copy of the label values
+	for _, family := range []string{"rsyslog_core_queue_size", "rsyslog_core_queue_enqueued"} {
+		labels := RsyslogStatsLabels{Name: "name", Value: strings.Clone("main Q")}
+		rs.add(RsyslogStatsMetrics{family: {labels: 1}})
+	}
+
+	values := map[*byte]bool{}
+
+	for _, family := range []string{"rsyslog_core_queue_size", "rsyslog_core_queue_enqueued"} {
+		if len(rs.Metrics[family]) != 1 {
+			t.Fatalf("%s: want 1 series, got %d", family, len(rs.Metrics[family]))
+		}
+
+		for labels := range rs.Metrics[family] {
+			values[unsafe.StringData(labels.Value)] = true
+		}
+	}
+
+	if len(values) != 1 {
+		t.Errorf("label values of the families must share the storage, got %d copies", len(values))
+	}
+}
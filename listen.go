@@ -0,0 +1,385 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Listener address families
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+	familyDual = "dual"
+)
+
+// Listener options set by the syslog input URL query or the HTTP listener flags:
+//
+//	family: ipv4, ipv6 (IPv6 only) or dual (IPv4 and IPv6 on the [::] socket)
+//	iface:  bind the socket to the network interface (Linux only)
+type listenOptions struct {
+	Family    string
+	Interface string
+	ReusePort bool
+}
+
+// Get the listener options from the URL query
+func listenOptionsFromQuery(q url.Values) listenOptions {
+	return listenOptions{Family: q.Get("family"), Interface: q.Get("iface")}
+}
+
+// Check if any option is set
+func (lo listenOptions) isSet() bool {
+	return lo.Family != "" || lo.Interface != ""
+}
+
+// Get the network for the protocol (tcp, udp) and check the address matches
+// the family
+func (lo listenOptions) network(proto string, addr string) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", fmt.Errorf("invalid listen address %s: %w", addr, err)
+	}
+
+	ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+
+	switch lo.Family {
+	case "":
+		return proto, nil
+	case familyIPv4:
+		if ip != nil && ip.To4() == nil {
+			return "", fmt.Errorf("IPv6 address %s cannot be used with the %s family", host, lo.Family)
+		}
+
+		return proto + "4", nil
+	case familyIPv6:
+		if ip != nil && ip.To4() != nil {
+			return "", fmt.Errorf("IPv4 address %s cannot be used with the %s family", host, lo.Family)
+		}
+
+		return proto + "6", nil
+	case familyDual:
+		if host != "" && !(ip != nil && ip.To4() == nil && ip.IsUnspecified()) {
+			return "", fmt.Errorf("dual-stack listener requires [::] or empty host, got %s", host)
+		}
+
+		return proto, nil
+	}
+
+	return "", fmt.Errorf("unknown address family %s (ipv4, ipv6, dual)", lo.Family)
+}
+
+// Get the listen config applying the socket options
+func (lo listenOptions) listenConfig() (net.ListenConfig, error) {
+	lc := net.ListenConfig{}
+
+	if lo.Interface == "" && !lo.ReusePort {
+		return lc, nil
+	}
+
+	if lo.Interface != "" {
+		if _, err := net.InterfaceByName(lo.Interface); err != nil {
+			return lc, fmt.Errorf("cannot bind to interface %s: %w", lo.Interface, err)
+		}
+	}
+
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		var err error
+
+		if lo.ReusePort {
+			if e := c.Control(func(fd uintptr) { err = reusePort(fd) }); e != nil {
+				return e
+			}
+
+			if err != nil {
+				return fmt.Errorf("cannot set SO_REUSEPORT: %w", err)
+			}
+		}
+
+		if lo.Interface == "" {
+			return nil
+		}
+
+		if e := c.Control(func(fd uintptr) { err = bindToDevice(fd, lo.Interface) }); e != nil {
+			return e
+		}
+
+		if err != nil {
+			return fmt.Errorf("cannot bind to interface %s: %w", lo.Interface, err)
+		}
+
+		return nil
+	}
+
+	return lc, nil
+}
+
+// Listen on the stream address (tcp)
+func (lo listenOptions) listen(proto string, addr string) (net.Listener, error) {
+	network, err := lo.network(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lc, err := lo.listenConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// Listen on the datagram address (udp)
+func (lo listenOptions) listenPacket(proto string, addr string) (net.PacketConn, error) {
+	network, err := lo.network(proto, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	lc, err := lo.listenConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return lc.ListenPacket(context.Background(), network, addr)
+}
+
+// IPv6 zone in the URL host not escaped as %25 ([fe80::1%eth0] e.g.)
+var reUnescapedZone = regexp.MustCompile(`(\[[0-9a-fA-F:.]+)%([^2\]]|2[^5])`)
+
+// Parse the listen URL. IPv6 literals must be enclosed in brackets, the zone
+// may be set as is ([fe80::1%eth0]:5145) or escaped ([fe80::1%25eth0]:5145).
+func parseListenURL(conn string) (*url.URL, error) {
+	u, err := url.Parse(reUnescapedZone.ReplaceAllString(conn, "$1%25$2"))
+	if err != nil {
+		return nil, err
+	}
+
+	if host := u.Host; !strings.HasPrefix(host, "[") && strings.Count(host, ":") > 1 {
+		return nil, fmt.Errorf("IPv6 address in %s must be enclosed in brackets ([::1]:5145 e.g.)", conn)
+	}
+
+	return u, nil
+}
+
+// Serve syslog over the TCP listener (the same way go-syslog server does)
+// until the listener is closed. Compressed streams are decompressed before
+// the messages are split (compression is detected per connection with auto).
+// TLS connections are tagged with the tenant of the connection identity.
+// Messages are split by the TCP framing set.
+func serveSyslogTCP(ln net.Listener, f format.Format, channel syslog.LogPartsChannel, compression string) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("cannot accept syslog connection on %s: %s", ln.Addr(), err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go func() {
+			defer conn.Close()
+
+			client := conn.RemoteAddr().String()
+
+			var state *tls.ConnectionState
+			if tc, ok := conn.(*tls.Conn); ok {
+				if err := tlsHandshake(tc, ln.Addr().String()); err != nil {
+					log.Printf("TLS handshake with %s failed: %s", client, err)
+					return
+				}
+
+				cs := tc.ConnectionState()
+				state = &cs
+			}
+
+			var r io.Reader = conn
+			if compression != compressionNone {
+				dr, used, err := newDecompressor(bufio.NewReader(conn), compression)
+				if err != nil {
+					log.Printf("cannot read %s stream from %s: %s", used, client, err)
+					return
+				}
+				defer dr.Close()
+
+				r = dr
+			}
+
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(nil, maxSyslogMessageSize+16)
+			scanner.Split(syslogSplitFunc(tcpFraming, f))
+
+			for scanner.Scan() {
+				if state == nil {
+					sendSyslog(channel, f, scanner.Bytes(), client)
+				} else if parts := parseSyslog(f, scanner.Bytes(), client); parts != nil {
+					channel <- tenants.tag(parts, *state)
+				}
+			}
+
+			// wrong framing, the stream can't be resynchronised
+			if err := scanner.Err(); err != nil {
+				log.Printf("cannot read syslog stream from %s: %s", client, err)
+			}
+		}()
+	}
+}
+
+// Delay before accepting the next connection after the accept error (too
+// many open files e.g.)
+const acceptRetryDelay = 100 * time.Millisecond
+
+// Run the listener loop until it's stopped by the stop function
+func runListener(loop func() error, stop func() error) inputListener {
+	died := make(chan error, 1)
+	go func() { died <- loop() }()
+
+	return inputListener{died: died, stop: stop}
+}
+
+// Syslog listener with the socket options: udp://host:port?family=...&iface=...
+// TCP streams may be compressed: tcp://host:port?compression=auto|gzip|zlib|zstd
+// UDP datagrams may be read by parallel sockets: udp://host:port?readers=4
+// TLS listener (tls://host:port) serves TCP syslog with -syslog-tls-* config.
+func syslogListenerInit(f format.Format, u *url.URL, lo listenOptions, channel syslog.LogPartsChannel) (inputListener, error) {
+	compression := u.Query().Get("compression")
+	if err := checkCompression(compression); err != nil {
+		return inputListener{}, err
+	}
+
+	readers := 1
+	if r := u.Query().Get("readers"); r != "" {
+		var err error
+		if readers, err = strconv.Atoi(r); err != nil || readers < 1 || readers > maxUDPReaders {
+			return inputListener{}, fmt.Errorf("%w %s: readers must be 1..%d", errInputAddress, u, maxUDPReaders)
+		}
+
+		if u.Scheme != "udp" {
+			return inputListener{}, fmt.Errorf("%w %s: readers are supported by UDP syslog listeners only", errInputAddress, u)
+		}
+	}
+
+	switch u.Scheme {
+	case "udp":
+		if compression != compressionNone {
+			return inputListener{}, fmt.Errorf("compression is not supported by UDP syslog listener %s", u)
+		}
+
+		if readers > 1 {
+			return udpReadersInit(f, u, lo, readers, channel)
+		}
+
+		conn, err := lo.listenPacket(u.Scheme, u.Host)
+		if err != nil {
+			return inputListener{}, err
+		}
+
+		return runListener(func() error { return receiveDatagrams(conn, f, channel) }, conn.Close), nil
+	case "tcp":
+		ln, err := lo.listen(u.Scheme, u.Host)
+		if err != nil {
+			return inputListener{}, err
+		}
+
+		return runListener(func() error { return serveSyslogTCP(ln, f, channel, compression) }, ln.Close), nil
+	case "tls":
+		if syslogTLSConfig == nil {
+			return inputListener{}, fmt.Errorf("%w %s: -syslog-tls-cert and -syslog-tls-key must be set", errInputAddress, u.Redacted())
+		}
+
+		ln, err := lo.listen("tcp", u.Host)
+		if err != nil {
+			return inputListener{}, err
+		}
+
+		ln = tls.NewListener(ln, syslogTLSConfig)
+
+		return runListener(func() error { return serveSyslogTCP(ln, f, channel, compression) }, ln.Close), nil
+	}
+
+	return inputListener{}, fmt.Errorf("wrong syslog address: %s", u)
+}
+
+// Upper limit of the UDP syslog listener sockets sharing the port
+const maxUDPReaders = 64
+
+// UDP syslog listener with the sockets sharing the port by SO_REUSEPORT, one
+// reader goroutine per socket. The first socket resolves the port (:0 e.g.),
+// the rest bind to it.
+func udpReadersInit(f format.Format, u *url.URL, lo listenOptions, readers int, channel syslog.LogPartsChannel) (inputListener, error) {
+	lo.ReusePort = true
+	conns := make([]net.PacketConn, 0, readers)
+
+	stop := func() error {
+		var rv error
+
+		for _, conn := range conns {
+			if err := conn.Close(); err != nil && rv == nil {
+				rv = err
+			}
+		}
+
+		return rv
+	}
+
+	addr := u.Host
+	for i := 0; i < readers; i++ {
+		conn, err := lo.listenPacket(u.Scheme, addr)
+		if err != nil {
+			stop()
+			return inputListener{}, err
+		}
+
+		conns = append(conns, conn)
+		addr = conn.LocalAddr().String()
+	}
+
+	loop := func() error {
+		died := make(chan error, len(conns))
+		for _, conn := range conns {
+			go func(conn net.PacketConn) { died <- receiveDatagrams(conn, f, channel) }(conn)
+		}
+
+		// readers return only once their sockets are closed by stop
+		return <-died
+	}
+
+	return runListener(loop, stop), nil
+}
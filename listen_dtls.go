@@ -0,0 +1,195 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v2/udp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// DTLS sessions are connectionless, so the sessions of the senders gone
+// silently are closed once they are idle for this long
+const dtlsIdleTimeout = time.Hour
+
+// Get the DTLS config of the TLS syslog listener config
+func dtlsConfig(config *tls.Config) *dtls.Config {
+	dc := &dtls.Config{
+		Certificates:         config.Certificates,
+		ClientCAs:            config.ClientCAs,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+		},
+	}
+
+	if config.ClientAuth == tls.RequireAndVerifyClientCert {
+		dc.ClientAuth = dtls.RequireAndVerifyClientCert
+	}
+
+	return dc
+}
+
+// Accept the new sessions of the handshake datagrams only, the rest are
+// the datagrams of the sessions closed or not known
+func isDTLSHandshake(packet []byte) bool {
+	records, err := recordlayer.UnpackDatagram(packet)
+	if err != nil || len(records) < 1 {
+		return false
+	}
+
+	h := &recordlayer.Header{}
+	if err := h.Unmarshal(records[0]); err != nil {
+		return false
+	}
+
+	return h.ContentType == protocol.ContentTypeHandshake
+}
+
+// DTLS syslog listener (RFC 6012): dtls://host:port[?family=...]
+// The -syslog-tls-* config is used, the messages may be octet-counted.
+func dtlsListenerInit(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	f, err := syslogFormatByName(syslogFormat)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	if syslogTLSConfig == nil {
+		return inputListener{}, fmt.Errorf("%w %s: -syslog-tls-cert and -syslog-tls-key must be set", errInputAddress, u.Redacted())
+	}
+
+	lo := listenOptionsFromQuery(u.Query())
+	if lo.Interface != "" {
+		return inputListener{}, fmt.Errorf("%w %s: iface is not supported by DTLS listener", errInputAddress, u)
+	}
+
+	network, err := lo.network("udp", u.Host)
+	if err != nil {
+		return inputListener{}, fmt.Errorf("%w %s: %s", errInputAddress, u, err)
+	}
+
+	addr, err := net.ResolveUDPAddr(network, u.Host)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	lc := udp.ListenConfig{AcceptFilter: isDTLSHandshake}
+	ln, err := lc.Listen(network, addr)
+	if err != nil {
+		return inputListener{}, err
+	}
+
+	return runListener(func() error { return serveDTLS(ln, f, channel, dtlsConfig(syslogTLSConfig)) }, ln.Close), nil
+}
+
+// Serve DTLS sessions until the listener is closed. Handshakes are done in
+// the session goroutines, so slow senders don't block the rest.
+func serveDTLS(ln net.Listener, f format.Format, channel syslog.LogPartsChannel, config *dtls.Config) error {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) || errors.Is(err, udp.ErrClosedListener) {
+			return net.ErrClosed
+		}
+
+		if err != nil {
+			log.Printf("cannot accept DTLS session on %s: %s", ln.Addr(), err)
+			time.Sleep(acceptRetryDelay)
+
+			continue
+		}
+
+		go serveDTLSSession(conn, f, channel, config, ln.Addr().String())
+	}
+}
+
+// Read the syslog messages of the DTLS session. Every record may carry
+// several octet-counted or LF-terminated messages.
+func serveDTLSSession(conn net.Conn, f format.Format, channel syslog.LogPartsChannel, config *dtls.Config, listener string) {
+	client := conn.RemoteAddr().String()
+
+	dc, err := dtls.Server(conn, config)
+	if err != nil {
+		tlsHandshakeFailures.WithLabelValues(listener).Inc()
+		log.Printf("DTLS handshake with %s failed: %s", client, err)
+		conn.Close()
+
+		return
+	}
+	defer dc.Close()
+
+	state := dtlsConnectionState(dc.ConnectionState(), config)
+	buf := make([]byte, datagramSize)
+
+	for {
+		dc.SetReadDeadline(time.Now().Add(dtlsIdleTimeout))
+
+		n, err := dc.Read(buf)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("DTLS session with %s closed: %s", client, err)
+			}
+
+			return
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
+		scanner.Buffer(nil, datagramSize+16)
+		scanner.Split(syslogSplitFunc(framingAuto, f))
+
+		for scanner.Scan() {
+			if parts := parseSyslog(f, scanner.Bytes(), client); parts != nil {
+				channel <- tenants.tag(parts, state)
+			}
+		}
+	}
+}
+
+// Get the TLS connection state of the DTLS session to map the client
+// certificate CN to the tenant. The certificates are verified by the
+// handshake if the client ones are required.
+func dtlsConnectionState(state dtls.State, config *dtls.Config) tls.ConnectionState {
+	cs := tls.ConnectionState{}
+
+	if config.ClientAuth != dtls.RequireAndVerifyClientCert || len(state.PeerCertificates) == 0 {
+		return cs
+	}
+
+	if cert, err := x509.ParseCertificate(state.PeerCertificates[0]); err == nil {
+		cs.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+
+	return cs
+}
@@ -0,0 +1,93 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/v2/udp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// serveDTLS
+func TestSyslogDTLSListener(t *testing.T) {
+	t.Parallel()
+
+	cert, key := writeTestCertificate(t, t.TempDir())
+
+	config, err := loadSyslogTLSConfig(cert, key, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lc := udp.ListenConfig{AcceptFilter: isDTLSHandshake}
+	ln, err := lc.Listen("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := make(syslog.LogPartsChannel, 3)
+	listener := runListener(func() error { return serveDTLS(ln, syslog.RFC3164, channel, dtlsConfig(config)) }, ln.Close)
+	defer listener.stop()
+
+	pair, _ := tls.LoadX509KeyPair(cert, key)
+	clientConfig := &dtls.Config{
+		RootCAs:              config.ClientCAs,
+		Certificates:         []tls.Certificate{pair},
+		ServerName:           "localhost",
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	conn, err := dtls.Dial("udp4", ln.Addr().(*net.UDPAddr), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// plain and octet-counted (RFC 6012) records, several messages per record
+	conn.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n"))
+	conn.Write([]byte("45 <14>Oct 16 10:00:00 host2 rsyslogd-pstats: {}45 <14>Oct 16 10:00:00 host3 rsyslogd-pstats: {}"))
+
+	for _, host := range []string{"host1", "host2", "host3"} {
+		select {
+		case line := <-channel:
+			if line["hostname"] != host || line["content"] != "{}" {
+				t.Errorf("%s: unexpected message %v", host, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: message not received", host)
+		}
+	}
+
+	state := dtlsConnectionState(dtls.State{PeerCertificates: [][]byte{pair.Certificate[0]}}, dtlsConfig(config))
+	if len(state.VerifiedChains) != 1 || state.VerifiedChains[0][0].Subject.CommonName != "localhost" {
+		t.Errorf("want verified client certificate in connection state, got %v", state.VerifiedChains)
+	}
+
+	u, _ := url.Parse("dtls://127.0.0.1:0?iface=lo")
+	if _, err := dtlsListenerInit("rfc3164", u, channel); err == nil {
+		t.Error("dtls w/o certificate or with iface: error expected")
+	}
+}
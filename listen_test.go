@@ -0,0 +1,313 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// parseListenURL
+func TestParseListenURL(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input string
+		host  string
+		err   bool
+	}{
+		{"udp://0.0.0.0:5145", "0.0.0.0:5145", false},
+		{"udp://[::]:5145", "[::]:5145", false},
+		{"udp://[fe80::1%eth0]:5145", "[fe80::1%eth0]:5145", false},
+		{"udp://[fe80::1%25eth0]:5145", "[fe80::1%eth0]:5145", false},
+		{"tcp://[fe80::1%2]:5145?family=ipv6", "[fe80::1%2]:5145", false},
+		{"udp://::1:5145", "", true},
+		{"udp://[::1:5145", "", true},
+	}
+
+	for _, tt := range tests {
+		u, err := parseListenURL(tt.input)
+		if (err != nil) != tt.err {
+			t.Errorf("parseListenURL(%s): want error %v, got %v", tt.input, tt.err, err)
+			continue
+		}
+
+		if err == nil && u.Host != tt.host {
+			t.Errorf("parseListenURL(%s): want host %s, got %s", tt.input, tt.host, u.Host)
+		}
+	}
+}
+
+// listenOptions.network
+func TestListenOptionsNetwork(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		family  string
+		addr    string
+		network string
+		err     bool
+	}{
+		{"", "0.0.0.0:5145", "udp", false},
+		{"ipv4", ":5145", "udp4", false},
+		{"ipv4", "127.0.0.1:5145", "udp4", false},
+		{"ipv4", "[::1]:5145", "", true},
+		{"ipv6", "[::]:5145", "udp6", false},
+		{"ipv6", "[fe80::1%eth0]:5145", "udp6", false},
+		{"ipv6", "0.0.0.0:5145", "", true},
+		{"dual", "[::]:5145", "udp", false},
+		{"dual", ":5145", "udp", false},
+		{"dual", "0.0.0.0:5145", "", true},
+		{"dual", "[::1]:5145", "", true},
+		{"ipx", ":5145", "", true},
+		{"", "5145", "", true},
+	}
+
+	for _, tt := range tests {
+		network, err := listenOptions{Family: tt.family}.network("udp", tt.addr)
+		if (err != nil) != tt.err {
+			t.Errorf("network(%s, %s): want error %v, got %v", tt.family, tt.addr, tt.err, err)
+			continue
+		}
+
+		if network != tt.network {
+			t.Errorf("network(%s, %s): want %s, got %s", tt.family, tt.addr, tt.network, network)
+		}
+	}
+}
+
+// listenOptions.listen
+func TestListenOptionsInterface(t *testing.T) {
+	t.Parallel()
+
+	if _, err := (listenOptions{Interface: "no-such-iface0"}).listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("listen: want error for unknown interface")
+	}
+}
+
+// syslogListenerInit
+func TestSyslogListenerInit(t *testing.T) {
+	t.Parallel()
+
+	for _, proto := range []string{"udp", "tcp"} {
+		channel := make(syslog.LogPartsChannel, 1)
+		lo := listenOptions{Family: familyIPv4}
+
+		// find a free port
+		ln, err := lo.listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		addr := ln.Addr().String()
+		ln.Close()
+
+		u, err := parseListenURL(fmt.Sprintf("%s://%s?family=ipv4", proto, addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := syslogListenerInit(syslog.RFC3164, u, lo, channel); err != nil {
+			t.Fatal(err)
+		}
+
+		conn, err := net.Dial(proto, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := conn.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case line := <-channel:
+			if line["hostname"] != "host1" || line["content"] != "{}" {
+				t.Errorf("%s: unexpected message %v", proto, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: message not received", proto)
+		}
+
+		conn.Close()
+	}
+}
+
+// udpReadersInit
+func TestSyslogListenerReaders(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT is supported on Linux only")
+	}
+
+	// find a free port
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	channel := make(syslog.LogPartsChannel, 16)
+	u, _ := parseListenURL("udp://" + addr + "?readers=4")
+
+	listener, err := syslogListenerInit(syslog.RFC3164, u, listenOptions{}, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the datagrams are balanced by the sender address, so send them from
+	// many sockets
+	const senders = 16
+	for i := 0; i < senders; i++ {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := conn.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n")); err != nil {
+			t.Fatal(err)
+		}
+
+		conn.Close()
+	}
+
+	for i := 0; i < senders; i++ {
+		select {
+		case line := <-channel:
+			if line["content"] != "{}" {
+				t.Errorf("unexpected message %v", line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not received", i)
+		}
+	}
+
+	if err := listener.stop(); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case err := <-listener.died:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("readers not stopped")
+	}
+
+	for _, conn := range []string{"udp://127.0.0.1:0?readers=0", "udp://127.0.0.1:0?readers=x", "tcp://127.0.0.1:0?readers=2"} {
+		u, _ := parseListenURL(conn)
+		if _, err := syslogListenerInit(syslog.RFC3164, u, listenOptions{}, channel); !errors.Is(err, errInputAddress) {
+			t.Errorf("%s: want input address error, got %v", conn, err)
+		}
+	}
+}
+
+// serveSyslogTCP (compressed streams)
+func TestSyslogListenerCompression(t *testing.T) {
+	t.Parallel()
+
+	message := []byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n")
+
+	compress := map[string]func(w io.Writer) io.WriteCloser{
+		compressionNone: func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+		compressionGzip: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		compressionZlib: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		compressionZstd: func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+	}
+
+	for _, compression := range []string{compressionAuto, compressionGzip, compressionZlib, compressionZstd} {
+		channel := make(syslog.LogPartsChannel, 1)
+
+		u, _ := parseListenURL("tcp://127.0.0.1:0?compression=" + compression)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listener := runListener(func() error { return serveSyslogTCP(ln, syslog.RFC3164, channel, u.Query().Get("compression")) }, ln.Close)
+
+		for sent, newWriter := range compress {
+			// fixed compressions accept their streams only
+			if compression != compressionAuto && compression != sent {
+				continue
+			}
+
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w := newWriter(conn)
+			w.Write(message)
+			w.Close()
+			conn.(*net.TCPConn).CloseWrite()
+
+			select {
+			case line := <-channel:
+				if line["content"] != "{}" {
+					t.Errorf("%s/%s: unexpected message %v", compression, sent, line)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s/%s: message not received", compression, sent)
+			}
+
+			conn.Close()
+		}
+
+		listener.stop()
+	}
+
+	u, _ := parseListenURL("udp://127.0.0.1:0?compression=gzip")
+	if _, err := syslogListenerInit(syslog.RFC3164, u, listenOptions{}, make(syslog.LogPartsChannel)); err == nil {
+		t.Error("udp: error expected")
+	}
+
+	u, _ = parseListenURL("tcp://127.0.0.1:0?compression=lz4")
+	if _, err := syslogListenerInit(syslog.RFC3164, u, listenOptions{}, make(syslog.LogPartsChannel)); err == nil {
+		t.Error("lz4: error expected")
+	}
+}
+
+// WriteCloser w/o the close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close nothing
+func (nopWriteCloser) Close() error {
+	return nil
+}
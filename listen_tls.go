@@ -0,0 +1,98 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TLS config of the tls:// syslog listeners (nil if the certificate is not set)
+var syslogTLSConfig *tls.Config
+
+// Slow or stuck clients must not hold the connections forever
+const tlsHandshakeTimeout = 10 * time.Second
+
+var tlsHandshakeFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rsyslog_exporter_tls_handshake_failures",
+		Help: "Amount of TLS handshakes failed per syslog listener",
+	},
+	[]string{"listener"},
+)
+
+// Metrics exported by the TLS syslog listeners
+var tlsMetrics = []prometheus.Collector{
+	tlsHandshakeFailures,
+}
+
+// Load the TLS syslog listener config. Client certificates are required and
+// verified against the CA bundle if it's set.
+func loadSyslogTLSConfig(cert, key, ca string) (*tls.Config, error) {
+	if cert == "" || key == "" {
+		return nil, fmt.Errorf("both syslog TLS certificate and key must be set")
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{pair},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", ca)
+		}
+
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Complete the TLS handshake of the accepted connection, failures are counted
+// per listener
+func tlsHandshake(conn *tls.Conn, listener string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+
+	err := conn.HandshakeContext(ctx)
+	if err != nil {
+		tlsHandshakeFailures.WithLabelValues(listener).Inc()
+	}
+
+	return err
+}
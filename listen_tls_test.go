@@ -0,0 +1,175 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// Write the self-signed certificate (usable by the server, the clients and as
+// the CA) and its key to the directory
+func writeTestCertificate(t *testing.T, dir string) (cert string, key string) {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+
+	return cert, key
+}
+
+// serveSyslogTCP (TLS listener)
+func TestSyslogTLSListener(t *testing.T) {
+	t.Parallel()
+
+	cert, key := writeTestCertificate(t, t.TempDir())
+
+	config, err := loadSyslogTLSConfig(cert, key, cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	channel := make(syslog.LogPartsChannel, 1)
+	tlsLn := tls.NewListener(ln, config)
+	listener := runListener(func() error { return serveSyslogTCP(tlsLn, syslog.RFC3164, channel, compressionNone) }, tlsLn.Close)
+	defer listener.stop()
+
+	pair, _ := tls.LoadX509KeyPair(cert, key)
+	clientConfig := &tls.Config{RootCAs: config.ClientCAs, Certificates: []tls.Certificate{pair}, ServerName: "localhost"}
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n"))
+	conn.Close()
+
+	select {
+	case line := <-channel:
+		if line["hostname"] != "host1" || line["content"] != "{}" {
+			t.Errorf("unexpected message %v", line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not received")
+	}
+
+	// plain TCP and clients w/o certificate fail the handshake
+	failures := testutil.ToFloat64(tlsHandshakeFailures.WithLabelValues(ln.Addr().String()))
+
+	plain, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain.Write([]byte("<14>Oct 16 10:00:00 host1 rsyslogd-pstats: {}\n"))
+	plain.Close()
+
+	anonymous, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{RootCAs: config.ClientCAs, ServerName: "localhost"})
+	if err == nil {
+		anonymous.Read(make([]byte, 1))
+		anonymous.Close()
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(tlsHandshakeFailures.WithLabelValues(ln.Addr().String())) < failures+2 {
+		if time.Now().After(deadline) {
+			t.Fatal("want 2 handshake failures counted")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// loadSyslogTLSConfig
+func TestLoadSyslogTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cert, key := writeTestCertificate(t, dir)
+
+	if config, err := loadSyslogTLSConfig(cert, key, ""); err != nil || config.ClientAuth != tls.NoClientCert {
+		t.Errorf("want config w/o client certificates, got %v", err)
+	}
+
+	if config, err := loadSyslogTLSConfig(cert, key, cert); err != nil || config.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Errorf("want config requiring client certificates, got %v", err)
+	}
+
+	for _, files := range [][3]string{{cert, "", ""}, {cert, cert, ""}, {cert, key, key}, {cert, key, filepath.Join(dir, "missing.pem")}} {
+		if _, err := loadSyslogTLSConfig(files[0], files[1], files[2]); err == nil {
+			t.Errorf("%v: error expected", files)
+		}
+	}
+
+	u, _ := url.Parse("tls://127.0.0.1:0")
+	if _, err := syslogListenerInit(syslog.RFC3164, u, listenOptions{}, make(syslog.LogPartsChannel)); err == nil {
+		t.Error("tls w/o certificate: error expected")
+	}
+}
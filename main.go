@@ -20,16 +20,21 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
-	_ "net/http/pprof"
-
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -44,58 +49,193 @@ var (
 	builtBy = "unknown"
 )
 
-// Init syslog server
-func syslogServerInit(syslogFormat string, conn string) (*syslog.Server, syslog.LogPartsChannel, error) {
-	channel := make(syslog.LogPartsChannel)
-	handler := syslog.NewChannelHandler(channel)
-	server := syslog.NewServer()
-
-	var format format.Format
-
+// Get syslog format by name
+func syslogFormatByName(syslogFormat string) (format.Format, error) {
 	switch syslogFormat {
 	case "rfc3164":
-		format = syslog.RFC3164
+		return syslog.RFC3164, nil
 	case "rfc5424":
-		format = syslog.RFC5424
-	default:
-		return nil, nil, fmt.Errorf("format %s is not supported", syslogFormat)
+		return syslog.RFC5424, nil
 	}
 
-	server.SetFormat(format)
-	server.SetHandler(handler)
+	return nil, fmt.Errorf("format %s is not supported", syslogFormat)
+}
 
-	url, err := url.Parse(conn)
+// Init syslog server
+func syslogServerInit(syslogFormat string, u *url.URL, channel syslog.LogPartsChannel) (inputListener, error) {
+	format, err := syslogFormatByName(syslogFormat)
 	if err != nil {
-		return nil, nil, err
+		return inputListener{}, err
+	}
+
+	// socket options, parallel readers, compression, TLS tenants and
+	// octet-counted framing are not supported by go-syslog server
+	if lo := listenOptionsFromQuery(u.Query()); lo.isSet() || u.Query().Get("readers") != "" || u.Query().Get("compression") != "" || u.Scheme == "tls" || (u.Scheme == "tcp" && tcpFraming != framingLF) {
+		return syslogListenerInit(format, u, lo, channel)
 	}
 
-	switch url.Scheme {
+	handler := syslog.NewChannelHandler(channel)
+	server := syslog.NewServer()
+
+	server.SetFormat(format)
+	server.SetHandler(handler)
+
+	// go-syslog server doesn't report the listener errors. UDP server can't
+	// be stopped safely: Kill() races with the datagram reader.
+	var listener inputListener
+
+	switch u.Scheme {
 	case "udp":
-		err = server.ListenUDP(url.Host)
+		err = server.ListenUDP(u.Host)
 	case "tcp":
-		err = server.ListenTCP(url.Host)
+		err = server.ListenTCP(u.Host)
+		listener.stop = server.Kill
 	default:
-		err = fmt.Errorf("wrong syslog address: %s", conn)
+		err = fmt.Errorf("wrong syslog address: %s", u)
 	}
 
 	if err != nil {
-		return nil, nil, err
+		return inputListener{}, err
 	}
 
-	err = server.Boot()
-	if err != nil {
-		return nil, nil, err
+	return listener, server.Boot()
+}
+
+// Get the message sender: syslog hostname or the client address
+func messageSender(line format.LogParts) string {
+	if hostname, ok := line["hostname"].(string); ok && hostname != "" {
+		return hostname
 	}
 
-	return server, channel, nil
+	return messagePeer(line)
 }
 
-func processSyslogMessages(rs *RsyslogStats, channel syslog.LogPartsChannel) {
+// Get the message content: RFC3164 "content" or RFC5424 "message"
+func messageContent(line format.LogParts) (string, bool) {
+	if content, ok := line["content"].(string); ok {
+		return content, true
+	}
+
+	content, ok := line["message"].(string)
+
+	return content, ok
+}
+
+// Get the labels attached to all metrics of the message
+func messageLabels(line format.LogParts) RsyslogStatsLabels {
+	l := RsyslogStatsLabels{}
+
+	sd, _ := line["structured_data"].(string)
+	sdLabels := sdMapping.Extract(sd)
+
+	// Labels are attached to all messages to keep the label sets consistent
+	_, sdTenant := sdLabels["tenant"]
+	if tenants != nil || sdTenant {
+		// TLS connection identity takes precedence over the structured data
+		// and the source network
+		tenant, ok := line["tenant"].(string)
+		if !ok || tenant == unknownTenant {
+			tenant = sdLabels["tenant"]
+		}
+
+		if tenant == "" && tenants != nil {
+			client, _ := line["client"].(string)
+			tenant, _ = tenants.NetworkTenant(client)
+		}
+
+		if tenant == "" {
+			tenant = unknownTenant
+		}
+
+		l = l.With("tenant", tenant)
+	}
+
+	if listenerLabel {
+		listener, _ := line["listener"].(string)
+		l = l.With("listener", listener)
+	}
+
+	// Messages read from the files matching the glob file input pattern
+	if file, ok := line["file"].(string); ok {
+		l = l.With("file", file)
+	}
+
+	for _, name := range sdMapping.Labels() {
+		if name != "tenant" {
+			l = l.With(name, sdLabels[name])
+		}
+	}
+
+	return l
+}
+
+// Parse stats messages, non-stats ones are forwarded if the relay is set
+func processSyslogMessages(rs *RsyslogStats, channel syslog.LogPartsChannel, relay *syslogRelay) {
 	for line := range channel {
-		rs.Parse(line["content"].(string))
+		processingSince.Store(time.Now().UnixNano())
+		processSyslogMessage(rs, line, relay)
+		processingSince.Store(0)
 	}
 }
 
+// Parse the stats message recovering the parser panics
+func processSyslogMessage(rs *RsyslogStats, line format.LogParts, relay *syslogRelay) {
+	content, ok := messageContent(line)
+	if !ok {
+		return
+	}
+
+	client, _ := line["client"].(string)
+	defer recoverParser(client, content)
+
+	if relay != nil && !relay.isStats(line, content) {
+		relay.forward(line, content)
+		return
+	}
+
+	now := time.Now()
+	if !rateLimiter.Allow(messagePeer(line), now) {
+		return
+	}
+
+	sender, data := senderSource.resolve(line, content)
+	if data != nil {
+		defer putObject(data)
+	}
+
+	recorder.record(now, client, content)
+	archive.archive(now, sender, content)
+
+	timestamp, _ := line["timestamp"].(time.Time)
+	listener, _ := line["listener"].(string)
+	parseSerialised(rs, RsyslogStatsSource{Sender: sender, Timestamp: timestamp, Labels: messageLabels(line), Listener: listener, Data: data}, content)
+}
+
+// Repeatable string flag
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Split comma-separated flag value
+func splitList(value string) []string {
+	list := []string{}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+
+	return list
+}
+
 func printVersionAndExit() {
 	const versionInfo = `
 Version: %s
@@ -108,14 +248,120 @@ BuiltBy: %s
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		benchMain(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		exportMain(os.Args[2:])
+		return
+	}
+
 	var (
 		metricsAddr  = flag.String("listen-address", ":9292", "ip:port to serve metrics on")
+		adminAddr    = flag.String("admin-listen-address", "", "ip:port to serve debug, health and exporter self-metrics endpoints on (same as -listen-address if empty)")
+		retryBackoff = flag.Duration("input-retry-backoff", time.Second, "Initial delay between the input start retries, doubled after every failure")
+		retryMax     = flag.Duration("input-retry-max-backoff", 5*time.Minute, "Max delay between the input start retries")
+		udpBatch     = flag.Int("udp-batch-size", 32, "Datagrams read per recvmmsg(2) call by the UDP listeners with socket options and multicast inputs (Linux only, 1 disables)")
+		httpFamily   = flag.String("http-listen-family", "", "Address family of the HTTP listeners: ipv4, ipv6 (IPv6 only) or dual (IPv4 and IPv6 on [::]), any if empty")
+		httpIface    = flag.String("http-listen-interface", "", "Network interface to bind the HTTP listeners to (Linux only)")
 		metricsPath  = flag.String("metrics-endpoint", "/metrics", "URL path to serve metrics on")
+		ingestPath   = flag.String("ingest-endpoint", "", "URL path to accept impstats JSON lines pushed by HTTP POST on (omhttp e.g., disabled if empty)")
+		selfPath     = flag.String("exporter-metrics-endpoint", "", "URL path to serve the exporter self-metrics (Go, process and exporter_* ones) on separately from the rsyslog metrics (admin listener if set)")
 		syslogAddr   = flag.String("syslog-listen-address", "udp://0.0.0.0:5145", "proto://ip:port to listen on for the syslog input")
+		syslogCert   = flag.String("syslog-tls-cert", "", "TLS certificate file of the tls:// and dtls:// syslog listeners")
+		syslogKey    = flag.String("syslog-tls-key", "", "TLS private key file of the tls:// and dtls:// syslog listeners")
+		syslogCA     = flag.String("syslog-tls-ca", "", "CA bundle to verify the client certificates of the tls:// and dtls:// syslog listeners against (client certificates are required if set)")
+		framing      = flag.String("syslog-tcp-framing", framingLF, "TCP and TLS syslog framing: lf (non-transparent), octet-counted or auto (detected per message)")
 		syslogFormat = flag.String("syslog-format", "rfc3164", "Syslog version to use (rfc3164, rfc5424)")
+		scrapeOffset = flag.Duration("scrape-timeout-offset", 500*time.Millisecond, "Offset to subtract from the Prometheus scrape timeout when truncating the rsyslog metrics")
+		maxScrapes   = flag.Int("max-scrapes-in-flight", 0, "Max amount of concurrent scrapes, others get 503 (0 means unlimited)")
+		maxScrapeDur = flag.Duration("scrape-timeout", 0, "Max scrape duration, slower scrapes get 503 (0 means unlimited)")
+		deltas       = flag.Bool("delta-families", false, "Export the change of every counter since the previous scrape of the same scraper as the *_delta gauge families")
+		topFamilies  = flag.Int("cardinality-top-families", 10, "Number of the biggest metric families to export series counts for")
+		familyLimit  = flag.Int("max-family-series", 0, "Max amount of label sets per metric family, new ones are rejected beyond it (0 means unlimited)")
+		seriesTTL    = flag.Duration("series-ttl", 0, "Expire the series not updated for longer (dynstats buckets of the hosts gone e.g.), the memory is returned (0 disables)")
+		staleAfter   = flag.Duration("stale-input-after", 0, "Consider the input stalled when no rsyslog stats are parsed for longer (0 disables)")
+		staleAction  = flag.String("stale-input-action", staleActionGauge, "Action on the stalled input: gauge (set rsyslog_exporter_input_stalled to 1) or exit (exit with non-zero code)")
+		jsonPath     = flag.String("json-path", "", "JSON path to the impstats object inside wrapper documents ($.msg, $.log.original e.g.)")
+		relayAddr    = flag.String("relay-address", "", "proto://ip:port of the downstream syslog server to relay non-stats messages to (udp, tcp)")
+		statsTags    = flag.String("relay-stats-tags", "rsyslogd-pstats", "Comma-separated list of syslog tags (app names for RFC5424) of the stats messages, others are relayed (any tag if empty)")
+		allowSenders = flag.String("sender-allowlist", "", "Comma-separated list of hostnames, IPs and CIDRs to track per-sender series for, others are aggregated as \"other\" (all senders are tracked if empty)")
+		watermarks   = flag.Bool("queue-watermarks", false, "Export core.queue size and discard burst watermarks observed since the exporter start")
+		actionTarget = flag.String("action-target-pattern", "", "Regexp with target and port named groups to get the forwarding target labels from core.action names")
+		workerSums   = flag.Bool("aggregate-workers", false, "Sum the per-worker core.action and core.queue series into the *_workers families of the logical actions and queues")
+		workerRegexp = flag.String("worker-pattern", defaultWorkerPattern, "Regexp with action and worker named groups to match the per-worker core.action and core.queue names (with -aggregate-workers)")
+		fwdTargets   = flag.Bool("forward-target-labels", false, "Add protocol, target and port labels parsed from omfwd stats names (TCP-host-port)")
+		inputLabels  = flag.Bool("input-listener-labels", false, "Add listener, port and worker labels parsed from imudp, imtcp and imptcp stats names (imudp(*:514))")
+		processStats = flag.Bool("process-metrics", false, "Export impstats resource-usage counters as rsyslog_process_* metrics with the conventional names and units")
+		dbActions    = flag.Bool("database-actions", false, "Parse core.action stats of ommysql, ompgsql and ommongodb actions (default names) into the database action families")
+		kafkaStats   = flag.Bool("omkafka-stats", false, "Parse omkafka stats into the families labeled by the action and the topic")
+		kafkaTopic   = flag.String("omkafka-topic-pattern", "", "Regexp with topic named group to get the topic label from omkafka stats names (implies -omkafka-stats)")
+		suspensions  = flag.Bool("action-suspension", false, "Export core.action suspension state gauge and suspend/resume transitions counters")
+		failureRatio = flag.Bool("action-failure-ratio", false, "Export core.action failure ratio (failed / processed) between the stats reports")
+		queueKind    = flag.Bool("queue-kind-label", false, "Add queue_kind label (main, action, da, io, other) to core.queue metrics")
+		canonNames   = flag.Bool("canonical-names", false, "Remove config file references and replace spaces and colons by underscores in core.action and core.queue names")
+		rawNameLabel = flag.Bool("raw-name-label", false, "Keep the original core.action and core.queue name in the raw_name label (with -canonical-names)")
+		rawOrigins   = flag.String("raw-origins", "", "Comma-separated list of origins to additionally export with the original counter names in the rsyslog_raw family")
+		labelOrigins = flag.String("counter-label-origins", "", "Comma-separated list of origins to export as a single family with a \"counter\" label (core.queue, core.action e.g.)")
+		listenerLbl  = flag.Bool("listener-label", false, "Attach the listener label with the input the metrics arrived on (udp:0.0.0.0:5145 e.g.)")
+		selfMetrics  = flag.String("self-metrics", selfMetricsLegacy, "Exporter parsing metrics to export: legacy (flat counters), v2 (*_total per listener and origin, gauge timestamps) or both")
+		schemaFile   = flag.String("schema-file", "", "JSON file mapping origins to stat types, reloaded on change")
+		schemaReload = flag.Duration("schema-reload-interval", 5*time.Second, "How often to check the schema file for changes")
+		recordTo     = flag.String("record-to", "", "File to record every raw stats line received to (with the receive time and peer)")
+		recordSize   = flag.Int64("record-max-size", 100<<20, "Size in bytes to rotate the record and dead letter files at (0 disables rotation)")
+		recordFiles  = flag.Int("record-max-files", 5, "Amount of rotated record and dead letter files to keep")
+		deadLetterTo = flag.String("dead-letter-file", "", "File to write the stats lines the parser panicked on to (rotated the same way as the record file)")
+		alertWebhook = flag.String("alert-webhook-url", "", "URL to POST JSON alerts to when the parser failures or dropped messages rate exceeds the threshold")
+		alertParser  = flag.Float64("alert-parser-failures-rate", 0, "Parser failures per minute to alert on (0 disables)")
+		alertDropped = flag.Float64("alert-dropped-rate", 0, "Messages dropped by rsyslog queues per minute to alert on (0 disables)")
+		alertEvery   = flag.Duration("alert-interval", time.Minute, "How often to check the alert rates")
+		alertCool    = flag.Duration("alert-cooldown", 15*time.Minute, "Min time between the alerts of the same kind")
+		senderFrom   = flag.String("sender-source", "", "Comma-separated list of the sender identity sources tried in order: hostname, peer, rdns, field:<JSON path> (hostname,peer if empty)")
+		peerRate     = flag.Float64("peer-rate-limit", 0, "Stats lines parsed per second per peer address, the rest are dropped (0 disables)")
+		peerBurst    = flag.Int("peer-rate-burst", 1000, "Stats lines parsed per peer address in a burst above -peer-rate-limit (impstats emits all counters at once)")
+		accessLog    = flag.Bool("access-log", false, "Log every HTTP request served")
+		readTimeout  = flag.Duration("http-read-timeout", 10*time.Second, "Max duration for reading the HTTP request")
+		writeTimeout = flag.Duration("http-write-timeout", 60*time.Second, "Max duration before timing out the HTTP response write")
+		idleTimeout  = flag.Duration("http-idle-timeout", 120*time.Second, "Max time to wait for the next HTTP request on keep-alive connections")
+		shutdownWait = flag.Duration("shutdown-timeout", 5*time.Second, "Max time to wait for in-flight HTTP requests on shutdown")
+		autoMaxProcs = flag.Bool("auto-maxprocs", true, "Set GOMAXPROCS to the container CPU quota")
+		memoryRatio  = flag.Float64("memory-limit-ratio", 0.9, "Set GOMEMLIMIT to the ratio of the container memory limit unless GOMEMLIMIT is set (0 disables)")
+		mutexProfile = flag.Int("mutex-profile-fraction", 0, "Report 1/N of mutex contention events (0 disables)")
+		blockProfile = flag.Int("block-profile-rate", 0, "Sample one blocking event per N nanoseconds spent blocked (0 disables)")
+		snapshotDir  = flag.String("heap-snapshot-dir", os.TempDir(), "Directory to write heap snapshots to (admin listener only)")
+		tokenFile    = flag.String("admin-token-file", "", "File with the bearer token required by the admin API (the API is disabled if empty)")
+		sandbox      = flag.Bool("sandbox", false, "Deny process execution, privileged syscalls (seccomp) and file system modifications except the files written (landlock) after startup (Linux only)")
+		consulURL    = flag.String("register-consul-url", "", "Consul agent URL to register the exporter in (http://127.0.0.1:8500 e.g.)")
+		etcdURL      = flag.String("register-etcd-url", "", "etcd URL to register the exporter in as file_sd target group (http://127.0.0.1:2379 e.g.)")
+		etcdPrefix   = flag.String("register-etcd-prefix", "/services/", "etcd key prefix, the exporter is registered as <prefix><service>/<id>")
+		regService   = flag.String("register-service", "rsyslog_exporter", "Service name to register the exporter as")
+		regAddress   = flag.String("register-address", "", "host:port to register the exporter with (-listen-address with the hostname if empty)")
+		regInterval  = flag.Duration("register-interval", 10*time.Second, "Interval to refresh the registration, etcd lease TTL is 3 intervals")
+		pushURL      = flag.String("pushgateway-url", "", "Pushgateway URL to push the rsyslog metrics to (http://pushgateway:9091 e.g.)")
+		pushJob      = flag.String("pushgateway-job", "rsyslog_exporter", "Pushgateway job name")
+		pushInterval = flag.Duration("pushgateway-interval", time.Minute, "Interval to push the rsyslog metrics to Pushgateway")
+		archiveDB    = flag.String("archive-db", "", "SQLite database to archive every stats line counters to for the offline history queries")
+		archiveKeep  = flag.Duration("archive-retention", 30*24*time.Hour, "Archived stats counters older than the retention are pruned")
+		inputsFile   = flag.String("inputs-file", "", "File with the input addresses (one per line), the inputs changed via admin API are persisted to it")
 		versionFlag  = false
+		transforms   listFlag
+		statusRules  listFlag
+		inputs       listFlag
+		tenantMap    listFlag
+		sdLabels     listFlag
+		regLabels    listFlag
+		pushGrouping listFlag
 	)
 
+	flag.Var(&inputs, "input", "Additional input as proto://address (zmq://host:port e.g.) (repeatable)")
+	flag.Var(&tenantMap, "tenant-map", "Tenant label mapping as 'sni:<server name>=<tenant>', 'cn:<client certificate CN>=<tenant>' (TLS inputs) or 'cidr:<network>=<tenant>' (repeatable)")
+	flag.Var(&sdLabels, "sd-label", "RFC5424 structured data param to label mapping as '<SD-ID>/<PARAM-NAME>=<label>', the tenant label assigns the tenant (repeatable)")
+	flag.Var(&pushGrouping, "pushgateway-grouping", "Pushgateway grouping label as 'name=value', instance is the hostname unless set (repeatable)")
+	flag.Var(&regLabels, "register-label", "Label to register the exporter with as 'name=value' (repeatable)")
+	flag.Var(&transforms, "transform", "Expression hook applied to parsed metrics as '<drop|rename|relabel>:<expression>' (repeatable)")
+	flag.Var(&statusRules, "status-rule", "Threshold exported as rsyslog_status gauge per stats object as '<status>:<origin>:<bool expression>' (repeatable)")
 	flag.BoolVar(&versionFlag, "V", false, "Print the version and exit")
 	flag.BoolVar(&versionFlag, "version", false, "Print the version and exit")
 
@@ -125,38 +371,470 @@ func main() {
 		printVersionAndExit()
 	}
 
-	_, channel, err := syslogServerInit(*syslogFormat, *syslogAddr)
-	if err != nil {
+	setRuntimeLimits(*autoMaxProcs, *memoryRatio)
+	setMutexProfileFraction(*mutexProfile)
+	setBlockProfileRate(*blockProfile)
+
+	channel := make(syslog.LogPartsChannel)
+
+	if *syslogAddr != "" {
+		inputs = append(listFlag{*syslogAddr}, inputs...)
+	}
+
+	if *inputsFile != "" {
+		persisted, err := readInputsFile(*inputsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		inputs = append(inputs, persisted...)
+	}
+
+	switch *selfMetrics {
+	case selfMetricsLegacy, selfMetricsV2, selfMetricsBoth:
+	default:
+		log.Fatalf("self-metrics %s is not supported", *selfMetrics)
+	}
+
+	listenerLabel = *listenerLbl
+	listenerTagged = *listenerLbl || *selfMetrics != selfMetricsLegacy
+	udpBatchSize = *udpBatch
+
+	if err := checkFraming(*framing); err != nil {
 		log.Fatal(err)
 	}
 
+	tcpFraming = *framing
+
+	if *senderFrom != "" {
+		ss, err := NewSenderSource(splitList(*senderFrom))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		senderSource = ss
+	}
+
+	if *peerRate < 0 {
+		log.Fatalf("peer rate limit %v must not be negative", *peerRate)
+	}
+
+	if *peerRate > 0 {
+		rateLimiter = NewPeerRateLimiter(*peerRate, *peerBurst)
+	}
+
+	if *recordTo != "" {
+		r, err := newStreamRecorder(*recordTo, *recordSize, *recordFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		recorder = r
+	}
+
+	if *deadLetterTo != "" {
+		r, err := newStreamRecorder(*deadLetterTo, *recordSize, *recordFiles)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		deadLetters = r
+	}
+
+	if len(sdLabels) > 0 {
+		m, err := NewSDMapping(sdLabels)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sdMapping = m
+	}
+
+	if *syslogCert != "" || *syslogKey != "" {
+		config, err := loadSyslogTLSConfig(*syslogCert, *syslogKey, *syslogCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		syslogTLSConfig = config
+	}
+
+	if len(tenantMap) > 0 {
+		tm, err := NewTenantMap(tenantMap)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tenants = tm
+	}
+
 	// RsyslogStats structure
 	rs := NewRsyslogStats()
+	rs.MaxFamilySeries = *familyLimit
+	rs.SeriesTTL = *seriesTTL
+	rs.JSONPath = *jsonPath
+	rs.QueueKindLabel = *queueKind
+	rs.QueueWatermarks = *watermarks
+	rs.ActionSuspension = *suspensions
+	rs.ActionFailureRatio = *failureRatio
+	// imudp/imtcp/imptcp listener label clashes with the exporter's listener one
+	if *inputLabels && *listenerLbl {
+		log.Fatal("-input-listener-labels and -listener-label cannot be used together")
+	}
+
+	rs.ForwardTargetLabels = *fwdTargets
+	rs.ListenerStatsLabels = *inputLabels
+	rs.DatabaseActions = *dbActions
+	rs.ProcessMetrics = *processStats
+
+	rs.OmkafkaStats = *kafkaStats || *kafkaTopic != ""
+
+	if *kafkaTopic != "" {
+		pattern, err := regexp.Compile(*kafkaTopic)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.OmkafkaTopicPattern = pattern
+	}
+
+	if *actionTarget != "" {
+		pattern, err := regexp.Compile(*actionTarget)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.ActionTargetPattern = pattern
+	}
+
+	if *workerSums {
+		pattern, err := regexp.Compile(*workerRegexp)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.WorkerPattern = pattern
+	}
+	rs.CanonicalNames = *canonNames
+	rs.RawNameLabel = *rawNameLabel
+	rs.SetCounterLabelOrigins(splitList(*labelOrigins))
+	rs.SetRawOrigins(splitList(*rawOrigins))
+
+	if *allowSenders != "" {
+		allowlist, err := NewSenderAllowlist(splitList(*allowSenders))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.SenderAllowlist = allowlist
+	}
+
+	if *schemaFile != "" {
+		if err := reloadSchema(rs, *schemaFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, rule := range transforms {
+		t, err := NewTransform(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.Transforms = append(rs.Transforms, t)
+	}
+
+	for _, rule := range statusRules {
+		r, err := NewStatusRule(rule)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rs.StatusRules = append(rs.StatusRules, r)
+	}
+
+	if *archiveDB != "" {
+		a, err := newStatsArchive(*archiveDB, *archiveKeep, rs.NameField, rs.OriginField)
+		if err != nil {
+			log.Fatalf("cannot open archive %s: %s", *archiveDB, err)
+		}
+
+		archive = a
+	}
 
 	// RsyslogStatsCollector
 	rsc := NewRsyslogStatsCollector(rs)
+	rsc.TopFamilies = *topFamilies
+	rsc.TimeoutOffset = *scrapeOffset
+	rsc.SelfMetrics = *selfMetrics
+
+	if *deltas {
+		rsc.Deltas = newDeltaTracker(deltaScraperTTL)
+	}
 
-	// Prometheus registry
-	reg := prometheus.NewPedanticRegistry()
-	reg.MustRegister(
+	// Prometheus registries: rsyslog metrics and the exporter self-metrics
+	selfReg := prometheus.NewPedanticRegistry()
+	selfReg.MustRegister(
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 		collectors.NewGoCollector(),
 		collectors.NewBuildInfoCollector(),
-		rsc,
 	)
+	selfReg.MustRegister(inputMetrics...)
+	selfReg.MustRegister(pipelineMetrics...)
+	selfReg.MustRegister(httpMetrics...)
+	selfReg.MustRegister(relayMetrics...)
+	selfReg.MustRegister(runtimeMetrics...)
+	selfReg.MustRegister(schemaMetrics...)
+	selfReg.MustRegister(recorderMetrics...)
+	selfReg.MustRegister(alertMetrics...)
+	selfReg.MustRegister(deadLetterMetrics...)
+	selfReg.MustRegister(sandboxMetrics...)
+	selfReg.MustRegister(registrationMetrics...)
+	selfReg.MustRegister(pushMetrics...)
+	selfReg.MustRegister(archiveMetrics...)
+	selfReg.MustRegister(watchdogMetrics...)
+	selfReg.MustRegister(tlsMetrics...)
+	selfReg.MustRegister(ingestMetrics...)
+	selfReg.MustRegister(rateLimitMetrics...)
+	if *staleAfter > 0 {
+		selfReg.MustRegister(staleInputMetrics...)
+	}
+	selfReg.MustRegister(newConfigInfo(flag.CommandLine))
+
+	handlerOpts := promhttp.HandlerOpts{
+		// Opt into OpenMetrics to support exemplars.
+		EnableOpenMetrics: true,
+	}
+
+	// Admin endpoints are served by the main listener unless the admin one is set
+	mux := http.NewServeMux()
+	adminMux := mux
+	gatherers := prometheus.Gatherers{}
+
+	// the profiling controls require the admin token if it's set
+	adminToken := ""
+	if *tokenFile != "" {
+		token, err := readAdminToken(*tokenFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		adminToken = token
+	}
+
+	if *adminAddr != "" {
+		adminMux = http.NewServeMux()
+		registerProfilingHandlers(adminMux, *snapshotDir, adminToken)
+	}
+
+	// Self-metrics are served on their own path with the exporter group of
+	// the rsyslog collector, by the admin listener w/o the path for
+	// compatibility, or along with the rsyslog metrics otherwise
+	switch {
+	case *selfPath != "":
+		if *adminAddr == "" && *selfPath == *metricsPath {
+			log.Fatalf("exporter metrics endpoint %s must differ from the metrics one", *selfPath)
+		}
+
+		exporter := *rsc
+		exporter.Groups = map[string]bool{exporterGroup: true}
+		exporter.Deltas = nil
+		rsc.SeparateExporter = true
+
+		exporterReg := prometheus.NewPedanticRegistry()
+		exporterReg.MustRegister(&exporter)
+
+		adminMux.Handle(*selfPath, instrumentHandler("self-metrics", promhttp.HandlerFor(prometheus.Gatherers{exporterReg, selfReg}, handlerOpts)))
+	case *adminAddr != "":
+		adminMux.Handle(*metricsPath, instrumentHandler("self-metrics", promhttp.HandlerFor(selfReg, handlerOpts)))
+	default:
+		gatherers = append(gatherers, selfReg)
+	}
 
 	// Expose the registered metrics via HTTP.
-	http.Handle(*metricsPath, promhttp.HandlerFor(
-		reg,
-		promhttp.HandlerOpts{
-			// Opt into OpenMetrics to support exemplars.
-			EnableOpenMetrics: true,
-		},
-	))
+	mux.Handle(*metricsPath, instrumentHandler("metrics", limitHandler(newMetricsHandler(rsc, gatherers, handlerOpts), *maxScrapes, *maxScrapeDur)))
+	registerMetricsAPIHandlers(mux, rs)
+
+	if *ingestPath != "" {
+		if *ingestPath == *metricsPath {
+			log.Fatalf("ingest endpoint %s must differ from the metrics one", *ingestPath)
+		}
+
+		registerIngestHandlers(mux, *ingestPath, "http:"+*metricsAddr+*ingestPath, channel)
+	}
+	registerAdminHandlers(adminMux)
+	registerQuarantineHandlers(adminMux, rs)
+	registerUIHandlers(adminMux, rs, flag.CommandLine, *topFamilies)
+
+	im := newInputManager(*syslogFormat, channel, *retryBackoff, *retryMax, *inputsFile)
+
+	if *tokenFile != "" {
+		registerSnapshotHandlers(adminMux, rs, adminToken)
+		registerInputHandlers(adminMux, im, adminToken)
+	}
+
+	wrapHandler := func(handler http.Handler) http.Handler {
+		if *accessLog {
+			return accessLogHandler(handler)
+		}
+
+		return handler
+	}
+
+	timeouts := httpTimeouts{Read: *readTimeout, Write: *writeTimeout, Idle: *idleTimeout}
+	servers := []*http.Server{newHTTPServer(*metricsAddr, wrapHandler(mux), timeouts)}
+
+	if *adminAddr != "" {
+		servers = append(servers, newHTTPServer(*adminAddr, wrapHandler(adminMux), timeouts))
+	}
+
+	var relay *syslogRelay
+
+	if *relayAddr != "" {
+		var err error
+		if relay, err = newSyslogRelay(*relayAddr, *syslogFormat, splitList(*statsTags)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Raw line streams are parsed directly unless non-stats lines are relayed
+	if relay == nil {
+		lineDecoder = &directDecoder{rs: rs}
+	}
+
+	// Inputs are started once the parser is configured. Inputs set by both
+	// the flags and the inputs file are started once.
+	for _, conn := range inputs {
+		if err := im.add(conn); err != nil && !errors.Is(err, errInputExists) {
+			log.Fatal(err)
+		}
+	}
+
+	publishExpvars(rs, channel)
 
 	// Read and print syslog messages
-	go processSyslogMessages(rs, channel)
+	go processSyslogMessages(rs, channel, relay)
 
 	// start prometheus web-server
-	log.Fatal(http.ListenAndServe(*metricsAddr, nil))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *schemaFile != "" {
+		go watchSchema(ctx, rs, *schemaFile, *schemaReload)
+	}
+
+	if rs.SeriesTTL > 0 {
+		go runSeriesExpiry(ctx, rs, min(rs.SeriesTTL, time.Minute))
+	}
+
+	if *staleAfter > 0 {
+		w, err := newStaleInputWatchdog(*staleAfter, *staleAction)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			if err := w.run(ctx, rs); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if *alertWebhook != "" {
+		aw := newAlertWebhook(*alertWebhook, *alertParser, *alertDropped, *alertCool)
+		go aw.run(ctx, rs, *alertEvery)
+	}
+
+	// Heap snapshots are written via the admin listener only
+	writable := sandboxWritableDirs(*recordTo, *deadLetterTo, *inputsFile, *archiveDB)
+	writable = append(writable, sandboxSocketDirs(inputs)...)
+	if *adminAddr != "" {
+		writable = append(writable, *snapshotDir)
+	}
+
+	applySandbox(*sandbox, writable)
+
+	// Tasks finishing their work once the HTTP servers are shut down
+	var shutdownTasks sync.WaitGroup
+
+	if archive != nil {
+		shutdownTasks.Add(1)
+
+		go func() {
+			defer shutdownTasks.Done()
+			archive.run(ctx)
+		}()
+	}
+
+	if *pushURL != "" {
+		pusher, err := newPushgatewayPusher(*pushURL, *pushJob, pushGrouping, rsc)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		shutdownTasks.Add(1)
+
+		go func() {
+			defer shutdownTasks.Done()
+			runPushgateway(ctx, pusher, *pushInterval)
+		}()
+	}
+
+	// Register the exporter in the service registries, it's deregistered on
+	// shutdown
+	if *consulURL != "" || *etcdURL != "" {
+		address, checkAddress := *regAddress, *metricsAddr
+		if address == "" {
+			address = *metricsAddr
+		}
+
+		if *adminAddr != "" {
+			checkAddress = *adminAddr
+		}
+
+		service, err := newServiceInfo(*regService, address, checkAddress, regLabels)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		registries := []serviceRegistry{}
+		if *consulURL != "" {
+			registries = append(registries, newConsulRegistry(*consulURL))
+		}
+
+		if *etcdURL != "" {
+			registries = append(registries, newEtcdRegistry(*etcdURL, *etcdPrefix, 3**regInterval))
+		}
+
+		for _, r := range registries {
+			shutdownTasks.Add(1)
+
+			go func(r serviceRegistry) {
+				defer shutdownTasks.Done()
+				runRegistration(ctx, r, service, *regInterval)
+			}(r)
+		}
+	}
+
+	// Service the systemd watchdog (WatchdogSec=) while the self-checks pass
+	watchdog, err := sdWatchdogInterval()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if watchdog > 0 {
+		go runWatchdog(ctx, watchdog, defaultWatchdogChecks(rs))
+	}
+
+	// Inputs are started already, systemd is notified once the HTTP servers
+	// are listening
+	ready := func() { sdNotifyLogged(sdReady) }
+
+	if err := serveHTTP(ctx, servers, listenOptions{Family: *httpFamily, Interface: *httpIface}, *shutdownWait, ready); err != nil {
+		log.Fatal(err)
+	}
+
+	sdNotifyLogged(sdStopping)
+
+	shutdownTasks.Wait()
 }
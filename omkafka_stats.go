@@ -0,0 +1,86 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import "strings"
+
+// omkafka families: <prefix>_omkafka_<counter>
+const omkafkaFamily = "_omkafka_"
+
+// omkafka counters which are gauges: queue high-water mark and the
+// librdkafka averages
+var omkafkaGauges = map[string]bool{
+	"maxoutqsize":          true,
+	"topicdynacache_used":  true,
+	"rtt_avg_usec":         true,
+	"throttle_avg_msec":    true,
+	"int_latency_avg_usec": true,
+}
+
+// Check if the family is the omkafka gauge (the named omkafka stats are
+// counters for compatibility)
+func (rs *RsyslogStats) isOmkafkaGauge(metricName string) bool {
+	if !rs.OmkafkaStats {
+		return false
+	}
+
+	counter, found := strings.CutPrefix(metricName, rs.MetricPrefix+omkafkaFamily)
+
+	return found && omkafkaGauges[counter]
+}
+
+// Get the topic of the omkafka stats name matched by the "topic" named group
+// of the pattern (empty if not set or not matched)
+func (rs *RsyslogStats) omkafkaTopic(name string) string {
+	if rs.OmkafkaTopicPattern == nil {
+		return ""
+	}
+
+	match := rs.OmkafkaTopicPattern.FindStringSubmatch(name)
+	if i := rs.OmkafkaTopicPattern.SubexpIndex("topic"); match != nil && i > 0 {
+		return match[i]
+	}
+
+	return ""
+}
+
+// Parse the omkafka stats (module-wide "omkafka" and per-action statsname
+// ones) into the <prefix>_omkafka_<counter>{action,topic} families. Dotted
+// counters are flattened: topicdynacache.miss -> topicdynacache_miss.
+func (rs *RsyslogStats) parseOmkafkaStats(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
+	m := RsyslogStatsMetrics{}
+	l := RsyslogStatsLabels{}.With("action", name).With("topic", rs.omkafkaTopic(name))
+	metricName := rs.MetricPrefix + omkafkaFamily
+
+	for counter, value := range data {
+		if counter == rs.NameField || counter == rs.OriginField {
+			continue
+		}
+
+		if v, e := getValue(value); e != nil {
+			errs = append(errs, &fieldError{counter, e})
+		} else {
+			rs.appendMetric(m, metricName+counter, l, v)
+		}
+	}
+
+	return m, errs
+}
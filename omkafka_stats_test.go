@@ -0,0 +1,74 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RsyslogStats.parseOmkafkaStats
+func TestRsyslogStatsOmkafka(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.OmkafkaStats = true
+	rs.OmkafkaTopicPattern = regexp.MustCompile(`^kafka-(?P<topic>.+)$`)
+	rs.Parse(`{"name": "omkafka", "origin": "omkafka", "submitted": 10, "maxoutqsize": 5, "topicdynacache.miss": 1, "rtt_avg_usec": 1200}`)
+	rs.Parse(`{"name": "kafka-logs", "origin": "omkafka", "submitted": 7, "acked": 6}`)
+	rs.Parse(`{"name": "omkafka", "sent": 3}`)
+
+	module := RsyslogStatsLabels{}.With("action", "omkafka").With("topic", "")
+	logs := RsyslogStatsLabels{}.With("action", "kafka-logs").With("topic", "logs")
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_omkafka_submitted":           {module: 10, logs: 7},
+		"rsyslog_omkafka_maxoutqsize":         {module: 5},
+		"rsyslog_omkafka_topicdynacache_miss": {module: 1},
+		"rsyslog_omkafka_rtt_avg_usec":        {module: 1200},
+		"rsyslog_omkafka_acked":               {logs: 6},
+		"rsyslog_omkafka_sent":                {module: 3},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	for family, want := range map[string]prometheus.ValueType{
+		"rsyslog_omkafka_submitted":    prometheus.CounterValue,
+		"rsyslog_omkafka_maxoutqsize":  prometheus.GaugeValue,
+		"rsyslog_omkafka_rtt_avg_usec": prometheus.GaugeValue,
+	} {
+		if got := metricValueType(rs, family); got != want {
+			t.Errorf("%s: want type %v, got %v", family, want, got)
+		}
+	}
+
+	// the named parser is used by default
+	rs = NewRsyslogStats()
+	rs.Parse(`{"name": "omkafka", "origin": "omkafka", "submitted": 10}`)
+
+	if got := rs.Metrics["rsyslog_omkafka_submitted"][RsyslogStatsLabels{"name", "omkafka"}]; got != 10 {
+		t.Errorf("want named omkafka stats by default, got %v", rs.Metrics)
+	}
+}
@@ -0,0 +1,353 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Thrift compact protocol types used by the Parquet metadata
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// Thrift compact protocol encoder. Only the subset needed to write the
+// Parquet page headers and the file footer is implemented.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16 // last field id written by the struct nesting level
+}
+
+// Start encoding the top-level struct
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{last: []int16{0}}
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func (w *thriftWriter) zigzag(v int64) {
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	level := len(w.last) - 1
+
+	if delta := id - w.last[level]; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+
+	w.last[level] = id
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.zigzag(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.zigzag(v)
+}
+
+func (w *thriftWriter) str(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.uvarint(uint64(len(s)))
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+	} else {
+		w.buf.WriteByte(0xf0 | typ)
+		w.uvarint(uint64(n))
+	}
+}
+
+// Begin the struct field
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+// Begin the struct list element
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+// End the struct with the stop field
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0)
+	w.last = w.last[:len(w.last)-1]
+}
+
+// Parquet format constants
+const (
+	parquetMagic = "PAR1"
+
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetDataPage     = 0
+	parquetUncompressed = 0
+
+	// Rows buffered per row group
+	parquetRowGroupSize = 1 << 16
+)
+
+// Parquet column of the export schema
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32 // -1 if none
+}
+
+// Export schema: the archive table columns
+var parquetColumns = []parquetColumn{
+	{name: "time", typ: parquetInt64, converted: parquetTimestampMillis},
+	{name: "sender", typ: parquetByteArray, converted: parquetUTF8},
+	{name: "origin", typ: parquetByteArray, converted: parquetUTF8},
+	{name: "name", typ: parquetByteArray, converted: parquetUTF8},
+	{name: "counter", typ: parquetByteArray, converted: parquetUTF8},
+	{name: "value", typ: parquetDouble, converted: -1},
+}
+
+// Column chunk written
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// Row group written
+type parquetRowGroup struct {
+	rows   int64
+	chunks []parquetChunk
+}
+
+// Minimal Parquet writer of the archive rows: required columns only, PLAIN
+// encoding, no compression, a data page per column chunk. Good enough for
+// pandas/Spark/DuckDB to read without the heavy Parquet dependencies (the
+// output is checked against testdata/golden/export.parquet, which the tests
+// read with the parquet-go reader).
+type parquetWriter struct {
+	w         io.Writer
+	createdBy string
+	offset    int64
+	values    []bytes.Buffer // PLAIN-encoded values of the current row group by column
+	rows      int64
+	groups    []parquetRowGroup
+}
+
+// Create the writer writing the file header
+func newParquetWriter(w io.Writer, createdBy string) (*parquetWriter, error) {
+	p := &parquetWriter{w: w, createdBy: createdBy, values: make([]bytes.Buffer, len(parquetColumns))}
+
+	if err := p.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *parquetWriter) write(data []byte) error {
+	n, err := p.w.Write(data)
+	p.offset += int64(n)
+
+	return err
+}
+
+func appendInt64(b *bytes.Buffer, v int64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(v))
+	b.Write(buf[:])
+}
+
+func appendByteArray(b *bytes.Buffer, s string) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(len(s)))
+	b.Write(buf[:])
+	b.WriteString(s)
+}
+
+// Append the row, the row group is flushed once it's full
+func (p *parquetWriter) writeRow(r archiveRow) error {
+	appendInt64(&p.values[0], r.Time.UnixMilli())
+	appendByteArray(&p.values[1], r.Sender)
+	appendByteArray(&p.values[2], r.Origin)
+	appendByteArray(&p.values[3], r.Name)
+	appendByteArray(&p.values[4], r.Counter)
+	appendInt64(&p.values[5], int64(math.Float64bits(exportValue(r.Value))))
+
+	p.rows++
+	if p.rows < parquetRowGroupSize {
+		return nil
+	}
+
+	return p.flush()
+}
+
+// Write the buffered rows as the row group
+func (p *parquetWriter) flush() error {
+	group := parquetRowGroup{rows: p.rows}
+
+	for i := range p.values {
+		values := p.values[i].Bytes()
+
+		h := newThriftWriter()
+		h.i32(1, parquetDataPage)
+		h.i32(2, int32(len(values)))
+		h.i32(3, int32(len(values)))
+		h.structField(5)
+		h.i32(1, int32(p.rows))
+		h.i32(2, parquetPlain)
+		h.i32(3, parquetRLE)
+		h.i32(4, parquetRLE)
+		h.end()
+		h.end()
+
+		chunk := parquetChunk{offset: p.offset, size: int64(h.buf.Len() + len(values))}
+
+		if err := p.write(h.buf.Bytes()); err != nil {
+			return err
+		}
+
+		if err := p.write(values); err != nil {
+			return err
+		}
+
+		group.chunks = append(group.chunks, chunk)
+		p.values[i].Reset()
+	}
+
+	p.groups = append(p.groups, group)
+	p.rows = 0
+
+	return nil
+}
+
+// Flush the rows buffered and write the file footer
+func (p *parquetWriter) close() error {
+	if p.rows > 0 {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+
+	var total int64
+	for _, g := range p.groups {
+		total += g.rows
+	}
+
+	m := newThriftWriter()
+	m.i32(1, 1)
+
+	m.list(2, thriftStruct, len(parquetColumns)+1)
+	m.begin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(parquetColumns)))
+	m.end()
+
+	for _, c := range parquetColumns {
+		m.begin()
+		m.i32(1, c.typ)
+		m.i32(3, parquetRequired)
+		m.str(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, c.converted)
+		}
+		m.end()
+	}
+
+	m.i64(3, total)
+
+	m.list(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		m.begin()
+		m.list(1, thriftStruct, len(g.chunks))
+
+		var size int64
+		for i, chunk := range g.chunks {
+			c := parquetColumns[i]
+			size += chunk.size
+
+			m.begin()
+			m.i64(2, chunk.offset)
+			m.structField(3)
+			m.i32(1, c.typ)
+			m.list(2, thriftI32, 2)
+			m.zigzag(parquetPlain)
+			m.zigzag(parquetRLE)
+			m.list(3, thriftBinary, 1)
+			m.uvarint(uint64(len(c.name)))
+			m.buf.WriteString(c.name)
+			m.i32(4, parquetUncompressed)
+			m.i64(5, g.rows)
+			m.i64(6, chunk.size)
+			m.i64(7, chunk.size)
+			m.i64(9, chunk.offset)
+			m.end()
+			m.end()
+		}
+
+		m.i64(2, size)
+		m.i64(3, g.rows)
+		m.end()
+	}
+
+	m.str(6, p.createdBy)
+	m.end()
+
+	footer := m.buf.Bytes()
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+
+	for _, data := range [][]byte{footer, length[:], []byte(parquetMagic)} {
+		if err := p.write(data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
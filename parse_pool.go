@@ -0,0 +1,81 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+)
+
+// Pools of the parse path buffers reused between the messages to reduce the
+// GC pressure at high message rates
+var (
+	// Byte buffers: line copies for the JSON decoder and the names sanitised
+	bufferPool = sync.Pool{
+		New: func() interface{} {
+			b := make([]byte, 0, 1024)
+			return &b
+		},
+	}
+
+	// JSON objects the generic path decodes the lines into
+	objectPool = sync.Pool{
+		New: func() interface{} {
+			return make(map[string]interface{})
+		},
+	}
+)
+
+// Max capacity of the buffers returned to the pool, bigger ones are left for
+// the GC so one huge line doesn't pin the memory
+const maxPooledBuffer = 64 * 1024
+
+// Get the empty buffer from the pool
+func getBuffer() *[]byte {
+	b := bufferPool.Get().(*[]byte)
+	*b = (*b)[:0]
+
+	return b
+}
+
+// Return the buffer to the pool
+func putBuffer(b *[]byte) {
+	if cap(*b) <= maxPooledBuffer {
+		bufferPool.Put(b)
+	}
+}
+
+// Get the empty JSON object from the pool
+func getObject() map[string]interface{} {
+	return objectPool.Get().(map[string]interface{})
+}
+
+// Return the JSON object to the pool. The nested values are not reused: they
+// may be referenced by the metrics parsed.
+func putObject(m map[string]interface{}) {
+	if len(m) > maxPooledObjectKeys {
+		return
+	}
+
+	clear(m)
+	objectPool.Put(m)
+}
+
+// Max amount of keys of the JSON objects returned to the pool
+const maxPooledObjectKeys = 1024
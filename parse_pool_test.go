@@ -0,0 +1,122 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Reference regexp-based sanitising the pooled one must match
+var (
+	reNonAlNum    = regexp.MustCompile("[^_a-zA-Z0-9]")
+	reUnderscores = regexp.MustCompile("_+")
+)
+
+func sanitiseMetricNameRegexp(name string) string {
+	nn := strings.ToLower(name)
+	nn = reNonAlNum.ReplaceAllLiteralString(nn, "_")
+	nn = reUnderscores.ReplaceAllLiteralString(nn, "_")
+
+	return strings.TrimRight(nn, "_")
+}
+
+// sanitiseMetricName (regexp equivalence)
+func TestSanitiseMetricNameRegexp(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{
+		"",
+		"_",
+		"__a",
+		"rsyslog_core_queue_size",
+		"Rsyslog_Test_123_",
+		"main Q.discarded.full",
+		"юникод_queue",
+		"K", // Kelvin sign lowercases to ASCII k
+		"a\xffb",
+		strings.Repeat("x.", 100000),
+	} {
+		if want, got := sanitiseMetricNameRegexp(name), sanitiseMetricName(name); want != got {
+			t.Errorf("%.32q: want %.32q, got %.32q", name, want, got)
+		}
+	}
+}
+
+// validateSeries
+func TestValidateSeries(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		labels RsyslogStatsLabels
+		valid  bool
+	}{
+		{RsyslogStatsLabels{}, true},
+		{RsyslogStatsLabels{"name", "main Q"}, true},
+		{RsyslogStatsLabels{}.With("name", "main Q").With("counter", ""), true},
+		{RsyslogStatsLabels{"name,counter", "main Q"}, false},
+		{RsyslogStatsLabels{"name,", "main Q\x00size"}, false},
+		{RsyslogStatsLabels{"__name", "main Q"}, false},
+		{RsyslogStatsLabels{"name", "\xff"}, false},
+	}
+
+	for _, c := range tests {
+		if err := validateSeries("rsyslog_test", c.labels); (err == nil) != c.valid {
+			t.Errorf("%q: want valid %v, got error %v", c.labels, c.valid, err)
+		}
+	}
+}
+
+// Parse path allocations: go test -run XXX -bench Parse -benchmem
+func BenchmarkParse(b *testing.B) {
+	var benches = []struct {
+		name string
+		line string
+	}{
+		{"typed", `{"name":"main Q","origin":"core.queue","size":3,"enqueued":10,"full":0,"discarded.full":0,"discarded.nf":0,"maxqsize":100}`},
+		{"generic", `{"name":"resource-usage","origin":"impstats","utime":1000,"stime":500,"maxrss":20480,"minflt":3,"majflt":0,"inblock":0,"oublock":1,"nvcsw":7,"nivcsw":2,"openfiles":10}`},
+		{"dynstats", dynstatsBucketLine(testHosts(0, 50)...)},
+	}
+
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			rs := NewRsyslogStats()
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				rs.Parse(bench.line)
+			}
+		})
+	}
+}
+
+// sanitiseMetricName: the regexp-based one for comparison
+func BenchmarkSanitiseMetricName(b *testing.B) {
+	for name, sanitise := range map[string]func(string) string{"pooled": sanitiseMetricName, "regexp": sanitiseMetricNameRegexp} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				sanitise("rsyslog_core_queue_discarded.full")
+			}
+		})
+	}
+}
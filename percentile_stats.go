@@ -0,0 +1,113 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Percentile stats origins (rsyslog percentile_observe() buckets)
+const (
+	percentileOrigin       = "percentile"
+	percentileBucketSuffix = ".bucket"
+)
+
+// Bucket stats families: quantiles and the window statistics
+const (
+	percentileQuantiles = "_percentile_bucket"
+	percentileWindow    = "_percentile_bucket_window"
+)
+
+// Delimiter of the observed key and the statistic ("msg_per_host|p95")
+const percentileDelimiter = "|"
+
+// Check if the origin is the percentile stats one
+func isPercentileOrigin(origin string) bool {
+	return origin == percentileOrigin || origin == percentileOrigin+percentileBucketSuffix
+}
+
+// Check if the family is the percentile bucket gauge
+func (rs *RsyslogStats) isPercentileGauge(metricName string) bool {
+	return metricName == rs.MetricPrefix+percentileQuantiles || metricName == rs.MetricPrefix+percentileWindow
+}
+
+// Get the quantile of the percentile statistic: "p95" -> "0.95"
+func percentileQuantile(stat string) (string, bool) {
+	if !strings.HasPrefix(stat, "p") {
+		return "", false
+	}
+
+	p, err := strconv.ParseFloat(stat[1:], 64)
+	if err != nil || p < 0 || p > 100 {
+		return "", false
+	}
+
+	// rounded to hide the float division error: p99.9 -> 0.999
+	return strconv.FormatFloat(p/100, 'g', 12, 64), true
+}
+
+// Parse the percentile stats. Bucket counters (".bucket" origin) are
+// exported as <prefix>_percentile_bucket{bucket,key,quantile} and
+// <prefix>_percentile_bucket_window{bucket,key,window} gauges, the bucket
+// housekeeping ones as <prefix>_percentile_<counter>{bucket} counters.
+func (rs *RsyslogStats) parsePercentileStats(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
+	m := RsyslogStatsMetrics{}
+
+	values, found := data["values"].(map[string]interface{})
+	if !found {
+		return nil, append(errs, fmt.Errorf("'values' object is required but not found"))
+	}
+
+	l := RsyslogStatsLabels{"bucket", name}
+
+	for field, value := range values {
+		v, e := getValue(value)
+		if e != nil {
+			errs = append(errs, &fieldError{field, e})
+			continue
+		}
+
+		if !strings.HasSuffix(origin, percentileBucketSuffix) {
+			rs.appendMetric(m, rs.MetricPrefix+"_percentile_"+field, l, v)
+			continue
+		}
+
+		i := strings.LastIndex(field, percentileDelimiter)
+		if i < 0 {
+			errs = append(errs, &fieldError{field, fmt.Errorf("must be in '<key>%s<statistic>' format", percentileDelimiter)})
+			continue
+		}
+
+		key, stat := field[:i], field[i+len(percentileDelimiter):]
+
+		if window := strings.TrimPrefix(stat, "window_"); window != stat {
+			rs.appendMetric(m, rs.MetricPrefix+percentileWindow, l.With("key", key).With("window", window), v)
+		} else if quantile, ok := percentileQuantile(stat); ok {
+			rs.appendMetric(m, rs.MetricPrefix+percentileQuantiles, l.With("key", key).With("quantile", quantile), v)
+		} else {
+			errs = append(errs, &fieldError{field, fmt.Errorf("unknown percentile statistic '%s'", stat)})
+		}
+	}
+
+	return m, errs
+}
@@ -0,0 +1,102 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// percentileQuantile
+func TestPercentileQuantile(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		stat     string
+		quantile string
+		ok       bool
+	}{
+		{"p95", "0.95", true},
+		{"p50", "0.5", true},
+		{"p99.9", "0.999", true},
+		{"p100", "1", true},
+		{"p101", "", false},
+		{"px", "", false},
+		{"95", "", false},
+	}
+
+	for _, tt := range tests {
+		quantile, ok := percentileQuantile(tt.stat)
+		if quantile != tt.quantile || ok != tt.ok {
+			t.Errorf("%s: want %s, %v, got %s, %v", tt.stat, tt.quantile, tt.ok, quantile, ok)
+		}
+	}
+}
+
+// RsyslogStats.parsePercentileStats
+func TestRsyslogStatsPercentiles(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "host_statistics", "origin": "percentile", "values": {"new_metric_add": 1, "ops_overflow": 0}}`)
+	rs.Parse(`{"name": "host_statistics", "origin": "percentile.bucket", "values": {"msg_per_host|p95": 1950, "msg_per_host|p50": 1500, "msg_per_host|window_min": 1001, "msg_per_host|window_count": 1000}}`)
+
+	bucket := RsyslogStatsLabels{"bucket", "host_statistics"}
+	key := bucket.With("key", "msg_per_host")
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_percentile_new_metric_add": {bucket: 1},
+		"rsyslog_percentile_ops_overflow":   {bucket: 0},
+		"rsyslog_percentile_bucket": {
+			key.With("quantile", "0.95"): 1950,
+			key.With("quantile", "0.5"):  1500,
+		},
+		"rsyslog_percentile_bucket_window": {
+			key.With("window", "min"):   1001,
+			key.With("window", "count"): 1000,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if rs.ParserFailures != 0 {
+		t.Errorf("want no parser failures, got %d", rs.ParserFailures)
+	}
+
+	for family, want := range map[string]prometheus.ValueType{
+		"rsyslog_percentile_new_metric_add": prometheus.CounterValue,
+		"rsyslog_percentile_bucket":         prometheus.GaugeValue,
+		"rsyslog_percentile_bucket_window":  prometheus.GaugeValue,
+	} {
+		if got := metricValueType(rs, family); got != want {
+			t.Errorf("%s: want type %v, got %v", family, want, got)
+		}
+	}
+
+	// malformed bucket counters are parser failures
+	rs.Parse(`{"name": "host_statistics", "origin": "percentile.bucket", "values": {"msg_per_host": 1, "msg_per_host|avg": 2}}`)
+	if rs.ParserFailures != 2 {
+		t.Errorf("want 2 parser failures, got %d", rs.ParserFailures)
+	}
+}
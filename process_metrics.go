@@ -0,0 +1,77 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// Conventional process metric families (w/o the metric prefix) derived from
+// the impstats resource-usage counters
+const (
+	processCPUSeconds          = "_process_cpu_seconds_total"
+	processMaxResidentMemory   = "_process_max_resident_memory_bytes"
+	processOpenFDs             = "_process_open_fds"
+	processVoluntarySwitches   = "_process_voluntary_context_switches_total"
+	processInvoluntarySwitches = "_process_involuntary_context_switches_total"
+)
+
+// Add the conventional process metrics (names and units of the Prometheus
+// client process collector) translated from the resource-usage counters:
+// utime and stime are microseconds, maxrss is KiB. The name label is replaced
+// by the sender label to tell the reporting instances apart.
+func (rs *RsyslogStats) addProcessMetrics(m RsyslogStatsMetrics, sender string) {
+	prefix := rs.MetricPrefix + "_impstats_"
+
+	translate := func(family string, counters []string, scale RsyslogStatsValue) {
+		family = rs.MetricPrefix + family
+
+	values:
+		for labels, value := range m[prefix+counters[0]] {
+			for _, counter := range counters[1:] {
+				v, found := m[prefix+counter][labels]
+				if !found {
+					continue values
+				}
+
+				value += v
+			}
+
+			if _, found := m[family]; !found {
+				m[family] = make(RsyslogStatsLabeledValues)
+			}
+
+			// CPU seconds are fractional, appendMetric would truncate them
+			l, _ := withoutLabel(labels, "name")
+			if sender != "" {
+				l = l.With("sender", sender)
+			}
+
+			m[family][l] = value * scale
+		}
+	}
+
+	translate(processCPUSeconds, []string{"utime", "stime"}, 1e-6)
+	translate(processMaxResidentMemory, []string{"maxrss"}, 1024)
+	translate(processOpenFDs, []string{"openfiles"}, 1)
+	translate(processVoluntarySwitches, []string{"nvcsw"}, 1)
+	translate(processInvoluntarySwitches, []string{"nivcsw"}, 1)
+}
+
+// Check if the family is the process metrics gauge
+func (rs *RsyslogStats) isProcessGauge(metricName string) bool {
+	return metricName == rs.MetricPrefix+processMaxResidentMemory || metricName == rs.MetricPrefix+processOpenFDs
+}
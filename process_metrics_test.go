@@ -0,0 +1,72 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RsyslogStats.addProcessMetrics
+func TestRsyslogStatsProcessMetrics(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ProcessMetrics = true
+
+	line := `{"name": "resource-usage", "origin": "impstats", "utime": 1500000, "stime": 250000, "maxrss": 4096, "minflt": 10, "majflt": 0, "inblock": 0, "oublock": 8, "nvcsw": 120, "nivcsw": 3, "openfiles": 17}`
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, line)
+	rs.ParseFrom(RsyslogStatsSource{}, `{"name": "resource-usage", "origin": "impstats", "utime": 1000000, "stime": 0, "maxrss": 1024}`)
+
+	host1 := RsyslogStatsLabels{"sender", "host1"}
+	local := RsyslogStatsLabels{}
+
+	want := map[string]RsyslogStatsLabeledValues{
+		"rsyslog_process_cpu_seconds_total":                  {host1: 1.75, local: 1},
+		"rsyslog_process_max_resident_memory_bytes":          {host1: 4194304, local: 1048576},
+		"rsyslog_process_open_fds":                           {host1: 17},
+		"rsyslog_process_voluntary_context_switches_total":   {host1: 120},
+		"rsyslog_process_involuntary_context_switches_total": {host1: 3},
+	}
+
+	for family, values := range want {
+		if diff := cmp.Diff(values, rs.Metrics[family]); diff != "" {
+			t.Errorf("%s mismatch (-want +got):\n%s", family, diff)
+		}
+	}
+
+	if _, found := rs.Metrics["rsyslog_impstats_utime"]; !found {
+		t.Error("want impstats families kept")
+	}
+
+	types := map[string]prometheus.ValueType{
+		"rsyslog_process_cpu_seconds_total":         prometheus.CounterValue,
+		"rsyslog_process_max_resident_memory_bytes": prometheus.GaugeValue,
+		"rsyslog_process_open_fds":                  prometheus.GaugeValue,
+	}
+
+	for family, want := range types {
+		if got := metricValueType(rs, family); got != want {
+			t.Errorf("%s: want %v, got %v", family, want, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Current mutex profile fraction and block profile rate (the runtime doesn't
+// report the latter back)
+var (
+	mutexProfileFraction atomic.Int64
+	blockProfileRate     atomic.Int64
+)
+
+// Set the mutex profile fraction
+func setMutexProfileFraction(fraction int) {
+	mutexProfileFraction.Store(int64(fraction))
+	runtime.SetMutexProfileFraction(fraction)
+}
+
+// Set the block profile rate
+func setBlockProfileRate(rate int) {
+	blockProfileRate.Store(int64(rate))
+	runtime.SetBlockProfileRate(rate)
+}
+
+// Heap snapshots kept in the directory, the oldest ones are removed
+const maxHeapSnapshots = 10
+
+// Heap snapshot file name pattern
+const heapSnapshotPattern = "rsyslog_exporter-heap-*.pprof"
+
+// Serialises the heap snapshots
+var heapSnapshotMu sync.Mutex
+
+// Write the heap profile to a new file in the directory, removing the oldest
+// snapshots beyond maxHeapSnapshots
+func writeHeapSnapshot(dir string) (string, error) {
+	heapSnapshotMu.Lock()
+	defer heapSnapshotMu.Unlock()
+
+	path := filepath.Join(dir, strings.Replace(heapSnapshotPattern, "*", time.Now().Format("20060102T150405.000000000"), 1))
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// get up-to-date statistics
+	runtime.GC()
+
+	if err = pprof.WriteHeapProfile(f); err != nil {
+		return "", err
+	}
+
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+
+	return path, pruneHeapSnapshots(dir)
+}
+
+// Remove the oldest heap snapshots beyond maxHeapSnapshots (the names are
+// ordered by time)
+func pruneHeapSnapshots(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, heapSnapshotPattern))
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(paths)
+
+	for len(paths) > maxHeapSnapshots {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+
+		paths = paths[1:]
+	}
+
+	return nil
+}
+
+// Register runtime profiling control endpoints:
+//
+//	GET  /debug/profiling                     current profiling rates
+//	POST /debug/profiling/mutex?fraction=N    set the mutex profile fraction
+//	POST /debug/profiling/block?rate=N        set the block profile rate
+//	POST /debug/profiling/heap-snapshot       write the heap profile to the snapshot dir
+//
+// The changing endpoints require the bearer token if it's set.
+func registerProfilingHandlers(mux *http.ServeMux, snapshotDir string, token string) {
+	guard := func(handler http.Handler) http.Handler {
+		if token == "" {
+			return handler
+		}
+
+		return requireToken(token, handler)
+	}
+
+	mux.Handle("/debug/profiling", instrumentHandler("profiling", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "mutex_profile_fraction %d\nblock_profile_rate %d\n", mutexProfileFraction.Load(), blockProfileRate.Load())
+	})))
+
+	setRate := func(param string, set func(int)) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			value, err := strconv.Atoi(r.URL.Query().Get(param))
+			if err != nil || value < 0 {
+				http.Error(w, fmt.Sprintf("'%s' must be a non-negative integer", param), http.StatusBadRequest)
+				return
+			}
+
+			set(value)
+			log.Printf("%s %s is set to %d", r.URL.Path, param, value)
+		})
+	}
+
+	mux.Handle("/debug/profiling/mutex", instrumentHandler("profiling", guard(setRate("fraction", setMutexProfileFraction))))
+	mux.Handle("/debug/profiling/block", instrumentHandler("profiling", guard(setRate("rate", setBlockProfileRate))))
+
+	mux.Handle("/debug/profiling/heap-snapshot", instrumentHandler("profiling", guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		path, err := writeHeapSnapshot(snapshotDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("heap snapshot is written to %s", path)
+		fmt.Fprintln(w, path)
+	}))))
+}
@@ -0,0 +1,156 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// registerProfilingHandlers
+func TestProfilingHandlers(t *testing.T) {
+	dir := t.TempDir()
+	mux := http.NewServeMux()
+	registerProfilingHandlers(mux, dir, "")
+
+	defer setMutexProfileFraction(0)
+	defer setBlockProfileRate(0)
+
+	var tests = []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"POST", "/debug/profiling/mutex?fraction=5", http.StatusOK},
+		{"POST", "/debug/profiling/block?rate=1000", http.StatusOK},
+		{"GET", "/debug/profiling/mutex?fraction=5", http.StatusMethodNotAllowed},
+		{"POST", "/debug/profiling/block?rate=-1", http.StatusBadRequest},
+		{"POST", "/debug/profiling/mutex", http.StatusBadRequest},
+		{"GET", "/debug/profiling", http.StatusOK},
+	}
+
+	for _, c := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+
+		if rec.Code != c.code {
+			t.Errorf("%s %s: want %d, got %d", c.method, c.path, c.code, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/profiling", nil))
+
+	if want, got := "mutex_profile_fraction 5\nblock_profile_rate 1000\n", rec.Body.String(); want != got {
+		t.Errorf("want '%s', got '%s'", want, got)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("POST", "/debug/profiling/heap-snapshot", nil))
+
+	path := strings.TrimSpace(rec.Body.String())
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 || !strings.HasPrefix(path, dir) {
+		t.Errorf("heap snapshot is not written to %s: %v", dir, err)
+	}
+}
+
+// registerProfilingHandlers
+func TestProfilingHandlersToken(t *testing.T) {
+	dir := t.TempDir()
+	mux := http.NewServeMux()
+	registerProfilingHandlers(mux, dir, "secret")
+
+	defer setMutexProfileFraction(0)
+
+	var tests = []struct {
+		method string
+		path   string
+		auth   string
+		code   int
+	}{
+		{"POST", "/debug/profiling/mutex?fraction=5", "", http.StatusUnauthorized},
+		{"POST", "/debug/profiling/block?rate=1000", "Bearer wrong", http.StatusUnauthorized},
+		{"POST", "/debug/profiling/heap-snapshot", "", http.StatusUnauthorized},
+		{"POST", "/debug/profiling/mutex?fraction=5", "Bearer secret", http.StatusOK},
+		{"GET", "/debug/profiling", "", http.StatusOK},
+	}
+
+	for _, c := range tests {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		if c.auth != "" {
+			req.Header.Set("Authorization", c.auth)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != c.code {
+			t.Errorf("%s %s (%q): want %d, got %d", c.method, c.path, c.auth, c.code, rec.Code)
+		}
+	}
+
+	if paths, _ := filepath.Glob(filepath.Join(dir, "*")); len(paths) != 0 {
+		t.Errorf("want no heap snapshots without the token, got %v", paths)
+	}
+}
+
+// pruneHeapSnapshots
+func TestPruneHeapSnapshots(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	var want []string
+	for i := 0; i < maxHeapSnapshots+3; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("rsyslog_exporter-heap-20261016T1200%02d.000000000.pprof", i))
+		if err := os.WriteFile(path, []byte("heap"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if i >= 3 {
+			want = append(want, path)
+		}
+	}
+
+	other := filepath.Join(dir, "other.pprof")
+	if err := os.WriteFile(other, []byte("other"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneHeapSnapshots(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := filepath.Glob(filepath.Join(dir, heapSnapshotPattern))
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("pruneHeapSnapshots() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("unrelated file is removed: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+var (
+	pushesSent = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_pushes",
+			Help: "Amount of metric snapshots pushed to the Pushgateway",
+		},
+	)
+
+	pushFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_push_failures",
+			Help: "Amount of metric snapshots failed to push to the Pushgateway",
+		},
+	)
+)
+
+// Metrics exported by the Pushgateway output
+var pushMetrics = []prometheus.Collector{
+	pushesSent,
+	pushFailures,
+}
+
+// Create the Pushgateway pusher of the collector metrics. The grouping labels
+// are set as 'name=value', the instance label is the hostname unless it's set.
+func newPushgatewayPusher(url string, job string, grouping []string, c prometheus.Collector) (*push.Pusher, error) {
+	pusher := push.New(url, job).
+		Collector(c).
+		Client(&http.Client{Timeout: 10 * time.Second})
+
+	instance := false
+
+	for _, label := range grouping {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("pushgateway grouping label '%s' must be in 'name=value' format", label)
+		}
+
+		instance = instance || parts[0] == "instance"
+		pusher = pusher.Grouping(parts[0], parts[1])
+	}
+
+	if !instance {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+
+		pusher = pusher.Grouping("instance", hostname)
+	}
+
+	return pusher, nil
+}
+
+// Push the metric snapshot replacing the metrics of the group
+func pushSnapshot(pusher *push.Pusher) {
+	if err := pusher.Push(); err != nil {
+		log.Printf("cannot push metrics to Pushgateway: %s", err)
+		pushFailures.Inc()
+
+		return
+	}
+
+	pushesSent.Inc()
+}
+
+// Push the metric snapshot every interval until the context is done. The last
+// snapshot is pushed on shutdown, so the gateway keeps the final values.
+func runPushgateway(ctx context.Context, pusher *push.Pusher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			pushSnapshot(pusher)
+			return
+		case <-ticker.C:
+			pushSnapshot(pusher)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newPushgatewayPusher
+func TestNewPushgatewayPusher(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		grouping []string
+		want     string
+		err      bool
+	}{
+		{nil, "/metrics/job/rsyslog_exporter/instance/" + hostname, false},
+		{[]string{"instance=relay1"}, "/metrics/job/rsyslog_exporter/instance/relay1", false},
+		{[]string{"instance"}, "", true},
+	}
+
+	for _, tt := range tests {
+		paths := make(chan string, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths <- r.Method + " " + r.URL.Path
+		}))
+
+		rs := NewRsyslogStats()
+		pusher, err := newPushgatewayPusher(srv.URL, "rsyslog_exporter", tt.grouping, NewRsyslogStatsCollector(rs))
+
+		if (err != nil) != tt.err {
+			t.Errorf("newPushgatewayPusher(%v): want error %t, got %v", tt.grouping, tt.err, err)
+		}
+
+		if err == nil {
+			if err := pusher.Push(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := <-paths; got != "PUT "+tt.want {
+				t.Errorf("newPushgatewayPusher(%v): want PUT %s, got %s", tt.grouping, tt.want, got)
+			}
+		}
+
+		srv.Close()
+	}
+}
+
+// pushSnapshot
+func TestPushSnapshot(t *testing.T) {
+	bodies := make(chan string, 2)
+	status := http.StatusOK
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(status)
+		bodies <- string(body)
+	}))
+	defer srv.Close()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name":"imuxsock","origin":"imuxsock","submitted":5}`)
+
+	pusher, err := newPushgatewayPusher(srv.URL, "rsyslog_exporter", []string{"instance=relay1"}, NewRsyslogStatsCollector(rs))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sent, failures := testutil.ToFloat64(pushesSent), testutil.ToFloat64(pushFailures)
+
+	pushSnapshot(pusher)
+
+	if body := <-bodies; !strings.Contains(body, "rsyslog_imuxsock_submitted") {
+		t.Errorf("pushed snapshot has no rsyslog metrics:\n%q", body)
+	}
+
+	status = http.StatusInternalServerError
+	pushSnapshot(pusher)
+	<-bodies
+
+	if got := testutil.ToFloat64(pushesSent) - sent; got != 1 {
+		t.Errorf("rsyslog_exporter_pushes: want 1, got %v", got)
+	}
+
+	if got := testutil.ToFloat64(pushFailures) - failures; got != 1 {
+		t.Errorf("rsyslog_exporter_push_failures: want 1, got %v", got)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+// Queue watermarks observed since the exporter start
+type queueWatermark struct {
+	// Latest discarded (full + nf) counter value
+	discarded RsyslogStatsValue
+	maxSize   RsyslogStatsValue
+	maxBurst  RsyslogStatsValue
+}
+
+// Family name suffixes of the queue watermarks
+const (
+	queueSizeWatermark  = "_core_queue_size_watermark"
+	queueBurstWatermark = "_core_queue_discard_burst_watermark"
+)
+
+// Add the max queue size and the max discard burst (discarded messages between
+// two stats reports) observed to the core.queue metrics. Unlike rsyslog's
+// maxqsize they survive rsyslog restarts. The first report of the queue is the
+// discard baseline, a counter reset (rsyslog restart) is a burst on its own.
+func (rs *RsyslogStats) addQueueWatermarks(m RsyslogStatsMetrics) {
+	prefix := rs.MetricPrefix + "_core_queue_"
+	sizes := m[prefix+"size"]
+
+	if len(sizes) == 0 {
+		return
+	}
+
+	sizeFamily := rs.MetricPrefix + queueSizeWatermark
+	burstFamily := rs.MetricPrefix + queueBurstWatermark
+	m[sizeFamily] = make(RsyslogStatsLabeledValues, len(sizes))
+	m[burstFamily] = make(RsyslogStatsLabeledValues, len(sizes))
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	for labels, size := range sizes {
+		discarded := m[prefix+"discarded_full"][labels] + m[prefix+"discarded_nf"][labels]
+
+		w, found := rs.watermarks[labels]
+		if !found {
+			w = &queueWatermark{discarded: discarded}
+			rs.watermarks[labels] = w
+		}
+
+		burst := discarded - w.discarded
+		if burst < 0 {
+			burst = discarded
+		}
+
+		if burst > w.maxBurst {
+			w.maxBurst = burst
+		}
+
+		if size > w.maxSize {
+			w.maxSize = size
+		}
+
+		w.discarded = discarded
+		m[sizeFamily][labels] = w.maxSize
+		m[burstFamily][labels] = w.maxBurst
+	}
+}
+
+// Check if the family is a queue watermark one
+func (rs *RsyslogStats) isQueueWatermark(metricName string) bool {
+	return metricName == rs.MetricPrefix+queueSizeWatermark || metricName == rs.MetricPrefix+queueBurstWatermark
+}
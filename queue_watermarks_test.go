@@ -0,0 +1,65 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RsyslogStats.addQueueWatermarks
+func TestRsyslogStatsQueueWatermarks(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.QueueWatermarks = true
+
+	for _, line := range []string{
+		`{"name": "main Q", "origin": "core.queue", "size": 10, "discarded.full": 100, "discarded.nf": 5}`,
+		`{"name": "main Q", "origin": "core.queue", "size": 50, "discarded.full": 120, "discarded.nf": 5}`,
+		`{"name": "main Q", "origin": "core.queue", "size": 20, "discarded.full": 125, "discarded.nf": 10}`,
+		// rsyslog restart
+		`{"name": "main Q", "origin": "core.queue", "size": 0, "discarded.full": 3, "discarded.nf": 0}`,
+		`{"name": "imudp(*:514)", "origin": "imudp", "submitted": 1}`,
+	} {
+		rs.Parse(line)
+	}
+
+	labels := RsyslogStatsLabels{"name", "main Q"}
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size_watermark":          {labels: 50},
+		"rsyslog_core_queue_discard_burst_watermark": {labels: 20},
+	}
+
+	got := RsyslogStatsMetrics{}
+	for name := range want {
+		got[name] = rs.Metrics[name]
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("watermarks mismatch (-want +got):\n%s", diff)
+	}
+
+	if mType := metricValueType(rs, "rsyslog_core_queue_size_watermark"); mType != prometheus.GaugeValue {
+		t.Errorf("watermark must be a gauge, got %v", mType)
+	}
+}
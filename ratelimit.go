@@ -0,0 +1,132 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Idle peer buckets are dropped once per interval
+const rateLimitPruneInterval = time.Minute
+
+var rateLimited = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rsyslog_exporter_ratelimited_total",
+		Help: "Amount of stats lines dropped by the per-peer rate limit",
+	},
+	[]string{"peer"},
+)
+
+// Metrics exported by the per-peer rate limiter
+var rateLimitMetrics = []prometheus.Collector{
+	rateLimited,
+}
+
+// Token bucket of the peer
+type peerBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// PeerRateLimiter limits the stats lines parsed per peer address by the token
+// bucket, so a single misconfigured host can't starve the parser
+type PeerRateLimiter struct {
+	sync.Mutex
+	rate   float64
+	burst  float64
+	peers  map[string]*peerBucket
+	pruned time.Time
+}
+
+// Per-peer rate limiter (no limit if nil)
+var rateLimiter *PeerRateLimiter
+
+// NewPeerRateLimiter is the PeerRateLimiter constructor: rate lines per second
+// with bursts of up to burst lines
+func NewPeerRateLimiter(rate float64, burst int) *PeerRateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &PeerRateLimiter{
+		rate:  rate,
+		burst: float64(burst),
+		peers: make(map[string]*peerBucket),
+	}
+}
+
+// Allow checks if the peer line may be parsed, counting the dropped ones
+func (rl *PeerRateLimiter) Allow(peer string, now time.Time) bool {
+	if rl == nil {
+		return true
+	}
+
+	if rl.take(peer, now) {
+		return true
+	}
+
+	rateLimited.WithLabelValues(peer).Inc()
+
+	return false
+}
+
+// Take the token from the peer bucket
+func (rl *PeerRateLimiter) take(peer string, now time.Time) bool {
+	rl.Lock()
+	defer rl.Unlock()
+
+	if now.Sub(rl.pruned) >= rateLimitPruneInterval {
+		rl.prune(now)
+	}
+
+	b, found := rl.peers[peer]
+	if !found {
+		b = &peerBucket{tokens: rl.burst, updated: now}
+		rl.peers[peer] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(rl.burst, b.tokens+elapsed*rl.rate)
+		b.updated = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Drop the buckets refilled completely: they are the same as the new ones.
+// Must be called with the lock held.
+func (rl *PeerRateLimiter) prune(now time.Time) {
+	for peer, b := range rl.peers {
+		if b.tokens+now.Sub(b.updated).Seconds()*rl.rate >= rl.burst {
+			delete(rl.peers, peer)
+		}
+	}
+
+	rl.pruned = now
+}
@@ -0,0 +1,80 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// PeerRateLimiter.Allow
+func TestPeerRateLimiter(t *testing.T) {
+	t.Parallel()
+
+	rl := NewPeerRateLimiter(2, 3)
+	now := time.Unix(1600000000, 0)
+	limited := testutil.ToFloat64(rateLimited.WithLabelValues("10.0.0.1"))
+
+	var tests = []struct {
+		peer    string
+		elapsed time.Duration
+		want    bool
+	}{
+		// burst
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, false},
+		// other peers are not affected
+		{"10.0.0.2", 0, true},
+		// 2 lines per second
+		{"10.0.0.1", 500 * time.Millisecond, true},
+		{"10.0.0.1", 0, false},
+		{"10.0.0.1", time.Second, true},
+		{"10.0.0.1", 0, true},
+		{"10.0.0.1", 0, false},
+	}
+
+	for i, tt := range tests {
+		now = now.Add(tt.elapsed)
+
+		if got := rl.Allow(tt.peer, now); got != tt.want {
+			t.Errorf("%d: %s: want allowed %v, got %v", i, tt.peer, tt.want, got)
+		}
+	}
+
+	// the counter is global, so check the increase only
+	if got := testutil.ToFloat64(rateLimited.WithLabelValues("10.0.0.1")) - limited; got != 3 {
+		t.Errorf("rate limited lines: want 3, got %v", got)
+	}
+
+	// refilled buckets are pruned
+	rl.Allow("10.0.0.1", now.Add(rateLimitPruneInterval))
+	if len(rl.peers) != 1 {
+		t.Errorf("want 1 peer bucket after pruning, got %d", len(rl.peers))
+	}
+
+	var nilLimiter *PeerRateLimiter
+	if !nilLimiter.Allow("10.0.0.1", now) {
+		t.Error("nil limiter must allow all lines")
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	recordedLines = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_recorded_lines",
+			Help: "Amount of raw stats lines recorded",
+		},
+	)
+
+	recordFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_record_failures",
+			Help: "Amount of raw stats lines failed to record",
+		},
+	)
+)
+
+// Metrics exported by the stream recorder
+var recorderMetrics = []prometheus.Collector{
+	recordedLines,
+	recordFailures,
+}
+
+// Raw stats stream recorder (disabled if nil)
+var recorder *streamRecorder
+
+// Recorded raw stats line
+type recordedLine struct {
+	Time time.Time `json:"time"`
+	Peer string    `json:"peer"`
+	Line string    `json:"line"`
+}
+
+// Tee of the raw stats lines received to the JSON lines file. The file is
+// rotated once it exceeds maxSize: file -> file.1 -> ... -> file.<maxFiles>.
+type streamRecorder struct {
+	sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+// Create the recorder appending to the file
+func newStreamRecorder(path string, maxSize int64, maxFiles int) (*streamRecorder, error) {
+	r := &streamRecorder{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Open the file for appending
+func (r *streamRecorder) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.file, r.size = f, fi.Size()
+
+	return nil
+}
+
+// Shift the rotated files, the oldest one is removed
+func (r *streamRecorder) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	for i := r.maxFiles; i > 0; i-- {
+		from := r.path
+		if i > 1 {
+			from = fmt.Sprintf("%s.%d", r.path, i-1)
+		}
+
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", r.path, i)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if r.maxFiles < 1 {
+		if err := os.Remove(r.path); err != nil {
+			return err
+		}
+	}
+
+	return r.open()
+}
+
+// Record the raw stats line
+func (r *streamRecorder) record(t time.Time, peer, line string) {
+	if r == nil {
+		return
+	}
+
+	if err := r.write(recordedLine{Time: t, Peer: peer, Line: line}); err != nil {
+		recordFailures.Inc()
+		log.Printf("cannot record stats line to %s: %s", r.path, err)
+
+		return
+	}
+
+	recordedLines.Inc()
+}
+
+// Write the JSON line to the file rotating it if needed
+func (r *streamRecorder) write(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	data = append(data, '\n')
+
+	r.Lock()
+	defer r.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(data)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			log.Printf("cannot rotate %s: %s", r.path, err)
+		}
+	}
+
+	n, err := r.file.Write(data)
+	r.size += int64(n)
+
+	return err
+}
@@ -0,0 +1,94 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Read the recorded lines
+func readRecorded(t *testing.T, path string) []recordedLine {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rv := []recordedLine{}
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		var l recordedLine
+		if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+
+		rv = append(rv, l)
+	}
+
+	return rv
+}
+
+// streamRecorder.record
+func TestStreamRecorder(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "stats.jsonl")
+	ts := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	lines := []recordedLine{
+		{ts, "10.0.0.1:514", `{"name": "main Q", "origin": "core.queue", "size": 1}`},
+		{ts, "10.0.0.2:514", `{"name": "main Q", "origin": "core.queue", "size": 2}`},
+		{ts, "10.0.0.3:514", `{"name": "main Q", "origin": "core.queue", "size": 3}`},
+		{ts, "10.0.0.4:514", `{"name": "main Q", "origin": "core.queue", "size": 4}`},
+	}
+
+	data, err := json.Marshal(lines[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two lines per file, one rotated file is kept
+	r, err := newStreamRecorder(path, int64(2*(len(data)+1)), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range lines {
+		r.record(l.Time, l.Peer, l.Line)
+	}
+
+	if diff := cmp.Diff(lines[2:], readRecorded(t, path)); diff != "" {
+		t.Errorf("recorded lines mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(lines[:2], readRecorded(t, path+".1")); diff != "" {
+		t.Errorf("rotated lines mismatch (-want +got):\n%s", diff)
+	}
+
+	var nilRecorder *streamRecorder
+	nilRecorder.record(ts, "", "")
+}
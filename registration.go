@@ -0,0 +1,345 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var registrationUp = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rsyslog_exporter_registration_up",
+		Help: "Whether the exporter is registered in the service registry",
+	},
+	[]string{"registry"},
+)
+
+// Metrics exported by the service registration
+var registrationMetrics = []prometheus.Collector{
+	registrationUp,
+}
+
+// Service registered for the Prometheus target discovery
+type serviceInfo struct {
+	ID       string
+	Name     string
+	Host     string
+	Port     int
+	Labels   map[string]string
+	CheckURL string
+}
+
+// Create the service info: the address is advertised as is, the host is the
+// hostname if it's empty or unspecified (":9292" e.g.), the health is checked
+// via the check address the same way
+func newServiceInfo(name string, address string, checkAddress string, labels []string) (serviceInfo, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return serviceInfo{}, fmt.Errorf("wrong registration address %s: %w", address, err)
+	}
+
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		if host, err = os.Hostname(); err != nil {
+			return serviceInfo{}, err
+		}
+	}
+
+	s := serviceInfo{Name: name, Host: host, Labels: map[string]string{}}
+
+	if s.Port, err = strconv.Atoi(port); err != nil {
+		return serviceInfo{}, fmt.Errorf("wrong registration address %s: %w", address, err)
+	}
+
+	s.ID = fmt.Sprintf("%s-%s-%d", name, host, s.Port)
+
+	checkHost, checkPort, err := net.SplitHostPort(checkAddress)
+	if err != nil {
+		return serviceInfo{}, fmt.Errorf("wrong health check address %s: %w", checkAddress, err)
+	}
+
+	if ip := net.ParseIP(checkHost); checkHost == "" || ip != nil && ip.IsUnspecified() {
+		checkHost = host
+	}
+
+	s.CheckURL = "http://" + net.JoinHostPort(checkHost, checkPort) + "/healthz"
+
+	for _, label := range labels {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return serviceInfo{}, fmt.Errorf("registration label '%s' must be in 'name=value' format", label)
+		}
+
+		s.Labels[parts[0]] = parts[1]
+	}
+
+	return s, nil
+}
+
+// Service registry client
+type serviceRegistry interface {
+	// Registry name reported in the metrics
+	name() string
+	// Register the service or refresh the registration
+	register(ctx context.Context, s serviceInfo) error
+	// Remove the service registration
+	deregister(ctx context.Context, s serviceInfo) error
+}
+
+// Send the JSON request to the registry, the response is decoded to rv unless
+// it's nil
+func registryRequest(ctx context.Context, client *http.Client, method string, url string, header http.Header, body interface{}, rv interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s %s: unexpected status %s", method, url, resp.Status)
+	}
+
+	if rv == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(rv)
+}
+
+// Consul agent registry: the service is registered with the HTTP health
+// check, so Consul removes it if the exporter dies w/o deregistration
+type consulRegistry struct {
+	url    string
+	header http.Header
+	client *http.Client
+}
+
+// Consul registry constructor. The ACL token is read from CONSUL_HTTP_TOKEN.
+func newConsulRegistry(url string) *consulRegistry {
+	header := http.Header{}
+	if token := os.Getenv("CONSUL_HTTP_TOKEN"); token != "" {
+		header.Set("X-Consul-Token", token)
+	}
+
+	return &consulRegistry{url: strings.TrimSuffix(url, "/"), header: header, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (c *consulRegistry) name() string {
+	return "consul"
+}
+
+// Consul service registration request
+type consulService struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Meta    map[string]string
+	Check   consulCheck
+}
+
+// Consul service health check
+type consulCheck struct {
+	HTTP                           string
+	Interval                       string
+	DeregisterCriticalServiceAfter string
+}
+
+func (c *consulRegistry) register(ctx context.Context, s serviceInfo) error {
+	return registryRequest(ctx, c.client, http.MethodPut, c.url+"/v1/agent/service/register", c.header, consulService{
+		ID:      s.ID,
+		Name:    s.Name,
+		Address: s.Host,
+		Port:    s.Port,
+		Meta:    s.Labels,
+		Check: consulCheck{
+			HTTP:                           s.CheckURL,
+			Interval:                       "10s",
+			DeregisterCriticalServiceAfter: "1m",
+		},
+	}, nil)
+}
+
+func (c *consulRegistry) deregister(ctx context.Context, s serviceInfo) error {
+	return registryRequest(ctx, c.client, http.MethodPut, c.url+"/v1/agent/service/deregister/"+s.ID, c.header, nil, nil)
+}
+
+// etcd registry: the service is put as the file_sd target group
+// ({"targets": [...], "labels": {...}}) to <prefix><name>/<id> key attached to
+// the lease kept alive by the exporter. The key is removed by etcd once the
+// lease expires.
+type etcdRegistry struct {
+	url    string
+	prefix string
+	ttl    time.Duration
+	client *http.Client
+	lease  string
+}
+
+// etcd registry constructor (v3 JSON gateway)
+func newEtcdRegistry(url string, prefix string, ttl time.Duration) *etcdRegistry {
+	return &etcdRegistry{url: strings.TrimSuffix(url, "/"), prefix: prefix, ttl: ttl, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (e *etcdRegistry) name() string {
+	return "etcd"
+}
+
+// etcd lease response
+type etcdLease struct {
+	ID     string `json:"ID"`
+	Result *struct {
+		TTL string `json:"TTL"`
+	} `json:"result"`
+}
+
+// Get the service key
+func (e *etcdRegistry) key(s serviceInfo) string {
+	return e.prefix + s.Name + "/" + s.ID
+}
+
+// Grant the lease and put the service key or keep the lease alive
+func (e *etcdRegistry) register(ctx context.Context, s serviceInfo) error {
+	if e.lease != "" {
+		var rv etcdLease
+		if err := registryRequest(ctx, e.client, http.MethodPost, e.url+"/v3/lease/keepalive", nil, map[string]string{"ID": e.lease}, &rv); err != nil {
+			return err
+		}
+
+		// expired lease has no TTL, it's granted again
+		if rv.Result != nil && rv.Result.TTL != "" && rv.Result.TTL != "0" {
+			return nil
+		}
+
+		e.lease = ""
+	}
+
+	var lease etcdLease
+	if err := registryRequest(ctx, e.client, http.MethodPost, e.url+"/v3/lease/grant", nil, map[string]int64{"TTL": int64(e.ttl.Seconds())}, &lease); err != nil {
+		return err
+	}
+
+	group, err := json.Marshal(map[string]interface{}{
+		"targets": []string{net.JoinHostPort(s.Host, strconv.Itoa(s.Port))},
+		"labels":  s.Labels,
+	})
+	if err != nil {
+		return err
+	}
+
+	put := map[string]string{
+		"key":   base64.StdEncoding.EncodeToString([]byte(e.key(s))),
+		"value": base64.StdEncoding.EncodeToString(group),
+		"lease": lease.ID,
+	}
+
+	if err := registryRequest(ctx, e.client, http.MethodPost, e.url+"/v3/kv/put", nil, put, nil); err != nil {
+		return err
+	}
+
+	e.lease = lease.ID
+
+	return nil
+}
+
+// Revoke the lease removing the service key
+func (e *etcdRegistry) deregister(ctx context.Context, s serviceInfo) error {
+	if e.lease == "" {
+		return nil
+	}
+
+	return registryRequest(ctx, e.client, http.MethodPost, e.url+"/v3/lease/revoke", nil, map[string]string{"ID": e.lease}, nil)
+}
+
+// Register the service and refresh the registration every interval (so it's
+// restored after the registry restart) until the context is done, then
+// deregister it
+func runRegistration(ctx context.Context, r serviceRegistry, s serviceInfo, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	registered := false
+
+	for {
+		err := r.register(ctx, s)
+
+		switch {
+		case err == nil:
+			if !registered {
+				log.Printf("%s is registered in %s", s.ID, r.name())
+			}
+
+			registered = true
+			registrationUp.WithLabelValues(r.name()).Set(1)
+		case ctx.Err() == nil:
+			log.Printf("cannot register %s in %s: %s", s.ID, r.name(), err)
+			registrationUp.WithLabelValues(r.name()).Set(0)
+		}
+
+		select {
+		case <-ctx.Done():
+			deregisterService(r, s)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deregister the service on shutdown
+func deregisterService(r serviceRegistry, s serviceInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.deregister(ctx, s); err != nil {
+		log.Printf("cannot deregister %s from %s: %s", s.ID, r.name(), err)
+		return
+	}
+
+	registrationUp.WithLabelValues(r.name()).Set(0)
+	log.Printf("%s is deregistered from %s", s.ID, r.name())
+}
@@ -0,0 +1,294 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// newServiceInfo
+func TestNewServiceInfo(t *testing.T) {
+	t.Parallel()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		address string
+		check   string
+		labels  []string
+		want    serviceInfo
+		err     bool
+	}{
+		{
+			"exporter.example.com:9292", ":9293", []string{"env=prod", "url=http://a/?b=c"},
+			serviceInfo{
+				ID:       "rsyslog_exporter-exporter.example.com-9292",
+				Name:     "rsyslog_exporter",
+				Host:     "exporter.example.com",
+				Port:     9292,
+				Labels:   map[string]string{"env": "prod", "url": "http://a/?b=c"},
+				CheckURL: "http://exporter.example.com:9293/healthz",
+			},
+			false,
+		},
+		{
+			"0.0.0.0:9292", "127.0.0.1:9292", nil,
+			serviceInfo{
+				ID:       "rsyslog_exporter-" + hostname + "-9292",
+				Name:     "rsyslog_exporter",
+				Host:     hostname,
+				Port:     9292,
+				Labels:   map[string]string{},
+				CheckURL: "http://127.0.0.1:9292/healthz",
+			},
+			false,
+		},
+		{"9292", ":9292", nil, serviceInfo{}, true},
+		{":http", ":9292", nil, serviceInfo{}, true},
+		{":9292", ":9292", []string{"env"}, serviceInfo{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := newServiceInfo("rsyslog_exporter", tt.address, tt.check, tt.labels)
+		if (err != nil) != tt.err {
+			t.Errorf("newServiceInfo(%s): want error %t, got %v", tt.address, tt.err, err)
+			continue
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("newServiceInfo(%s) mismatch (-want +got):\n%s", tt.address, diff)
+		}
+	}
+}
+
+// Fake registry API recording the requests
+type fakeRegistryAPI struct {
+	sync.Mutex
+	requests []string
+	bodies   map[string]string
+	handler  func(path string) string
+}
+
+func (f *fakeRegistryAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.bodies[r.URL.Path] = string(body)
+	f.Unlock()
+
+	if f.handler != nil {
+		_, _ = io.WriteString(w, f.handler(r.URL.Path))
+	}
+}
+
+// Get the requests received
+func (f *fakeRegistryAPI) get() ([]string, map[string]string) {
+	f.Lock()
+	defer f.Unlock()
+
+	bodies := make(map[string]string, len(f.bodies))
+	for path, body := range f.bodies {
+		bodies[path] = body
+	}
+
+	return append([]string{}, f.requests...), bodies
+}
+
+// Run the registration until the context is canceled
+func runTestRegistration(t *testing.T, r serviceRegistry, s serviceInfo, wait func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		runRegistration(ctx, r, s, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !wait() {
+		if time.Now().After(deadline) {
+			t.Fatal("registration is not refreshed")
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+var testService = serviceInfo{
+	ID:       "rsyslog_exporter-host-9292",
+	Name:     "rsyslog_exporter",
+	Host:     "host",
+	Port:     9292,
+	Labels:   map[string]string{"env": "prod"},
+	CheckURL: "http://host:9292/healthz",
+}
+
+// consulRegistry
+func TestConsulRegistry(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeRegistryAPI{bodies: map[string]string{}}
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	runTestRegistration(t, newConsulRegistry(ts.URL), testService, func() bool {
+		requests, _ := api.get()
+		return len(requests) >= 2
+	})
+
+	requests, bodies := api.get()
+
+	if got, want := requests[0], "PUT /v1/agent/service/register"; got != want {
+		t.Errorf("first request: want %s, got %s", want, got)
+	}
+
+	if got, want := requests[len(requests)-1], "PUT /v1/agent/service/deregister/rsyslog_exporter-host-9292"; got != want {
+		t.Errorf("last request: want %s, got %s", want, got)
+	}
+
+	var got consulService
+	if err := json.Unmarshal([]byte(bodies["/v1/agent/service/register"]), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	want := consulService{
+		ID:      "rsyslog_exporter-host-9292",
+		Name:    "rsyslog_exporter",
+		Address: "host",
+		Port:    9292,
+		Meta:    map[string]string{"env": "prod"},
+		Check:   consulCheck{HTTP: "http://host:9292/healthz", Interval: "10s", DeregisterCriticalServiceAfter: "1m"},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("registration mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// etcdRegistry
+func TestEtcdRegistry(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeRegistryAPI{bodies: map[string]string{}}
+	api.handler = func(path string) string {
+		switch path {
+		case "/v3/lease/grant":
+			return `{"ID":"7587","TTL":"30"}`
+		case "/v3/lease/keepalive":
+			return `{"result":{"ID":"7587","TTL":"30"}}`
+		}
+
+		return "{}"
+	}
+
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	runTestRegistration(t, newEtcdRegistry(ts.URL, "/services/", 30*time.Second), testService, func() bool {
+		requests, _ := api.get()
+		return len(requests) >= 4
+	})
+
+	requests, bodies := api.get()
+
+	want := []string{"POST /v3/lease/grant", "POST /v3/kv/put", "POST /v3/lease/keepalive"}
+	if diff := cmp.Diff(want, requests[:3]); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+
+	if got, want := requests[len(requests)-1], "POST /v3/lease/revoke"; got != want {
+		t.Errorf("last request: want %s, got %s", want, got)
+	}
+
+	var put map[string]string
+	if err := json.Unmarshal([]byte(bodies["/v3/kv/put"]), &put); err != nil {
+		t.Fatal(err)
+	}
+
+	key, _ := base64.StdEncoding.DecodeString(put["key"])
+	value, _ := base64.StdEncoding.DecodeString(put["value"])
+
+	got := map[string]string{"key": string(key), "value": string(value), "lease": put["lease"]}
+	wantPut := map[string]string{
+		"key":   "/services/rsyslog_exporter/rsyslog_exporter-host-9292",
+		"value": `{"labels":{"env":"prod"},"targets":["host:9292"]}`,
+		"lease": "7587",
+	}
+
+	if diff := cmp.Diff(wantPut, got); diff != "" {
+		t.Errorf("put mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// etcdRegistry.register
+func TestEtcdRegistryExpiredLease(t *testing.T) {
+	t.Parallel()
+
+	api := &fakeRegistryAPI{bodies: map[string]string{}}
+	api.handler = func(path string) string {
+		if path == "/v3/lease/grant" {
+			return `{"ID":"7588","TTL":"30"}`
+		}
+
+		// expired lease keepalive response has no TTL
+		return `{"result":{"ID":"7587"}}`
+	}
+
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	e := newEtcdRegistry(ts.URL, "/services/", 30*time.Second)
+	e.lease = "7587"
+
+	if err := e.register(context.Background(), testService); err != nil {
+		t.Fatal(err)
+	}
+
+	if e.lease != "7588" {
+		t.Errorf("lease: want 7588, got %s", e.lease)
+	}
+
+	requests, _ := api.get()
+	want := []string{"POST /v3/lease/keepalive", "POST /v3/lease/grant", "POST /v3/kv/put"}
+
+	if diff := cmp.Diff(want, requests); diff != "" {
+		t.Errorf("requests mismatch (-want +got):\n%s", diff)
+	}
+}
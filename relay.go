@@ -0,0 +1,162 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+var (
+	relayedMessages = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_relayed_messages",
+			Help: "Amount of non-stats messages relayed downstream",
+		},
+	)
+
+	relayFailures = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_relay_failures",
+			Help: "Amount of non-stats messages failed to relay downstream",
+		},
+	)
+)
+
+// Metrics exported by the relay
+var relayMetrics = []prometheus.Collector{
+	relayedMessages,
+	relayFailures,
+}
+
+// Relay of non-stats messages to the downstream syslog server.
+// URL format: udp://host:port or tcp://host:port
+type syslogRelay struct {
+	url    *url.URL
+	format string
+	// Tags (RFC3164) or app names (RFC5424) of the stats messages, any if empty
+	tags map[string]bool
+	conn net.Conn
+}
+
+// Create the syslog relay
+func newSyslogRelay(addr string, syslogFormat string, tags []string) (*syslogRelay, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "udp" && u.Scheme != "tcp" {
+		return nil, fmt.Errorf("wrong relay address: %s", addr)
+	}
+
+	if _, err = syslogFormatByName(syslogFormat); err != nil {
+		return nil, err
+	}
+
+	r := &syslogRelay{url: u, format: syslogFormat, tags: make(map[string]bool)}
+	for _, tag := range tags {
+		r.tags[tag] = true
+	}
+
+	return r, nil
+}
+
+// Check if the message is the stats one: the tag is listed and the content is a JSON object
+func (r *syslogRelay) isStats(line format.LogParts, content string) bool {
+	if len(r.tags) > 0 {
+		tag, _ := line["tag"].(string)
+		if appName, ok := line["app_name"].(string); ok {
+			tag = appName
+		}
+
+		if !r.tags[tag] {
+			return false
+		}
+	}
+
+	return strings.HasPrefix(strings.TrimSpace(content), "{")
+}
+
+// Get the RFC5424 header field, "-" if it's empty
+func rfc5424Field(line format.LogParts, key string) string {
+	if value, ok := line[key].(string); ok && value != "" {
+		return value
+	}
+
+	return "-"
+}
+
+// Format the message back to the syslog wire format
+func (r *syslogRelay) formatMessage(line format.LogParts, content string) string {
+	timestamp, ok := line["timestamp"].(time.Time)
+	if !ok || timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	if r.format == "rfc5424" {
+		return fmt.Sprintf("<%v>1 %s %s %s %s %s %s %s",
+			line["priority"], timestamp.Format(time.RFC3339Nano), rfc5424Field(line, "hostname"),
+			rfc5424Field(line, "app_name"), rfc5424Field(line, "proc_id"), rfc5424Field(line, "msg_id"),
+			rfc5424Field(line, "structured_data"), content)
+	}
+
+	return fmt.Sprintf("<%v>%s %s %s: %s",
+		line["priority"], timestamp.Format(time.Stamp), messageSender(line), line["tag"], content)
+}
+
+// Forward the message downstream reconnecting if needed
+func (r *syslogRelay) forward(line format.LogParts, content string) {
+	msg := r.formatMessage(line, content)
+	if r.url.Scheme == "tcp" {
+		msg += "\n"
+	}
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout(r.url.Scheme, r.url.Host, 5*time.Second)
+		if err != nil {
+			log.Printf("cannot connect to the relay %s: %s", r.url.Redacted(), err)
+			relayFailures.Inc()
+
+			return
+		}
+
+		r.conn = conn
+	}
+
+	if _, err := r.conn.Write([]byte(msg)); err != nil {
+		log.Printf("cannot relay message to %s: %s", r.url.Redacted(), err)
+		relayFailures.Inc()
+
+		r.conn.Close()
+		r.conn = nil
+
+		return
+	}
+
+	relayedMessages.Inc()
+}
@@ -0,0 +1,95 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// syslogRelay.isStats
+func TestSyslogRelayIsStats(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		tags    []string
+		line    format.LogParts
+		content string
+		output  bool
+	}{
+		{[]string{"rsyslogd-pstats"}, format.LogParts{"tag": "rsyslogd-pstats"}, `{"name": "main Q"}`, true},
+		{[]string{"rsyslogd-pstats"}, format.LogParts{"tag": "sshd"}, `{"name": "main Q"}`, false},
+		{[]string{"rsyslogd-pstats"}, format.LogParts{"tag": "rsyslogd-pstats"}, `rsyslogd started`, false},
+		{[]string{"rsyslogd-pstats"}, format.LogParts{"app_name": "rsyslogd-pstats"}, ` {}`, true},
+		{[]string{}, format.LogParts{"tag": "sshd"}, `{}`, true},
+	}
+
+	for _, c := range tests {
+		r, err := newSyslogRelay("udp://127.0.0.1:514", "rfc3164", c.tags)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := r.isStats(c.line, c.content); got != c.output {
+			t.Errorf("%v %s: want %t, got %t", c.line, c.content, c.output, got)
+		}
+	}
+}
+
+// syslogRelay.formatMessage
+func TestSyslogRelayFormatMessage(t *testing.T) {
+	t.Parallel()
+
+	timestamp := time.Date(2021, time.October, 6, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		format string
+		line   format.LogParts
+		output string
+	}{
+		{
+			"rfc3164",
+			format.LogParts{"priority": 13, "timestamp": timestamp, "hostname": "host1", "tag": "sshd"},
+			"<13>Oct  6 12:00:00 host1 sshd: hello",
+		},
+		{
+			"rfc5424",
+			format.LogParts{"priority": 13, "timestamp": timestamp, "hostname": "host1", "app_name": "sshd", "proc_id": "12", "structured_data": "-"},
+			"<13>1 2021-10-06T12:00:00Z host1 sshd 12 - - hello",
+		},
+	}
+
+	for _, c := range tests {
+		r, err := newSyslogRelay("tcp://127.0.0.1:514", c.format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := r.formatMessage(c.line, "hello"); got != c.output {
+			t.Errorf("want '%s', got '%s'", c.output, got)
+		}
+	}
+
+	if _, err := newSyslogRelay("http://127.0.0.1:514", "rfc3164", nil); err == nil {
+		t.Error("wrong relay address error expected")
+	}
+}
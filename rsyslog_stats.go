@@ -21,40 +21,76 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Sanitise metric name
+// Check if the byte is allowed in the sanitised metric name
+func isMetricNameByte(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9')
+}
+
+// Sanitise metric name: lowercase, non-alnum chars replaced by underscore,
+// multiple underscores squashed, trailing ones stripped
 func sanitiseMetricName(name string) string {
-	reNonAlNum := regexp.MustCompile("[^_a-zA-Z0-9]")
-	reUnderscores := regexp.MustCompile("_+")
-	nn := strings.ToLower(name)
-	// replace all non-alnum chars by underscore
-	nn = reNonAlNum.ReplaceAllLiteralString(nn, "_")
-	// squash multiple underscores
-	nn = reUnderscores.ReplaceAllLiteralString(nn, "_")
-	// strip trailing underscore
-	nn = strings.TrimRight(nn, "_")
+	sane := true
+	for i := 0; i < len(name) && sane; i++ {
+		sane = isMetricNameByte(name[i]) && !(name[i] == '_' && (i == len(name)-1 || name[i+1] == '_'))
+	}
+
+	if sane {
+		return name
+	}
+
+	b := getBuffer()
+	defer putBuffer(b)
+
+	nn := *b
+	for _, r := range name {
+		r = unicode.ToLower(r)
+
+		c := byte('_')
+		if r < utf8.RuneSelf && isMetricNameByte(byte(r)) {
+			c = byte(r)
+		}
+
+		if c == '_' && len(nn) > 0 && nn[len(nn)-1] == '_' {
+			continue
+		}
 
-	return nn
+		nn = append(nn, c)
+	}
+
+	*b = nn
+
+	return strings.TrimRight(string(nn), "_")
 }
 
 // Split dynstats counter stats by "." from right
 func splitRight(str string) (string, string) {
 	i := strings.LastIndexAny(str, ".")
+	if i < 0 {
+		return "", str
+	}
 
 	return str[:i], str[i+1:]
 }
 
-func appendMetric(m RsyslogStatsMetrics, metricName string, labels RsyslogStatsLabels, value interface{}) RsyslogStatsMetrics {
+func appendMetric(m RsyslogStatsMetrics, metricName string, labels RsyslogStatsLabels, value float64) RsyslogStatsMetrics {
 	saneMetricName := sanitiseMetricName(metricName)
-	saneValue := RsyslogStatsValue(value.(float64))
+	// rsyslog counters are integers
+	saneValue := RsyslogStatsValue(math.Trunc(value))
 
 	if _, found := m[saneMetricName]; !found {
 		m[saneMetricName] = make(RsyslogStatsLabeledValues)
@@ -65,6 +101,29 @@ func appendMetric(m RsyslogStatsMetrics, metricName string, labels RsyslogStatsL
 	return m
 }
 
+// Append the metric value checking that no other raw counter is sanitised to
+// the same series
+func (rs *RsyslogStats) appendMetric(m RsyslogStatsMetrics, metricName string, labels RsyslogStatsLabels, value float64) RsyslogStatsMetrics {
+	saneMetricName := sanitiseMetricName(metricName)
+
+	if _, found := rs.rawNames[saneMetricName]; !found {
+		rs.rawNames[rs.interned.intern(saneMetricName)] = make(map[RsyslogStatsLabels]string)
+	}
+
+	if raw, found := rs.rawNames[saneMetricName][labels]; found && raw != metricName {
+		rs.Lock()
+		if rs.Collisions[saneMetricName] == 0 {
+			log.Printf("metric %s%v collision: %s and %s are sanitised to the same series", saneMetricName, labels.Values(), raw, metricName)
+		}
+		rs.Collisions[saneMetricName]++
+		rs.Unlock()
+	}
+
+	rs.rawNames[saneMetricName][rs.interned.internLabels(labels)] = rs.interned.intern(metricName)
+
+	return appendMetric(m, metricName, labels, value)
+}
+
 func getValue(value interface{}) (rv float64, e error) {
 	switch v := value.(type) {
 	case float64:
@@ -78,21 +137,144 @@ func getValue(value interface{}) (rv float64, e error) {
 	return rv, e
 }
 
-// RsyslogStatsValue is the metric value type
-type RsyslogStatsValue int
+// Field value conversion error: the field is skipped, other fields of the
+// object are still parsed
+type fieldError struct {
+	field string
+	err   error
+}
+
+// Error implements error
+func (fe *fieldError) Error() string {
+	return fmt.Sprintf("field '%s': %s", fe.field, fe.err)
+}
+
+// Unwrap returns the conversion error
+func (fe *fieldError) Unwrap() error {
+	return fe.err
+}
+
+// RsyslogStatsValue is the metric value type. Parsed counters are integers,
+// derived gauges (ratios e.g.) may be fractional.
+type RsyslogStatsValue float64
 
 // RsyslogStatsLabels holds the metric value labels
 // Label: {name="main Q"} -> { Name: "name", Value: "main Q" }
-// Just one label per value is used at the moment
+// Multiple labels are joined by separators to keep the struct comparable:
+// {name="main Q",counter="size"} -> { Name: "name,counter", Value: "main Q\x00size" }
 type RsyslogStatsLabels struct {
 	Name  string
 	Value string
 }
 
+const (
+	labelNameSep  = ","
+	labelValueSep = "\x00"
+)
+
+// With returns a copy of the labels with one more label appended
+func (l RsyslogStatsLabels) With(name, value string) RsyslogStatsLabels {
+	if l.Name == "" {
+		return RsyslogStatsLabels{name, value}
+	}
+
+	return RsyslogStatsLabels{l.Name + labelNameSep + name, l.Value + labelValueSep + value}
+}
+
+// Merge returns a copy of the labels with the other labels appended
+func (l RsyslogStatsLabels) Merge(other RsyslogStatsLabels) RsyslogStatsLabels {
+	if other.Name == "" {
+		return l
+	}
+
+	if l.Name == "" {
+		return other
+	}
+
+	return RsyslogStatsLabels{l.Name + labelNameSep + other.Name, l.Value + labelValueSep + other.Value}
+}
+
+// Attach the labels to all metric values
+func withLabels(m RsyslogStatsMetrics, labels RsyslogStatsLabels) RsyslogStatsMetrics {
+	if labels.Name == "" {
+		return m
+	}
+
+	rv := make(RsyslogStatsMetrics, len(m))
+	for metricName, labeledValues := range m {
+		rv[metricName] = make(RsyslogStatsLabeledValues, len(labeledValues))
+		for l, value := range labeledValues {
+			rv[metricName][l.Merge(labels)] = value
+		}
+	}
+
+	return rv
+}
+
+// Names returns the label names
+func (l RsyslogStatsLabels) Names() []string {
+	if l.Name == "" {
+		return nil
+	}
+
+	return strings.Split(l.Name, labelNameSep)
+}
+
+// Values returns the label values (in the same order as Names)
+func (l RsyslogStatsLabels) Values() []string {
+	if l.Name == "" {
+		return nil
+	}
+
+	return strings.Split(l.Value, labelValueSep)
+}
+
 // RsyslogStatsLabeledValues is the map of labeled metric values
 // Map of metric values with their labels: { {name="main Q"}: 123, ...}
 type RsyslogStatsLabeledValues map[RsyslogStatsLabels]RsyslogStatsValue
 
+// SortedLabels returns the label sets sorted by label values, then names
+func (lv RsyslogStatsLabeledValues) SortedLabels() []RsyslogStatsLabels {
+	rv := make([]RsyslogStatsLabels, 0, len(lv))
+	for labels := range lv {
+		rv = append(rv, labels)
+	}
+
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Value != rv[j].Value {
+			return rv[i].Value < rv[j].Value
+		}
+
+		return rv[i].Name < rv[j].Name
+	})
+
+	return rv
+}
+
+// SortedNames returns the metric names sorted
+func (m RsyslogStatsMetrics) SortedNames() []string {
+	rv := make([]string, 0, len(m))
+	for metricName := range m {
+		rv = append(rv, metricName)
+	}
+
+	sort.Strings(rv)
+
+	return rv
+}
+
+// Get the map keys sorted
+func sortedKeys[V any](m map[string]V) []string {
+	rv := make([]string, 0, len(m))
+	for key := range m {
+		rv = append(rv, key)
+	}
+
+	sort.Strings(rv)
+
+	return rv
+}
+
 // RsyslogStatsMetrics holds the metrics with their labeled values
 // Map of metrics: '{ "rsyslog_core_queue_discarded_full": { {"name":"main Q"}: 123 }, ... }, ...'
 type RsyslogStatsMetrics map[string]RsyslogStatsLabeledValues
@@ -107,6 +289,117 @@ type RsyslogStats struct {
 	MetricPrefix   string
 	NameField      string
 	OriginField    string
+	// JSON path to the impstats object inside wrapper documents (`$.log.original` e.g.)
+	JSONPath string
+	// Expression hooks applied to parsed metrics before they are stored
+	Transforms []*Transform
+	// Max amount of label sets per metric family (0 means unlimited)
+	MaxFamilySeries int
+	// Amount of label sets rejected per metric family due to MaxFamilySeries
+	RejectedSeries map[string]int
+	// Amount of parser failures per sender
+	SenderParserFailures map[string]int
+	// Amount of series overwritten by a different raw counter per metric family
+	Collisions map[string]int
+	// Amount of series with invalid metric/label names or values
+	QuarantinedSeries int
+	// Amount of messages parsed per origin
+	OriginMessages map[string]int
+	// Amount of messages parsed per listener and origin
+	ListenerOriginMessages map[RsyslogStatsListenerOrigin]int
+	// Amount of rsyslog stats parsing failures per listener
+	ListenerParserFailures map[string]int
+	// Amount of fields skipped due to the value conversion errors per origin and field
+	FieldFailures map[RsyslogStatsOriginField]int
+	// Add queue_kind label to core.queue metrics
+	QueueKindLabel bool
+	// Canonicalise core.action and core.queue names
+	CanonicalNames bool
+	// Keep the original name in the raw_name label when CanonicalNames is set
+	RawNameLabel bool
+	// Senders tracked individually, others are aggregated (all are tracked if nil)
+	SenderAllowlist *SenderAllowlist
+	// Export core.queue size and discard burst watermarks
+	QueueWatermarks bool
+	// Export core.action suspension state and transitions
+	ActionSuspension bool
+	// Export core.action per-interval failure ratio
+	ActionFailureRatio bool
+	// Add target and port labels matched by the named groups in core.action names
+	ActionTargetPattern *regexp.Regexp
+	// Add protocol, target and port labels parsed from omfwd stats names
+	ForwardTargetLabels bool
+	// Add listener, port and worker labels parsed from imudp, imtcp and imptcp
+	// stats names
+	ListenerStatsLabels bool
+	// Export the impstats resource-usage counters as the conventional process
+	// metrics as well
+	ProcessMetrics bool
+	// Parse the core.action stats of the database output modules by their
+	// default names (action-2-ommysql) as the database action stats
+	DatabaseActions bool
+	// Parse the omkafka stats into the families labeled by the action and the
+	// topic (matched by the "topic" named group of the pattern if set)
+	OmkafkaStats        bool
+	OmkafkaTopicPattern *regexp.Regexp
+	// Sum the per-worker core.action and core.queue series matched by the
+	// "action" and "worker" named groups into the logical series (if set)
+	WorkerPattern *regexp.Regexp
+	// Threshold rules exported as the status gauges per stats object
+	StatusRules []*StatusRule
+	// Decode core.queue, core.action and sender stats into structs (generic path is used otherwise)
+	TypedDecoding bool
+	// Series not updated for longer are expired (never if 0)
+	SeriesTTL time.Duration
+	// Amount of series expired
+	ExpiredSeries int
+	// Amount of metric families rebuilt to return the memory of the expired series
+	RebuiltFamilies int
+
+	// Origins exported as a single family with a "counter" label (origin -> family name)
+	counterLabelOrigins  map[string]string
+	counterLabelFamilies map[string]bool
+
+	// Origins additionally exported with the original counter names
+	rawOrigins map[string]bool
+
+	// Latest message counters of the senders not in the allowlist
+	otherSenderMessages map[string]otherSenderCounter
+	// Last update time per metric family
+	familyUpdated map[string]time.Time
+	// Latest quarantined series
+	quarantineSamples []RsyslogStatsQuarantineSample
+	// Latest parse failures
+	parseFailureSamples []RsyslogStatsParseFailure
+	// Last parse time per sender
+	senderSeen map[string]time.Time
+	// Last parse failure time per sender
+	senderFailed map[string]time.Time
+	// Last parse time of any stats message
+	lastParsed time.Time
+	// Raw (unsanitised) metric names of the series stored
+	rawNames map[string]map[RsyslogStatsLabels]string
+	// Source families of the families renamed by the transforms
+	renamedFamilies map[string]string
+	// Queue watermarks per core.queue series labels
+	watermarks map[RsyslogStatsLabels]*queueWatermark
+	// Action suspension state per core.action series labels
+	suspensions map[RsyslogStatsLabels]*actionSuspension
+	// Latest core.action counters per series labels
+	actionCounters map[RsyslogStatsLabels]actionCounters
+	// Latest counters per stats object evaluated by the status rules
+	statusCounters map[statusObject]map[string]float64
+	// Approximate memory used by the stored series
+	storeBytes int64
+	// Peak series count per family since it was created or rebuilt
+	familyPeak map[string]int
+	// Last update Unix time per series (tracked if SeriesTTL is set)
+	seriesUpdated map[string]map[RsyslogStatsLabels]int64
+	// Metric names and label names and values stored
+	interned stringPool
+
+	// Origin to stat type mapping, swapped atomically on reload
+	schema atomic.Pointer[RsyslogStatsSchema]
 
 	parsersByType map[rsyslogStatType]parserForType
 }
@@ -117,14 +410,41 @@ func NewRsyslogStats() *RsyslogStats {
 	rs.MetricPrefix = "rsyslog"
 	rs.NameField = "name"
 	rs.OriginField = "origin"
+	rs.TypedDecoding = true
 	rs.ParserFailures = 0
 	rs.ParsedMessages = 0
 	rs.Metrics = make(RsyslogStatsMetrics)
+	rs.RejectedSeries = make(map[string]int)
+	rs.SenderParserFailures = make(map[string]int)
+	rs.OriginMessages = make(map[string]int)
+	rs.ListenerOriginMessages = make(map[RsyslogStatsListenerOrigin]int)
+	rs.ListenerParserFailures = make(map[string]int)
+	rs.FieldFailures = make(map[RsyslogStatsOriginField]int)
+	rs.otherSenderMessages = make(map[string]otherSenderCounter)
+	rs.familyUpdated = make(map[string]time.Time)
+	rs.Collisions = make(map[string]int)
+	rs.rawNames = make(map[string]map[RsyslogStatsLabels]string)
+	rs.renamedFamilies = make(map[string]string)
+	rs.watermarks = make(map[RsyslogStatsLabels]*queueWatermark)
+	rs.suspensions = make(map[RsyslogStatsLabels]*actionSuspension)
+	rs.actionCounters = make(map[RsyslogStatsLabels]actionCounters)
+	rs.statusCounters = make(map[statusObject]map[string]float64)
+	rs.familyPeak = make(map[string]int)
+	rs.seriesUpdated = make(map[string]map[RsyslogStatsLabels]int64)
+	rs.counterLabelOrigins = make(map[string]string)
+	rs.counterLabelFamilies = make(map[string]bool)
+	rs.rawOrigins = make(map[string]bool)
+	rs.senderSeen = make(map[string]time.Time)
+	rs.senderFailed = make(map[string]time.Time)
 
 	rs.parsersByType = map[rsyslogStatType]parserForType{
 		rtDynstatGlobal: rs.parseDynstatsGlobal,
 		rtDynstatBucket: rs.parseDynstatsBucket,
 		rtSender:        rs.parseSenderStats,
+		rtDatabase:      rs.parseDatabaseStats,
+		rtPercentile:    rs.parsePercentileStats,
+		rtDynafileCache: rs.parseDynafileCacheStats,
+		rtOmkafka:       rs.parseOmkafkaStats,
 		rtNamed:         rs.parseNamedStats,
 		rtDefault:       rs.parseDefault,
 	}
@@ -132,25 +452,319 @@ func NewRsyslogStats() *RsyslogStats {
 	return rs
 }
 
-// Add collected metrics from `m`
-func (rs *RsyslogStats) add(m RsyslogStatsMetrics) {
+// SetSchema replaces the origin to stat type mapping
+func (rs *RsyslogStats) SetSchema(s *RsyslogStatsSchema) {
+	rs.schema.Store(s)
+}
+
+// SetCounterLabelOrigins enables the counter-as-label layout for the origins
+// listed: `rsyslog_core_queue{name="main Q",counter="enqueued"}` instead of
+// `rsyslog_core_queue_enqueued{name="main Q"}`
+func (rs *RsyslogStats) SetCounterLabelOrigins(origins []string) {
+	for _, origin := range origins {
+		family := sanitiseMetricName(rs.MetricPrefix + "_" + origin)
+		rs.counterLabelOrigins[origin] = family
+		rs.counterLabelFamilies[family] = true
+	}
+}
+
+// SetRawOrigins enables the raw passthrough family for the origins listed:
+// `rsyslog_raw{origin="core.queue",name="main Q",counter="discarded.full"}`
+func (rs *RsyslogStats) SetRawOrigins(origins []string) {
+	for _, origin := range origins {
+		rs.rawOrigins[origin] = true
+	}
+}
+
+// RawFamily returns the raw passthrough family name
+func (rs *RsyslogStats) RawFamily() string {
+	return rs.MetricPrefix + "_raw"
+}
+
+// IsCounterLabelFamily checks if the metric family uses the counter-as-label layout
+func (rs *RsyslogStats) IsCounterLabelFamily(metricName string) bool {
+	return rs.counterLabelFamilies[metricName]
+}
+
+// Add collected metrics from `m`. Returns the amount of series stored (the
+// quarantined and the rejected ones are not).
+func (rs *RsyslogStats) add(m RsyslogStatsMetrics) int {
+	now := time.Now()
+	stored := 0
+
 	for metric, data := range m {
 		rs.Lock()
+		metric = rs.interned.intern(metric)
+
 		for labels, value := range data {
+			if err := validateSeries(metric, labels); err != nil {
+				rs.quarantine(metric, labels, err)
+				continue
+			}
+
+			// the stored map keys are replaced on every update
+			labels = rs.interned.internLabels(labels)
+
+			rs.familyUpdated[metric] = now
+
 			if _, found := rs.Metrics[metric]; !found {
 				rs.Metrics[metric] = RsyslogStatsLabeledValues{}
+				rs.storeBytes += familyBytes(metric)
+			}
+
+			_, found := rs.Metrics[metric][labels]
+			if !found && rs.familyIsFull(metric) {
+				if rs.RejectedSeries[metric] == 0 {
+					log.Printf("metric family %s reached %d series limit, rejecting new label sets", metric, rs.MaxFamilySeries)
+				}
+				rs.RejectedSeries[metric]++
+				continue
 			}
 
 			rs.Metrics[metric][labels] = value
+			rs.trackSeries(metric, labels, now, !found)
+			stored++
 		}
 		rs.Unlock()
 	}
+
+	return stored
+}
+
+// FamilyUpdated returns the metric family last update time (must be called with lock held)
+func (rs *RsyslogStats) FamilyUpdated(metric string) time.Time {
+	return rs.familyUpdated[metric]
+}
+
+// Prometheus metric and label name formats
+var (
+	reMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	reLabelName  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Check the series can be exported safely
+func validateSeries(metric string, labels RsyslogStatsLabels) error {
+	if !reMetricName.MatchString(metric) {
+		return fmt.Errorf("invalid metric name '%s'", metric)
+	}
+
+	if labels.Name == "" {
+		return nil
+	}
+
+	// labels are walked in place, the series are validated on every update
+	names, values := labels.Name, labels.Value
+	if n, v := strings.Count(names, labelNameSep), strings.Count(values, labelValueSep); n != v {
+		return fmt.Errorf("%d label names don't match %d label values", n+1, v+1)
+	}
+
+	for more := true; more; {
+		var name, value string
+
+		name, names, more = strings.Cut(names, labelNameSep)
+		value, values, _ = strings.Cut(values, labelValueSep)
+
+		if !reLabelName.MatchString(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("invalid label name '%s'", name)
+		}
+
+		if !utf8.ValidString(value) {
+			return fmt.Errorf("label '%s' value '%q' is not valid UTF-8", name, value)
+		}
+	}
+
+	return nil
+}
+
+// Max amount of the latest quarantined series kept for debugging
+const maxQuarantineSamples = 20
+
+// RsyslogStatsQuarantineSample describes the series quarantined
+type RsyslogStatsQuarantineSample struct {
+	Name   string    `json:"name"`
+	Labels string    `json:"labels"`
+	Reason string    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// Quarantine the invalid series (must be called with lock held)
+func (rs *RsyslogStats) quarantine(metric string, labels RsyslogStatsLabels, err error) {
+	if rs.QuarantinedSeries == 0 {
+		log.Printf("series %s%q is quarantined: %s", metric, labels.Values(), err)
+	}
+
+	rs.QuarantinedSeries++
+
+	sample := RsyslogStatsQuarantineSample{
+		Name:   metric,
+		Labels: fmt.Sprintf("%q=%q", labels.Names(), labels.Values()),
+		Reason: err.Error(),
+		Time:   time.Now(),
+	}
+
+	rs.quarantineSamples = append(rs.quarantineSamples, sample)
+	if len(rs.quarantineSamples) > maxQuarantineSamples {
+		rs.quarantineSamples = rs.quarantineSamples[1:]
+	}
+}
+
+// QuarantineSamples returns the latest quarantined series
+func (rs *RsyslogStats) QuarantineSamples() []RsyslogStatsQuarantineSample {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return append([]RsyslogStatsQuarantineSample{}, rs.quarantineSamples...)
+}
+
+// Check if the metric family reached the series limit (must be called with lock held)
+func (rs *RsyslogStats) familyIsFull(metric string) bool {
+	return rs.MaxFamilySeries > 0 && len(rs.Metrics[metric]) >= rs.MaxFamilySeries
+}
+
+// RsyslogStatsFamilySeries holds the amount of labeled series in a metric family
+type RsyslogStatsFamilySeries struct {
+	Name   string
+	Series int
+}
+
+// Cardinality returns the amount of metric families and labeled series stored,
+// plus up to `top` biggest families sorted by their series count
+func (rs *RsyslogStats) Cardinality(top int) (families int, series int, biggest []RsyslogStatsFamilySeries) {
+	rs.RLock()
+
+	families = len(rs.Metrics)
+	biggest = make([]RsyslogStatsFamilySeries, 0, families)
+
+	for metricName, labeledValues := range rs.Metrics {
+		series += len(labeledValues)
+		biggest = append(biggest, RsyslogStatsFamilySeries{metricName, len(labeledValues)})
+	}
+
+	rs.RUnlock()
+
+	sort.Slice(biggest, func(i, j int) bool {
+		if biggest[i].Series != biggest[j].Series {
+			return biggest[i].Series > biggest[j].Series
+		}
+		return biggest[i].Name < biggest[j].Name
+	})
+
+	if top < 0 {
+		top = 0
+	}
+
+	if len(biggest) > top {
+		biggest = biggest[:top]
+	}
+
+	return families, series, biggest
 }
 
 // Parsing error wrapper
-func (rs *RsyslogStats) failToParse(err error, source string) {
+func (rs *RsyslogStats) failToParse(err error, source string, src RsyslogStatsSource) {
 	log.Printf("%s! JSON string is %s", err, source)
 	rs.ParserFailures++
+
+	sender := src.Sender
+	if sender != "" && !rs.SenderAllowlist.Allowed(sender) {
+		sender = otherSender
+	}
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	if sender != "" {
+		rs.SenderParserFailures[sender]++
+		rs.senderFailed[sender] = time.Now()
+	}
+
+	rs.ListenerParserFailures[src.Listener]++
+
+	sample := RsyslogStatsParseFailure{
+		Sender: sender,
+		Error:  err.Error(),
+		Line:   source,
+		Time:   time.Now(),
+	}
+
+	rs.parseFailureSamples = append(rs.parseFailureSamples, sample)
+	if len(rs.parseFailureSamples) > maxParseFailureSamples {
+		rs.parseFailureSamples = rs.parseFailureSamples[1:]
+	}
+}
+
+// Count the field skipped if the error is the field conversion error
+func (rs *RsyslogStats) countFieldFailure(origin string, err error) {
+	var fe *fieldError
+	if !errors.As(err, &fe) {
+		return
+	}
+
+	rs.Lock()
+	rs.FieldFailures[RsyslogStatsOriginField{Origin: origin, Field: fe.field}]++
+	rs.Unlock()
+}
+
+// RsyslogStatsOriginField is the field conversion failures key
+type RsyslogStatsOriginField struct {
+	Origin string
+	Field  string
+}
+
+// Max amount of the latest parse failures kept for debugging
+const maxParseFailureSamples = 20
+
+// RsyslogStatsParseFailure describes the line failed to parse
+type RsyslogStatsParseFailure struct {
+	Sender string    `json:"sender"`
+	Error  string    `json:"error"`
+	Line   string    `json:"line"`
+	Time   time.Time `json:"time"`
+}
+
+// ParseFailureSamples returns the latest parse failures
+func (rs *RsyslogStats) ParseFailureSamples() []RsyslogStatsParseFailure {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return append([]RsyslogStatsParseFailure{}, rs.parseFailureSamples...)
+}
+
+// RsyslogStatsReportingHost describes the host reporting the stats
+type RsyslogStatsReportingHost struct {
+	Sender         string
+	LastSeen       time.Time
+	ParserFailures int
+}
+
+// ReportingHosts returns the hosts reporting the stats sorted by name: the
+// senders of the parsed lines and the ones failed to parse
+func (rs *RsyslogStats) ReportingHosts() []RsyslogStatsReportingHost {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	hosts := make(map[string]*RsyslogStatsReportingHost, len(rs.senderSeen))
+
+	for sender, seen := range rs.senderSeen {
+		hosts[sender] = &RsyslogStatsReportingHost{Sender: sender, LastSeen: seen}
+	}
+
+	for sender, failures := range rs.SenderParserFailures {
+		if _, found := hosts[sender]; !found {
+			hosts[sender] = &RsyslogStatsReportingHost{Sender: sender}
+		}
+
+		hosts[sender].ParserFailures = failures
+	}
+
+	rv := make([]RsyslogStatsReportingHost, 0, len(hosts))
+	for _, h := range hosts {
+		rv = append(rv, *h)
+	}
+
+	sort.Slice(rv, func(i, j int) bool { return rv[i].Sender < rv[j].Sender })
+
+	return rv
 }
 
 // Parsers
@@ -163,33 +777,82 @@ const (
 	rtDynstatBucket
 	rtNamed
 	rtSender
+	rtDatabase
+	rtPercentile
+	rtDynafileCache
+	rtOmkafka
 )
 
+// Stat type name as exported in the parse duration labels
+func (st rsyslogStatType) String() string {
+	switch st {
+	case rtDynstatGlobal:
+		return "dynstats_global"
+	case rtDynstatBucket:
+		return "dynstats_bucket"
+	case rtNamed:
+		return "named"
+	case rtSender:
+		return "sender"
+	case rtDatabase:
+		return "database"
+	case rtPercentile:
+		return "percentile"
+	case rtDynafileCache:
+		return "dynafile_cache"
+	case rtOmkafka:
+		return "omkafka"
+	}
+
+	return "default"
+}
+
 type parserForType func(string, string, map[string]interface{}) (RsyslogStatsMetrics, []error)
 
 // Parse global dynstats counters
 func (rs *RsyslogStats) parseDynstatsGlobal(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
 	m := RsyslogStatsMetrics{}
 	metricName := rs.MetricPrefix + "_" + origin + "_" + name
 
-	for field, value := range data["values"].(map[string]interface{}) {
+	values, found := data["values"].(map[string]interface{})
+	if !found {
+		return nil, append(errs, fmt.Errorf("'values' object is required but not found"))
+	}
+
+	for field, value := range values {
 		cname, counter := splitRight(field)
-		appendMetric(m, metricName+"_"+counter, RsyslogStatsLabels{"counter", cname}, value)
+
+		if v, e := getValue(value); e != nil {
+			errs = append(errs, &fieldError{field, e})
+		} else {
+			rs.appendMetric(m, metricName+"_"+counter, RsyslogStatsLabels{"counter", cname}, v)
+		}
 	}
 
-	return m, nil
+	return m, errs
 }
 
 // Parse dynstats.bucket counters
 func (rs *RsyslogStats) parseDynstatsBucket(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
+	errs := []error{}
 	m := RsyslogStatsMetrics{}
 	metricName := rs.MetricPrefix + "_" + origin + "_" + name
 
-	for counter, value := range data["values"].(map[string]interface{}) {
-		appendMetric(m, metricName, RsyslogStatsLabels{"bucket", counter}, value)
+	values, found := data["values"].(map[string]interface{})
+	if !found {
+		return nil, append(errs, fmt.Errorf("'values' object is required but not found"))
+	}
+
+	for counter, value := range values {
+		if v, e := getValue(value); e != nil {
+			errs = append(errs, &fieldError{counter, e})
+		} else {
+			rs.appendMetric(m, metricName, RsyslogStatsLabels{"bucket", counter}, v)
+		}
 	}
 
-	return m, nil
+	return m, errs
 }
 
 // Parse sender stats
@@ -198,23 +861,170 @@ func (rs *RsyslogStats) parseSenderStats(name, origin string, data map[string]in
 	v, e := getValue(data["messages"])
 
 	if e != nil {
-		return nil, append(errs, e)
+		return nil, append(errs, &fieldError{"messages", e})
 	}
 
+	sender, found := data["sender"].(string)
+	if !found {
+		return nil, append(errs, fmt.Errorf("'sender' field is required but not found"))
+	}
+
+	return rs.senderMetrics(sender, v), nil
+}
+
+// Latest message counter of the sender not in the allowlist
+type otherSenderCounter struct {
+	messages float64
+	updated  time.Time
+}
+
+// Get the sender messages metric
+func (rs *RsyslogStats) senderMetrics(sender string, v float64) RsyslogStatsMetrics {
 	m := RsyslogStatsMetrics{}
-	l := RsyslogStatsLabels{"sender", data["sender"].(string)}
+	l := RsyslogStatsLabels{"sender", sender}
 	metricName := rs.MetricPrefix + "_" + "sender_stat_messages"
-	appendMetric(m, metricName, l, v)
 
-	return m, nil
+	// aggregate the senders not in the allowlist
+	if !rs.SenderAllowlist.Allowed(sender) {
+		rs.otherSenderMessages[sender] = otherSenderCounter{messages: v, updated: time.Now()}
+		l.Value, v = otherSender, 0
+
+		for _, c := range rs.otherSenderMessages {
+			v += c.messages
+		}
+	}
+
+	rs.appendMetric(m, metricName, l, v)
+
+	return m
+}
+
+// rsyslog auto-generated queue names
+var (
+	reDAQueue     = regexp.MustCompile(`\[DA\]$`)
+	reActionQueue = regexp.MustCompile(`^action[ -]\d+.* queue$`)
+)
+
+// Get the queue kind by its name: main, action, da (disk-assisted), io or other
+func queueKind(name string) string {
+	switch {
+	case reDAQueue.MatchString(name):
+		return "da"
+	case name == "main Q":
+		return "main"
+	case name == "io-work-q":
+		return "io"
+	case reActionQueue.MatchString(name):
+		return "action"
+	}
+
+	return "other"
+}
+
+// Config file references in action names: "(/etc/rsyslog.conf:12)", "rsyslog.d/fwd.conf:3"
+var (
+	reConfigRef  = regexp.MustCompile(`\s*\(?[\w./-]+\.conf:\d+\)?`)
+	reNameDelims = regexp.MustCompile(`[\s:]+`)
+)
+
+// Get the canonical action/queue name: config file references are removed,
+// spaces and colons are replaced by underscores
+func canonicalName(name string) string {
+	name = reConfigRef.ReplaceAllLiteralString(name, "")
+	name = reNameDelims.ReplaceAllLiteralString(strings.TrimSpace(name), "_")
+
+	return name
+}
+
+// Get the forwarding target of the omfwd stats name: "TCP-logs.example.com-514"
+func forwardTarget(name string) (protocol string, target string, port string) {
+	protocol, rest, found := strings.Cut(name, "-")
+	if !found {
+		return "", name, ""
+	}
+
+	if i := strings.LastIndex(rest, "-"); i >= 0 {
+		return protocol, rest[:i], rest[i+1:]
+	}
+
+	return protocol, rest, ""
+}
+
+// Get the forwarding target encoded in the action name by the pattern with
+// "target" and "port" named groups (empty if not matched)
+func actionTarget(pattern *regexp.Regexp, name string) (target string, port string) {
+	match := pattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", ""
+	}
+
+	if i := pattern.SubexpIndex("target"); i > 0 {
+		target = match[i]
+	}
+
+	if i := pattern.SubexpIndex("port"); i > 0 {
+		port = match[i]
+	}
+
+	return target, port
+}
+
+// Get the labels of "named" counters
+func (rs *RsyslogStats) namedLabels(name, origin string) RsyslogStatsLabels {
+	l := RsyslogStatsLabels{"name", name}
+
+	if rs.CanonicalNames && (origin == "core.queue" || origin == "core.action") {
+		l.Value = canonicalName(name)
+
+		if rs.RawNameLabel {
+			l = l.With("raw_name", name)
+		}
+	}
+
+	if rs.QueueKindLabel && origin == "core.queue" {
+		l = l.With("queue_kind", queueKind(name))
+	}
+
+	if rs.ActionTargetPattern != nil && origin == "core.action" {
+		target, port := actionTarget(rs.ActionTargetPattern, name)
+		l = l.With("target", target).With("port", port)
+	}
+
+	if rs.ForwardTargetLabels && origin == "omfwd" {
+		protocol, target, port := forwardTarget(name)
+		l = l.With("protocol", protocol).With("target", target).With("port", port)
+	}
+
+	if rs.ListenerStatsLabels && listenerStatsOrigins[origin] {
+		listener, port := listenerStatsAddress(name)
+		rest, _ := withoutLabel(l, "name")
+		l = RsyslogStatsLabels{"name", listenerStatsModule(name)}.Merge(rest)
+		l = l.With("listener", listener).With("port", port)
+
+		if worker := listenerStatsWorker(name); worker != "" {
+			l = l.With("worker", worker)
+		}
+	}
+
+	return l
+}
+
+// Append a "named" counter value
+func (rs *RsyslogStats) appendNamed(m RsyslogStatsMetrics, origin string, l RsyslogStatsLabels, counter string, v float64) {
+	metricName := rs.MetricPrefix + "_" + origin
+
+	if _, counterAsLabel := rs.counterLabelOrigins[origin]; counterAsLabel {
+		rs.appendMetric(m, metricName, l.With("counter", counter), v)
+	} else {
+		rs.appendMetric(m, metricName+"_"+counter, l, v)
+	}
 }
 
 // Parse "named" counters (core.queue, core.action)
 func (rs *RsyslogStats) parseNamedStats(name, origin string, data map[string]interface{}) (RsyslogStatsMetrics, []error) {
 	errs := []error{}
 	m := RsyslogStatsMetrics{}
-	l := RsyslogStatsLabels{"name", name}
-	metricName := rs.MetricPrefix + "_" + origin
+	l := rs.namedLabels(name, origin)
 
 	for counter, value := range data {
 		if counter == rs.NameField || counter == rs.OriginField {
@@ -222,9 +1032,9 @@ func (rs *RsyslogStats) parseNamedStats(name, origin string, data map[string]int
 		}
 
 		if v, e := getValue(value); e != nil {
-			errs = append(errs, e)
+			errs = append(errs, &fieldError{counter, e})
 		} else {
-			appendMetric(m, metricName+"_"+counter, l, v)
+			rs.appendNamed(m, origin, l, counter, v)
 		}
 	}
 
@@ -244,15 +1054,92 @@ func (rs *RsyslogStats) parseDefault(name, origin string, data map[string]interf
 		}
 
 		if v, e := getValue(value); e != nil {
-			errs = append(errs, e)
+			errs = append(errs, &fieldError{counter, e})
 		} else {
-			appendMetric(m, metricName+"_"+counter, l, v)
+			rs.appendMetric(m, metricName+"_"+counter, l, v)
 		}
 	}
 
 	return m, errs
 }
 
+// Parse counters with their original (unsanitised, un-renamed) names into the
+// raw passthrough family. Errors are reported by the main parser already.
+func (rs *RsyslogStats) parseRaw(name, origin string, data map[string]interface{}) RsyslogStatsMetrics {
+	m := RsyslogStatsMetrics{}
+	l := RsyslogStatsLabels{}.With("origin", origin).With("name", name)
+
+	for counter, value := range data {
+		if counter == rs.NameField || counter == rs.OriginField {
+			continue
+		}
+
+		// dynstats values
+		if values, ok := value.(map[string]interface{}); ok {
+			for key, value := range values {
+				if v, e := getValue(value); e == nil {
+					appendMetric(m, rs.RawFamily(), l.With("counter", counter+"."+key), v)
+				}
+			}
+
+			continue
+		}
+
+		if v, e := getValue(value); e == nil {
+			appendMetric(m, rs.RawFamily(), l.With("counter", counter), v)
+		}
+	}
+
+	return m
+}
+
+// Locate the impstats object inside the wrapper document by rs.JSONPath
+// Objects encoded as JSON strings (`{"msg": "{\"name\": ...}"}`) are decoded as well
+func (rs *RsyslogStats) unwrap(data map[string]interface{}) (map[string]interface{}, error) {
+	path := strings.TrimPrefix(strings.TrimSpace(rs.JSONPath), "$")
+
+	for _, key := range strings.Split(path, ".") {
+		if key == "" {
+			continue
+		}
+
+		value, found := data[key]
+		if !found {
+			return nil, fmt.Errorf("'%s' JSON path is not found at '%s'", rs.JSONPath, key)
+		}
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			data = v
+		case string:
+			data = nil
+			if err := json.Unmarshal([]byte(v), &data); err != nil {
+				return nil, fmt.Errorf("cannot parse JSON at '%s': %w", key, err)
+			}
+		default:
+			return nil, fmt.Errorf("'%s' JSON path element '%s' is not an object", rs.JSONPath, key)
+		}
+	}
+
+	return data, nil
+}
+
+// Restore the dots of the field names replaced by bangs in the
+// json-elasticsearch impstats format ("discarded!full" e.g.), so both formats
+// give the same metrics. Nested objects (dynstats values) are restored too.
+func normaliseFieldNames(data map[string]interface{}) {
+	for key, value := range data {
+		if nested, ok := value.(map[string]interface{}); ok {
+			normaliseFieldNames(nested)
+		}
+
+		if strings.IndexByte(key, '!') >= 0 {
+			delete(data, key)
+			data[strings.ReplaceAll(key, "!", ".")] = value
+		}
+	}
+}
+
 // Identify statLine type
 func (rs *RsyslogStats) identify(data map[string]interface{}) (name string, origin string, st rsyslogStatType, e error) {
 	var found bool
@@ -282,43 +1169,184 @@ func (rs *RsyslogStats) identify(data map[string]interface{}) (name string, orig
 	case "dynstats.bucket":
 		st = rtDynstatBucket
 	default:
-		switch name {
-		case "_sender_stat":
+		switch {
+		case name == "_sender_stat":
 			st = rtSender
+		case rs.isDatabaseStats(name, origin):
+			st = rtDatabase
+		case isPercentileOrigin(origin):
+			st = rtPercentile
+		case isDynafileCacheStats(name, origin):
+			st = rtDynafileCache
+		case rs.OmkafkaStats && origin == "omkafka":
+			st = rtOmkafka
 		}
 	}
 
+	if schemaType, found := rs.schema.Load().statType(origin); found {
+		st = schemaType
+	}
+
 	return
 }
 
+// RsyslogStatsSource describes where the stats line comes from
+type RsyslogStatsSource struct {
+	// Reporting host, parser failures are counted per sender if set
+	Sender string
+	// Message timestamp, parse time is used if it's not set
+	Timestamp time.Time
+	// Labels attached to all metrics of the message (tenant e.g.)
+	Labels RsyslogStatsLabels
+	// Input the message arrived on (udp:0.0.0.0:5145 e.g.) if tagged
+	Listener string
+	// Message decoded already (by the sender resolution), the line is decoded
+	// by the parser if it's nil. It's owned by the caller.
+	Data map[string]interface{}
+}
+
+// RsyslogStatsListenerOrigin is the parsed messages breakdown key
+type RsyslogStatsListenerOrigin struct {
+	Listener string
+	Origin   string
+}
+
+// Message timestamps too far in the future are replaced by the parse time
+const maxTimestampSkew = 5 * time.Minute
+
+// Get the source timestamp falling back to the current time
+func (src RsyslogStatsSource) timestamp(now time.Time) time.Time {
+	if src.Timestamp.IsZero() || src.Timestamp.After(now.Add(maxTimestampSkew)) {
+		return now
+	}
+
+	return src.Timestamp
+}
+
 // Parse JSON line and store metrics
 func (rs *RsyslogStats) Parse(statLine string) {
+	rs.ParseFrom(RsyslogStatsSource{}, statLine)
+}
+
+// ParseFrom parses JSON line received from the source and stores metrics
+func (rs *RsyslogStats) ParseFrom(src RsyslogStatsSource, statLine string) {
 	var (
-		data   map[string]interface{}
-		name   string
-		origin string
+		data map[string]interface{}
+		errs []error
+		err  error
 	)
 
-	err := json.Unmarshal([]byte(statLine), &data)
-	if err != nil {
-		rs.failToParse(fmt.Errorf("cannot parse JSON: %w", err), statLine)
-		return
+	start := time.Now()
+
+	// the typed decoding is skipped if the line is decoded already
+	data = src.Data
+
+	name, origin, rsType, m, typed := "", "", rtDefault, RsyslogStatsMetrics(nil), false
+	if data == nil {
+		name, origin, rsType, m, typed = rs.parseTyped(statLine)
 	}
 
-	name, origin, rsType, err := rs.identify(data)
-	if err != nil {
-		rs.failToParse(err, statLine)
-		return
+	if !typed {
+		if data == nil {
+			line := getBuffer()
+			*line = append(*line, statLine...)
+
+			data = getObject()
+			defer putObject(data)
+
+			err = json.Unmarshal(*line, &data)
+			putBuffer(line)
+
+			if err != nil {
+				rs.failToParse(fmt.Errorf("cannot parse JSON: %w", err), statLine, src)
+				return
+			}
+		}
+
+		if rs.JSONPath != "" {
+			data, err = rs.unwrap(data)
+			if err != nil {
+				rs.failToParse(err, statLine, src)
+				return
+			}
+		}
+
+		normaliseFieldNames(data)
+
+		name, origin, rsType, err = rs.identify(data)
+		if err != nil {
+			rs.failToParse(err, statLine, src)
+			return
+		}
+
+		if rs.rawOrigins[origin] {
+			rs.add(rs.parseRaw(name, origin, data))
+		}
+
+		m, errs = rs.parsersByType[rsType](name, origin, data)
+
+		for _, e := range errs {
+			rs.countFieldFailure(origin, e)
+			rs.failToParse(e, statLine, src)
+		}
+	}
+
+	m = withLabels(m, src.Labels)
+
+	if rs.QueueWatermarks && origin == "core.queue" {
+		rs.addQueueWatermarks(m)
 	}
 
-	m, errs := rs.parsersByType[rsType](name, origin, data)
+	if rs.ActionSuspension && origin == "core.action" {
+		rs.addActionSuspension(m)
+	}
+
+	if rs.ActionFailureRatio && origin == "core.action" {
+		rs.addActionFailureRatio(m)
+	}
+
+	if rs.ProcessMetrics && origin == "impstats" && name == "resource-usage" {
+		sender := src.Sender
+		if sender != "" && !rs.SenderAllowlist.Allowed(sender) {
+			sender = otherSender
+		}
+
+		rs.addProcessMetrics(m, sender)
+	}
+
+	for _, e := range rs.addStatus(m, name, origin) {
+		rs.failToParse(e, statLine, src)
+	}
+
+	m, errs = rs.applyTransforms(m)
 
 	for _, e := range errs {
-		rs.failToParse(e, statLine)
+		rs.failToParse(e, statLine, src)
 	}
 
 	rs.add(m)
 
+	if rs.WorkerPattern != nil && (origin == "core.action" || origin == "core.queue") {
+		rs.add(rs.workerSums(origin, m))
+	}
+
+	rs.Lock()
+	rs.OriginMessages[origin]++
+	rs.ListenerOriginMessages[RsyslogStatsListenerOrigin{Listener: src.Listener, Origin: origin}]++
+	if sender := src.Sender; sender != "" {
+		if !rs.SenderAllowlist.Allowed(sender) {
+			sender = otherSender
+		}
+		rs.senderSeen[sender] = start
+	}
+	rs.lastParsed = start
+	rs.Unlock()
+
 	rs.ParsedMessages++
-	rs.ParseTimestamp = time.Now().Unix()
+	// replayed stats must not move the timestamp backwards
+	if ts := src.timestamp(start).Unix(); ts > rs.ParseTimestamp {
+		rs.ParseTimestamp = ts
+	}
+
+	parseDuration.WithLabelValues(origin, rsType.String()).Observe(time.Since(start).Seconds())
 }
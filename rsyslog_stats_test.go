@@ -20,12 +20,17 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"regexp"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 )
 
 // sanitiseMetricName
@@ -64,6 +69,7 @@ func TestRsyslogStatsSplitRight(t *testing.T) {
 		{"a1.", "a1", ""},
 		{"a1..", "a1.", ""},
 		{".c3", "", "c3"},
+		{"c3", "", "c3"},
 	}
 
 	for _, c := range tests {
@@ -431,3 +437,735 @@ func TestRsyslogStatsParse(t *testing.T) {
 		t.Errorf("Wrong ParseTimestamp: want '%d' > got '%d'", want, got)
 	}
 }
+
+// Cardinality
+func TestRsyslogStatsCardinality(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.add(
+		RsyslogStatsMetrics{
+			"rsyslog_test_123": {
+				RsyslogStatsLabels{"name", "t123.1"}: 1,
+				RsyslogStatsLabels{"name", "t123.2"}: 2,
+			},
+			"rsyslog_test_345": {
+				RsyslogStatsLabels{"name", "t345"}: 3,
+			},
+			"rsyslog_test_678": {
+				RsyslogStatsLabels{"name", "t678.1"}: 1,
+				RsyslogStatsLabels{"name", "t678.2"}: 2,
+				RsyslogStatsLabels{"name", "t678.3"}: 3,
+			},
+		},
+	)
+
+	families, series, biggest := rs.Cardinality(2)
+
+	if want, got := 3, families; want != got {
+		t.Errorf("families mismatch: want '%d', got '%d'", want, got)
+	}
+
+	if want, got := 6, series; want != got {
+		t.Errorf("series mismatch: want '%d', got '%d'", want, got)
+	}
+
+	want := []RsyslogStatsFamilySeries{
+		{"rsyslog_test_678", 3},
+		{"rsyslog_test_123", 2},
+	}
+
+	if diff := cmp.Diff(want, biggest); diff != "" {
+		t.Errorf("RsyslogStatsFamilySeries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// add with MaxFamilySeries
+func TestRsyslogStatsAddFamilyLimit(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.MaxFamilySeries = 2
+	rs.add(
+		RsyslogStatsMetrics{
+			"rsyslog_test_123": {
+				RsyslogStatsLabels{"bucket", "a"}: 1,
+				RsyslogStatsLabels{"bucket", "b"}: 2,
+			},
+		},
+	)
+	rs.add(
+		RsyslogStatsMetrics{
+			"rsyslog_test_123": {
+				RsyslogStatsLabels{"bucket", "a"}: 10,
+				RsyslogStatsLabels{"bucket", "c"}: 3,
+				RsyslogStatsLabels{"bucket", "d"}: 4,
+			},
+		},
+	)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_test_123": {
+			RsyslogStatsLabels{"bucket", "a"}: 10,
+			RsyslogStatsLabels{"bucket", "b"}: 2,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if want, got := 2, rs.RejectedSeries["rsyslog_test_123"]; want != got {
+		t.Errorf("RejectedSeries mismatch: want '%d', got '%d'", want, got)
+	}
+}
+
+// RsyslogStatsLabels.With
+func TestRsyslogStatsLabelsWith(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input  RsyslogStatsLabels
+		names  []string
+		values []string
+	}{
+		{RsyslogStatsLabels{}, nil, nil},
+		{RsyslogStatsLabels{}.With("name", "main Q"), []string{"name"}, []string{"main Q"}},
+		{RsyslogStatsLabels{"name", "main Q"}.With("counter", "size"), []string{"name", "counter"}, []string{"main Q", "size"}},
+	}
+
+	for _, c := range tests {
+		if diff := cmp.Diff(c.names, c.input.Names()); diff != "" {
+			t.Errorf("label names mismatch (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(c.values, c.input.Values()); diff != "" {
+			t.Errorf("label values mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+// parseNamedStats with counter-as-label layout
+func TestRsyslogStatsParseNamedStatsCounterLabel(t *testing.T) {
+	t.Parallel()
+
+	input := map[string]interface{}{"name": "main Q", "origin": "core.queue", "size": 1.0, "enqueued": 42.0}
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue": {
+			RsyslogStatsLabels{"name", "main Q"}.With("counter", "size"):     1,
+			RsyslogStatsLabels{"name", "main Q"}.With("counter", "enqueued"): 42,
+		},
+	}
+
+	rs := NewRsyslogStats()
+	rs.SetCounterLabelOrigins([]string{"core.queue"})
+
+	got, errs := rs.parseNamedStats(input["name"].(string), input["origin"].(string), input)
+	for _, e := range errs {
+		t.Errorf("%v", e)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if !rs.IsCounterLabelFamily("rsyslog_core_queue") {
+		t.Errorf("rsyslog_core_queue is expected to be a counter-as-label family")
+	}
+}
+
+// unwrap
+func TestRsyslogStatsUnwrap(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		path   string
+		input  map[string]interface{}
+		output map[string]interface{}
+		fail   bool
+	}{
+		{
+			"$.log.original",
+			map[string]interface{}{"log": map[string]interface{}{"original": map[string]interface{}{"name": "main Q", "origin": "core.queue"}}},
+			map[string]interface{}{"name": "main Q", "origin": "core.queue"},
+			false,
+		},
+		{
+			"$.msg",
+			map[string]interface{}{"msg": `{"name": "main Q", "origin": "core.queue"}`},
+			map[string]interface{}{"name": "main Q", "origin": "core.queue"},
+			false,
+		},
+		{
+			"$",
+			map[string]interface{}{"name": "main Q", "origin": "core.queue"},
+			map[string]interface{}{"name": "main Q", "origin": "core.queue"},
+			false,
+		},
+		{"$.msg", map[string]interface{}{"message": "{}"}, nil, true},
+		{"$.msg", map[string]interface{}{"msg": 42.0}, nil, true},
+		{"$.msg", map[string]interface{}{"msg": "not a json"}, nil, true},
+	}
+
+	for _, c := range tests {
+		rs := NewRsyslogStats()
+		rs.JSONPath = c.path
+
+		got, err := rs.unwrap(c.input)
+		if (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error state: %v", c.path, err)
+		}
+
+		if diff := cmp.Diff(c.output, got); diff != "" {
+			t.Errorf("unwrapped data mismatch (-want +got):\n%s", diff)
+		}
+	}
+}
+
+// Parse (malformed input)
+func TestRsyslogStatsParseMalformed(t *testing.T) {
+	t.Parallel()
+
+	var tests = []string{
+		`null`,
+		`{"name": 1, "origin": 2}`,
+		`{"name": "global", "origin": "dynstats"}`,
+		`{"name": "global", "origin": "dynstats", "values": 42}`,
+		`{"name": "global", "origin": "dynstats", "values": {"no_dot": 1, "a.b": "x"}}`,
+		`{"name": "bucket", "origin": "dynstats.bucket", "values": {"a": null}}`,
+		`{"name": "bucket", "origin": "dynstats.bucket", "values": [1, 2]}`,
+		`{"name": "_sender_stat", "messages": 1}`,
+		`{"name": "_sender_stat", "sender": 42, "messages": 1}`,
+		`{"name": "main Q", "origin": "core.queue", "size": {"a": 1}}`,
+	}
+
+	for _, c := range tests {
+		rs := NewRsyslogStats()
+		rs.Parse(c)
+
+		if rs.ParserFailures == 0 {
+			t.Errorf("%s: parser failure expected", c)
+		}
+	}
+}
+
+// Parse
+func FuzzRsyslogStatsParse(f *testing.F) {
+	f.Add(`{"name":"main Q","origin":"core.queue","size":1,"enqueued":2}`)
+	f.Add(`{"name":"global","origin":"dynstats","values":{"msg_per_host.ops_overflow":0}}`)
+	f.Add(`{"name":"msg_per_host","origin":"dynstats.bucket","values":{"host1":1}}`)
+	f.Add(`{"name":"_sender_stat","origin":"impstats","sender":"host1","messages":"42"}`)
+	f.Add(`{"msg":"{\"name\":\"main Q\",\"origin\":\"core.queue\",\"size\":1}"}`)
+
+	f.Fuzz(func(t *testing.T, line string) {
+		rs := NewRsyslogStats()
+		rs.Parse(line)
+
+		rs = NewRsyslogStats()
+		rs.JSONPath = "$.msg"
+		rs.SetCounterLabelOrigins([]string{"core.queue"})
+		rs.Parse(line)
+	})
+}
+
+// identify
+func FuzzRsyslogStatsIdentify(f *testing.F) {
+	f.Add(`{"name":"main Q","origin":"core.queue"}`)
+	f.Add(`{"name":"omkafka"}`)
+	f.Add(`{"name":"_sender_stat"}`)
+
+	f.Fuzz(func(t *testing.T, line string) {
+		var data map[string]interface{}
+		if json.Unmarshal([]byte(line), &data) != nil {
+			return
+		}
+
+		rs := NewRsyslogStats()
+		if _, _, st, err := rs.identify(data); err == nil {
+			if _, found := rs.parsersByType[st]; !found {
+				t.Errorf("%s: no parser for type %d", line, st)
+			}
+		}
+	})
+}
+
+// sanitiseMetricName
+func FuzzSanitiseMetricName(f *testing.F) {
+	f.Add("Rsyslog_Test_123_")
+	f.Add("main Q.discarded.full")
+	f.Add("юникод")
+
+	reSane := regexp.MustCompile("^[_a-z0-9]*$")
+
+	f.Fuzz(func(t *testing.T, name string) {
+		got := sanitiseMetricName(name)
+		if !reSane.MatchString(got) || strings.Contains(got, "__") || strings.HasSuffix(got, "_") {
+			t.Errorf("%q: insane metric name %q", name, got)
+		}
+
+		if want := sanitiseMetricNameRegexp(name); got != want {
+			t.Errorf("%q: want %q as the regexp-based sanitising, got %q", name, want, got)
+		}
+	})
+}
+
+// Parse (duration histogram)
+func TestRsyslogStatsParseDuration(t *testing.T) {
+	t.Parallel()
+
+	sampleCount := func() uint64 {
+		m := &dto.Metric{}
+		if err := parseDuration.WithLabelValues("test.parse_duration", "named").(prometheus.Histogram).Write(m); err != nil {
+			t.Fatal(err)
+		}
+
+		return m.GetHistogram().GetSampleCount()
+	}
+
+	before := sampleCount()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "duration", "origin": "test.parse_duration", "processed": 1}`)
+	rs.Parse(`{"name": "duration", "origin": "test.parse_duration", "processed": 2}`)
+
+	if got := sampleCount() - before; got != 2 {
+		t.Errorf("parse duration sample count: want 2, got %d", got)
+	}
+}
+
+// ParseFrom (per-sender failures)
+func TestRsyslogStatsParseFromSenderFailures(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `not a json`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `{"name": "main Q", "origin": "core.queue", "size": "x"}`)
+	rs.Parse(`not a json`)
+
+	want := map[string]int{"host2": 2}
+	if diff := cmp.Diff(want, rs.SenderParserFailures); diff != "" {
+		t.Errorf("SenderParserFailures mismatch (-want +got):\n%s", diff)
+	}
+
+	if rs.ParserFailures != 3 {
+		t.Errorf("ParserFailures: want 3, got %d", rs.ParserFailures)
+	}
+}
+
+// ParseFrom (source labels)
+func TestRsyslogStatsParseFromLabels(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Labels: RsyslogStatsLabels{"tenant", "a"}}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Labels: RsyslogStatsLabels{"tenant", "b"}}, `{"name": "main Q", "origin": "core.queue", "size": 2}`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size": {
+			RsyslogStatsLabels{}.With("name", "main Q").With("tenant", "a"): 1,
+			RsyslogStatsLabels{}.With("name", "main Q").With("tenant", "b"): 2,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// ParseFrom (message timestamp)
+func TestRsyslogStatsParseFromTimestamp(t *testing.T) {
+	t.Parallel()
+
+	line := `{"name": "main Q", "origin": "core.queue", "size": 1}`
+	hourAgo := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Timestamp: hourAgo}, line)
+
+	if want, got := hourAgo.Unix(), rs.ParseTimestamp; want != got {
+		t.Errorf("message timestamp: want %d, got %d", want, got)
+	}
+
+	// older messages must not move the timestamp backwards
+	rs.ParseFrom(RsyslogStatsSource{Timestamp: hourAgo.Add(-time.Hour)}, line)
+
+	if want, got := hourAgo.Unix(), rs.ParseTimestamp; want != got {
+		t.Errorf("replayed message timestamp: want %d, got %d", want, got)
+	}
+
+	// messages from the future fall back to the parse time
+	before := time.Now().Unix()
+	rs.ParseFrom(RsyslogStatsSource{Timestamp: time.Now().Add(time.Hour)}, line)
+
+	if got := rs.ParseTimestamp; got < before || got > time.Now().Unix() {
+		t.Errorf("future message timestamp: want parse time, got %d", got)
+	}
+}
+
+// parseSenderStats (allowlist)
+func TestRsyslogStatsParseSenderStatsAllowlist(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.SenderAllowlist, _ = NewSenderAllowlist([]string{"host1"})
+
+	rs.Parse(`{"name": "_sender_stat", "origin": "impstats", "sender": "host1", "messages": 1}`)
+	rs.Parse(`{"name": "_sender_stat", "origin": "impstats", "sender": "host2", "messages": 2}`)
+	rs.Parse(`{"name": "_sender_stat", "origin": "impstats", "sender": "host3", "messages": 3}`)
+	rs.Parse(`{"name": "_sender_stat", "origin": "impstats", "sender": "host2", "messages": 4}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host4"}, `not a json`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_sender_stat_messages": {
+			RsyslogStatsLabels{"sender", "host1"}: 1,
+			RsyslogStatsLabels{"sender", "other"}: 7,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(map[string]int{"other": 1}, rs.SenderParserFailures); diff != "" {
+		t.Errorf("SenderParserFailures mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// queueKind
+func TestRsyslogStatsQueueKind(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		kind string
+	}{
+		{"main Q", "main"},
+		{"main Q[DA]", "da"},
+		{"action 1 queue", "action"},
+		{"action-3-builtin:omfile queue", "action"},
+		{"action-3-builtin:omfile queue[DA]", "da"},
+		{"io-work-q", "io"},
+		{"fwd_ruleset", "other"},
+		{"action 1", "other"},
+	}
+
+	for _, c := range tests {
+		if got := queueKind(c.name); got != c.kind {
+			t.Errorf("%s: want %s, got %s", c.name, c.kind, got)
+		}
+	}
+}
+
+// parseNamedStats (queue kind label)
+func TestRsyslogStatsParseNamedStatsQueueKind(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.QueueKindLabel = true
+
+	rs.Parse(`{"name": "main Q[DA]", "origin": "core.queue", "size": 1}`)
+	rs.Parse(`{"name": "action 1", "origin": "core.action", "processed": 2}`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size":       {RsyslogStatsLabels{}.With("name", "main Q[DA]").With("queue_kind", "da"): 1},
+		"rsyslog_core_action_processed": {RsyslogStatsLabels{"name", "action 1"}: 2},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// canonicalName
+func TestRsyslogStatsCanonicalName(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name      string
+		canonical string
+	}{
+		{"main Q", "main_Q"},
+		{"action-3-builtin:omfile", "action-3-builtin_omfile"},
+		{"fwd to central (/etc/rsyslog.d/fwd.conf:12)", "fwd_to_central"},
+		{"fwd to central (/etc/rsyslog.d/fwd.conf:15)", "fwd_to_central"},
+		{"action 1 rsyslog.conf:7 queue", "action_1_queue"},
+		{"  spaced   out  ", "spaced_out"},
+	}
+
+	for _, c := range tests {
+		if got := canonicalName(c.name); got != c.canonical {
+			t.Errorf("%s: want %s, got %s", c.name, c.canonical, got)
+		}
+	}
+}
+
+// parseNamedStats (canonical names)
+func TestRsyslogStatsParseNamedStatsCanonicalNames(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.CanonicalNames = true
+	rs.RawNameLabel = true
+
+	rs.Parse(`{"name": "fwd (/etc/rsyslog.conf:3)", "origin": "core.action", "processed": 1}`)
+	rs.Parse(`{"name": "imuxsock", "origin": "imuxsock", "submitted": 2}`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_action_processed": {RsyslogStatsLabels{}.With("name", "fwd").With("raw_name", "fwd (/etc/rsyslog.conf:3)"): 1},
+		"rsyslog_imuxsock_submitted":    {RsyslogStatsLabels{"name", "imuxsock"}: 2},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// appendMetric (collisions)
+func TestRsyslogStatsAppendMetricCollisions(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "discarded.full": 1, "discarded_full": 2}`)
+	rs.Parse(`{"name": "main Q", "origin": "core_queue", "size": 3}`)
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 4}`)
+	rs.Parse(`{"name": "action 1 queue", "origin": "core.queue", "size": 5}`)
+
+	want := map[string]int{
+		"rsyslog_core_queue_discarded_full": 1,
+		"rsyslog_core_queue_size":           1,
+	}
+
+	if diff := cmp.Diff(want, rs.Collisions); diff != "" {
+		t.Errorf("Collisions mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// parseRaw
+func TestRsyslogStatsParseRaw(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.SetRawOrigins([]string{"core.queue", "dynstats"})
+
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "discarded.full": 1}`)
+	rs.Parse(`{"name": "global", "origin": "dynstats", "values": {"msg_per_host.ops_overflow": 2}}`)
+	rs.Parse(`{"name": "action 1", "origin": "core.action", "processed": 3}`)
+
+	raw := func(origin, name, counter string) RsyslogStatsLabels {
+		return RsyslogStatsLabels{}.With("origin", origin).With("name", name).With("counter", counter)
+	}
+
+	want := RsyslogStatsLabeledValues{
+		raw("core.queue", "main Q", "discarded.full"):                 1,
+		raw("dynstats", "global", "values.msg_per_host.ops_overflow"): 2,
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics["rsyslog_raw"]); diff != "" {
+		t.Errorf("RsyslogStatsLabeledValues mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, found := rs.Metrics["rsyslog_core_queue_discarded_full"]; !found {
+		t.Error("sanitised metric is not stored along with the raw one")
+	}
+}
+
+// add (quarantine)
+func TestRsyslogStatsAddQuarantine(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		metric string
+		labels RsyslogStatsLabels
+	}{
+		{"rsyslog_dynstats_bucket_x", RsyslogStatsLabels{"bucket", "a\x00b"}},
+		{"123_starts_with_digit", RsyslogStatsLabels{}},
+		{"", RsyslogStatsLabels{}},
+		{"rsyslog_x", RsyslogStatsLabels{"__reserved", "a"}},
+		{"rsyslog_x", RsyslogStatsLabels{"bad-name", "a"}},
+		{"rsyslog_x", RsyslogStatsLabels{"name", "\xff"}},
+	}
+
+	rs := NewRsyslogStats()
+	for _, c := range tests {
+		rs.add(RsyslogStatsMetrics{c.metric: {c.labels: 1}})
+	}
+
+	rs.add(RsyslogStatsMetrics{"rsyslog_valid": {RsyslogStatsLabels{"name", "a"}: 1}})
+
+	if want, got := len(tests), rs.QuarantinedSeries; want != got {
+		t.Errorf("QuarantinedSeries: want %d, got %d", want, got)
+	}
+
+	if want, got := len(tests), len(rs.QuarantineSamples()); want != got {
+		t.Errorf("quarantine samples: want %d, got %d", want, got)
+	}
+
+	if want, got := 1, len(rs.Metrics); want != got {
+		t.Errorf("stored families: want %d, got %d", want, got)
+	}
+
+	// the collector must not panic on the quarantined series
+	collectOrder(t, NewRsyslogStatsCollector(rs))
+
+	for i := 0; i < maxQuarantineSamples; i++ {
+		rs.add(RsyslogStatsMetrics{"": {RsyslogStatsLabels{}: 1}})
+	}
+
+	if want, got := maxQuarantineSamples, len(rs.QuarantineSamples()); want != got {
+		t.Errorf("quarantine samples: want %d, got %d", want, got)
+	}
+}
+
+// forwardTarget
+func TestForwardTarget(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input  string
+		output []string
+	}{
+		{"TCP-logs.example.com-514", []string{"TCP", "logs.example.com", "514"}},
+		{"UDP-my-relay-host-10514", []string{"UDP", "my-relay-host", "10514"}},
+		{"TCP-2001:db8::1-514", []string{"TCP", "2001:db8::1", "514"}},
+		{"TCP-host", []string{"TCP", "host", ""}},
+		{"omfwd", []string{"", "omfwd", ""}},
+	}
+
+	for _, c := range tests {
+		protocol, target, port := forwardTarget(c.input)
+		if diff := cmp.Diff(c.output, []string{protocol, target, port}); diff != "" {
+			t.Errorf("%s: forward target mismatch (-want +got):\n%s", c.input, diff)
+		}
+	}
+}
+
+// ParseFrom (forwarding target labels)
+func TestRsyslogStatsTargetLabels(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ForwardTargetLabels = true
+	rs.ActionTargetPattern = regexp.MustCompile(`^fwd-(?P<target>.+)-(?P<port>\d+)$`)
+
+	rs.Parse(`{"name": "TCP-logs.example.com-514", "origin": "omfwd", "bytes.sent": 1024}`)
+	rs.Parse(`{"name": "fwd-logs.example.com-514", "origin": "core.action", "failed": 2}`)
+	rs.Parse(`{"name": "action-0-builtin:omfile", "origin": "core.action", "failed": 0}`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_omfwd_bytes_sent": {
+			RsyslogStatsLabels{}.With("name", "TCP-logs.example.com-514").With("protocol", "TCP").With("target", "logs.example.com").With("port", "514"): 1024,
+		},
+		"rsyslog_core_action_failed": {
+			RsyslogStatsLabels{}.With("name", "fwd-logs.example.com-514").With("target", "logs.example.com").With("port", "514"): 2,
+			RsyslogStatsLabels{}.With("name", "action-0-builtin:omfile").With("target", "").With("port", ""):                     0,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// ReportingHosts, ParseFailureSamples
+func TestRsyslogStatsReportingHosts(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, `not a json`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `{"name": "main Q"}`)
+
+	hosts := rs.ReportingHosts()
+
+	got := []string{}
+	for _, h := range hosts {
+		got = append(got, h.Sender+":"+strconv.Itoa(h.ParserFailures)+":"+strconv.FormatBool(h.LastSeen.IsZero()))
+	}
+
+	if diff := cmp.Diff([]string{"host1:1:true", "host2:1:false"}, got); diff != "" {
+		t.Errorf("ReportingHosts mismatch (-want +got):\n%s", diff)
+	}
+
+	failures := rs.ParseFailureSamples()
+
+	got = []string{}
+	for _, f := range failures {
+		got = append(got, f.Sender+":"+f.Line)
+	}
+
+	if diff := cmp.Diff([]string{"host1:not a json", `host2:{"name": "main Q"}`}, got); diff != "" {
+		t.Errorf("ParseFailureSamples mismatch (-want +got):\n%s", diff)
+	}
+
+	for i := 0; i < 2*maxParseFailureSamples; i++ {
+		rs.Parse(`not a json`)
+	}
+
+	if got := len(rs.ParseFailureSamples()); got != maxParseFailureSamples {
+		t.Errorf("ParseFailureSamples: want %d samples, got %d", maxParseFailureSamples, got)
+	}
+}
+
+// RsyslogStats.ParseFrom (field conversion failures)
+func TestRsyslogStatsFieldFailures(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": "big", "enqueued": 1}`)
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": "big", "enqueued": 2}`)
+	rs.Parse(`{"name": "fwd", "origin": "core.action", "processed": true}`)
+	rs.Parse(`{"name": "msg_per_host", "origin": "dynstats", "values": {"host1.ops_overflow": "n/a"}}`)
+	rs.Parse(`not a json`)
+
+	want := map[RsyslogStatsOriginField]int{
+		{"core.queue", "size"}:             2,
+		{"core.action", "processed"}:       1,
+		{"dynstats", "host1.ops_overflow"}: 1,
+	}
+
+	if diff := cmp.Diff(want, rs.FieldFailures); diff != "" {
+		t.Errorf("FieldFailures mismatch (-want +got):\n%s", diff)
+	}
+
+	if got := rs.Metrics["rsyslog_core_queue_enqueued"][RsyslogStatsLabels{"name", "main Q"}]; got != 2 {
+		t.Errorf("want other fields parsed, got enqueued %v", got)
+	}
+}
+
+// normaliseFieldNames
+func TestRsyslogStatsParseElasticsearchFormat(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		json string
+		es   string
+	}{
+		{
+			`{"name": "main Q", "origin": "core.queue", "size": 1, "discarded.full": 2}`,
+			`{"name": "main Q", "origin": "core.queue", "size": 1, "discarded!full": 2}`,
+		},
+		{
+			`{"name": "msg_per_host", "origin": "dynstats", "values": {"host1.example.com.new_metric_add": 3, "host1.example.com.ops_overflow": 0}}`,
+			`{"name": "msg_per_host", "origin": "dynstats", "values": {"host1!example!com!new_metric_add": 3, "host1!example!com!ops_overflow": 0}}`,
+		},
+		{
+			`{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {"host1.example.com": 4}}`,
+			`{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {"host1!example!com": 4}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		want, got := NewRsyslogStats(), NewRsyslogStats()
+		want.Parse(tt.json)
+		got.Parse(tt.es)
+
+		if got.ParserFailures != 0 {
+			t.Errorf("%s: want no parser failures, got %d", tt.es, got.ParserFailures)
+		}
+
+		if diff := cmp.Diff(want.Metrics, got.Metrics); diff != "" {
+			t.Errorf("%s: metrics mismatch (-want +got):\n%s", tt.es, diff)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/automaxprocs/maxprocs"
+)
+
+// Default cgroup filesystem mount point
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroup v1 reports the page-aligned max int64 if there is no limit
+const cgroupV1Unlimited = 1 << 62
+
+// Metrics exported for the runtime limits
+var runtimeMetrics = []prometheus.Collector{
+	prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_gomaxprocs",
+			Help: "Effective GOMAXPROCS value",
+		},
+		func() float64 { return float64(runtime.GOMAXPROCS(0)) },
+	),
+	prometheus.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_gomemlimit_bytes",
+			Help: "Effective Go runtime soft memory limit",
+		},
+		func() float64 { return float64(debug.SetMemoryLimit(-1)) },
+	),
+}
+
+// Get the memory limit of the cgroup (v2 or v1), 0 means unlimited
+func cgroupMemoryLimit(root string) (int64, error) {
+	data, err := os.ReadFile(filepath.Join(root, "memory.max"))
+	if os.IsNotExist(err) {
+		data, err = os.ReadFile(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse cgroup memory limit '%s': %w", value, err)
+	}
+
+	if limit >= cgroupV1Unlimited {
+		return 0, nil
+	}
+
+	return limit, nil
+}
+
+// Set GOMAXPROCS to the cgroup CPU quota and GOMEMLIMIT to the ratio of the
+// cgroup memory limit. Values set by the environment are respected.
+func setRuntimeLimits(autoMaxProcs bool, memoryLimitRatio float64) {
+	if autoMaxProcs {
+		if _, err := maxprocs.Set(maxprocs.Logger(log.Printf)); err != nil {
+			log.Printf("cannot set GOMAXPROCS: %s", err)
+		}
+	}
+
+	if memoryLimitRatio <= 0 || os.Getenv("GOMEMLIMIT") != "" {
+		return
+	}
+
+	limit, err := cgroupMemoryLimit(cgroupRoot)
+	if err != nil {
+		log.Printf("cannot get cgroup memory limit: %s", err)
+		return
+	}
+
+	if limit > 0 {
+		limit = int64(float64(limit) * memoryLimitRatio)
+		debug.SetMemoryLimit(limit)
+		log.Printf("GOMEMLIMIT is set to %d bytes", limit)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cgroupMemoryLimit
+func TestCgroupMemoryLimit(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		file  string
+		value string
+		limit int64
+		fail  bool
+	}{
+		{"memory.max", "536870912\n", 536870912, false},
+		{"memory.max", "max\n", 0, false},
+		{"memory/memory.limit_in_bytes", "268435456\n", 268435456, false},
+		{"memory/memory.limit_in_bytes", "9223372036854771712\n", 0, false},
+		{"memory.max", "garbage\n", 0, true},
+		{"", "", 0, true},
+	}
+
+	for _, c := range tests {
+		root := t.TempDir()
+
+		if c.file != "" {
+			path := filepath.Join(root, c.file)
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := os.WriteFile(path, []byte(c.value), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		limit, err := cgroupMemoryLimit(root)
+		if (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error state: %v", c.file, err)
+		}
+
+		if limit != c.limit {
+			t.Errorf("%s: want %d, got %d", c.file, c.limit, limit)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var sandboxInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "rsyslog_exporter_sandbox_info",
+		Help: "Sandbox restrictions applied after startup",
+	},
+	[]string{"seccomp", "landlock"},
+)
+
+// Metrics exported by the sandbox
+var sandboxMetrics = []prometheus.Collector{
+	sandboxInfo,
+}
+
+// Get the directories the files are written to (empty paths are skipped)
+func sandboxWritableDirs(files ...string) []string {
+	dirs := []string{}
+
+	for _, file := range files {
+		if file != "" {
+			dirs = append(dirs, filepath.Dir(file))
+		}
+	}
+
+	return dirs
+}
+
+// Get the socket directories of the unix datagram inputs: the sockets are
+// created again when the inputs are restarted and removed once they are
+// stopped. Inputs added at runtime are not known yet, so their sockets outside
+// of the writable directories can't be created.
+func sandboxSocketDirs(inputs []string) []string {
+	dirs := []string{}
+
+	for _, conn := range inputs {
+		u, err := parseInputURL(conn)
+		if err != nil || u.Scheme != "unixgram" || u.Path == "" {
+			continue
+		}
+
+		dirs = append(dirs, filepath.Dir(u.Path))
+	}
+
+	return dirs
+}
+
+// Restrict the exporter once it's started, as it parses untrusted network
+// input: deny process execution and privileged syscalls (seccomp) and deny
+// file system modifications outside of the writable directories (landlock).
+// Restrictions not supported by the platform or the kernel are skipped.
+func applySandbox(enabled bool, writable []string) {
+	seccomp, landlock := false, false
+
+	if enabled {
+		if err := applySeccomp(); err != nil {
+			log.Printf("cannot apply seccomp filter: %s", err)
+		} else {
+			seccomp = true
+		}
+
+		if err := applyLandlock(writable); err != nil {
+			log.Printf("cannot apply landlock ruleset: %s", err)
+		} else {
+			landlock = true
+		}
+
+		log.Printf("sandbox is applied: seccomp %t, landlock %t", seccomp, landlock)
+	}
+
+	sandboxInfo.WithLabelValues(strconv.FormatBool(seccomp), strconv.FormatBool(landlock)).Set(1)
+}
@@ -0,0 +1,55 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// sandboxWritableDirs
+func TestSandboxWritableDirs(t *testing.T) {
+	t.Parallel()
+
+	got := sandboxWritableDirs("/var/log/rsyslog_exporter/record.log", "", "inputs")
+	want := []string{"/var/log/rsyslog_exporter", "."}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sandboxWritableDirs() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// sandboxSocketDirs
+func TestSandboxSocketDirs(t *testing.T) {
+	t.Parallel()
+
+	got := sandboxSocketDirs([]string{
+		"udp://127.0.0.1:5145",
+		"unixgram:///run/rsyslog_exporter/stats.sock?mode=0660",
+		"unixgram://",
+		"file:///var/log/rsyslog-stats.log",
+	})
+	want := []string{"/run/rsyslog_exporter"}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("sandboxSocketDirs() mismatch (-want +got):\n%s", diff)
+	}
+}
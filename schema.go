@@ -0,0 +1,169 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	schemaReloads = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rsyslog_exporter_schema_reloads",
+			Help: "Amount of schema mapping file reloads by result",
+		},
+		[]string{"result"},
+	)
+
+	schemaReloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_schema_last_reload_successful",
+			Help: "Whether the last schema mapping file reload attempt was successful",
+		},
+	)
+
+	schemaReloadTimestamp = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rsyslog_exporter_schema_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful schema mapping file reload",
+		},
+	)
+)
+
+// Metrics exported by the schema watcher
+var schemaMetrics = []prometheus.Collector{
+	schemaReloads,
+	schemaReloadSuccess,
+	schemaReloadTimestamp,
+}
+
+// RsyslogStatsSchema maps origins to the stat types overriding the built-in
+// detection. File format (JSON):
+//
+//	{"origins": {"mydynstats": "dynstats_global", "mymodule": "named"}}
+type RsyslogStatsSchema struct {
+	Origins map[string]string `json:"origins"`
+
+	types map[string]rsyslogStatType
+}
+
+// Get the stat type by its name (see rsyslogStatType.String)
+func parseStatType(name string) (rsyslogStatType, error) {
+	for _, st := range []rsyslogStatType{rtDefault, rtDynstatGlobal, rtDynstatBucket, rtNamed, rtSender, rtDatabase, rtPercentile, rtDynafileCache, rtOmkafka} {
+		if st.String() == name {
+			return st, nil
+		}
+	}
+
+	return rtDefault, fmt.Errorf("stat type %s is not supported", name)
+}
+
+// LoadSchema reads and validates the schema mapping file
+func LoadSchema(path string) (*RsyslogStatsSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &RsyslogStatsSchema{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("cannot parse schema %s: %w", path, err)
+	}
+
+	s.types = make(map[string]rsyslogStatType, len(s.Origins))
+	for origin, name := range s.Origins {
+		st, err := parseStatType(name)
+		if err != nil {
+			return nil, fmt.Errorf("origin %s in schema %s: %w", origin, path, err)
+		}
+
+		s.types[origin] = st
+	}
+
+	return s, nil
+}
+
+// Get the stat type of the origin if it's mapped
+func (s *RsyslogStatsSchema) statType(origin string) (rsyslogStatType, bool) {
+	if s == nil {
+		return rtDefault, false
+	}
+
+	st, found := s.types[origin]
+
+	return st, found
+}
+
+// Load the schema and apply it, the schema in use is kept on failure
+func reloadSchema(rs *RsyslogStats, path string) error {
+	s, err := LoadSchema(path)
+	if err != nil {
+		schemaReloads.WithLabelValues("failure").Inc()
+		schemaReloadSuccess.Set(0)
+
+		return err
+	}
+
+	rs.SetSchema(s)
+	schemaReloads.WithLabelValues("success").Inc()
+	schemaReloadSuccess.Set(1)
+	schemaReloadTimestamp.SetToCurrentTime()
+
+	return nil
+}
+
+// Reload the schema every time the file is changed until the context is done.
+// The file is polled as editors and config management tools often replace it.
+// The first check always reloads the file to catch the changes made since the
+// initial load.
+func watchSchema(ctx context.Context, rs *RsyslogStats, path string, interval time.Duration) {
+	var modTime time.Time
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil || fi.ModTime().Equal(modTime) {
+			continue
+		}
+
+		modTime = fi.ModTime()
+
+		if err := reloadSchema(rs, path); err != nil {
+			log.Printf("schema reload failed: %s", err)
+		} else {
+			log.Printf("schema %s reloaded", path)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// LoadSchema
+func TestLoadSchema(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	var tests = []struct {
+		content string
+		fails   bool
+	}{
+		{`{"origins": {"mydyn": "dynstats_global", "mymodule": "named"}}`, false},
+		{`{"origins": {"mydyn": "dynstats"}}`, true},
+		{`{"origins": `, true},
+	}
+
+	for i, c := range tests {
+		path := filepath.Join(dir, string(rune('a'+i))+".json")
+		if err := os.WriteFile(path, []byte(c.content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadSchema(path); (err != nil) != c.fails {
+			t.Errorf("%s: want error %t, got %v", c.content, c.fails, err)
+		}
+	}
+
+	if _, err := LoadSchema(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("missing file error expected")
+	}
+}
+
+// RsyslogStats.identify (schema mapping)
+func TestRsyslogStatsIdentifySchema(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(`{"origins": {"mydyn": "dynstats_global"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := LoadSchema(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRsyslogStats()
+	data := map[string]interface{}{"name": "global", "origin": "mydyn"}
+
+	if _, _, st, _ := rs.identify(data); st != rtNamed {
+		t.Errorf("want %s type before the schema is set, got %s", rtNamed, st)
+	}
+
+	rs.SetSchema(s)
+
+	if _, _, st, _ := rs.identify(data); st != rtDynstatGlobal {
+		t.Errorf("want %s type, got %s", rtDynstatGlobal, st)
+	}
+}
+
+// watchSchema
+func TestWatchSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(`{"origins": {}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRsyslogStats()
+	if err := reloadSchema(rs, path); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	successes := testutil.ToFloat64(schemaReloads.WithLabelValues("success"))
+	go watchSchema(ctx, rs, path, 10*time.Millisecond)
+
+	// wait for the first check
+	for i := 0; i < 100 && testutil.ToFloat64(schemaReloads.WithLabelValues("success")) == successes; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// broken file must keep the schema in use
+	failures := testutil.ToFloat64(schemaReloads.WithLabelValues("failure"))
+	if err := os.WriteFile(path, []byte(`{"origins": {"mydyn": "dynstats"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100 && testutil.ToFloat64(schemaReloads.WithLabelValues("failure")) == failures; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if got := testutil.ToFloat64(schemaReloadSuccess); got != 0 {
+		t.Errorf("want last reload failed, got %v", got)
+	}
+
+	if _, found := rs.schema.Load().statType("mydyn"); found {
+		t.Error("broken schema must not be applied")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"origins": {"mydyn": "dynstats_global"}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, found := rs.schema.Load().statType("mydyn"); found {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Error("schema was not reloaded")
+}
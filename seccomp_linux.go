@@ -0,0 +1,139 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// Seccomp constants missing in x/sys
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTSync = 1
+	seccompRetErrno        = 0x00050000
+	seccompRetAllow        = 0x7fff0000
+	// x32 ABI syscalls share the x86_64 audit architecture
+	x32SyscallBit = 0x40000000
+)
+
+// Syscalls denied by the seccomp filter: the exporter never runs processes,
+// loads modules, mounts file systems or inspects other processes
+var seccompDenied = []uint32{
+	unix.SYS_EXECVE,
+	unix.SYS_EXECVEAT,
+	unix.SYS_PTRACE,
+	unix.SYS_PROCESS_VM_READV,
+	unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_MOUNT,
+	unix.SYS_UMOUNT2,
+	unix.SYS_PIVOT_ROOT,
+	unix.SYS_CHROOT,
+	unix.SYS_UNSHARE,
+	unix.SYS_SETNS,
+	unix.SYS_INIT_MODULE,
+	unix.SYS_FINIT_MODULE,
+	unix.SYS_DELETE_MODULE,
+	unix.SYS_KEXEC_LOAD,
+	unix.SYS_KEXEC_FILE_LOAD,
+	unix.SYS_REBOOT,
+	unix.SYS_SWAPON,
+	unix.SYS_SWAPOFF,
+	unix.SYS_ACCT,
+	unix.SYS_BPF,
+	unix.SYS_PERF_EVENT_OPEN,
+	unix.SYS_USERFAULTFD,
+	unix.SYS_KEYCTL,
+	unix.SYS_ADD_KEY,
+	unix.SYS_REQUEST_KEY,
+}
+
+// Get the audit architecture of the running binary
+func seccompArch() uint32 {
+	if runtime.GOARCH == "arm64" {
+		return unix.AUDIT_ARCH_AARCH64
+	}
+
+	return unix.AUDIT_ARCH_X86_64
+}
+
+// Build the seccomp filter: the denied syscalls and syscalls of the foreign
+// architecture or ABI fail with EPERM, the rest are allowed
+func seccompFilter() ([]bpf.RawInstruction, error) {
+	deny := bpf.RetConstant{Val: seccompRetErrno | uint32(unix.EPERM)}
+
+	// struct seccomp_data: int nr; __u32 arch; ...
+	prog := []bpf.Instruction{
+		bpf.LoadAbsolute{Off: 4, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpEqual, Val: seccompArch(), SkipTrue: 1},
+		deny,
+		bpf.LoadAbsolute{Off: 0, Size: 4},
+		bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: x32SyscallBit, SkipTrue: uint8(len(seccompDenied) + 1)},
+	}
+
+	for i, nr := range seccompDenied {
+		// jump to the deny instruction following the allow one
+		prog = append(prog, bpf.JumpIf{Cond: bpf.JumpEqual, Val: nr, SkipTrue: uint8(len(seccompDenied) - i)})
+	}
+
+	prog = append(prog, bpf.RetConstant{Val: seccompRetAllow}, deny)
+
+	return bpf.Assemble(prog)
+}
+
+// Install the seccomp filter on all threads
+func applySeccomp() error {
+	raw, err := seccompFilter()
+	if err != nil {
+		return err
+	}
+
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// the filter may be installed by unprivileged process with no_new_privs
+	// set only, it's synced to the other threads by TSYNC
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("cannot set no_new_privs: %w", err)
+	}
+
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTSync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return errno
+	}
+
+	if tid != 0 {
+		return fmt.Errorf("cannot sync the filter to thread %d", tid)
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// seccompFilter
+func TestSeccompFilter(t *testing.T) {
+	t.Parallel()
+
+	raw, err := seccompFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prog, ok := bpf.Disassemble(raw)
+	if !ok {
+		t.Fatal("cannot disassemble the filter")
+	}
+
+	vm, err := bpf.NewVM(prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deny := seccompRetErrno | int(unix.EPERM)
+
+	var tests = []struct {
+		name string
+		nr   uint32
+		arch uint32
+		want int
+	}{
+		{"read", unix.SYS_READ, seccompArch(), seccompRetAllow},
+		{"recvmmsg", unix.SYS_RECVMMSG, seccompArch(), seccompRetAllow},
+		{"first denied", seccompDenied[0], seccompArch(), deny},
+		{"last denied", seccompDenied[len(seccompDenied)-1], seccompArch(), deny},
+		{"ptrace", unix.SYS_PTRACE, seccompArch(), deny},
+		{"x32 ABI", x32SyscallBit | unix.SYS_READ, seccompArch(), deny},
+		{"foreign arch", unix.SYS_READ, unix.AUDIT_ARCH_I386, deny},
+	}
+
+	for _, tt := range tests {
+		// bpf.VM loads the words in the network byte order, the kernel does
+		// in the native one
+		data := make([]byte, 64)
+		binary.BigEndian.PutUint32(data[0:], tt.nr)
+		binary.BigEndian.PutUint32(data[4:], tt.arch)
+
+		got, err := vm.Run(data)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: want %#x, got %#x", tt.name, tt.want, got)
+		}
+	}
+}
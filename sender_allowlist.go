@@ -0,0 +1,73 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Sender label value of the aggregated senders not in the allowlist
+const otherSender = "other"
+
+// SenderAllowlist restricts per-sender series to the listed hostnames, IPs and CIDRs
+type SenderAllowlist struct {
+	hosts map[string]bool
+	nets  []*net.IPNet
+}
+
+// NewSenderAllowlist is the SenderAllowlist constructor
+func NewSenderAllowlist(items []string) (*SenderAllowlist, error) {
+	sa := &SenderAllowlist{hosts: make(map[string]bool)}
+
+	for _, item := range items {
+		if strings.Contains(item, "/") {
+			_, ipNet, err := net.ParseCIDR(item)
+			if err != nil {
+				return nil, fmt.Errorf("wrong sender allowlist CIDR %s: %w", item, err)
+			}
+
+			sa.nets = append(sa.nets, ipNet)
+			continue
+		}
+
+		sa.hosts[strings.ToLower(item)] = true
+	}
+
+	return sa, nil
+}
+
+// Allowed checks if the sender is tracked individually
+func (sa *SenderAllowlist) Allowed(sender string) bool {
+	if sa == nil || sa.hosts[strings.ToLower(sender)] {
+		return true
+	}
+
+	if ip := net.ParseIP(sender); ip != nil {
+		for _, ipNet := range sa.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,61 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+)
+
+// SenderAllowlist.Allowed
+func TestSenderAllowlistAllowed(t *testing.T) {
+	t.Parallel()
+
+	sa, err := NewSenderAllowlist([]string{"Host1.example.com", "10.0.0.0/8", "2001:db8::/32", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		sender  string
+		allowed bool
+	}{
+		{"host1.example.com", true},
+		{"host2.example.com", false},
+		{"10.1.2.3", true},
+		{"11.1.2.3", false},
+		{"2001:db8::1", true},
+		{"192.0.2.1", true},
+		{"192.0.2.2", false},
+	}
+
+	for _, c := range tests {
+		if got := sa.Allowed(c.sender); got != c.allowed {
+			t.Errorf("%s: want %t, got %t", c.sender, c.allowed, got)
+		}
+	}
+
+	if sa = nil; !sa.Allowed("any") {
+		t.Error("nil allowlist must allow any sender")
+	}
+
+	if _, err = NewSenderAllowlist([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("wrong CIDR error expected")
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Sender identity sources
+const (
+	senderHostname    = "hostname"
+	senderPeer        = "peer"
+	senderRDNS        = "rdns"
+	senderFieldPrefix = "field:"
+)
+
+// Reverse DNS lookups are cached (failures too) to keep the parsing fast
+const (
+	rdnsTimeout   = time.Second
+	rdnsCacheTTL  = 5 * time.Minute
+	rdnsCacheSize = 10000
+)
+
+// Cached reverse DNS name
+type rdnsEntry struct {
+	name    string
+	expires time.Time
+	// The lookup is in progress (the name is the stale one if set)
+	pending bool
+}
+
+// SenderSource resolves the sender identity trying the sources in order:
+// syslog HOSTNAME, remote peer IP, reverse DNS name of the peer or a stats
+// message JSON field (dotted path)
+type SenderSource struct {
+	sources []string
+
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	rdnsLock   sync.Mutex
+	rdnsCache  map[string]rdnsEntry
+	// Reverse DNS lookups running in the background
+	lookups sync.WaitGroup
+}
+
+// Sender identity source (hostname then peer if nil)
+var senderSource *SenderSource
+
+// NewSenderSource is the SenderSource constructor
+func NewSenderSource(items []string) (*SenderSource, error) {
+	ss := &SenderSource{
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		rdnsCache:  make(map[string]rdnsEntry),
+	}
+
+	for _, item := range items {
+		switch {
+		case item == senderHostname, item == senderPeer, item == senderRDNS:
+		case strings.HasPrefix(item, senderFieldPrefix) && len(item) > len(senderFieldPrefix):
+		default:
+			return nil, fmt.Errorf("sender source %s is not supported", item)
+		}
+
+		ss.sources = append(ss.sources, item)
+	}
+
+	if len(ss.sources) == 0 {
+		return nil, fmt.Errorf("at least one sender source is required")
+	}
+
+	return ss, nil
+}
+
+// Get the peer IP of the message
+func messagePeer(line format.LogParts) string {
+	client, _ := line["client"].(string)
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		return host
+	}
+
+	return client
+}
+
+// Check if the sender is resolved from the stats message fields
+func (ss *SenderSource) needsData() bool {
+	if ss == nil {
+		return false
+	}
+
+	for _, source := range ss.sources {
+		if strings.HasPrefix(source, senderFieldPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the string field of the decoded stats message by the dotted path
+func jsonField(data map[string]interface{}, path string) string {
+	var value interface{} = data
+
+	for _, key := range strings.Split(path, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+
+		value = m[key]
+	}
+
+	rv, _ := value.(string)
+
+	return rv
+}
+
+// Get the reverse DNS name of the IP. Names are resolved in the background,
+// the IP (or the stale name) is returned until the lookup is done.
+func (ss *SenderSource) rdns(ip string) string {
+	if net.ParseIP(ip) == nil {
+		return ""
+	}
+
+	now := time.Now()
+
+	ss.rdnsLock.Lock()
+	defer ss.rdnsLock.Unlock()
+
+	entry, found := ss.rdnsCache[ip]
+	if found && now.Before(entry.expires) {
+		return entry.name
+	}
+
+	if !entry.pending {
+		if len(ss.rdnsCache) >= rdnsCacheSize {
+			ss.rdnsCache = make(map[string]rdnsEntry)
+		}
+
+		ss.rdnsCache[ip] = rdnsEntry{name: entry.name, pending: true}
+
+		ss.lookups.Add(1)
+		go ss.lookup(ip)
+	}
+
+	if entry.name != "" {
+		return entry.name
+	}
+
+	return ip
+}
+
+// Look the reverse DNS name of the IP up and cache it (failures too)
+func (ss *SenderSource) lookup(ip string) {
+	defer ss.lookups.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), rdnsTimeout)
+	defer cancel()
+
+	entry := rdnsEntry{}
+	if names, err := ss.lookupAddr(ctx, ip); err == nil && len(names) > 0 {
+		entry.name = strings.TrimSuffix(names[0], ".")
+	}
+
+	ss.rdnsLock.Lock()
+	entry.expires = time.Now().Add(rdnsCacheTTL)
+	ss.rdnsCache[ip] = entry
+	ss.rdnsLock.Unlock()
+}
+
+// Resolve the sender of the stats message. The message is decoded for the
+// field sources only, the decoded message is returned to be reused by the
+// parser (nil if it's not decoded). It's returned to the pool by the caller.
+func (ss *SenderSource) resolve(line format.LogParts, content string) (string, map[string]interface{}) {
+	if !ss.needsData() {
+		return ss.Sender(line, nil), nil
+	}
+
+	data := getObject()
+	if err := json.Unmarshal([]byte(content), &data); err != nil {
+		putObject(data)
+		data = nil
+	}
+
+	return ss.Sender(line, data), data
+}
+
+// Sender returns the first non-empty sender identity. The field sources are
+// looked up in the decoded stats message (they are empty if it's nil).
+func (ss *SenderSource) Sender(line format.LogParts, data map[string]interface{}) string {
+	if ss == nil {
+		return messageSender(line)
+	}
+
+	for _, source := range ss.sources {
+		var sender string
+
+		switch source {
+		case senderHostname:
+			sender, _ = line["hostname"].(string)
+		case senderPeer:
+			sender = messagePeer(line)
+		case senderRDNS:
+			sender = ss.rdns(messagePeer(line))
+		default:
+			sender = jsonField(data, strings.TrimPrefix(source, senderFieldPrefix))
+		}
+
+		if sender != "" {
+			return sender
+		}
+	}
+
+	return ""
+}
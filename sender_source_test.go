@@ -0,0 +1,183 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// SenderSource.Sender
+func TestSenderSourceSender(t *testing.T) {
+	t.Parallel()
+
+	var lookups atomic.Int32
+	lookupAddr := func(ctx context.Context, addr string) ([]string, error) {
+		lookups.Add(1)
+		if addr == "10.0.0.1" {
+			return []string{"host1.example.com."}, nil
+		}
+
+		return nil, errors.New("not found")
+	}
+
+	content := `{"name": "main Q", "origin": "core.queue", "size": 1, "meta": {"host": "json-host"}}`
+
+	var tests = []struct {
+		sources []string
+		line    format.LogParts
+		sender  string
+	}{
+		{[]string{"hostname", "peer"}, format.LogParts{"hostname": "host1", "client": "10.0.0.1:514"}, "host1"},
+		{[]string{"hostname", "peer"}, format.LogParts{"hostname": "", "client": "10.0.0.1:514"}, "10.0.0.1"},
+		{[]string{"peer", "hostname"}, format.LogParts{"hostname": "host1", "client": "10.0.0.1:514"}, "10.0.0.1"},
+		{[]string{"rdns", "peer"}, format.LogParts{"client": "10.0.0.1:514"}, "host1.example.com"},
+		{[]string{"rdns", "peer"}, format.LogParts{"client": "10.0.0.2:514"}, "10.0.0.2"},
+		{[]string{"rdns"}, format.LogParts{"client": "zmq"}, ""},
+		{[]string{"field:meta.host", "hostname"}, format.LogParts{"hostname": "host1"}, "json-host"},
+		{[]string{"field:meta.missing", "hostname"}, format.LogParts{"hostname": "host1"}, "host1"},
+		{[]string{"field:size", "hostname"}, format.LogParts{"hostname": "host1"}, "host1"},
+	}
+
+	for _, c := range tests {
+		ss, err := NewSenderSource(c.sources)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ss.lookupAddr = lookupAddr
+
+		// the reverse DNS names are resolved in the background
+		ss.resolve(c.line, content)
+		ss.lookups.Wait()
+
+		got, data := ss.resolve(c.line, content)
+		if got != c.sender {
+			t.Errorf("%v: want %s, got %s", c.sources, c.sender, got)
+		}
+
+		if (data != nil) != ss.needsData() {
+			t.Errorf("%v: want the decoded message returned for the field sources only", c.sources)
+		}
+	}
+
+	// reverse DNS names are cached
+	ss, err := NewSenderSource([]string{"rdns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss.lookupAddr = lookupAddr
+	lookups.Store(0)
+
+	for i := 0; i < 3; i++ {
+		ss.Sender(format.LogParts{"client": "10.0.0.1:514"}, nil)
+		ss.lookups.Wait()
+	}
+
+	if n := lookups.Load(); n != 1 {
+		t.Errorf("want 1 lookup, got %d", n)
+	}
+
+	if ss = nil; ss.Sender(format.LogParts{"hostname": "host1"}, nil) != "host1" {
+		t.Error("nil sender source must fall back to the hostname")
+	}
+
+	for _, sources := range [][]string{{"dns"}, {"field:"}, {}} {
+		if _, err := NewSenderSource(sources); err == nil {
+			t.Errorf("%v: error expected", sources)
+		}
+	}
+}
+
+// SenderSource.rdns
+func TestSenderSourceRDNSPending(t *testing.T) {
+	t.Parallel()
+
+	ss, err := NewSenderSource([]string{"rdns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := make(chan struct{})
+	ss.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		<-release
+		return []string{"host1.example.com."}, nil
+	}
+
+	line := format.LogParts{"client": "10.0.0.1:514"}
+
+	// the IP is used until the name is resolved, the parsing isn't blocked
+	for i := 0; i < 2; i++ {
+		if got := ss.Sender(line, nil); got != "10.0.0.1" {
+			t.Errorf("pending lookup: want 10.0.0.1, got %s", got)
+		}
+	}
+
+	close(release)
+	ss.lookups.Wait()
+
+	if got := ss.Sender(line, nil); got != "host1.example.com" {
+		t.Errorf("resolved: want host1.example.com, got %s", got)
+	}
+
+	// the expired name is used until it's refreshed
+	ss.rdnsLock.Lock()
+	ss.rdnsCache["10.0.0.1"] = rdnsEntry{name: "old.example.com"}
+	ss.rdnsLock.Unlock()
+
+	if got := ss.Sender(line, nil); got != "old.example.com" {
+		t.Errorf("refreshing: want old.example.com, got %s", got)
+	}
+
+	ss.lookups.Wait()
+
+	if got := ss.Sender(line, nil); got != "host1.example.com" {
+		t.Errorf("refreshed: want host1.example.com, got %s", got)
+	}
+}
+
+// RsyslogStats.ParseFrom (message decoded by the sender resolution)
+func TestRsyslogStatsParseDecoded(t *testing.T) {
+	t.Parallel()
+
+	ss, err := NewSenderSource([]string{"field:meta.host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := `{"name": "main Q", "origin": "core.queue", "size": 3, "meta": {"host": "json-host"}}`
+
+	sender, data := ss.resolve(format.LogParts{}, content)
+	if sender != "json-host" || data == nil {
+		t.Fatalf("want json-host and the decoded message, got %q, %v", sender, data)
+	}
+
+	rs := NewRsyslogStats()
+	rs.ParseFrom(RsyslogStatsSource{Sender: sender, Data: data}, content)
+
+	if got := rs.Metrics["rsyslog_core_queue_size"][RsyslogStatsLabels{"name", "main Q"}]; got != 3 {
+		t.Errorf("rsyslog_core_queue_size: want 3, got %v", got)
+	}
+}
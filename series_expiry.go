@@ -0,0 +1,272 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Approximate memory used by the stored series and metric families besides
+// the name and label strings: map entries, string headers and values
+const (
+	seriesOverhead = 64
+	familyOverhead = 128
+)
+
+// Go maps never release the buckets of the deleted entries, so the families
+// shrunk to 1/familyShrinkRatio of their peak size are rebuilt. Small families
+// are not worth copying.
+const (
+	familyShrinkRatio     = 2
+	familyShrinkMinSeries = 64
+)
+
+// Approximate memory used by the stored series
+func (rs *RsyslogStats) seriesBytes(labels RsyslogStatsLabels) int64 {
+	n := int64(seriesOverhead + len(labels.Name) + len(labels.Value))
+
+	// update time entry
+	if rs.SeriesTTL > 0 {
+		n += seriesOverhead
+	}
+
+	return n
+}
+
+// Approximate memory used by the empty metric family
+func familyBytes(metric string) int64 {
+	return int64(familyOverhead + len(metric))
+}
+
+// Account the series stored, must be called with the lock held
+func (rs *RsyslogStats) trackSeries(metric string, labels RsyslogStatsLabels, now time.Time, added bool) {
+	if added {
+		rs.storeBytes += rs.seriesBytes(labels)
+
+		if n := len(rs.Metrics[metric]); n > rs.familyPeak[metric] {
+			rs.familyPeak[metric] = n
+		}
+	}
+
+	if rs.SeriesTTL <= 0 {
+		return
+	}
+
+	updated, found := rs.seriesUpdated[metric]
+	if !found {
+		updated = make(map[RsyslogStatsLabels]int64)
+		rs.seriesUpdated[metric] = updated
+	}
+
+	updated[labels] = now.Unix()
+}
+
+// StoreBytes returns the approximate memory used by the metric store
+func (rs *RsyslogStats) StoreBytes() int64 {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return rs.storeBytes
+}
+
+// Expire the series not updated within SeriesTTL (dynstats buckets of the
+// hosts gone e.g.). The families left empty are deleted, the ones shrunk well
+// below their peak size are rebuilt so the memory is returned. The state kept
+// for the expired series and the senders not seen within SeriesTTL is dropped
+// as well. Must be called with the parser paused as the raw names are owned
+// by the parser goroutine. Returns the amount of series expired.
+func (rs *RsyslogStats) Expire(now time.Time) int {
+	if rs.SeriesTTL <= 0 {
+		return 0
+	}
+
+	deadline := now.Add(-rs.SeriesTTL).Unix()
+	expired := 0
+	stale := map[RsyslogStatsLabels]struct{}{}
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	for metric, updated := range rs.seriesUpdated {
+		for labels, ts := range updated {
+			if ts >= deadline {
+				continue
+			}
+
+			delete(updated, labels)
+			delete(rs.Metrics[metric], labels)
+			delete(rs.rawNames[metric], labels)
+			rs.storeBytes -= rs.seriesBytes(labels)
+			stale[labels] = struct{}{}
+			expired++
+		}
+
+		if len(updated) == 0 {
+			rs.deleteFamily(metric)
+			continue
+		}
+
+		rs.shrinkFamily(metric)
+	}
+
+	rs.ExpiredSeries += expired
+
+	if expired > 0 {
+		rs.interned.reset()
+	}
+
+	rs.pruneSeriesState(stale)
+	rs.pruneSenders(deadline)
+
+	return expired
+}
+
+// Check if any family holds the series with the labels
+func (rs *RsyslogStats) hasLabels(labels RsyslogStatsLabels) bool {
+	for _, labeledValues := range rs.Metrics {
+		if _, found := labeledValues[labels]; found {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Drop the queue watermarks, the action suspensions and counters and the
+// status counters of the expired label sets no family holds anymore
+func (rs *RsyslogStats) pruneSeriesState(stale map[RsyslogStatsLabels]struct{}) {
+	for labels := range stale {
+		if rs.hasLabels(labels) {
+			delete(stale, labels)
+			continue
+		}
+
+		delete(rs.watermarks, labels)
+		delete(rs.suspensions, labels)
+		delete(rs.actionCounters, labels)
+	}
+
+	if len(stale) == 0 {
+		return
+	}
+
+	for object := range rs.statusCounters {
+		if _, found := stale[object.labels]; found {
+			delete(rs.statusCounters, object)
+		}
+	}
+}
+
+// Forget the senders neither parsed nor failed since the deadline
+func (rs *RsyslogStats) pruneSenders(deadline int64) {
+	for sender, seen := range rs.senderSeen {
+		if seen.Unix() < deadline {
+			delete(rs.senderSeen, sender)
+		}
+	}
+
+	for sender, failed := range rs.senderFailed {
+		if failed.Unix() < deadline {
+			delete(rs.senderFailed, sender)
+		}
+	}
+
+	for sender := range rs.SenderParserFailures {
+		_, seen := rs.senderSeen[sender]
+		_, failed := rs.senderFailed[sender]
+
+		if !seen && !failed {
+			delete(rs.SenderParserFailures, sender)
+		}
+	}
+
+	for sender, c := range rs.otherSenderMessages {
+		if c.updated.Unix() < deadline {
+			delete(rs.otherSenderMessages, sender)
+		}
+	}
+}
+
+// Delete the empty metric family
+func (rs *RsyslogStats) deleteFamily(metric string) {
+	delete(rs.Metrics, metric)
+	delete(rs.seriesUpdated, metric)
+	delete(rs.familyUpdated, metric)
+	delete(rs.familyPeak, metric)
+	delete(rs.rawNames, metric)
+	delete(rs.renamedFamilies, metric)
+
+	rs.storeBytes -= familyBytes(metric)
+}
+
+// Rebuild the family maps if the family is shrunk well below its peak size
+func (rs *RsyslogStats) shrinkFamily(metric string) {
+	n, peak := len(rs.Metrics[metric]), rs.familyPeak[metric]
+	if peak-n < familyShrinkMinSeries || n*familyShrinkRatio > peak {
+		return
+	}
+
+	values := make(RsyslogStatsLabeledValues, n)
+	for labels, value := range rs.Metrics[metric] {
+		values[labels] = value
+	}
+
+	updated := make(map[RsyslogStatsLabels]int64, n)
+	for labels, ts := range rs.seriesUpdated[metric] {
+		updated[labels] = ts
+	}
+
+	if raw, found := rs.rawNames[metric]; found {
+		rawNames := make(map[RsyslogStatsLabels]string, len(raw))
+		for labels, name := range raw {
+			rawNames[labels] = name
+		}
+
+		rs.rawNames[metric] = rawNames
+	}
+
+	rs.Metrics[metric] = values
+	rs.seriesUpdated[metric] = updated
+	rs.familyPeak[metric] = n
+	rs.RebuiltFamilies++
+}
+
+// Expire the series periodically until the context is done
+func runSeriesExpiry(ctx context.Context, rs *RsyslogStats, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			parseMu.Lock()
+			expired := rs.Expire(now)
+			parseMu.Unlock()
+
+			if expired > 0 {
+				log.Printf("%d series not updated for %s are expired", expired, rs.SeriesTTL)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
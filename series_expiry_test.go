@@ -0,0 +1,170 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Dynstats bucket line with the counters of the hosts given
+func dynstatsBucketLine(hosts ...string) string {
+	values := make([]string, len(hosts))
+	for i, host := range hosts {
+		values[i] = fmt.Sprintf(`"%s": 1`, host)
+	}
+
+	return `{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {` + strings.Join(values, ", ") + `}}`
+}
+
+// Host names host<from>..host<to-1>
+func testHosts(from, to int) []string {
+	hosts := []string{}
+	for i := from; i < to; i++ {
+		hosts = append(hosts, fmt.Sprintf("host%d", i))
+	}
+
+	return hosts
+}
+
+// RsyslogStats.StoreBytes
+func TestRsyslogStatsStoreBytes(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	if got := rs.StoreBytes(); got != 0 {
+		t.Errorf("empty store: want 0 bytes, got %d", got)
+	}
+
+	rs.Parse(dynstatsBucketLine(testHosts(0, 10)...))
+	before := rs.StoreBytes()
+
+	if before <= 0 {
+		t.Fatalf("want positive store size, got %d", before)
+	}
+
+	rs.Parse(dynstatsBucketLine(testHosts(0, 10)...))
+	if got := rs.StoreBytes(); got != before {
+		t.Errorf("updated series: want %d bytes, got %d", before, got)
+	}
+
+	rs.Parse(dynstatsBucketLine(testHosts(10, 20)...))
+	if got := rs.StoreBytes(); got <= before {
+		t.Errorf("new series: want more than %d bytes, got %d", before, got)
+	}
+}
+
+// RsyslogStats.Expire
+func TestRsyslogStatsExpire(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.SeriesTTL = time.Minute
+
+	// nothing is expired within the TTL
+	rs.Parse(dynstatsBucketLine(testHosts(0, 200)...))
+
+	if got := rs.Expire(time.Now()); got != 0 {
+		t.Errorf("within TTL: want 0 series expired, got %d", got)
+	}
+
+	// most of the hosts are gone: the family is rebuilt
+	old := time.Now().Add(-2 * time.Minute).Unix()
+	for labels := range rs.seriesUpdated["rsyslog_dynstats_bucket_msg_per_host"] {
+		if labels.Value != "host0" && labels.Value != "host1" {
+			rs.seriesUpdated["rsyslog_dynstats_bucket_msg_per_host"][labels] = old
+		}
+	}
+
+	if got := rs.Expire(time.Now()); got != 198 {
+		t.Errorf("gone hosts: want 198 series expired, got %d", got)
+	}
+
+	if got := len(rs.Metrics["rsyslog_dynstats_bucket_msg_per_host"]); got != 2 {
+		t.Errorf("want 2 series left, got %d", got)
+	}
+
+	if rs.RebuiltFamilies != 1 || rs.ExpiredSeries != 198 {
+		t.Errorf("want 1 family rebuilt and 198 series expired, got %d and %d", rs.RebuiltFamilies, rs.ExpiredSeries)
+	}
+
+	fresh := NewRsyslogStats()
+	fresh.SeriesTTL = time.Minute
+	fresh.Parse(dynstatsBucketLine("host0", "host1"))
+
+	if got, want := rs.StoreBytes(), fresh.StoreBytes(); got != want {
+		t.Errorf("store size after expiry: want %d, got %d", want, got)
+	}
+
+	// all hosts are gone: the family is deleted
+	if got := rs.Expire(time.Now().Add(2 * time.Minute)); got != 2 {
+		t.Errorf("all hosts gone: want 2 series expired, got %d", got)
+	}
+
+	if _, found := rs.Metrics["rsyslog_dynstats_bucket_msg_per_host"]; found {
+		t.Error("empty family must be deleted")
+	}
+
+	if got := rs.StoreBytes(); got != 0 {
+		t.Errorf("empty store: want 0 bytes, got %d", got)
+	}
+}
+
+// RsyslogStats.Expire
+func TestRsyslogStatsExpireState(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.SeriesTTL = time.Minute
+
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host1"}, `{"name": "main Q", "origin": "core.queue", "size": 1}`)
+	rs.ParseFrom(RsyslogStatsSource{Sender: "host2"}, `{"name": "main Q", "origin": "core.queue", "size": `)
+
+	// the state derived from the series
+	labels := RsyslogStatsLabels{"name", "main Q"}
+	rs.watermarks[labels] = &queueWatermark{}
+	rs.suspensions[labels] = &actionSuspension{}
+	rs.actionCounters[labels] = actionCounters{}
+	rs.statusCounters[statusObject{origin: "core.queue", labels: labels}] = map[string]float64{}
+	rs.otherSenderMessages["host3"] = otherSenderCounter{messages: 1, updated: time.Now()}
+
+	sizes := func() []int {
+		return []int{
+			len(rs.watermarks), len(rs.suspensions), len(rs.actionCounters), len(rs.statusCounters),
+			len(rs.otherSenderMessages), len(rs.senderSeen), len(rs.senderFailed), len(rs.SenderParserFailures),
+		}
+	}
+
+	rs.Expire(time.Now())
+
+	if diff := cmp.Diff([]int{1, 1, 1, 1, 1, 1, 1, 1}, sizes()); diff != "" {
+		t.Errorf("within TTL: state sizes mismatch (-want +got):\n%s", diff)
+	}
+
+	rs.Expire(time.Now().Add(2 * time.Minute))
+
+	if diff := cmp.Diff([]int{0, 0, 0, 0, 0, 0, 0, 0}, sizes()); diff != "" {
+		t.Errorf("senders gone: state sizes mismatch (-want +got):\n%s", diff)
+	}
+}
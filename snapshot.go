@@ -0,0 +1,181 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Snapshot format version
+const snapshotVersion = 1
+
+// RsyslogStatsSnapshotSeries holds a single labeled metric value
+type RsyslogStatsSnapshotSeries struct {
+	Name   string             `json:"name"`
+	Labels RsyslogStatsLabels `json:"labels"`
+	Value  RsyslogStatsValue  `json:"value"`
+}
+
+// RsyslogStatsSnapshot is the metric store snapshot
+type RsyslogStatsSnapshot struct {
+	Version        int                          `json:"version"`
+	Timestamp      int64                        `json:"timestamp"`
+	ParsedMessages int                          `json:"parsed_messages"`
+	ParserFailures int                          `json:"parser_failures"`
+	ParseTimestamp int64                        `json:"parse_timestamp"`
+	Series         []RsyslogStatsSnapshotSeries `json:"series"`
+}
+
+// Snapshot returns the current metric store snapshot
+func (rs *RsyslogStats) Snapshot() RsyslogStatsSnapshot {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	s := RsyslogStatsSnapshot{
+		Version:        snapshotVersion,
+		Timestamp:      time.Now().Unix(),
+		ParsedMessages: rs.ParsedMessages,
+		ParserFailures: rs.ParserFailures,
+		ParseTimestamp: rs.ParseTimestamp,
+		Series:         []RsyslogStatsSnapshotSeries{},
+	}
+
+	for metricName, labeledValues := range rs.Metrics {
+		for labels, value := range labeledValues {
+			s.Series = append(s.Series, RsyslogStatsSnapshotSeries{Name: metricName, Labels: labels, Value: value})
+		}
+	}
+
+	return s
+}
+
+// Restore loads the snapshot into the metric store. Series received by this
+// instance already are kept as they are fresher than the snapshot ones.
+// Returns the amount of series restored (the ones rejected by the store are
+// not counted). Must be called with parseMu held, as the parser updates the
+// same counters.
+func (rs *RsyslogStats) Restore(s RsyslogStatsSnapshot) (int, error) {
+	if s.Version != snapshotVersion {
+		return 0, fmt.Errorf("snapshot version %d is not supported", s.Version)
+	}
+
+	m := RsyslogStatsMetrics{}
+
+	rs.RLock()
+	for _, series := range s.Series {
+		if _, found := rs.Metrics[series.Name][series.Labels]; found {
+			continue
+		}
+
+		if _, found := m[series.Name]; !found {
+			m[series.Name] = make(RsyslogStatsLabeledValues)
+		}
+
+		m[series.Name][series.Labels] = series.Value
+	}
+	rs.RUnlock()
+
+	restored := rs.add(m)
+
+	rs.Lock()
+	rs.ParsedMessages += s.ParsedMessages
+	rs.ParserFailures += s.ParserFailures
+
+	if s.ParseTimestamp > rs.ParseTimestamp {
+		rs.ParseTimestamp = s.ParseTimestamp
+	}
+	rs.Unlock()
+
+	return restored, nil
+}
+
+// Read the admin API bearer token from the file
+func readAdminToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("admin token file %s is empty", path)
+	}
+
+	return token, nil
+}
+
+// Require the bearer token to access the handler
+func requireToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// Register the metric store snapshot endpoints:
+//
+//	GET  /api/v1/snapshot   download the metric store snapshot
+//	POST /api/v1/snapshot   load the snapshot into the metric store
+func registerSnapshotHandlers(mux *http.ServeMux, rs *RsyslogStats, token string) {
+	mux.Handle("/api/v1/snapshot", instrumentHandler("snapshot", requireToken(token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(rs.Snapshot()); err != nil {
+				log.Printf("cannot send snapshot: %s", err)
+			}
+		case http.MethodPost:
+			var s RsyslogStatsSnapshot
+
+			if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+				http.Error(w, fmt.Sprintf("cannot parse snapshot: %s", err), http.StatusBadRequest)
+				return
+			}
+
+			parseMu.Lock()
+			restored, err := rs.Restore(s)
+			parseMu.Unlock()
+
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			log.Printf("%d series are restored from the snapshot", restored)
+			fmt.Fprintf(w, "%d series restored\n", restored)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}))))
+}
@@ -0,0 +1,147 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// Snapshot, Restore
+func TestRsyslogStatsSnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	src := NewRsyslogStats()
+	src.Parse(`{"name": "main Q", "origin": "core.queue", "size": 10, "enqueued": 20}`)
+	src.Parse(`{"name": "msg_per_host", "origin": "dynstats.bucket", "values": {"host1": 1}}`)
+
+	data, err := json.Marshal(src.Snapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s RsyslogStatsSnapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewRsyslogStats()
+	dst.Parse(`{"name": "main Q", "origin": "core.queue", "size": 5}`)
+
+	restored, err := dst.Restore(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored != 2 {
+		t.Errorf("restored series: want 2, got %d", restored)
+	}
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size":              {RsyslogStatsLabels{"name", "main Q"}: 5},
+		"rsyslog_core_queue_enqueued":          {RsyslogStatsLabels{"name", "main Q"}: 20},
+		"rsyslog_dynstats_bucket_msg_per_host": {RsyslogStatsLabels{"bucket", "host1"}: 1},
+	}
+
+	if diff := cmp.Diff(want, dst.Metrics); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if dst.ParsedMessages != 3 {
+		t.Errorf("ParsedMessages: want 3, got %d", dst.ParsedMessages)
+	}
+
+	s.Version = 0
+	if _, err = dst.Restore(s); err == nil {
+		t.Error("snapshot version error expected")
+	}
+}
+
+// Restore
+func TestRsyslogStatsRestoreRejected(t *testing.T) {
+	t.Parallel()
+
+	s := RsyslogStatsSnapshot{
+		Version: snapshotVersion,
+		Series: []RsyslogStatsSnapshotSeries{
+			{Name: "rsyslog_dynstats_bucket_msg_per_host", Labels: RsyslogStatsLabels{"bucket", "host1"}, Value: 1},
+			{Name: "rsyslog_dynstats_bucket_msg_per_host", Labels: RsyslogStatsLabels{"bucket", "host2"}, Value: 2},
+			{Name: "rsyslog-invalid", Labels: RsyslogStatsLabels{"name", "main Q"}, Value: 3},
+		},
+	}
+
+	// the second bucket is over the family limit, the invalid name is quarantined
+	rs := NewRsyslogStats()
+	rs.MaxFamilySeries = 1
+
+	restored, err := rs.Restore(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if restored != 1 {
+		t.Errorf("restored series: want 1, got %d", restored)
+	}
+}
+
+// registerSnapshotHandlers
+func TestSnapshotHandlers(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 10}`)
+
+	mux := http.NewServeMux()
+	registerSnapshotHandlers(mux, rs, "secret")
+
+	var tests = []struct {
+		method string
+		token  string
+		body   string
+		code   int
+	}{
+		{"GET", "", "", http.StatusUnauthorized},
+		{"GET", "wrong", "", http.StatusUnauthorized},
+		{"GET", "secret", "", http.StatusOK},
+		{"POST", "secret", `{"version": 1, "series": []}`, http.StatusOK},
+		{"POST", "secret", `{"version": 2}`, http.StatusBadRequest},
+		{"POST", "secret", `not a json`, http.StatusBadRequest},
+		{"DELETE", "secret", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, c := range tests {
+		req := httptest.NewRequest(c.method, "/api/v1/snapshot", bytes.NewBufferString(c.body))
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		if rec.Code != c.code {
+			t.Errorf("%s %s: want %d, got %d", c.method, c.body, c.code, rec.Code)
+		}
+	}
+}
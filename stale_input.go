@@ -0,0 +1,129 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Stale input actions
+const (
+	staleActionGauge = "gauge"
+	staleActionExit  = "exit"
+)
+
+var inputStalled = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "rsyslog_exporter_input_stalled",
+		Help: "Whether no rsyslog stats are parsed for longer than -stale-input-after (1) or not (0)",
+	},
+)
+
+// Metrics exported by the stale input watchdog
+var staleInputMetrics = []prometheus.Collector{
+	inputStalled,
+}
+
+// Stale input watchdog: silent input death (rsyslog impstats disabled,
+// the forwarding broken, the listener gone) is detected when no stats are
+// parsed for longer than after. The exporter start time is used until the
+// first stats message.
+type staleInputWatchdog struct {
+	after   time.Duration
+	action  string
+	started time.Time
+	stalled bool
+}
+
+// Create the stale input watchdog
+func newStaleInputWatchdog(after time.Duration, action string) (*staleInputWatchdog, error) {
+	switch action {
+	case staleActionGauge, staleActionExit:
+	default:
+		return nil, fmt.Errorf("stale input action %s is not supported", action)
+	}
+
+	return &staleInputWatchdog{after: after, action: action, started: time.Now()}, nil
+}
+
+// Time of the last stats message parsed
+func (rs *RsyslogStats) LastParsed() time.Time {
+	rs.RLock()
+	defer rs.RUnlock()
+
+	return rs.lastParsed
+}
+
+// Check the input and update the stalled gauge. Returns an error if the input
+// is stalled and the action is exit.
+func (w *staleInputWatchdog) check(rs *RsyslogStats, now time.Time) error {
+	last := rs.LastParsed()
+	if last.IsZero() {
+		last = w.started
+	}
+
+	idle := now.Sub(last)
+	stalled := idle > w.after
+
+	if stalled != w.stalled {
+		if stalled {
+			log.Printf("no rsyslog stats parsed for %s, the input is stalled", idle.Truncate(time.Second))
+		} else {
+			log.Printf("rsyslog stats are parsed again, the input is recovered")
+		}
+
+		w.stalled = stalled
+	}
+
+	if stalled {
+		inputStalled.Set(1)
+	} else {
+		inputStalled.Set(0)
+	}
+
+	if stalled && w.action == staleActionExit {
+		return fmt.Errorf("no rsyslog stats parsed for %s, exiting", idle.Truncate(time.Second))
+	}
+
+	return nil
+}
+
+// Check the input periodically until the context is done. Returns the error
+// of the exit action.
+func (w *staleInputWatchdog) run(ctx context.Context, rs *RsyslogStats) error {
+	ticker := time.NewTicker(max(min(w.after/4, time.Minute), time.Second))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if err := w.check(rs, now); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newStaleInputWatchdog
+func TestNewStaleInputWatchdog(t *testing.T) {
+	t.Parallel()
+
+	for _, action := range []string{staleActionGauge, staleActionExit} {
+		if _, err := newStaleInputWatchdog(time.Minute, action); err != nil {
+			t.Errorf("%s: %v", action, err)
+		}
+	}
+
+	if _, err := newStaleInputWatchdog(time.Minute, "restart"); err == nil {
+		t.Error("restart: error expected")
+	}
+}
+
+// staleInputWatchdog.check
+func TestStaleInputWatchdogCheck(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	gauge, _ := newStaleInputWatchdog(time.Minute, staleActionGauge)
+	exit, _ := newStaleInputWatchdog(time.Minute, staleActionExit)
+
+	var tests = []struct {
+		name    string
+		parse   bool
+		after   time.Duration
+		stalled float64
+		err     bool
+	}{
+		{"started", false, time.Second, 0, false},
+		{"never parsed", false, 2 * time.Minute, 1, true},
+		{"parsed", true, time.Second, 0, false},
+		{"parsed long ago", false, 2 * time.Minute, 1, true},
+	}
+
+	for _, tt := range tests {
+		if tt.parse {
+			rs.Parse(dynstatsBucketLine("host1"))
+		}
+
+		now := time.Now().Add(tt.after)
+
+		if err := gauge.check(rs, now); err != nil {
+			t.Errorf("%s: gauge action must not fail: %v", tt.name, err)
+		}
+
+		if got := testutil.ToFloat64(inputStalled); got != tt.stalled {
+			t.Errorf("%s: want stalled %v, got %v", tt.name, tt.stalled, got)
+		}
+
+		if err := exit.check(rs, now); (err != nil) != tt.err {
+			t.Errorf("%s: want exit error %v, got %v", tt.name, tt.err, err)
+		}
+	}
+}
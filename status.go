@@ -0,0 +1,201 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Family name suffix of the status gauges
+const statusGauge = "_status"
+
+// Expression environment: the counters of a single stats object (a queue, an
+// action e.g.) reported
+type statusEnv struct {
+	Origin   string             `expr:"origin"`
+	Name     string             `expr:"name"`
+	Counters map[string]float64 `expr:"counters"`
+	Delta    map[string]float64 `expr:"delta"`
+}
+
+// StatusRule is the threshold evaluated against every stats object of the
+// origin and exported as the status gauge (1 if the expression is true, 0
+// otherwise). Rule format is `<status>:<origin>:<bool expression>`:
+//
+//	queue_full:core.queue:counters.size / 10000 > 0.8
+//	discarding:core.queue:delta.discarded_full + delta.discarded_nf > 0
+//
+// `origin`, `name`, `counters` (the counters reported) and `delta` (the
+// counters change since the previous report of the object) variables are
+// available in the expression. Counter names are sanitised (discarded.full
+// is discarded_full).
+type StatusRule struct {
+	Rule    string
+	Status  string
+	Origin  string
+	program *vm.Program
+}
+
+// NewStatusRule is the StatusRule constructor
+func NewStatusRule(rule string) (*StatusRule, error) {
+	parts := strings.SplitN(rule, ":", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("status rule '%s' must be in '<status>:<origin>:<expression>' format", rule)
+	}
+
+	status, origin, code := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), strings.TrimSpace(parts[2])
+	if status == "" || origin == "" {
+		return nil, fmt.Errorf("status rule '%s' must have the status and the origin set", rule)
+	}
+
+	program, err := expr.Compile(code, expr.Env(statusEnv{}), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile status rule '%s': %w", rule, err)
+	}
+
+	return &StatusRule{Rule: rule, Status: status, Origin: origin, program: program}, nil
+}
+
+// Stats object the counters are reported for
+type statusObject struct {
+	origin string
+	labels RsyslogStatsLabels
+}
+
+// Get the label value and the labels without it
+func withoutLabel(l RsyslogStatsLabels, name string) (RsyslogStatsLabels, string) {
+	names, values := l.Names(), l.Values()
+	rv, value := RsyslogStatsLabels{}, ""
+
+	for i := range names {
+		if names[i] == name {
+			value = values[i]
+			continue
+		}
+
+		rv = rv.With(names[i], values[i])
+	}
+
+	return rv, value
+}
+
+// Group the metrics parsed from the stats message by the object labels. The
+// name label is added to the objects of the unlabeled counters.
+func statusObjects(m RsyslogStatsMetrics, prefix, name, origin string) map[RsyslogStatsLabels]map[string]float64 {
+	base := prefix + "_" + sanitiseMetricName(origin)
+	objects := map[RsyslogStatsLabels]map[string]float64{}
+
+	for metric, values := range m {
+		for labels, value := range values {
+			var counter string
+
+			if metric == base {
+				labels, counter = withoutLabel(labels, "counter")
+			} else if rest, found := strings.CutPrefix(metric, base+"_"); found {
+				counter = rest
+			} else {
+				continue
+			}
+
+			if names := labels.Names(); len(names) == 0 || names[0] != "name" {
+				counter = strings.TrimPrefix(counter, sanitiseMetricName(name)+"_")
+				labels = RsyslogStatsLabels{"name", name}.Merge(labels)
+			}
+
+			if objects[labels] == nil {
+				objects[labels] = map[string]float64{}
+			}
+			objects[labels][counter] = float64(value)
+		}
+	}
+
+	return objects
+}
+
+// Evaluate the status rules of the origin against the objects of the metrics
+// parsed and add the status gauges. The counters reported are kept to compute
+// the delta, the counter resets (rsyslog restart) use the counters as is.
+func (rs *RsyslogStats) addStatus(m RsyslogStatsMetrics, name, origin string) []error {
+	rules := []*StatusRule{}
+	for _, r := range rs.StatusRules {
+		if r.Origin == origin {
+			rules = append(rules, r)
+		}
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	errs := []error{}
+	family := rs.MetricPrefix + statusGauge
+	objects := statusObjects(m, rs.MetricPrefix, name, origin)
+
+	rs.Lock()
+	defer rs.Unlock()
+
+	for labels, counters := range objects {
+		key := statusObject{origin: origin, labels: labels}
+		prev := rs.statusCounters[key]
+		delta := make(map[string]float64, len(counters))
+
+		for counter, value := range counters {
+			delta[counter] = value
+			if p, found := prev[counter]; found && value >= p {
+				delta[counter] = value - p
+			}
+		}
+
+		rs.statusCounters[key] = counters
+
+		_, objectName := withoutLabel(labels, "name")
+		env := statusEnv{Origin: origin, Name: objectName, Counters: counters, Delta: delta}
+
+		for _, r := range rules {
+			out, err := expr.Run(r.program, env)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("status rule '%s' failed: %w", r.Rule, err))
+				continue
+			}
+
+			if m[family] == nil {
+				m[family] = RsyslogStatsLabeledValues{}
+			}
+
+			l := RsyslogStatsLabels{"status", r.Status}.With("origin", origin).Merge(labels)
+
+			m[family][l] = 0
+			if out.(bool) {
+				m[family][l] = 1
+			}
+		}
+	}
+
+	return errs
+}
+
+// Check if the family is the status gauge one
+func (rs *RsyslogStats) isStatusGauge(metricName string) bool {
+	return metricName == rs.MetricPrefix+statusGauge
+}
@@ -0,0 +1,120 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// NewStatusRule
+func TestStatusRuleNew(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		rule string
+		fail bool
+	}{
+		{`queue_full:core.queue:counters.size > 8000`, false},
+		{`degraded:core.action:delta.failed > 0 || name == "fwd"`, false},
+		{`queue_full:core.queue:counters.size`, true},
+		{`queue_full:counters.size > 8000`, true},
+		{`:core.queue:true`, true},
+	}
+
+	for _, c := range tests {
+		if _, err := NewStatusRule(c.rule); (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error state: %v", c.rule, err)
+		}
+	}
+}
+
+// RsyslogStats.addStatus
+func TestRsyslogStatsStatus(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.SetCounterLabelOrigins([]string{"core.action"})
+
+	for _, rule := range []string{
+		`queue_full:core.queue:counters.size / 1000 > 0.8`,
+		`discarding:core.queue:delta.discarded_full + delta.discarded_nf > 0`,
+		`suspended:core.action:delta.suspended > 0`,
+		`high_cpu:impstats:delta.utime > 1000000`,
+	} {
+		r, err := NewStatusRule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rs.StatusRules = append(rs.StatusRules, r)
+	}
+
+	status := func(status, origin, name string) RsyslogStatsLabels {
+		return RsyslogStatsLabels{"status", status}.With("origin", origin).With("name", name)
+	}
+
+	var tests = []struct {
+		lines []string
+		want  RsyslogStatsLabeledValues
+	}{
+		{
+			[]string{
+				`{"name": "main Q", "origin": "core.queue", "size": 900, "discarded.full": 5, "discarded.nf": 0}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 0}`,
+				`{"name": "resource-usage", "origin": "impstats", "utime": 500000}`,
+			},
+			RsyslogStatsLabeledValues{
+				status("queue_full", "core.queue", "main Q"):     1,
+				status("discarding", "core.queue", "main Q"):     1,
+				status("suspended", "core.action", "fwd"):        0,
+				status("high_cpu", "impstats", "resource-usage"): 0,
+			},
+		},
+		{
+			[]string{
+				`{"name": "main Q", "origin": "core.queue", "size": 10, "discarded.full": 5, "discarded.nf": 0}`,
+				`{"name": "fwd", "origin": "core.action", "suspended": 2}`,
+				`{"name": "resource-usage", "origin": "impstats", "utime": 2000000}`,
+			},
+			RsyslogStatsLabeledValues{
+				status("queue_full", "core.queue", "main Q"):     0,
+				status("discarding", "core.queue", "main Q"):     0,
+				status("suspended", "core.action", "fwd"):        1,
+				status("high_cpu", "impstats", "resource-usage"): 1,
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		for _, line := range tt.lines {
+			rs.Parse(line)
+		}
+
+		if diff := cmp.Diff(tt.want, rs.Metrics["rsyslog_status"]); diff != "" {
+			t.Errorf("%d: status mismatch (-want +got):\n%s", i, diff)
+		}
+	}
+
+	if rs.ParserFailures != 0 {
+		t.Errorf("want no parser failures, got %d", rs.ParserFailures)
+	}
+}
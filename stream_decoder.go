@@ -0,0 +1,193 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Stats lines are parsed by one goroutine at a time: the syslog channel
+// consumer and the direct decoders share the parser
+var parseMu sync.Mutex
+
+// Parse the stats line serialised with the other parser callers
+func parseSerialised(rs *RsyslogStats, src RsyslogStatsSource, content string) {
+	parseMu.Lock()
+	defer parseMu.Unlock()
+
+	rs.ParseFrom(src, content)
+}
+
+// Direct decoder of the raw stats line streams (no syslog envelope). Lines are
+// parsed in the reading goroutine bypassing the syslog channel, the listener
+// tagging goroutine and the per-line message maps. The decoder is used by the
+// raw inputs when it's set (nil means the lines are sent to the channel).
+var lineDecoder *directDecoder
+
+// Gates of the managed inputs by the channel their listeners send to. Lines
+// decoded directly don't pass the managed input forwarding, so they are
+// checked against the gate of the input channel instead.
+var directGates sync.Map
+
+// Raw line streams are decoded by the syslog channel consumer when the relay
+// is enabled, so non-stats lines are relayed as before
+type directDecoder struct {
+	rs *RsyslogStats
+}
+
+// Processing start times of the lines being decoded directly (a clock per
+// stream), checked by the watchdog the way processingSince is
+type decodingClocks struct {
+	sync.Mutex
+	clocks map[*atomic.Int64]struct{}
+}
+
+// Direct decoder streams processing times
+var directDecoding = &decodingClocks{clocks: map[*atomic.Int64]struct{}{}}
+
+// Add the stream clock
+func (dc *decodingClocks) add() *atomic.Int64 {
+	dc.Lock()
+	defer dc.Unlock()
+
+	clock := &atomic.Int64{}
+	dc.clocks[clock] = struct{}{}
+
+	return clock
+}
+
+// Remove the stream clock once the stream is done
+func (dc *decodingClocks) remove(clock *atomic.Int64) {
+	dc.Lock()
+	defer dc.Unlock()
+
+	delete(dc.clocks, clock)
+}
+
+// Get the earliest processing start time of the streams (0 if all of them
+// are waiting for a line)
+func (dc *decodingClocks) since() int64 {
+	dc.Lock()
+	defer dc.Unlock()
+
+	var rv int64
+
+	for clock := range dc.clocks {
+		if started := clock.Load(); started != 0 && (rv == 0 || started < rv) {
+			rv = started
+		}
+	}
+
+	return rv
+}
+
+// Decode the newline-delimited stats lines of the stream. The message parts
+// (client, tenant, listener) are the same for all lines of the stream. Lines
+// of the paused input are discarded, reading stops once the input is removed.
+func (d *directDecoder) decode(r io.Reader, parts format.LogParts, gate *managedInput) error {
+	client, _ := parts["client"].(string)
+	labels := messageLabels(parts)
+
+	clock := directDecoding.add()
+	defer directDecoding.remove(clock)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		if gate.discards() {
+			if gate.removed.Load() {
+				return errInputStopped
+			}
+
+			continue
+		}
+
+		clock.Store(time.Now().UnixNano())
+		d.parse(parts, client, labels, line)
+		clock.Store(0)
+	}
+
+	return scanner.Err()
+}
+
+// Parse the stream line recovering the parser panics
+func (d *directDecoder) parse(parts format.LogParts, client string, labels RsyslogStatsLabels, line string) {
+	defer recoverParser(client, line)
+
+	now := time.Now()
+	if !rateLimiter.Allow(messagePeer(parts), now) {
+		return
+	}
+
+	sender, data := senderSource.resolve(parts, line)
+	if data != nil {
+		defer putObject(data)
+	}
+
+	recorder.record(now, client, line)
+	archive.archive(now, sender, line)
+	listener, _ := parts["listener"].(string)
+	parseSerialised(d.rs, RsyslogStatsSource{Sender: sender, Labels: labels, Listener: listener, Data: data}, line)
+}
+
+// Send the stream lines to the channel (the way the syslog inputs do)
+func sendLines(channel syslog.LogPartsChannel, r io.Reader, parts format.LogParts) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			message := format.LogParts{"content": line}
+			for key, value := range parts {
+				message[key] = value
+			}
+
+			channel <- message
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Read the raw stats line stream by the direct decoder if it's enabled,
+// falling back to the channel otherwise
+func readLines(channel syslog.LogPartsChannel, r io.Reader, parts format.LogParts) error {
+	if lineDecoder != nil {
+		gate, _ := directGates.Load(channel)
+		mi, _ := gate.(*managedInput)
+
+		return lineDecoder.decode(r, parts, mi)
+	}
+
+	return sendLines(channel, r, parts)
+}
@@ -0,0 +1,143 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+const streamLines = `{"name":"main Q","origin":"core.queue","size":1}
+
+{"name":"action-0","origin":"core.action","processed":2}
+`
+
+// directDecoder.decode
+func TestDirectDecoderDecode(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	d := &directDecoder{rs: rs}
+
+	if err := d.decode(strings.NewReader(streamLines), format.LogParts{"client": "192.0.2.1:1234"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size":       {RsyslogStatsLabels{"name", "main Q"}: 1},
+		"rsyslog_core_action_processed": {RsyslogStatsLabels{"name", "action-0"}: 2},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	if rs.ParsedMessages != 2 {
+		t.Errorf("ParsedMessages: want 2, got %d", rs.ParsedMessages)
+	}
+}
+
+// sendLines
+func TestSendLines(t *testing.T) {
+	t.Parallel()
+
+	channel := make(syslog.LogPartsChannel, 10)
+	parts := format.LogParts{"client": "192.0.2.1:1234", "listener": "quic::4433"}
+
+	if err := sendLines(channel, strings.NewReader(streamLines), parts); err != nil {
+		t.Fatal(err)
+	}
+
+	close(channel)
+
+	got := []format.LogParts{}
+	for message := range channel {
+		got = append(got, message)
+	}
+
+	want := []format.LogParts{
+		{"content": `{"name":"main Q","origin":"core.queue","size":1}`, "client": "192.0.2.1:1234", "listener": "quic::4433"},
+		{"content": `{"name":"action-0","origin":"core.action","processed":2}`, "client": "192.0.2.1:1234", "listener": "quic::4433"},
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("messages mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, found := parts["content"]; found {
+		t.Error("sendLines: stream parts must not be modified")
+	}
+}
+
+// directDecoder.decode
+func TestDirectDecoderGate(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	d := &directDecoder{rs: rs}
+	gate := &managedInput{input: "raw://gate.test:5145"}
+	gate.paused.Store(true)
+
+	if err := d.decode(strings.NewReader(streamLines), format.LogParts{"client": "192.0.2.1:1234"}, gate); err != nil {
+		t.Fatal(err)
+	}
+
+	if rs.ParsedMessages != 0 {
+		t.Errorf("paused input: want no lines parsed, got %d", rs.ParsedMessages)
+	}
+
+	gate.removed.Store(true)
+
+	if err := d.decode(strings.NewReader(streamLines), format.LogParts{"client": "192.0.2.1:1234"}, gate); !errors.Is(err, errInputStopped) {
+		t.Errorf("removed input: want input stopped error, got %v", err)
+	}
+}
+
+// decodingClocks.since
+func TestDecodingClocks(t *testing.T) {
+	t.Parallel()
+
+	dc := &decodingClocks{clocks: map[*atomic.Int64]struct{}{}}
+	idle, first, second := dc.add(), dc.add(), dc.add()
+
+	if got := dc.since(); got != 0 {
+		t.Errorf("idle streams: want 0, got %d", got)
+	}
+
+	first.Store(200)
+	second.Store(100)
+
+	if got := dc.since(); got != 100 {
+		t.Errorf("busy streams: want the earliest start 100, got %d", got)
+	}
+
+	dc.remove(second)
+	dc.remove(idle)
+
+	if got := dc.since(); got != 200 {
+		t.Errorf("removed stream: want 200, got %d", got)
+	}
+}
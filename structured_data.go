@@ -0,0 +1,169 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Label the structured data param is mapped to
+type sdParam struct {
+	id    string
+	name  string
+	label string
+}
+
+// SDMapping maps RFC5424 STRUCTURED-DATA params to labels
+type SDMapping struct {
+	params []sdParam
+}
+
+// Structured data mapping of the syslog inputs (disabled if nil)
+var sdMapping *SDMapping
+
+// NewSDMapping is the SDMapping constructor. Items format is
+// `<SD-ID>/<PARAM-NAME>=<label>`. The `tenant` label assigns the tenant.
+func NewSDMapping(items []string) (*SDMapping, error) {
+	m := &SDMapping{}
+	labels := map[string]bool{}
+
+	for _, item := range items {
+		param, label, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("structured data mapping '%s' must be in '<SD-ID>/<PARAM-NAME>=<label>' format", item)
+		}
+
+		i := strings.LastIndex(param, "/")
+		if i < 1 || i == len(param)-1 {
+			return nil, fmt.Errorf("structured data mapping '%s' must be in '<SD-ID>/<PARAM-NAME>=<label>' format", item)
+		}
+
+		if !reLabelName.MatchString(label) || strings.HasPrefix(label, "__") {
+			return nil, fmt.Errorf("invalid label name '%s'", label)
+		}
+
+		if labels[label] {
+			return nil, fmt.Errorf("label '%s' is mapped more than once", label)
+		}
+
+		labels[label] = true
+		m.params = append(m.params, sdParam{id: param[:i], name: param[i+1:], label: label})
+	}
+
+	return m, nil
+}
+
+// Labels returns the mapped label names in the mapping order
+func (m *SDMapping) Labels() []string {
+	if m == nil {
+		return nil
+	}
+
+	rv := make([]string, 0, len(m.params))
+	for _, p := range m.params {
+		rv = append(rv, p.label)
+	}
+
+	return rv
+}
+
+// Extract the mapped label values from the structured data. Values of the
+// params not found are empty.
+func (m *SDMapping) Extract(sd string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	// elements parsed before the error are still usable
+	elements, _ := parseStructuredData(sd)
+
+	rv := make(map[string]string, len(m.params))
+	for _, p := range m.params {
+		rv[p.label] = elements[p.id][p.name]
+	}
+
+	return rv
+}
+
+// Parse RFC5424 STRUCTURED-DATA: `[id name="value" ...][id ...]` or `-`.
+// PARAM-VALUE escapes (\", \\ and \]) are unescaped. Returns the elements
+// parsed before the error if any.
+func parseStructuredData(sd string) (map[string]map[string]string, error) {
+	rv := map[string]map[string]string{}
+
+	if sd == "" || sd == "-" {
+		return rv, nil
+	}
+
+	i := 0
+	for i < len(sd) {
+		if sd[i] != '[' {
+			return rv, fmt.Errorf("structured data element must start with '[' at %d", i)
+		}
+
+		i++
+		end := strings.IndexAny(sd[i:], " ]")
+		if end < 1 {
+			return rv, fmt.Errorf("structured data element ID expected at %d", i)
+		}
+
+		id := sd[i : i+end]
+		params := map[string]string{}
+		i += end
+
+		for i < len(sd) && sd[i] == ' ' {
+			i++
+
+			eq := strings.IndexByte(sd[i:], '=')
+			if eq < 1 || i+eq+1 >= len(sd) || sd[i+eq+1] != '"' {
+				return rv, fmt.Errorf("structured data param expected at %d", i)
+			}
+
+			name := sd[i : i+eq]
+			i += eq + 2
+
+			var value strings.Builder
+			for ; i < len(sd) && sd[i] != '"'; i++ {
+				if sd[i] == '\\' && i+1 < len(sd) && strings.IndexByte(`"\]`, sd[i+1]) >= 0 {
+					i++
+				}
+
+				value.WriteByte(sd[i])
+			}
+
+			if i == len(sd) {
+				return rv, fmt.Errorf("structured data param '%s' value is not terminated", name)
+			}
+
+			params[name] = value.String()
+			i++
+		}
+
+		if i == len(sd) || sd[i] != ']' {
+			return rv, fmt.Errorf("structured data element '%s' is not terminated", id)
+		}
+
+		rv[id] = params
+		i++
+	}
+
+	return rv, nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// parseStructuredData
+func TestParseStructuredData(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		input  string
+		output map[string]map[string]string
+		fails  bool
+	}{
+		{"-", map[string]map[string]string{}, false},
+		{`[origin@32473 instance="i-1" ip="10.0.0.1"]`, map[string]map[string]string{"origin@32473": {"instance": "i-1", "ip": "10.0.0.1"}}, false},
+		{`[a x="1"][b]`, map[string]map[string]string{"a": {"x": "1"}, "b": {}}, false},
+		{`[a x="q\"b\\s\]"]`, map[string]map[string]string{"a": {"x": `q"b\s]`}}, false},
+		{`[a x="1"][b y="2`, map[string]map[string]string{"a": {"x": "1"}}, true},
+		{`[a x=1]`, map[string]map[string]string{}, true},
+		{`[a x="1"`, map[string]map[string]string{}, true},
+		{`[]`, map[string]map[string]string{}, true},
+		{`a`, map[string]map[string]string{}, true},
+	}
+
+	for _, c := range tests {
+		got, err := parseStructuredData(c.input)
+		if (err != nil) != c.fails {
+			t.Errorf("%s: want error %t, got %v", c.input, c.fails, err)
+		}
+
+		if diff := cmp.Diff(c.output, got); diff != "" {
+			t.Errorf("%s: structured data mismatch (-want +got):\n%s", c.input, diff)
+		}
+	}
+}
+
+// SDMapping.Extract
+func TestSDMappingExtract(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewSDMapping([]string{"origin@32473/instance=instance", "meta/tenant=tenant"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{"instance", "tenant"}, m.Labels()); diff != "" {
+		t.Errorf("Labels mismatch (-want +got):\n%s", diff)
+	}
+
+	want := map[string]string{"instance": "i-1", "tenant": ""}
+	if diff := cmp.Diff(want, m.Extract(`[origin@32473 instance="i-1"]`)); diff != "" {
+		t.Errorf("Extract mismatch (-want +got):\n%s", diff)
+	}
+
+	if m = nil; m.Extract(`[origin@32473 instance="i-1"]`) != nil || m.Labels() != nil {
+		t.Error("nil mapping must extract nothing")
+	}
+
+	for _, item := range []string{"origin/instance", "instance=instance", "origin/=instance", "origin/instance=in-stance", "origin/instance=__instance", "a/b=l,c/d=l"} {
+		if _, err := NewSDMapping(splitList(item)); err == nil {
+			t.Errorf("%s: error expected", item)
+		}
+	}
+}
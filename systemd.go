@@ -0,0 +1,222 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// systemd notification states
+const (
+	sdReady     = "READY=1"
+	sdStopping  = "STOPPING=1"
+	sdWatchdog  = "WATCHDOG=1"
+	sdNotifyEnv = "NOTIFY_SOCKET"
+)
+
+var watchdogFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "rsyslog_exporter_watchdog_failures",
+		Help: "Amount of failed watchdog self-checks, the systemd watchdog is not serviced while any check fails",
+	},
+	[]string{"check"},
+)
+
+// Metrics exported by the systemd watchdog
+var watchdogMetrics = []prometheus.Collector{
+	watchdogFailures,
+}
+
+// Unix time in nanoseconds the current message processing is started at, 0
+// while the channel consumer is waiting for a message
+var processingSince atomic.Int64
+
+// Send the state to the systemd notification socket. Returns false if the
+// exporter is not started by systemd with the notification socket set.
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv(sdNotifyEnv)
+	if socket == "" {
+		return false, nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("cannot connect to the systemd notification socket %s: %w", socket, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("cannot notify systemd: %w", err)
+	}
+
+	return true, nil
+}
+
+// Notify systemd logging the errors only
+func sdNotifyLogged(state string) {
+	if _, err := sdNotify(state); err != nil {
+		log.Print(err)
+	}
+}
+
+// Return the systemd watchdog interval (WatchdogSec=) or 0 if the watchdog is
+// not enabled for this process
+func sdWatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid WATCHDOG_USEC value '%s'", usec)
+	}
+
+	return time.Duration(n) * time.Microsecond, nil
+}
+
+// Watchdog self-check, returns an error if the exporter looks hung. The
+// timeout is the time the check is allowed to wait.
+type watchdogCheck struct {
+	name  string
+	check func(timeout time.Duration) error
+}
+
+// Check the lock is acquired in time. A probe stuck on the lock is not
+// duplicated, the check keeps failing until it's done.
+func lockCheck(name string, l sync.Locker) watchdogCheck {
+	var pending atomic.Bool
+
+	return watchdogCheck{
+		name: name,
+		check: func(timeout time.Duration) error {
+			if !pending.CompareAndSwap(false, true) {
+				return errors.New("previous probe is still waiting for the lock")
+			}
+
+			done := make(chan struct{})
+
+			go func() {
+				l.Lock()
+				l.Unlock()
+				pending.Store(false)
+				close(done)
+			}()
+
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+
+			select {
+			case <-done:
+				return nil
+			case <-timer.C:
+				return fmt.Errorf("lock is not acquired in %s", timeout)
+			}
+		},
+	}
+}
+
+// Check the syslog channel consumer is not stuck on a single message, since is
+// the processing start time (see processingSince)
+func channelCheck(since *atomic.Int64) watchdogCheck {
+	return stallCheck("channel", since.Load)
+}
+
+// Check the message consumer is not stuck on a single message, since returns
+// the processing start time (0 while the consumer is idle)
+func stallCheck(name string, since func() int64) watchdogCheck {
+	return watchdogCheck{
+		name: name,
+		check: func(timeout time.Duration) error {
+			started := since()
+			if started == 0 {
+				return nil
+			}
+
+			if busy := time.Since(time.Unix(0, started)); busy > timeout {
+				return fmt.Errorf("message is processed for %s", busy.Truncate(time.Millisecond))
+			}
+
+			return nil
+		},
+	}
+}
+
+// Self-checks of the parser, the metric store, the syslog channel consumer and
+// the direct decoder streams
+func defaultWatchdogChecks(rs *RsyslogStats) []watchdogCheck {
+	return []watchdogCheck{
+		lockCheck("store", rs.RLocker()),
+		lockCheck("parser", &parseMu),
+		channelCheck(&processingSince),
+		stallCheck("decoder", directDecoding.since),
+	}
+}
+
+// Run the checks returning true if all of them are passed
+func runWatchdogChecks(checks []watchdogCheck, timeout time.Duration) bool {
+	ok := true
+
+	for _, c := range checks {
+		if err := c.check(timeout); err != nil {
+			log.Printf("watchdog check %s failed: %v", c.name, err)
+			watchdogFailures.WithLabelValues(c.name).Inc()
+
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// Service the systemd watchdog every half of the interval while the
+// self-checks are passed. The checks are allowed to wait for a quarter of the
+// interval so a hung exporter misses the watchdog deadline and it's
+// restarted by systemd.
+func runWatchdog(ctx context.Context, interval time.Duration, checks []watchdogCheck) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if runWatchdogChecks(checks, interval/4) {
+				sdNotifyLogged(sdWatchdog)
+			}
+		}
+	}
+}
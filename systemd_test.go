@@ -0,0 +1,166 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sdNotify
+func TestSdNotify(t *testing.T) {
+	t.Setenv(sdNotifyEnv, "")
+
+	if sent, err := sdNotify(sdReady); sent || err != nil {
+		t.Errorf("want no notification without the socket, got %v, %v", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv(sdNotifyEnv, socket)
+
+	if sent, err := sdNotify(sdReady); !sent || err != nil {
+		t.Fatalf("want notification sent, got %v, %v", sent, err)
+	}
+
+	buf := make([]byte, 64)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := string(buf[:n]); got != sdReady {
+		t.Errorf("want %s, got %s", sdReady, got)
+	}
+
+	t.Setenv(sdNotifyEnv, filepath.Join(t.TempDir(), "missing.sock"))
+
+	if _, err := sdNotify(sdReady); err == nil {
+		t.Error("error expected for the missing socket")
+	}
+}
+
+// sdWatchdogInterval
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	var tests = []struct {
+		usec string
+		pid  string
+		want time.Duration
+		err  bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 30 * time.Second, false},
+		{"30000000", pid, 30 * time.Second, false},
+		{"30000000", "1", 0, false},
+		{"0", "", 0, true},
+		{"30s", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+
+		got, err := sdWatchdogInterval()
+		if (err != nil) != tt.err {
+			t.Errorf("%s/%s: want error %v, got %v", tt.usec, tt.pid, tt.err, err)
+		}
+
+		if got != tt.want {
+			t.Errorf("%s/%s: want %s, got %s", tt.usec, tt.pid, tt.want, got)
+		}
+	}
+}
+
+// lockCheck
+func TestLockCheck(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+
+	c := lockCheck("test", &mu)
+
+	if err := c.check(time.Second); err != nil {
+		t.Errorf("unlocked: %v", err)
+	}
+
+	mu.Lock()
+
+	if err := c.check(10 * time.Millisecond); err == nil {
+		t.Error("locked: error expected")
+	}
+
+	if err := c.check(time.Second); err == nil {
+		t.Error("locked: error expected for the pending probe")
+	}
+
+	mu.Unlock()
+
+	// The pending probe is done shortly after the unlock
+	deadline := time.Now().Add(time.Second)
+	for c.check(time.Second) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("unlocked: check is still failing")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// channelCheck
+func TestChannelCheck(t *testing.T) {
+	t.Parallel()
+
+	var since atomic.Int64
+
+	c := channelCheck(&since)
+
+	if err := c.check(time.Second); err != nil {
+		t.Errorf("idle: %v", err)
+	}
+
+	since.Store(time.Now().UnixNano())
+
+	if err := c.check(time.Second); err != nil {
+		t.Errorf("busy: %v", err)
+	}
+
+	since.Store(time.Now().Add(-time.Minute).UnixNano())
+
+	if err := c.check(time.Second); err == nil {
+		t.Error("stuck: error expected")
+	}
+}
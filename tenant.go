@@ -0,0 +1,133 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Tenant of the messages received from the unmapped connections
+const unknownTenant = "unknown"
+
+// Tenant of the source network
+type tenantNet struct {
+	net    *net.IPNet
+	tenant string
+}
+
+// TenantMap maps TLS connection identities (client certificate CN or SNI) and
+// source networks to tenants
+type TenantMap struct {
+	sni  map[string]string
+	cn   map[string]string
+	nets []tenantNet
+}
+
+// Tenant map of the inputs (tenant label is not attached if nil)
+var tenants *TenantMap
+
+// NewTenantMap is the TenantMap constructor. Items format is
+// `sni:<server name>=<tenant>`, `cn:<client certificate CN>=<tenant>` or
+// `cidr:<network>=<tenant>`.
+func NewTenantMap(items []string) (*TenantMap, error) {
+	tm := &TenantMap{sni: make(map[string]string), cn: make(map[string]string)}
+
+	for _, item := range items {
+		kind, mapping, found := strings.Cut(item, ":")
+		if !found {
+			return nil, fmt.Errorf("tenant mapping '%s' must be in '<sni|cn|cidr>:<identity>=<tenant>' format", item)
+		}
+
+		identity, tenant, found := strings.Cut(mapping, "=")
+		if !found || identity == "" || tenant == "" {
+			return nil, fmt.Errorf("tenant mapping '%s' must be in '<sni|cn|cidr>:<identity>=<tenant>' format", item)
+		}
+
+		switch kind {
+		case "sni":
+			tm.sni[strings.ToLower(identity)] = tenant
+		case "cn":
+			tm.cn[identity] = tenant
+		case "cidr":
+			_, ipNet, err := net.ParseCIDR(identity)
+			if err != nil {
+				return nil, fmt.Errorf("wrong tenant mapping CIDR %s: %w", identity, err)
+			}
+
+			tm.nets = append(tm.nets, tenantNet{ipNet, tenant})
+		default:
+			return nil, fmt.Errorf("tenant identity %s is not supported", kind)
+		}
+	}
+
+	return tm, nil
+}
+
+// Tenant returns the tenant of the TLS connection. The verified client
+// certificate is preferred over SNI as the latter is claimed by the client.
+func (tm *TenantMap) Tenant(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		if tenant, found := tm.cn[state.VerifiedChains[0][0].Subject.CommonName]; found {
+			return tenant
+		}
+	}
+
+	if tenant, found := tm.sni[strings.ToLower(state.ServerName)]; found {
+		return tenant
+	}
+
+	return unknownTenant
+}
+
+// NetworkTenant returns the tenant of the most specific network the client
+// address (ip or ip:port) belongs to
+func (tm *TenantMap) NetworkTenant(client string) (string, bool) {
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return "", false
+	}
+
+	tenant, bestOnes := "", -1
+	for _, n := range tm.nets {
+		if ones, _ := n.net.Mask.Size(); ones > bestOnes && n.net.Contains(ip) {
+			tenant, bestOnes = n.tenant, ones
+		}
+	}
+
+	return tenant, bestOnes >= 0
+}
+
+// Attach the tenant of the TLS connection to the message if the tenant map is set
+func (tm *TenantMap) tag(parts format.LogParts, state tls.ConnectionState) format.LogParts {
+	if tm != nil {
+		parts["tenant"] = tm.Tenant(state)
+	}
+
+	return parts
+}
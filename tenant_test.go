@@ -0,0 +1,133 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Build the TLS connection state with the verified client certificate
+func tenantConnState(serverName, cn string) tls.ConnectionState {
+	state := tls.ConnectionState{ServerName: serverName}
+	if cn != "" {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+	}
+
+	return state
+}
+
+// TenantMap.Tenant
+func TestTenantMapTenant(t *testing.T) {
+	t.Parallel()
+
+	tm, err := NewTenantMap([]string{"sni:Stats.A.example.com=a", "sni:stats.b.example.com=b", "cn:client-b=b", "cn:client-c=c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		serverName string
+		cn         string
+		tenant     string
+	}{
+		{"stats.a.example.com", "", "a"},
+		{"STATS.B.EXAMPLE.COM", "", "b"},
+		{"stats.a.example.com", "client-c", "c"},
+		{"stats.a.example.com", "client-x", "a"},
+		{"", "client-b", "b"},
+		{"stats.x.example.com", "", unknownTenant},
+		{"", "", unknownTenant},
+	}
+
+	for _, c := range tests {
+		if got := tm.Tenant(tenantConnState(c.serverName, c.cn)); got != c.tenant {
+			t.Errorf("%s/%s: want %s, got %s", c.serverName, c.cn, c.tenant, got)
+		}
+	}
+}
+
+// NewTenantMap (wrong mappings)
+func TestNewTenantMapErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, item := range []string{"stats.a.example.com=a", "sni:stats.a.example.com", "sni:=a", "sni:stats.a.example.com=", "ip:10.0.0.1=a"} {
+		if _, err := NewTenantMap([]string{item}); err == nil {
+			t.Errorf("%s: error expected", item)
+		}
+	}
+}
+
+// TenantMap.tag
+func TestTenantMapTag(t *testing.T) {
+	t.Parallel()
+
+	var tm *TenantMap
+	if parts := tm.tag(format.LogParts{}, tenantConnState("stats.a.example.com", "")); parts["tenant"] != nil {
+		t.Errorf("nil tenant map must not tag the message, got %v", parts["tenant"])
+	}
+
+	tm, err := NewTenantMap([]string{"sni:stats.a.example.com=a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parts := tm.tag(format.LogParts{}, tenantConnState("stats.a.example.com", "")); parts["tenant"] != "a" {
+		t.Errorf("want tenant a, got %v", parts["tenant"])
+	}
+}
+
+// TenantMap.NetworkTenant
+func TestTenantMapNetworkTenant(t *testing.T) {
+	t.Parallel()
+
+	tm, err := NewTenantMap([]string{"cidr:10.0.0.0/8=prod", "cidr:10.20.0.0/16=stage", "cidr:2001:db8::/32=dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		client string
+		tenant string
+		found  bool
+	}{
+		{"10.1.2.3:514", "prod", true},
+		{"10.20.2.3:514", "stage", true},
+		{"10.20.2.3", "stage", true},
+		{"[2001:db8::1]:514", "dev", true},
+		{"192.0.2.1:514", "", false},
+		{"zmq", "", false},
+	}
+
+	for _, c := range tests {
+		if tenant, found := tm.NetworkTenant(c.client); tenant != c.tenant || found != c.found {
+			t.Errorf("%s: want (%s, %t), got (%s, %t)", c.client, c.tenant, c.found, tenant, found)
+		}
+	}
+
+	if _, err = NewTenantMap([]string{"cidr:10.0.0.0/33=prod"}); err == nil {
+		t.Error("wrong CIDR error expected")
+	}
+}
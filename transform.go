@@ -0,0 +1,286 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Transform actions
+const (
+	transformDrop    = "drop"
+	transformRename  = "rename"
+	transformRelabel = "relabel"
+)
+
+// Expression environment: every parsed metric value is passed through it
+type transformEnv struct {
+	Name   string            `expr:"name"`
+	Labels map[string]string `expr:"labels"`
+	Value  float64           `expr:"value"`
+}
+
+// Transform is the user-defined expression hook applied to parsed metrics
+// before they are stored. Rule format is `<action>:<expression>`:
+//
+//	drop:    bool expression, metric value is dropped when true
+//	rename:  string expression, returns the new metric name
+//	relabel: map expression, returns the new labels
+//
+// `name`, `labels` and `value` variables are available in the expression.
+type Transform struct {
+	Rule    string
+	action  string
+	program *vm.Program
+}
+
+// NewTransform is the Transform constructor
+func NewTransform(rule string) (*Transform, error) {
+	parts := strings.SplitN(rule, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("transform '%s' must be in '<action>:<expression>' format", rule)
+	}
+
+	action, code := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	opts := []expr.Option{expr.Env(transformEnv{})}
+
+	switch action {
+	case transformDrop:
+		opts = append(opts, expr.AsBool())
+	case transformRename:
+		opts = append(opts, expr.AsKind(reflect.String))
+	case transformRelabel:
+		opts = append(opts, expr.AsKind(reflect.Map))
+	default:
+		return nil, fmt.Errorf("transform action %s is not supported", action)
+	}
+
+	program, err := expr.Compile(code, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile transform '%s': %w", rule, err)
+	}
+
+	return &Transform{Rule: rule, action: action, program: program}, nil
+}
+
+// Convert labels to the expression environment form
+func labelsToMap(l RsyslogStatsLabels) map[string]string {
+	names, values := l.Names(), l.Values()
+	labels := make(map[string]string, len(names))
+
+	for i, name := range names {
+		labels[name] = values[i]
+	}
+
+	return labels
+}
+
+// Convert the expression result back to labels (sorted by name)
+func labelsFromMap(m map[string]interface{}) (RsyslogStatsLabels, error) {
+	l := RsyslogStatsLabels{}
+	names := make([]string, 0, len(m))
+
+	for name := range m {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, ok := m[name].(string)
+		if !ok {
+			return l, fmt.Errorf("label '%s' value must be a string, got '%T'", name, m[name])
+		}
+
+		l = l.With(name, value)
+	}
+
+	return l, nil
+}
+
+// Apply the transform to a single metric value.
+// Returns false if the value must be dropped.
+func (t *Transform) apply(name string, labels RsyslogStatsLabels, value RsyslogStatsValue) (string, RsyslogStatsLabels, bool, error) {
+	env := transformEnv{Name: name, Labels: labelsToMap(labels), Value: float64(value)}
+
+	out, err := expr.Run(t.program, env)
+	if err != nil {
+		return name, labels, true, fmt.Errorf("transform '%s' failed: %w", t.Rule, err)
+	}
+
+	switch t.action {
+	case transformDrop:
+		return name, labels, !out.(bool), nil
+	case transformRename:
+		return sanitiseMetricName(out.(string)), labels, true, nil
+	case transformRelabel:
+		m := map[string]interface{}{}
+
+		switch v := out.(type) {
+		case map[string]interface{}:
+			m = v
+		case map[string]string:
+			for name, value := range v {
+				m[name] = value
+			}
+		default:
+			return name, labels, true, fmt.Errorf("transform '%s' must return map[string]string, got '%T'", t.Rule, out)
+		}
+
+		// the input labels are kept on error, not the partial ones
+		relabeled, err := labelsFromMap(m)
+		if err != nil {
+			return name, labels, true, fmt.Errorf("transform '%s' failed: %w", t.Rule, err)
+		}
+
+		return name, relabeled, true, nil
+	}
+
+	return name, labels, true, nil
+}
+
+// Check the label sets have the same label names (in any order)
+func sameLabelNames(a, b RsyslogStatsLabels) bool {
+	if a.Name == b.Name {
+		return true
+	}
+
+	an, bn := a.Names(), b.Names()
+	if len(an) != len(bn) {
+		return false
+	}
+
+	sort.Strings(an)
+	sort.Strings(bn)
+
+	for i := range an {
+		if an[i] != bn[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check the transformed series can be exported along with its family: the
+// label names must match the ones of the family (transformed or stored) and
+// the renamed series must not change the value type of an existing family.
+// The value type of the source family is carried to the new one otherwise.
+// Must be called with lock held.
+func (rs *RsyslogStats) checkTransformed(rv RsyslogStatsMetrics, source string, name string, labels RsyslogStatsLabels) error {
+	family := rv[name]
+	if len(family) == 0 {
+		family = rs.Metrics[name]
+	}
+
+	for other := range family {
+		if !sameLabelNames(other, labels) {
+			return fmt.Errorf("label names %q don't match %q of the family", labels.Names(), other.Names())
+		}
+
+		break
+	}
+
+	sourceType := metricValueType(rs, source)
+	if name == source || metricValueType(rs, name) == sourceType {
+		return nil
+	}
+
+	if len(family) > 0 {
+		return fmt.Errorf("renamed %s series don't match the value type of the family", source)
+	}
+
+	// the type is taken from the family the chain of renames started at
+	if root, found := rs.renamedFamilies[source]; found {
+		source = root
+	}
+
+	if source != name {
+		rs.renamedFamilies[name] = source
+	}
+
+	return nil
+}
+
+// Apply all transforms to the metrics collected. The transformed series
+// which can't be exported along with their family are quarantined.
+func (rs *RsyslogStats) applyTransforms(m RsyslogStatsMetrics) (RsyslogStatsMetrics, []error) {
+	transforms := rs.Transforms
+	if len(transforms) == 0 {
+		return m, nil
+	}
+
+	errs := []error{}
+	rv := RsyslogStatsMetrics{}
+
+	// the families are walked in order, so the series accepted first into the
+	// family are the same on every parse
+	for _, metricName := range m.SortedNames() {
+		labeledValues := m[metricName]
+
+	values:
+		for labels, value := range labeledValues {
+			name, l := metricName, labels
+
+			for _, t := range transforms {
+				var (
+					keep bool
+					err  error
+				)
+
+				name, l, keep, err = t.apply(name, l, value)
+				if err != nil {
+					errs = append(errs, err)
+				}
+
+				if !keep {
+					continue values
+				}
+			}
+
+			if name != metricName || l != labels {
+				rs.Lock()
+				err := rs.checkTransformed(rv, metricName, name, l)
+				if err != nil {
+					rs.quarantine(name, l, err)
+				}
+				rs.Unlock()
+
+				if err != nil {
+					continue
+				}
+			}
+
+			if _, found := rv[name]; !found {
+				rv[name] = make(RsyslogStatsLabeledValues)
+			}
+
+			rv[name][l] = value
+		}
+	}
+
+	return rv, errs
+}
@@ -0,0 +1,221 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewTransform
+func TestTransformNew(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		rule string
+		fail bool
+	}{
+		{`drop: value == 0`, false},
+		{`rename: name + "_total"`, false},
+		{`relabel: {"queue": labels.name}`, false},
+		{`drop: name`, true},
+		{`rename: value`, true},
+		{`keep: true`, true},
+		{`value == 0`, true},
+	}
+
+	for _, c := range tests {
+		if _, err := NewTransform(c.rule); (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error state: %v", c.rule, err)
+		}
+	}
+}
+
+// applyTransforms
+func TestTransformApply(t *testing.T) {
+	t.Parallel()
+
+	input := RsyslogStatsMetrics{
+		"rsyslog_core_queue_size": {
+			RsyslogStatsLabels{"name", "main Q"}:   1,
+			RsyslogStatsLabels{"name", "action 0"}: 0,
+		},
+		"rsyslog_core_queue_full": {
+			RsyslogStatsLabels{"name", "main Q"}: 2,
+		},
+	}
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_queue_size": {
+			RsyslogStatsLabels{"queue", "main Q"}.With("site", "dc1"): 1,
+		},
+		"rsyslog_queue_full": {
+			RsyslogStatsLabels{"queue", "main Q"}.With("site", "dc1"): 2,
+		},
+	}
+
+	transforms := []*Transform{}
+	for _, rule := range []string{
+		`drop: value == 0`,
+		`rename: replace(name, "_core_", "_")`,
+		`relabel: {"queue": labels.name, "site": "dc1"}`,
+	} {
+		tr, err := NewTransform(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		transforms = append(transforms, tr)
+	}
+
+	rs := NewRsyslogStats()
+	rs.Transforms = transforms
+
+	got, errs := rs.applyTransforms(input)
+	for _, e := range errs {
+		t.Errorf("%v", e)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RsyslogStatsMetrics mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// Transform.apply
+func TestTransformRelabelError(t *testing.T) {
+	t.Parallel()
+
+	tr, err := NewTransform(`relabel: {"a": "x", "b": value}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := RsyslogStatsLabels{"name", "main Q"}
+
+	name, got, keep, err := tr.apply("rsyslog_core_queue_size", labels, 1)
+	if err == nil {
+		t.Error("non-string label value: error expected")
+	}
+
+	if name != "rsyslog_core_queue_size" || !keep {
+		t.Errorf("want the series kept as rsyslog_core_queue_size, got %s kept %v", name, keep)
+	}
+
+	if diff := cmp.Diff(labels, got); diff != "" {
+		t.Errorf("labels mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// RsyslogStats.applyTransforms (label names of the family)
+func TestTransformLabelNames(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.Transforms = []*Transform{
+		mustTransform(t, `relabel: name startsWith "rsyslog_core_queue_" ? (labels.name == "main Q" ? {"queue": labels.name} : {"name": labels.name, "site": "dc1"}) : labels`),
+		mustTransform(t, `rename: name == "rsyslog_core_queue_full" ? "rsyslog_core_action_processed" : name`),
+	}
+
+	rs.Parse(`{"name": "action-1", "origin": "core.action", "processed": 5}`)
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1, "full": 2}`)
+	rs.Parse(`{"name": "action 0", "origin": "core.queue", "size": 3}`)
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_action_processed": {
+			RsyslogStatsLabels{"name", "action-1"}: 5,
+		},
+		"rsyslog_core_queue_size": {
+			RsyslogStatsLabels{"queue", "main Q"}: 1,
+		},
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics); diff != "" {
+		t.Errorf("Metrics mismatch (-want +got):\n%s", diff)
+	}
+
+	// the renamed queue series and the relabeled "action 0" one
+	if rs.QuarantinedSeries != 2 {
+		t.Errorf("QuarantinedSeries: want 2, got %d", rs.QuarantinedSeries)
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(NewRsyslogStatsCollector(rs))
+
+	if _, err := reg.Gather(); err != nil {
+		t.Errorf("Gather: %s", err)
+	}
+}
+
+// RsyslogStats.applyTransforms (value type of the renamed family)
+func TestTransformRenameType(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.QueueWatermarks = true
+	rs.Transforms = []*Transform{mustTransform(t, `rename: replace(name, "_core_queue_", "_queue_")`)}
+
+	rs.Parse(`{"name": "main Q", "origin": "core.queue", "size": 1, "enqueued": 10}`)
+
+	var tests = []struct {
+		metric string
+		want   prometheus.ValueType
+	}{
+		{"rsyslog_queue_size", prometheus.GaugeValue},
+		{"rsyslog_queue_size_watermark", prometheus.GaugeValue},
+		{"rsyslog_queue_enqueued", prometheus.CounterValue},
+	}
+
+	for _, tt := range tests {
+		if got := metricValueType(rs, tt.metric); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.metric, tt.want, got)
+		}
+	}
+
+	// the counter renamed into the gauge family is quarantined
+	rs.Transforms = append(rs.Transforms, mustTransform(t, `rename: name == "rsyslog_queue_enqueued" ? "rsyslog_queue_size" : name`))
+	rs.Parse(`{"name": "action 0", "origin": "core.queue", "size": 2, "enqueued": 20}`)
+
+	want := RsyslogStatsLabeledValues{
+		RsyslogStatsLabels{"name", "main Q"}:   1,
+		RsyslogStatsLabels{"name", "action 0"}: 2,
+	}
+
+	if diff := cmp.Diff(want, rs.Metrics["rsyslog_queue_size"]); diff != "" {
+		t.Errorf("rsyslog_queue_size mismatch (-want +got):\n%s", diff)
+	}
+
+	if rs.QuarantinedSeries != 1 {
+		t.Errorf("QuarantinedSeries: want 1, got %d", rs.QuarantinedSeries)
+	}
+}
+
+// Compile the transform rule
+func mustTransform(t *testing.T, rule string) *Transform {
+	t.Helper()
+
+	tr, err := NewTransform(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tr
+}
@@ -0,0 +1,226 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Typed decoding of the highest-volume stats lines. Decoding into structs
+// avoids the map and the boxed values allocated per counter by the generic
+// path. Lines not matching the struct exactly (unknown counters, non-numeric
+// values, trailing data e.g.) fall back to the generic path, so the metrics
+// and the parser errors stay the same.
+
+// Counter value decoded from a JSON number or a numeric string
+type typedValue struct {
+	value float64
+	set   bool
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (tv *typedValue) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if len(s) > 1 && s[0] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+
+	tv.value, tv.set = v, true
+
+	return nil
+}
+
+// Typed counter with its original name
+type typedCounter struct {
+	name  string
+	value *typedValue
+}
+
+// core.queue stats line
+type typedQueueStats struct {
+	Name          string     `json:"name"`
+	Origin        string     `json:"origin"`
+	Size          typedValue `json:"size"`
+	Enqueued      typedValue `json:"enqueued"`
+	Full          typedValue `json:"full"`
+	DiscardedFull typedValue `json:"discarded.full"`
+	DiscardedNF   typedValue `json:"discarded.nf"`
+	MaxQSize      typedValue `json:"maxqsize"`
+}
+
+func (qs *typedQueueStats) counters() []typedCounter {
+	return []typedCounter{
+		{"size", &qs.Size},
+		{"enqueued", &qs.Enqueued},
+		{"full", &qs.Full},
+		{"discarded.full", &qs.DiscardedFull},
+		{"discarded.nf", &qs.DiscardedNF},
+		{"maxqsize", &qs.MaxQSize},
+	}
+}
+
+// core.action stats line
+type typedActionStats struct {
+	Name              string     `json:"name"`
+	Origin            string     `json:"origin"`
+	Processed         typedValue `json:"processed"`
+	Failed            typedValue `json:"failed"`
+	Suspended         typedValue `json:"suspended"`
+	SuspendedDuration typedValue `json:"suspended.duration"`
+	Resumed           typedValue `json:"resumed"`
+}
+
+func (as *typedActionStats) counters() []typedCounter {
+	return []typedCounter{
+		{"processed", &as.Processed},
+		{"failed", &as.Failed},
+		{"suspended", &as.Suspended},
+		{"suspended.duration", &as.SuspendedDuration},
+		{"resumed", &as.Resumed},
+	}
+}
+
+// senders.keepTrack stats line
+type typedSenderStats struct {
+	Name     string     `json:"name"`
+	Origin   string     `json:"origin"`
+	Sender   string     `json:"sender"`
+	Messages typedValue `json:"messages"`
+}
+
+// Origin values selecting the typed decoding (quoted as in the JSON line)
+const (
+	typedOriginQueue  = `"core.queue"`
+	typedOriginAction = `"core.action"`
+	typedOriginSender = `"impstats"`
+)
+
+// Get the origin of the line by a cheap check of the bytes after the origin
+// key, without decoding the line. Returns "" if it's not a typed decoding
+// candidate.
+func typedOrigin(line string) string {
+	i := strings.Index(line, `"origin"`)
+	if i < 0 {
+		return ""
+	}
+
+	rest := strings.TrimLeft(line[i+len(`"origin"`):], " \t")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+
+	rest = strings.TrimLeft(rest[1:], " \t")
+
+	switch {
+	case strings.HasPrefix(rest, typedOriginQueue):
+		return "core.queue"
+	case strings.HasPrefix(rest, typedOriginAction):
+		return "core.action"
+	case strings.HasPrefix(rest, typedOriginSender) && strings.Contains(line, `"_sender_stat"`):
+		return "impstats"
+	}
+
+	return ""
+}
+
+// Decode the line into the struct strictly: unknown fields and trailing data
+// are rejected
+func decodeStrict(line string, v interface{}) bool {
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return false
+	}
+
+	return strings.TrimSpace(line[dec.InputOffset():]) == ""
+}
+
+// Check if the typed decoding may be used for the origin: it's the default
+// field layout w/o the options depending on the raw JSON object
+func (rs *RsyslogStats) typedDecodingAllowed(origin string) bool {
+	if !rs.TypedDecoding || rs.JSONPath != "" || rs.NameField != "name" || rs.OriginField != "origin" {
+		return false
+	}
+
+	if rs.rawOrigins[origin] {
+		return false
+	}
+
+	_, found := rs.schema.Load().statType(origin)
+
+	return !found
+}
+
+// Parse the named stats counters decoded
+func (rs *RsyslogStats) parseTypedNamed(name, origin string, counters []typedCounter) RsyslogStatsMetrics {
+	m := RsyslogStatsMetrics{}
+	l := rs.namedLabels(name, origin)
+
+	for _, c := range counters {
+		if c.value.set {
+			rs.appendNamed(m, origin, l, c.name, c.value.value)
+		}
+	}
+
+	return m
+}
+
+// Parse the line by the typed decoding. Returns false if the line must be
+// parsed by the generic path.
+func (rs *RsyslogStats) parseTyped(line string) (name string, origin string, st rsyslogStatType, m RsyslogStatsMetrics, ok bool) {
+	origin = typedOrigin(line)
+	if origin == "" || !rs.typedDecodingAllowed(origin) {
+		return "", "", rtDefault, nil, false
+	}
+
+	switch origin {
+	case "core.queue":
+		var qs typedQueueStats
+		if !decodeStrict(line, &qs) || qs.Name == "" || qs.Origin != origin {
+			break
+		}
+
+		return qs.Name, origin, rtNamed, rs.parseTypedNamed(qs.Name, origin, qs.counters()), true
+	case "core.action":
+		var as typedActionStats
+		if !decodeStrict(line, &as) || as.Name == "" || as.Origin != origin || rs.isDatabaseStats(as.Name, origin) {
+			break
+		}
+
+		return as.Name, origin, rtNamed, rs.parseTypedNamed(as.Name, origin, as.counters()), true
+	case "impstats":
+		var ss typedSenderStats
+		if !decodeStrict(line, &ss) || ss.Name != "_sender_stat" || ss.Origin != origin || ss.Sender == "" || !ss.Messages.set {
+			break
+		}
+
+		return ss.Name, origin, rtSender, rs.senderMetrics(ss.Sender, ss.Messages.value), true
+	}
+
+	return "", "", rtDefault, nil, false
+}
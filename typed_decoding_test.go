@@ -0,0 +1,137 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// typedOrigin
+func TestTypedOrigin(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		line string
+		want string
+	}{
+		{`{"name":"main Q","origin":"core.queue","size":1}`, "core.queue"},
+		{`{ "name": "main Q", "origin" : "core.queue", "size": 1 }`, "core.queue"},
+		{`{"name":"action-0","origin":"core.action","processed":1}`, "core.action"},
+		{`{"name":"_sender_stat","origin":"impstats","sender":"h","messages":"1"}`, "impstats"},
+		{`{"name":"resource-usage","origin":"impstats","utime":1}`, ""},
+		{`{"name":"global","origin":"dynstats","values":{}}`, ""},
+		{`{"name":"main Q","size":1}`, ""},
+		{`{"origin":"core.queued"}`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := typedOrigin(tt.line); got != tt.want {
+			t.Errorf("typedOrigin(%s): want '%s', got '%s'", tt.line, tt.want, got)
+		}
+	}
+}
+
+// RsyslogStats.parseTyped
+func TestRsyslogStatsParseTyped(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		line  string
+		typed bool
+	}{
+		{`{"name":"main Q","origin":"core.queue","size":1,"enqueued":2,"full":0,"discarded.full":3,"discarded.nf":4,"maxqsize":5}`, true},
+		{`{ "name": "main Q", "origin": "core.queue", "size": 1 }`, true},
+		{`{"name":"main Q","origin":"core.queue","size":1,"new.counter":2}`, false},
+		{`{"name":"main Q","origin":"core.queue","size":"bad"}`, false},
+		{`{"name":"main Q","origin":"core.queue","size":null}`, false},
+		{`{"name":"main Q","origin":"core.queue","size":1} trailing`, false},
+		{`{"origin":"core.queue","size":1}`, false},
+		{`{"name":"action-1-builtin:omfwd","origin":"core.action","processed":10,"failed":1,"suspended":0,"suspended.duration":0,"resumed":0}`, true},
+		{`{"name":"action-1-builtin:omfwd","origin":"core.action","processed":"10"}`, true},
+		{`{"name":"_sender_stat","origin":"impstats","sender":"host1","messages":"12"}`, true},
+		{`{"name":"_sender_stat","origin":"impstats","sender":"host1"}`, false},
+		{`{"name":"resource-usage","origin":"impstats","utime":1}`, false},
+	}
+
+	for _, tt := range tests {
+		typed := NewRsyslogStats()
+		typed.CanonicalNames = true
+		typed.QueueKindLabel = true
+
+		generic := NewRsyslogStats()
+		generic.CanonicalNames = true
+		generic.QueueKindLabel = true
+		generic.TypedDecoding = false
+
+		_, _, _, _, ok := typed.parseTyped(tt.line)
+		if ok != tt.typed {
+			t.Errorf("parseTyped(%s): want %v, got %v", tt.line, tt.typed, ok)
+		}
+
+		typed.Parse(tt.line)
+		generic.Parse(tt.line)
+
+		if diff := cmp.Diff(generic.Metrics, typed.Metrics); diff != "" {
+			t.Errorf("%s metrics mismatch (-generic +typed):\n%s", tt.line, diff)
+		}
+
+		if typed.ParserFailures != generic.ParserFailures {
+			t.Errorf("%s ParserFailures: want %d, got %d", tt.line, generic.ParserFailures, typed.ParserFailures)
+		}
+	}
+}
+
+// RsyslogStats.parseTyped
+func TestRsyslogStatsParseTypedDisabled(t *testing.T) {
+	t.Parallel()
+
+	line := `{"name":"main Q","origin":"core.queue","size":1}`
+
+	rs := NewRsyslogStats()
+	rs.SetRawOrigins([]string{"core.queue"})
+
+	if _, _, _, _, ok := rs.parseTyped(line); ok {
+		t.Error("parseTyped: want generic path for raw origins")
+	}
+
+	rs = NewRsyslogStats()
+	rs.NameField = "queue"
+
+	if _, _, _, _, ok := rs.parseTyped(line); ok {
+		t.Error("parseTyped: want generic path for custom name field")
+	}
+}
+
+// RsyslogStats.ParseFrom allocations
+func TestRsyslogStatsParseTypedAllocs(t *testing.T) {
+	line := `{"name":"main Q","origin":"core.queue","size":1,"enqueued":2,"full":0,"discarded.full":3,"discarded.nf":4,"maxqsize":5}`
+
+	rs := NewRsyslogStats()
+	typed := testing.AllocsPerRun(100, func() { rs.Parse(line) })
+
+	rs.TypedDecoding = false
+	generic := testing.AllocsPerRun(100, func() { rs.Parse(line) })
+
+	if typed >= generic {
+		t.Errorf("allocations: want typed (%.0f) < generic (%.0f)", typed, generic)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+)
+
+// Datagrams read per syscall by the internal UDP receiver (1 disables the
+// batched reads)
+var udpBatchSize = 1
+
+// Max syslog datagram size
+const datagramSize = 65536
+
+// Batched datagram reader: ipv4.PacketConn or ipv6.PacketConn (both use the
+// same message type)
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// Read syslog datagrams in batches until the connection is closed
+func receiveDatagramBatches(br batchReader, size int, f format.Format, channel syslog.LogPartsChannel) error {
+	ms := make([]ipv4.Message, size)
+	for i := range ms {
+		ms[i].Buffers = [][]byte{make([]byte, datagramSize)}
+	}
+
+	for {
+		n, err := br.ReadBatch(ms, 0)
+		if errors.Is(err, net.ErrClosed) {
+			return err
+		}
+
+		if err != nil {
+			log.Printf("cannot read datagrams: %s", err)
+			continue
+		}
+
+		for _, m := range ms[:n] {
+			client := ""
+			if m.Addr != nil {
+				client = m.Addr.String()
+			}
+
+			sendSyslog(channel, f, m.Buffers[0][:m.N], client)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gopkg.in/mcuadros/go-syslog.v2"
+)
+
+// receiveDatagramBatches
+func TestReceiveDatagramBatches(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	br := newBatchReader(conn)
+	if br == nil {
+		conn.Close()
+		t.Skip("batched reads are not supported on this platform")
+	}
+
+	sender, err := net.Dial("udp4", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	// the datagrams are queued before the read, so they are read in batches
+	want := []string{}
+	for i := 0; i < 10; i++ {
+		msg := fmt.Sprintf("message %d", i)
+		want = append(want, msg)
+
+		if _, err := fmt.Fprintf(sender, "<46>Oct 16 13:00:00 host rsyslogd-pstats: %s\n", msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	channel := make(syslog.LogPartsChannel, len(want))
+	done := make(chan error, 1)
+
+	go func() { done <- receiveDatagramBatches(br, 4, syslog.RFC3164, channel) }()
+
+	got := []string{}
+	for range want {
+		select {
+		case parts := <-channel:
+			got = append(got, parts["content"].(string))
+
+			if client := parts["client"]; client != sender.LocalAddr().String() {
+				t.Errorf("client: want %s, got %v", sender.LocalAddr(), client)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("datagrams are not received")
+		}
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("receiveDatagramBatches() mismatch (-want +got):\n%s", diff)
+	}
+
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Errorf("want closed connection error, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("receiveDatagramBatches is not returned on the closed connection")
+	}
+}
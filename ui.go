@@ -0,0 +1,115 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	_ "embed"
+	"flag"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+//go:embed ui/index.html
+var uiTemplateText string
+
+var uiTemplate = template.Must(template.New("ui").Parse(uiTemplateText))
+
+// Web UI input state
+type uiInput struct {
+	Input string
+	State string
+}
+
+// Web UI configuration flag
+type uiConfig struct {
+	Name  string
+	Value string
+}
+
+// Web UI page data
+type uiPage struct {
+	Version        string
+	Generated      time.Time
+	ParsedMessages int
+	ParserFailures int
+	Hosts          []RsyslogStatsReportingHost
+	Inputs         []uiInput
+	Failures       []RsyslogStatsParseFailure
+	Families       int
+	Series         int
+	Biggest        []RsyslogStatsFamilySeries
+	Config         []uiConfig
+}
+
+// Build the web UI page data
+func buildUIPage(rs *RsyslogStats, fs *flag.FlagSet, top int) uiPage {
+	page := uiPage{
+		Version:   version,
+		Generated: time.Now(),
+		Hosts:     rs.ReportingHosts(),
+		Failures:  rs.ParseFailureSamples(),
+	}
+
+	rs.RLock()
+	page.ParsedMessages, page.ParserFailures = rs.ParsedMessages, rs.ParserFailures
+	rs.RUnlock()
+
+	page.Families, page.Series, page.Biggest = rs.Cardinality(top)
+
+	for input, state := range getInputStates() {
+		page.Inputs = append(page.Inputs, uiInput{Input: input, State: state})
+	}
+
+	sort.Slice(page.Inputs, func(i, j int) bool { return page.Inputs[i].Input < page.Inputs[j].Input })
+
+	// the latest failures first
+	for i, j := 0, len(page.Failures)-1; i < j; i, j = i+1, j-1 {
+		page.Failures[i], page.Failures[j] = page.Failures[j], page.Failures[i]
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if !configInfoSkippedFlags[f.Name] {
+			name, value := configValue(f)
+			page.Config = append(page.Config, uiConfig{Name: name, Value: value})
+		}
+	})
+
+	return page
+}
+
+// Register the read-only web UI endpoint:
+//
+//	GET /ui/   reporting hosts, listeners, recent parse failures, cardinality and configuration
+func registerUIHandlers(mux *http.ServeMux, rs *RsyslogStats, fs *flag.FlagSet, top int) {
+	mux.Handle("/ui/", instrumentHandler("ui", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := uiTemplate.Execute(w, buildUIPage(rs, fs, top)); err != nil {
+			log.Printf("cannot render web UI: %s", err)
+		}
+	})))
+}
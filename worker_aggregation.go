@@ -0,0 +1,159 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Default pattern of the per-worker core.action and core.queue names: the
+// logical name followed by the worker number (omkafka-w2, "fwd worker 1" e.g.)
+const defaultWorkerPattern = `^(?P<action>.+?)[ _/-]+w(?:orker)?[ _-]?(?P<worker>\d+)$`
+
+// Family name parts of the logical (summed over the workers) series
+const (
+	workersFamily     = "_workers"
+	workerCountFamily = "_worker_count"
+)
+
+// Get the logical name and the worker of the per-worker name by the pattern
+// with "action" and "worker" named groups
+func workerName(pattern *regexp.Regexp, name string) (logical string, worker string, ok bool) {
+	match := pattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", "", false
+	}
+
+	if i := pattern.SubexpIndex("action"); i > 0 {
+		logical = match[i]
+	}
+
+	if i := pattern.SubexpIndex("worker"); i > 0 {
+		worker = match[i]
+	}
+
+	return logical, worker, logical != ""
+}
+
+// Get the labels of the logical series the per-worker series is summed into.
+// The name label is the first one of the core.action and core.queue series.
+func (rs *RsyslogStats) logicalLabels(l RsyslogStatsLabels) (RsyslogStatsLabels, string, bool) {
+	names, values := l.Names(), l.Values()
+	if len(names) == 0 || names[0] != "name" {
+		return l, "", false
+	}
+
+	logical, worker, ok := workerName(rs.WorkerPattern, values[0])
+	if !ok {
+		return l, "", false
+	}
+
+	rv := RsyslogStatsLabels{"name", logical}
+	for i := 1; i < len(names); i++ {
+		rv = rv.With(names[i], values[i])
+	}
+
+	return rv, worker, true
+}
+
+// Sum the per-worker series of the families updated into the logical series
+// (<prefix>_core_action_workers_processed{name="omkafka"} e.g.) and count the
+// workers reporting. The per-worker series are kept as is. Must be called
+// once the families are stored, as the workers report in separate messages.
+func (rs *RsyslogStats) workerSums(origin string, updated RsyslogStatsMetrics) RsyslogStatsMetrics {
+	base := rs.MetricPrefix + "_" + sanitiseMetricName(origin)
+	m := RsyslogStatsMetrics{}
+
+	rs.RLock()
+	defer rs.RUnlock()
+
+	for metric, values := range updated {
+		rest, found := strings.CutPrefix(metric, base)
+		if !found || rs.isQueueWatermark(metric) || strings.HasPrefix(rest, workersFamily) {
+			continue
+		}
+
+		// logical series of the workers updated
+		targets := map[RsyslogStatsLabels]bool{}
+		for labels := range values {
+			if logical, _, ok := rs.logicalLabels(labels); ok {
+				targets[logical] = true
+			}
+		}
+
+		if len(targets) == 0 {
+			continue
+		}
+
+		sums := RsyslogStatsLabeledValues{}
+		workers := map[RsyslogStatsLabels]map[string]bool{}
+
+		for labels, value := range rs.Metrics[metric] {
+			logical, worker, ok := rs.logicalLabels(labels)
+			if !ok || !targets[logical] {
+				continue
+			}
+
+			sums[logical] += value
+
+			if workers[logical] == nil {
+				workers[logical] = map[string]bool{}
+			}
+			workers[logical][worker] = true
+		}
+
+		m[base+workersFamily+rest] = sums
+
+		counts := RsyslogStatsLabeledValues{}
+		for logical, w := range workers {
+			counts[logical] = RsyslogStatsValue(len(w))
+		}
+		m[base+workerCountFamily] = counts
+	}
+
+	return m
+}
+
+// Origins of the per-worker series summed
+var workerOrigins = []string{"core.action", "core.queue"}
+
+// Check if the family is the worker count gauge
+func (rs *RsyslogStats) isWorkerCount(metricName string) bool {
+	for _, origin := range workerOrigins {
+		if metricName == rs.MetricPrefix+"_"+sanitiseMetricName(origin)+workerCountFamily {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get the per-worker family the logical series family is summed from
+func (rs *RsyslogStats) workerSumSource(metricName string) (string, bool) {
+	for _, origin := range workerOrigins {
+		base := rs.MetricPrefix + "_" + sanitiseMetricName(origin)
+		if rest, found := strings.CutPrefix(metricName, base+workersFamily); found {
+			return base + rest, true
+		}
+	}
+
+	return "", false
+}
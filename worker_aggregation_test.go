@@ -0,0 +1,112 @@
+/*
+ * Export rsyslog counters as prometheus metrics
+ *
+ * Copyright (c) 2021, Yury Bushmelev <jay4mail@gmail.com>
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// workerName
+func TestWorkerName(t *testing.T) {
+	t.Parallel()
+
+	pattern := regexp.MustCompile(defaultWorkerPattern)
+
+	var tests = []struct {
+		name    string
+		logical string
+		worker  string
+		ok      bool
+	}{
+		{"omkafka-w2", "omkafka", "2", true},
+		{"fwd worker 1", "fwd", "1", true},
+		{"es_worker_10", "es", "10", true},
+		{"action/w0", "action", "0", true},
+		{"action-0-builtin:omfile", "", "", false},
+		{"main Q", "", "", false},
+		{"w1", "", "", false},
+	}
+
+	for _, tt := range tests {
+		logical, worker, ok := workerName(pattern, tt.name)
+		if logical != tt.logical || worker != tt.worker || ok != tt.ok {
+			t.Errorf("%s: want %s, %s, %v, got %s, %s, %v", tt.name, tt.logical, tt.worker, tt.ok, logical, worker, ok)
+		}
+	}
+}
+
+// RsyslogStats.workerSums
+func TestRsyslogStatsWorkerSums(t *testing.T) {
+	t.Parallel()
+
+	rs := NewRsyslogStats()
+	rs.WorkerPattern = regexp.MustCompile(defaultWorkerPattern)
+
+	rs.Parse(`{"name": "omkafka-w0", "origin": "core.action", "processed": 10, "failed": 1}`)
+	rs.Parse(`{"name": "omkafka-w1", "origin": "core.action", "processed": 20, "failed": 0}`)
+	rs.Parse(`{"name": "omkafka-w0", "origin": "core.action", "processed": 15, "failed": 1}`)
+	rs.Parse(`{"name": "action-0-builtin:omfile", "origin": "core.action", "processed": 5, "failed": 0}`)
+	rs.Parse(`{"name": "omkafka-w1 queue", "origin": "core.queue", "size": 3}`)
+
+	omkafka := RsyslogStatsLabels{"name", "omkafka"}
+
+	want := RsyslogStatsMetrics{
+		"rsyslog_core_action_workers_processed": {omkafka: 35},
+		"rsyslog_core_action_workers_failed":    {omkafka: 1},
+		"rsyslog_core_action_worker_count":      {omkafka: 2},
+	}
+
+	got := RsyslogStatsMetrics{}
+	for metric := range want {
+		got[metric] = rs.Metrics[metric]
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("logical series mismatch (-want +got):\n%s", diff)
+	}
+
+	// per-worker series are kept, the queue name doesn't match the pattern
+	if got := len(rs.Metrics["rsyslog_core_action_processed"]); got != 3 {
+		t.Errorf("want 3 per-action series, got %d", got)
+	}
+
+	if _, found := rs.Metrics["rsyslog_core_queue_workers_size"]; found {
+		t.Error("unexpected logical queue series")
+	}
+
+	var types = []struct {
+		metric string
+		want   prometheus.ValueType
+	}{
+		{"rsyslog_core_action_workers_processed", prometheus.CounterValue},
+		{"rsyslog_core_action_worker_count", prometheus.GaugeValue},
+		{"rsyslog_core_queue_workers_size", prometheus.GaugeValue},
+	}
+
+	for _, tt := range types {
+		if got := metricValueType(rs, tt.metric); got != tt.want {
+			t.Errorf("%s: want type %v, got %v", tt.metric, tt.want, got)
+		}
+	}
+}